@@ -0,0 +1,23 @@
+// Command etims-mock runs the eTIMS mock server on its own, for pointing a
+// development backend at with ETIMS_MODE=http.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/OAthooh/BiasharaTrack.git/etims"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "address to listen on")
+	failRate := flag.Float64("fail-rate", 0, "fraction of requests to fail with a server error")
+	flag.Parse()
+
+	mock := etims.NewMockServer()
+	mock.FailRate = *failRate
+
+	log.Printf("eTIMS mock server listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mock))
+}
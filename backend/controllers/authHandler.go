@@ -69,7 +69,7 @@ func (auth *AuthHandler) Login(c *gin.Context) {
 		"exp":       time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
 	})
 
-	tokenString, err := token.SignedString([]byte("f50559429275498b09d13392269fc0fd02a2f548d8470c3765a8895212080636")) // To Do Later Replace with secure secret key
+	tokenString, err := token.SignedString(utils.JWTSecret())
 	if err != nil {
 		utils.ErrorLogger("Error generating token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
@@ -84,6 +84,7 @@ func (auth *AuthHandler) Login(c *gin.Context) {
 			"id":        user.ID,
 			"full_name": user.FullName,
 			"email":     user.Email,
+			"role":      user.Role,
 		},
 	})
 }
@@ -127,11 +128,32 @@ func (auth *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// The first account to register owns the shop; everyone after starts as a cashier
+	var userCount int64
+	if err := auth.db.Model(&models.User{}).Count(&userCount).Error; err != nil {
+		utils.ErrorLogger("Database error counting users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	role := models.RoleCashier
+	if userCount == 0 {
+		role = models.RoleOwner
+	}
+
+	businessID, err := defaultBusinessID(auth.db)
+	if err != nil {
+		utils.ErrorLogger("Database error loading business: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
 	// Create new user
 	newUser := models.User{
-		FullName: registerRequest.FullName,
-		Email:    registerRequest.Email,
-		Password: string(hashedPassword),
+		FullName:   registerRequest.FullName,
+		Email:      registerRequest.Email,
+		Password:   string(hashedPassword),
+		Role:       role,
+		BusinessID: businessID,
 	}
 
 	result = auth.db.Create(&newUser)
@@ -149,7 +171,7 @@ func (auth *AuthHandler) Register(c *gin.Context) {
 		"exp":       time.Now().Add(time.Hour * 24).Unix(),
 	})
 
-	tokenString, err := token.SignedString([]byte("your-secret-key")) // To Do Later Replace with secure secret key
+	tokenString, err := token.SignedString(utils.JWTSecret())
 	if err != nil {
 		utils.ErrorLogger("Error generating token for new user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
@@ -164,6 +186,7 @@ func (auth *AuthHandler) Register(c *gin.Context) {
 			"id":        newUser.ID,
 			"full_name": newUser.FullName,
 			"email":     newUser.Email,
+			"role":      newUser.Role,
 		},
 	})
 }
@@ -189,8 +212,8 @@ func (auth *AuthHandler) VerifyToken(c *gin.Context) {
 	}
 
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("f50559429275498b09d13392269fc0fd02a2f548d8470c3765a8895212080636"), nil // Replace with secure secret key
-	})
+		return utils.JWTSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil || !token.Valid {
 		utils.WarningLogger("Invalid token verification attempt")
@@ -217,3 +240,57 @@ func (auth *AuthHandler) checkPassword(providedPassword, storedPassword string)
 	err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(providedPassword))
 	return err == nil
 }
+
+func (auth *AuthHandler) GetUsers(c *gin.Context) {
+	var users []models.User
+	if err := auth.db.Order("full_name").Find(&users).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (auth *AuthHandler) UpdateUserRole(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !models.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A valid role is required"})
+		return
+	}
+
+	var user models.User
+	if err := auth.db.First(&user, id).Error; err != nil {
+		utils.WarningLogger("User not found: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Never leave the shop without an owner
+	if user.Role == models.RoleOwner && req.Role != models.RoleOwner {
+		var owners int64
+		if err := auth.db.Model(&models.User{}).Where("role = ?", models.RoleOwner).Count(&owners).Error; err != nil {
+			utils.ErrorLogger("Database error counting owners: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if owners <= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one owner is required"})
+			return
+		}
+	}
+
+	user.Role = req.Role
+	if err := auth.db.Save(&user).Error; err != nil {
+		utils.ErrorLogger("Failed to update role for user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	utils.InfoLogger("Set role of user %s to %s", id, req.Role)
+	c.JSON(http.StatusOK, user)
+}
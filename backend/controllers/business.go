@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/search"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type BusinessHandler struct {
+	db *gorm.DB
+}
+
+func NewBusinessHandler(db *gorm.DB) *BusinessHandler {
+	return &BusinessHandler{db: db}
+}
+
+// defaultBusinessID returns the shop's business, which the migration creates
+// on first run.
+func defaultBusinessID(db *gorm.DB) (uint, error) {
+	var business models.Business
+	if err := db.Order("id").Limit(1).Find(&business).Error; err != nil {
+		return 0, err
+	}
+	return business.ID, nil
+}
+
+// businessIDFor returns the business of the signed-in user, falling back to
+// the shop's business for requests made without a token.
+func businessIDFor(c *gin.Context, db *gorm.DB) (uint, error) {
+	if user, ok := middleware.CurrentUser(c); ok && user.BusinessID != 0 {
+		return user.BusinessID, nil
+	}
+	return defaultBusinessID(db)
+}
+
+func (bh *BusinessHandler) GetBusiness(c *gin.Context) {
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch business"})
+		return
+	}
+
+	var business models.Business
+	if err := bh.db.First(&business, businessID).Error; err != nil {
+		utils.WarningLogger("Business not found: %v", err)
+		c.JSON(404, gin.H{"error": "Business not found"})
+		return
+	}
+
+	c.JSON(200, business)
+}
+
+func (bh *BusinessHandler) UpdateBusiness(c *gin.Context) {
+	var input map[string]interface{}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorLogger("Failed to parse update business request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to update business"})
+		return
+	}
+
+	var business models.Business
+	if err := bh.db.First(&business, businessID).Error; err != nil {
+		utils.WarningLogger("Business not found: %v", err)
+		c.JSON(404, gin.H{"error": "Business not found"})
+		return
+	}
+
+	if name, ok := input["name"].(string); ok && strings.TrimSpace(name) != "" {
+		business.Name = name
+	}
+	if phone, ok := input["phone"].(string); ok {
+		business.Phone = phone
+	}
+	if email, ok := input["email"].(string); ok {
+		business.Email = email
+	}
+	if address, ok := input["address"].(string); ok {
+		business.Address = address
+	}
+	if pin, ok := input["kra_pin"].(string); ok {
+		business.KRAPin = strings.ToUpper(strings.TrimSpace(pin))
+	}
+	if registered, ok := input["vat_registered"].(bool); ok {
+		business.VATRegistered = registered
+	}
+	if included, ok := input["prices_include_tax"].(bool); ok {
+		business.PricesIncludeTax = included
+	}
+	if enabled, ok := input["etims_enabled"].(bool); ok {
+		business.EtimsEnabled = enabled
+	}
+	if branch, ok := input["etims_branch_id"].(string); ok && strings.TrimSpace(branch) != "" {
+		business.EtimsBranchID = strings.TrimSpace(branch)
+	}
+	if percent, ok := input["layaway_min_deposit_percent"].(float64); ok {
+		if percent < 0 || percent > 100 {
+			c.JSON(400, gin.H{"error": "Layaway minimum deposit must be between 0 and 100 percent"})
+			return
+		}
+		business.LayawayMinDepositPercent = percent
+	}
+	if percent, ok := input["layaway_forfeit_percent"].(float64); ok {
+		if percent < 0 || percent > 100 {
+			c.JSON(400, gin.H{"error": "Layaway forfeit must be between 0 and 100 percent"})
+			return
+		}
+		business.LayawayForfeitPercent = percent
+	}
+	if days, ok := input["layaway_term_days"].(float64); ok {
+		if days < 1 {
+			c.JSON(400, gin.H{"error": "Layaway term must be at least one day"})
+			return
+		}
+		business.LayawayTermDays = int(days)
+	}
+	if enabled, ok := input["loyalty_enabled"].(bool); ok {
+		business.LoyaltyEnabled = enabled
+	}
+	if rate, ok := input["loyalty_points_per_shilling"].(float64); ok {
+		if rate < 0 {
+			c.JSON(400, gin.H{"error": "Points per shilling must not be negative"})
+			return
+		}
+		business.LoyaltyPointsPerShilling = rate
+	}
+	if value, ok := input["loyalty_point_value"].(float64); ok {
+		if value <= 0 {
+			c.JSON(400, gin.H{"error": "Point value must be positive"})
+			return
+		}
+		business.LoyaltyPointValue = value
+	}
+	if days, ok := input["loyalty_expiry_days"].(float64); ok {
+		if days < 0 {
+			c.JSON(400, gin.H{"error": "Points expiry must not be negative; use 0 for points that never expire"})
+			return
+		}
+		business.LoyaltyExpiryDays = int(days)
+	}
+	if points, ok := input["loyalty_min_redeem_points"].(float64); ok {
+		if points < 0 {
+			c.JSON(400, gin.H{"error": "Minimum points to redeem must not be negative"})
+			return
+		}
+		business.LoyaltyMinRedeemPoints = int(points)
+	}
+	if business.EtimsEnabled && (!business.VATRegistered || !validKRAPin(business.KRAPin)) {
+		c.JSON(400, gin.H{"error": "eTIMS needs VAT registration and a valid KRA PIN"})
+		return
+	}
+
+	if err := bh.db.Save(&business).Error; err != nil {
+		utils.ErrorLogger("Failed to update business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to update business"})
+		return
+	}
+
+	utils.InfoLogger("Successfully updated business %d", business.ID)
+	c.JSON(200, business)
+}
+
+func (bh *BusinessHandler) GetSearchSynonyms(c *gin.Context) {
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch synonyms"})
+		return
+	}
+
+	var synonyms []models.SearchSynonym
+	if err := bh.db.Where("business_id = ?", businessID).Order("id").Find(&synonyms).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch synonyms: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch synonyms"})
+		return
+	}
+
+	c.JSON(200, synonyms)
+}
+
+type synonymRequest struct {
+	Words []string `json:"words" binding:"required"`
+}
+
+// normalizeSynonymWords trims and de-duplicates a synonym group, returning
+// nil when fewer than two distinct words remain.
+func normalizeSynonymWords(words []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(word, ",", " ")))
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		result = append(result, word)
+	}
+	if len(result) < 2 {
+		return nil
+	}
+	return result
+}
+
+func (bh *BusinessHandler) CreateSearchSynonym(c *gin.Context) {
+	var req synonymRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	words := normalizeSynonymWords(req.Words)
+	if words == nil {
+		c.JSON(400, gin.H{"error": "A synonym group needs at least two different words"})
+		return
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	synonym := models.SearchSynonym{
+		BusinessID: user.BusinessID,
+		Words:      strings.Join(words, ", "),
+	}
+	if err := bh.db.Create(&synonym).Error; err != nil {
+		utils.ErrorLogger("Failed to create synonym: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create synonym"})
+		return
+	}
+
+	utils.InfoLogger("Created search synonym group %d", synonym.ID)
+	c.JSON(200, synonym)
+}
+
+func (bh *BusinessHandler) UpdateSearchSynonym(c *gin.Context) {
+	id := c.Param("id")
+
+	var req synonymRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	words := normalizeSynonymWords(req.Words)
+	if words == nil {
+		c.JSON(400, gin.H{"error": "A synonym group needs at least two different words"})
+		return
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	var synonym models.SearchSynonym
+	if err := bh.db.Where("id = ? AND business_id = ?", id, user.BusinessID).First(&synonym).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Synonym not found"})
+		return
+	}
+
+	synonym.Words = strings.Join(words, ", ")
+	if err := bh.db.Save(&synonym).Error; err != nil {
+		utils.ErrorLogger("Failed to update synonym %s: %v", id, err)
+		c.JSON(500, gin.H{"error": "Failed to update synonym"})
+		return
+	}
+
+	c.JSON(200, synonym)
+}
+
+func (bh *BusinessHandler) DeleteSearchSynonym(c *gin.Context) {
+	id := c.Param("id")
+	user, _ := middleware.CurrentUser(c)
+
+	result := bh.db.Where("id = ? AND business_id = ?", id, user.BusinessID).Delete(&models.SearchSynonym{})
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to delete synonym %s: %v", id, result.Error)
+		c.JSON(500, gin.H{"error": "Failed to delete synonym"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Synonym not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Synonym deleted successfully"})
+}
+
+// loadSynonyms builds the synonym lookup for a business
+func loadSynonyms(db *gorm.DB, businessID uint) (search.Synonyms, error) {
+	var rows []models.SearchSynonym
+	if err := db.Where("business_id = ?", businessID).Find(&rows).Error; err != nil {
+		return search.Synonyms{}, err
+	}
+	groups := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		groups = append(groups, search.ParseWords(row.Words))
+	}
+	return search.NewSynonyms(groups), nil
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/OAthooh/BiasharaTrack.git/models"
@@ -25,17 +26,20 @@ func (cm *CreditManager) GetCreditsHistory(c *gin.Context) {
 		return
 	}
 
-	customerCredits := make(map[uint]*models.CreditCustomer)
+	// Credit is grouped by customer; rows from before the customer
+	// directory that could not be linked stand on their own
+	customerCredits := make(map[string]*models.CreditCustomer)
 
 	for _, transaction := range transactions {
-		customerID := transaction.ID
+		customerID := "T" + strconv.FormatUint(uint64(transaction.ID), 10)
+		if transaction.CustomerID != nil {
+			customerID = strconv.FormatUint(uint64(*transaction.CustomerID), 10)
+		}
 		if _, exists := customerCredits[customerID]; !exists {
 			customerCredits[customerID] = &models.CreditCustomer{
-				ID:              string(rune(customerID)),
+				ID:              customerID,
 				Name:            transaction.Name,
 				Phone:           transaction.PhoneNumber,
-				TotalCredit:     transaction.CreditAmount,
-				BalanceDue:      transaction.CreditAmount,
 				LastPaymentDate: time.Time{},
 				Status:          "active",
 			}
@@ -0,0 +1,507 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/mpesa"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultCustomerPageSize = 50
+	maxCustomerPageSize     = 200
+)
+
+type CustomerHandler struct {
+	db *gorm.DB
+}
+
+func NewCustomerHandler(db *gorm.DB) *CustomerHandler {
+	return &CustomerHandler{db: db}
+}
+
+type CustomerRequest struct {
+	Name   string `json:"name" binding:"required"`
+	Phone  string `json:"phone" binding:"required"`
+	Email  string `json:"email"`
+	KRAPin string `json:"kra_pin"`
+	Notes  string `json:"notes"`
+}
+
+type MergeCustomersRequest struct {
+	IntoID      uint   `json:"into_id" binding:"required"`
+	CustomerIDs []uint `json:"customer_ids" binding:"required"`
+}
+
+// customerSummary is what a customer has bought and owes
+type customerSummary struct {
+	Sales          int64      `json:"sales"`
+	TotalSpent     float64    `json:"total_spent"`
+	BalanceDue     float64    `json:"balance_due"`
+	LastPurchaseAt *time.Time `json:"last_purchase_at,omitempty"`
+}
+
+// followMerges returns the customer that customer was merged into, if any
+func followMerges(tx *gorm.DB, customer *models.Customer) (*models.Customer, error) {
+	for customer.MergedIntoID != nil {
+		var into models.Customer
+		if err := tx.First(&into, *customer.MergedIntoID).Error; err != nil {
+			return nil, err
+		}
+		customer = &into
+	}
+	return customer, nil
+}
+
+// findCustomerByPhone looks up the customer with the given normalised phone
+// number, following merges. It returns nil when there is none.
+func findCustomerByPhone(tx *gorm.DB, businessID uint, phone string) (*models.Customer, error) {
+	var customer models.Customer
+	err := tx.Where("business_id = ? AND phone = ?", businessID, phone).First(&customer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return followMerges(tx, &customer)
+}
+
+// resolveCustomer finds the directory entry for a sale's buyer: the
+// customer picked at the till, or else the one with the phone number given,
+// who is added to the directory on their first purchase. A sale without a
+// phone number, or with one that is not a valid Kenyan number, has no
+// customer.
+func resolveCustomer(tx *gorm.DB, businessID uint, saleData SaleData) (*models.Customer, error) {
+	if saleData.CustomerID != nil {
+		var customer models.Customer
+		if err := tx.Where("business_id = ?", businessID).First(&customer, *saleData.CustomerID).Error; err != nil {
+			return nil, newSaleError(404, "Customer %d not found", *saleData.CustomerID)
+		}
+		return followMerges(tx, &customer)
+	}
+
+	raw := strings.TrimSpace(saleData.CustomerPhone)
+	if raw == "" {
+		return nil, nil
+	}
+	phone, err := mpesa.NormalizePhoneNumber(raw)
+	if err != nil {
+		// Foreign numbers and typos must not hold up the till; the sale
+		// keeps the number as typed and is left off the directory
+		utils.WarningLogger("Customer phone number %s is not a valid Kenyan number, not linking a customer", raw)
+		return nil, nil
+	}
+
+	customer, err := findCustomerByPhone(tx, businessID, phone)
+	if err != nil || customer != nil {
+		return customer, err
+	}
+
+	customer = &models.Customer{
+		BusinessID: businessID,
+		Name:       strings.TrimSpace(saleData.CustomerName),
+		Phone:      phone,
+		KRAPin:     strings.ToUpper(strings.TrimSpace(saleData.CustomerPIN)),
+	}
+	// Another till may add the same customer at the same moment
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(customer).Error; err != nil {
+		return nil, err
+	}
+	if customer.ID == 0 {
+		return findCustomerByPhone(tx, businessID, phone)
+	}
+	return customer, nil
+}
+
+// summarizeCustomer adds up a customer's purchases and what they still owe
+func summarizeCustomer(db *gorm.DB, customerID uint) (customerSummary, error) {
+	var summary customerSummary
+	var totals struct {
+		Sales          int64
+		TotalSpent     float64
+		BalanceDue     float64
+		LastPurchaseAt *time.Time
+	}
+	if err := db.Table("sales").
+		Select("COUNT(*) AS sales, COALESCE(SUM("+netSaleRevenue+"), 0) AS total_spent, "+
+			"COALESCE(SUM(sales.balance_due), 0) AS balance_due, MAX(sales.created_at) AS last_purchase_at").
+		Where("sales.customer_id = ? AND sales.status <> ?", customerID, models.SaleStatusVoided).
+		Scan(&totals).Error; err != nil {
+		return summary, err
+	}
+	summary.Sales = totals.Sales
+	summary.TotalSpent = roundMoney(totals.TotalSpent)
+	summary.BalanceDue = roundMoney(totals.BalanceDue)
+	summary.LastPurchaseAt = totals.LastPurchaseAt
+	return summary, nil
+}
+
+// customerFields checks and tidies a customer request
+func customerFields(req CustomerRequest) (models.Customer, error) {
+	customer := models.Customer{
+		Name:   strings.TrimSpace(req.Name),
+		Email:  strings.TrimSpace(req.Email),
+		KRAPin: strings.ToUpper(strings.TrimSpace(req.KRAPin)),
+		Notes:  strings.TrimSpace(req.Notes),
+	}
+	if customer.Name == "" {
+		return customer, errors.New("customer name is required")
+	}
+	phone, err := mpesa.NormalizePhoneNumber(req.Phone)
+	if err != nil {
+		return customer, errors.New("phone number is not a valid Kenyan number")
+	}
+	customer.Phone = phone
+	if customer.KRAPin != "" && !validKRAPin(customer.KRAPin) {
+		return customer, errors.New("KRA PIN is not valid")
+	}
+	return customer, nil
+}
+
+func (ch *CustomerHandler) CreateCustomer(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Name and phone number are required"})
+		return
+	}
+	customer, err := customerFields(req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	customer.BusinessID = user.BusinessID
+
+	existing, err := findCustomerByPhone(ch.db, user.BusinessID, customer.Phone)
+	if err != nil {
+		utils.ErrorLogger("Failed to look up customer: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create customer"})
+		return
+	}
+	if existing != nil {
+		c.JSON(409, gin.H{"error": "A customer with this phone number already exists", "customer_id": existing.ID})
+		return
+	}
+
+	if err := ch.db.Create(&customer).Error; err != nil {
+		utils.ErrorLogger("Failed to create customer: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create customer"})
+		return
+	}
+
+	utils.InfoLogger("User %d added customer %d", user.ID, customer.ID)
+	c.JSON(201, gin.H{"success": true, "data": customer})
+}
+
+// GetCustomers lists the business's customers by name, leaving out those
+// merged into others. search matches the name, phone number or email.
+func (ch *CustomerHandler) GetCustomers(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	limit := defaultCustomerPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(400, gin.H{"error": "Limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxCustomerPageSize)
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	query := ch.db.Where("business_id = ? AND merged_into_id IS NULL", user.BusinessID)
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		pattern := "%" + search + "%"
+		phonePattern := pattern
+		if phone, err := mpesa.NormalizePhoneNumber(search); err == nil {
+			phonePattern = phone
+		}
+		query = query.Where("name LIKE ? OR phone LIKE ? OR email LIKE ?", pattern, phonePattern, pattern)
+	}
+
+	var customers []models.Customer
+	if err := query.Order("name").Order("id").Limit(limit).Offset(max(offset, 0)).Find(&customers).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch customers: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch customers"})
+		return
+	}
+
+	c.JSON(200, customers)
+}
+
+// GetCustomer returns a customer with what they have bought and owe
+func (ch *CustomerHandler) GetCustomer(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var customer models.Customer
+	if err := ch.db.Where("business_id = ?", user.BusinessID).First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	summary, err := summarizeCustomer(ch.db, customer.ID)
+	if err != nil {
+		utils.ErrorLogger("Failed to summarize customer %d: %v", customer.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch customer"})
+		return
+	}
+
+	c.JSON(200, gin.H{"customer": customer, "summary": summary})
+}
+
+func (ch *CustomerHandler) UpdateCustomer(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Name and phone number are required"})
+		return
+	}
+	fields, err := customerFields(req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var customer models.Customer
+	if err := ch.db.Where("business_id = ?", user.BusinessID).First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+	if customer.MergedIntoID != nil {
+		c.JSON(409, gin.H{"error": "Customer was merged into another customer", "customer_id": *customer.MergedIntoID})
+		return
+	}
+	if fields.Phone != customer.Phone {
+		var taken int64
+		if err := ch.db.Model(&models.Customer{}).
+			Where("business_id = ? AND phone = ? AND id <> ?", user.BusinessID, fields.Phone, customer.ID).
+			Count(&taken).Error; err != nil {
+			utils.ErrorLogger("Failed to look up customer: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to update customer"})
+			return
+		}
+		if taken > 0 {
+			c.JSON(409, gin.H{"error": "Another customer has this phone number; merge them instead"})
+			return
+		}
+	}
+
+	customer.Name = fields.Name
+	customer.Phone = fields.Phone
+	customer.Email = fields.Email
+	customer.KRAPin = fields.KRAPin
+	customer.Notes = fields.Notes
+	if err := ch.db.Save(&customer).Error; err != nil {
+		utils.ErrorLogger("Failed to update customer %d: %v", customer.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to update customer"})
+		return
+	}
+
+	utils.InfoLogger("User %d updated customer %d", user.ID, customer.ID)
+	c.JSON(200, gin.H{"success": true, "data": customer})
+}
+
+// GetCustomerSales lists a customer's purchases, newest first
+func (ch *CustomerHandler) GetCustomerSales(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var customer models.Customer
+	if err := ch.db.Where("business_id = ?", user.BusinessID).First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	limit := defaultCustomerPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(400, gin.H{"error": "Limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxSalesPageSize)
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	var total int64
+	if err := ch.db.Model(&models.Sale{}).Where("customer_id = ?", customer.ID).Count(&total).Error; err != nil {
+		utils.ErrorLogger("Failed to count sales for customer %d: %v", customer.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch customer sales"})
+		return
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+
+	var sales []models.Sale
+	if err := ch.db.Preload("Items").Preload("Payments").
+		Where("customer_id = ?", customer.ID).
+		Order("created_at DESC").Order("id DESC").
+		Limit(limit).Offset(max(offset, 0)).
+		Find(&sales).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch sales for customer %d: %v", customer.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch customer sales"})
+		return
+	}
+
+	c.JSON(200, sales)
+}
+
+// GetCustomerDuplicates lists groups of customers that look like the same
+// person under different phone numbers: the same name, or the same email
+func (ch *CustomerHandler) GetCustomerDuplicates(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	type duplicateGroup struct {
+		Reason    string            `json:"reason"`
+		Match     string            `json:"match"`
+		Customers []models.Customer `json:"customers"`
+	}
+	groups := []duplicateGroup{}
+
+	for _, field := range []struct{ reason, expr string }{
+		{"name", "LOWER(TRIM(name))"},
+		{"email", "LOWER(TRIM(email))"},
+	} {
+		var matches []string
+		if err := ch.db.Model(&models.Customer{}).
+			Select(field.expr).
+			Where("business_id = ? AND merged_into_id IS NULL", user.BusinessID).
+			Where(field.expr + " <> ''").
+			Group(field.expr).
+			Having("COUNT(*) > 1").
+			Scan(&matches).Error; err != nil {
+			utils.ErrorLogger("Failed to find duplicate customers: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to find duplicate customers"})
+			return
+		}
+
+		for _, match := range matches {
+			var customers []models.Customer
+			if err := ch.db.Where("business_id = ? AND merged_into_id IS NULL", user.BusinessID).
+				Where(field.expr+" = ?", match).
+				Order("id").
+				Find(&customers).Error; err != nil {
+				utils.ErrorLogger("Failed to find duplicate customers: %v", err)
+				c.JSON(500, gin.H{"error": "Failed to find duplicate customers"})
+				return
+			}
+			groups = append(groups, duplicateGroup{Reason: field.reason, Match: match, Customers: customers})
+		}
+	}
+
+	c.JSON(200, groups)
+}
+
+// MergeCustomers folds duplicate customers into one. Their sales, credit and
+// loyalty points move to the customer kept, which also takes any details it is missing.
+// The merged customers stay on file pointing at it, so their phone numbers
+// still find it at the till.
+func (ch *CustomerHandler) MergeCustomers(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req MergeCustomersRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.CustomerIDs) == 0 {
+		c.JSON(400, gin.H{"error": "into_id and customer_ids are required"})
+		return
+	}
+
+	var into models.Customer
+	err := ch.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&into, req.IntoID).Error; err != nil {
+			return newSaleError(404, "Customer %d not found", req.IntoID)
+		}
+		if into.MergedIntoID != nil {
+			return newSaleError(409, "Customer %d was merged into customer %d", into.ID, *into.MergedIntoID)
+		}
+
+		var customers []models.Customer
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ? AND id IN ?", user.BusinessID, req.CustomerIDs).
+			Find(&customers).Error; err != nil {
+			return err
+		}
+		if len(customers) != len(uniqueIDs(req.CustomerIDs)) {
+			return newSaleError(404, "Some customers were not found")
+		}
+
+		now := time.Now()
+		ids := make([]uint, 0, len(customers))
+		for i := range customers {
+			customer := &customers[i]
+			if customer.ID == into.ID {
+				return newSaleError(400, "A customer cannot be merged into itself")
+			}
+			if customer.MergedIntoID != nil {
+				return newSaleError(409, "Customer %d was already merged", customer.ID)
+			}
+			if into.Email == "" {
+				into.Email = customer.Email
+			}
+			if into.KRAPin == "" {
+				into.KRAPin = customer.KRAPin
+			}
+			if customer.Notes != "" {
+				into.Notes = strings.TrimSpace(into.Notes + "\n" + customer.Notes)
+			}
+			into.LoyaltyPoints += customer.LoyaltyPoints
+			customer.LoyaltyPoints = 0
+			customer.MergedIntoID = &into.ID
+			customer.MergedAt = &now
+			ids = append(ids, customer.ID)
+		}
+
+		if err := tx.Model(&models.Sale{}).Where("customer_id IN ?", ids).
+			Update("customer_id", into.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.CreditTransaction{}).Where("customer_id IN ?", ids).
+			Update("customer_id", into.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.LoyaltyEntry{}).Where("customer_id IN ?", ids).
+			Update("customer_id", into.ID).Error; err != nil {
+			return err
+		}
+		// Customers merged earlier into the ones going now follow them
+		if err := tx.Model(&models.Customer{}).Where("merged_into_id IN ?", ids).
+			Update("merged_into_id", into.ID).Error; err != nil {
+			return err
+		}
+		for i := range customers {
+			if err := tx.Save(&customers[i]).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&into).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d merged customers %v into %d", user.ID, req.CustomerIDs, into.ID)
+	c.JSON(200, gin.H{"success": true, "data": into})
+}
+
+// uniqueIDs drops repeated IDs
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
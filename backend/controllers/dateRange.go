@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dateLayout = "2006-01-02"
+
+// parseDateRange reads the from and to query parameters (YYYY-MM-DD). The
+// returned range is half-open: it starts at midnight on from and ends at
+// midnight after to. Without parameters it covers the last defaultDays days.
+func parseDateRange(c *gin.Context, defaultDays int) (time.Time, time.Time, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	to := today.AddDate(0, 0, 1)
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation(dateLayout, v, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to date, expected YYYY-MM-DD")
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+
+	from := to.AddDate(0, 0, -defaultDays)
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation(dateLayout, v, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from date, expected YYYY-MM-DD")
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from date must not be after to date")
+	}
+	return from, to, nil
+}
+
+// periodKey labels t with the day, ISO week or month it falls in.
+func periodKey(t time.Time, period string) string {
+	switch period {
+	case "day":
+		return t.Format(dateLayout)
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return t.Format("2006-01")
+	}
+}
+
+// validPeriod reports whether period is a supported report grouping
+func validPeriod(period string) bool {
+	return period == "day" || period == "week" || period == "month"
+}
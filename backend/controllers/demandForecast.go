@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/forecast"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultForecastDays = 56
+	maxForecastDays     = 365
+	defaultReviewDays   = 7
+	defaultLeadTimeDays = 7
+)
+
+type productForecast struct {
+	ProductID    uint            `json:"product_id"`
+	ProductName  string          `json:"product_name"`
+	Category     string          `json:"category,omitempty"`
+	SupplierID   *uint           `json:"supplier_id,omitempty"`
+	SupplierName string          `json:"supplier_name,omitempty"`
+	LeadTimeDays int             `json:"lead_time_days"`
+	PackSize     int             `json:"pack_size"`
+	CurrentStock int             `json:"current_stock"`
+	Threshold    int             `json:"low_stock_threshold"`
+	Forecast     forecast.Result `json:"forecast"`
+}
+
+// buildForecasts loads sales and stock movement history for the lookback
+// window and forecasts every product, or just productID when it is non-zero.
+func (im *InventoryManagementHandler) buildForecasts(lookbackDays, reviewDays int, productID uint) ([]productForecast, error) {
+	var products []struct {
+		ID                uint
+		Name              string
+		Category          string
+		SupplierID        *uint
+		PackSize          int
+		CreatedAt         time.Time
+		Quantity          int
+		LowStockThreshold int
+		SupplierName      string
+		LeadTimeDays      *int
+	}
+
+	query := im.db.Table("products").
+		Select("products.id, products.name, products.category, products.supplier_id, products.pack_size, products.created_at, " +
+			"COALESCE(inventory.quantity, 0) as quantity, COALESCE(inventory.low_stock_threshold, 0) as low_stock_threshold, " +
+			"suppliers.name as supplier_name, suppliers.lead_time_days").
+		Joins("LEFT JOIN inventory ON inventory.product_id = products.id").
+		Joins("LEFT JOIN suppliers ON suppliers.id = products.supplier_id")
+	if productID != 0 {
+		query = query.Where("products.id = ?", productID)
+	}
+	if err := query.Scan(&products).Error; err != nil {
+		return nil, err
+	}
+
+	today := time.Now()
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	since := today.AddDate(0, 0, -(lookbackDays - 1))
+
+	sold, err := im.dailyTotals("sale_items", "quantity - returned_quantity", since, productID)
+	if err != nil {
+		return nil, err
+	}
+	moved, err := im.dailyTotals("stock_movements", "quantity_change", since, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts := make([]productForecast, 0, len(products))
+	for _, p := range products {
+		leadTime := defaultLeadTimeDays
+		if p.LeadTimeDays != nil {
+			leadTime = *p.LeadTimeDays
+		}
+
+		// Walk back from today's stock level through each day's net movement
+		// to find the days on which the product could actually be sold.
+		history := make([]forecast.Day, 0, lookbackDays)
+		closing := p.Quantity
+		for d := 0; d < lookbackDays; d++ {
+			day := today.AddDate(0, 0, -d)
+			if day.Before(time.Date(p.CreatedAt.Year(), p.CreatedAt.Month(), p.CreatedAt.Day(), 0, 0, 0, 0, today.Location())) {
+				break
+			}
+			key := day.Format("2006-01-02")
+			daySold := sold[p.ID][key]
+			opening := closing - moved[p.ID][key]
+			history = append(history, forecast.Day{
+				Date:    day,
+				Sold:    daySold,
+				InStock: opening > 0 || daySold > 0,
+			})
+			closing = opening
+		}
+
+		forecasts = append(forecasts, productForecast{
+			ProductID:    p.ID,
+			ProductName:  p.Name,
+			Category:     p.Category,
+			SupplierID:   p.SupplierID,
+			SupplierName: p.SupplierName,
+			LeadTimeDays: leadTime,
+			PackSize:     p.PackSize,
+			CurrentStock: p.Quantity,
+			Threshold:    p.LowStockThreshold,
+			Forecast: forecast.Compute(history, forecast.Params{
+				CurrentStock: p.Quantity,
+				LeadTimeDays: leadTime,
+				ReviewDays:   reviewDays,
+				PackSize:     p.PackSize,
+			}, today),
+		})
+	}
+
+	return forecasts, nil
+}
+
+// dailyTotals sums a quantity column per product per calendar day.
+func (im *InventoryManagementHandler) dailyTotals(table, column string, since time.Time, productID uint) (map[uint]map[string]int, error) {
+	var rows []struct {
+		ProductID uint
+		Day       string
+		Total     int
+	}
+
+	query := im.db.Table(table).
+		Select("product_id, DATE(created_at) as day, SUM("+column+") as total").
+		Where("created_at >= ?", since).
+		Group("product_id, DATE(created_at)")
+	if productID != 0 {
+		query = query.Where("product_id = ?", productID)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uint]map[string]int)
+	for _, row := range rows {
+		if len(row.Day) < 10 {
+			continue
+		}
+		if totals[row.ProductID] == nil {
+			totals[row.ProductID] = make(map[string]int)
+		}
+		totals[row.ProductID][row.Day[:10]] += row.Total
+	}
+	return totals, nil
+}
+
+// forecastParams reads the lookback window and review period from the query.
+func forecastParams(c *gin.Context) (int, int) {
+	days := defaultForecastDays
+	if v, err := strconv.Atoi(c.Query("days")); err == nil && v > 0 {
+		days = v
+	}
+	if days > maxForecastDays {
+		days = maxForecastDays
+	}
+	reviewDays := defaultReviewDays
+	if v, err := strconv.Atoi(c.Query("review_days")); err == nil && v >= 0 {
+		reviewDays = v
+	}
+	return days, reviewDays
+}
+
+func (im *InventoryManagementHandler) GetDemandForecast(c *gin.Context) {
+	days, reviewDays := forecastParams(c)
+
+	var productID uint
+	if id := c.Query("product_id"); id != "" {
+		v, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid product id"})
+			return
+		}
+		productID = uint(v)
+	}
+
+	forecasts, err := im.buildForecasts(days, reviewDays, productID)
+	if err != nil {
+		utils.ErrorLogger("Failed to build demand forecast: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build demand forecast"})
+		return
+	}
+
+	utils.InfoLogger("Successfully built demand forecast for %d products", len(forecasts))
+	c.JSON(200, forecasts)
+}
+
+// GetReorderSuggestions returns a draft purchase list of every product at or
+// below its suggested reorder point, grouped by supplier.
+func (im *InventoryManagementHandler) GetReorderSuggestions(c *gin.Context) {
+	days, reviewDays := forecastParams(c)
+
+	forecasts, err := im.buildForecasts(days, reviewDays, 0)
+	if err != nil {
+		utils.ErrorLogger("Failed to build reorder suggestions: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build reorder suggestions"})
+		return
+	}
+
+	type purchaseLine struct {
+		ProductID    uint    `json:"product_id"`
+		ProductName  string  `json:"product_name"`
+		CurrentStock int     `json:"current_stock"`
+		ReorderPoint int     `json:"reorder_point"`
+		PackSize     int     `json:"pack_size"`
+		OrderQty     int     `json:"order_qty"`
+		Packs        int     `json:"packs"`
+		DaysOfCover  *int    `json:"days_of_cover"`
+		Velocity     float64 `json:"daily_velocity"`
+	}
+	type supplierDraft struct {
+		SupplierID   *uint          `json:"supplier_id"`
+		SupplierName string         `json:"supplier_name"`
+		LeadTimeDays int            `json:"lead_time_days"`
+		Lines        []purchaseLine `json:"lines"`
+	}
+
+	drafts := make(map[uint]*supplierDraft)
+	for _, f := range forecasts {
+		if !f.Forecast.NeedsReorder {
+			continue
+		}
+
+		var key uint
+		if f.SupplierID != nil {
+			key = *f.SupplierID
+		}
+		draft, ok := drafts[key]
+		if !ok {
+			draft = &supplierDraft{
+				SupplierID:   f.SupplierID,
+				SupplierName: f.SupplierName,
+				LeadTimeDays: f.LeadTimeDays,
+			}
+			if f.SupplierID == nil {
+				draft.SupplierName = "Unassigned"
+			}
+			drafts[key] = draft
+		}
+
+		packSize := f.PackSize
+		if packSize < 1 {
+			packSize = 1
+		}
+		draft.Lines = append(draft.Lines, purchaseLine{
+			ProductID:    f.ProductID,
+			ProductName:  f.ProductName,
+			CurrentStock: f.CurrentStock,
+			ReorderPoint: f.Forecast.ReorderPoint,
+			PackSize:     packSize,
+			OrderQty:     f.Forecast.SuggestedOrderQty,
+			Packs:        f.Forecast.SuggestedOrderQty / packSize,
+			DaysOfCover:  f.Forecast.DaysOfCover,
+			Velocity:     f.Forecast.DailyVelocity,
+		})
+	}
+
+	result := make([]*supplierDraft, 0, len(drafts))
+	for _, draft := range drafts {
+		result = append(result, draft)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].SupplierName < result[j].SupplierName
+	})
+
+	c.JSON(200, gin.H{
+		"generated_at":  time.Now(),
+		"lookback_days": days,
+		"review_days":   reviewDays,
+		"suppliers":     result,
+	})
+}
+
+// ApplySuggestedReorderPoint replaces a product's fixed low stock threshold
+// with the reorder point suggested by its forecast.
+func (im *InventoryManagementHandler) ApplySuggestedReorderPoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product id"})
+		return
+	}
+	days, reviewDays := forecastParams(c)
+
+	forecasts, err := im.buildForecasts(days, reviewDays, uint(id))
+	if err != nil {
+		utils.ErrorLogger("Failed to build demand forecast: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build demand forecast"})
+		return
+	}
+	if len(forecasts) == 0 {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	f := forecasts[0]
+
+	var inventory models.Inventory
+	if err := im.db.Where("product_id = ?", f.ProductID).First(&inventory).Error; err != nil {
+		utils.WarningLogger("Inventory not found for product %d: %v", f.ProductID, err)
+		c.JSON(404, gin.H{"error": "Product not found in inventory"})
+		return
+	}
+
+	inventory.LowStockThreshold = f.Forecast.ReorderPoint
+	inventory.LastUpdated = time.Now()
+	if err := im.db.Save(&inventory).Error; err != nil {
+		utils.ErrorLogger("Failed to update threshold for product %d: %v", f.ProductID, err)
+		c.JSON(500, gin.H{"error": "Failed to update threshold"})
+		return
+	}
+
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+	}
+	ctx, outbox := events.WithOutbox(context.Background(), businessID)
+	if err := syncLowStockAlert(im.db.WithContext(ctx), f.ProductName, inventory); err != nil {
+		utils.ErrorLogger("Failed to update low stock alert: %v", err)
+	}
+	outbox.Flush()
+
+	utils.InfoLogger("Set low stock threshold for product %d to %d", f.ProductID, inventory.LowStockThreshold)
+	c.JSON(200, gin.H{
+		"product_id":          f.ProductID,
+		"low_stock_threshold": inventory.LowStockThreshold,
+		"forecast":            f.Forecast,
+	})
+}
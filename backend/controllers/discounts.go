@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// DiscountRequest is a discount on one line or on the whole basket. A
+// PERCENT discount takes Value percent off; a FIXED one takes Value off the
+// line or basket as a whole.
+type DiscountRequest struct {
+	Type   string  `json:"type"`
+	Value  float64 `json:"value"`
+	Reason string  `json:"reason"`
+}
+
+// DiscountApproval carries a manager's credentials, entered at the till to
+// allow a discount larger than the cashier may give.
+type DiscountApproval struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// discountAmount works out how much d takes off amount
+func discountAmount(d *DiscountRequest, amount float64) (float64, error) {
+	if d.Value < 0 {
+		return 0, newSaleError(400, "Discount must not be negative")
+	}
+	if !models.ValidDiscountReason(d.Reason) {
+		return 0, newSaleError(400, "Discount reason must be one of LOYAL_CUSTOMER, BULK_PURCHASE, DAMAGED_PACKAGING, PRICE_MATCH, STAFF or OTHER")
+	}
+
+	var discount float64
+	switch d.Type {
+	case models.DiscountPercent:
+		if d.Value > 100 {
+			return 0, newSaleError(400, "Percentage discount must not exceed 100")
+		}
+		discount = amount * d.Value / 100
+	case models.DiscountFixed:
+		discount = d.Value
+	default:
+		return 0, newSaleError(400, "Discount type must be PERCENT or FIXED")
+	}
+
+	discount = roundMoney(discount)
+	if discount > amount {
+		return 0, newSaleError(400, "Discount of %.2f is more than the amount of %.2f", discount, amount)
+	}
+	return discount, nil
+}
+
+// applyLineDiscount takes the requested discount off a priced sale item
+func applyLineDiscount(item *models.SaleItem, d *DiscountRequest) error {
+	if d == nil {
+		return nil
+	}
+	d.Type = strings.ToUpper(d.Type)
+	d.Reason = strings.ToUpper(d.Reason)
+
+	discount, err := discountAmount(d, item.GrossAmount)
+	if err != nil {
+		return err
+	}
+	item.Discount = discount
+	item.DiscountType = d.Type
+	item.DiscountValue = d.Value
+	item.DiscountReason = d.Reason
+	item.LineTotal = roundMoney(item.GrossAmount - item.Discount)
+	return nil
+}
+
+// applyBasketDiscount takes the requested discount off the whole sale and
+// spreads it over the items in proportion to their value, so per-product
+// reports see the discount too.
+func applyBasketDiscount(sale *models.Sale, items []models.SaleItem, d *DiscountRequest) error {
+	if d == nil {
+		return nil
+	}
+	d.Type = strings.ToUpper(d.Type)
+	d.Reason = strings.ToUpper(d.Reason)
+
+	var base float64
+	for _, item := range items {
+		base += item.LineTotal
+	}
+	base = roundMoney(base)
+
+	discount, err := discountAmount(d, base)
+	if err != nil {
+		return err
+	}
+	sale.BasketDiscount = discount
+	sale.BasketDiscountType = d.Type
+	sale.BasketDiscountValue = d.Value
+	sale.BasketDiscountReason = d.Reason
+	if discount == 0 || base == 0 {
+		return nil
+	}
+
+	remaining := discount
+	for i := range items {
+		share := roundMoney(discount * items[i].LineTotal / base)
+		if i == len(items)-1 || share > remaining {
+			share = remaining
+		}
+		items[i].BasketDiscount = share
+		items[i].LineTotal = roundMoney(items[i].LineTotal - share)
+		remaining = roundMoney(remaining - share)
+	}
+	return nil
+}
+
+// largestDiscountPercent is the deepest discount on the sale, as a percentage
+// of the gross amount it was taken from. A line's share of the basket
+// discount counts on top of its own discount, so stacking the two cannot
+// slip past a limit that each would pass alone. A price overridden below
+// the list price counts as a discount from the list price too, so an
+// override cannot get round the limit either.
+func largestDiscountPercent(sale *models.Sale, items []models.SaleItem) float64 {
+	var largest, overrides float64
+	for _, item := range items {
+		override := overrideDiscount(item)
+		overrides += override
+		if gross := item.GrossAmount + override; gross > 0 {
+			largest = math.Max(largest, (item.Discount+item.BasketDiscount+override)/gross*100)
+		}
+	}
+	if gross := sale.Subtotal + overrides; gross > 0 {
+		largest = math.Max(largest, (sale.DiscountTotal+overrides)/gross*100)
+	}
+	return largest
+}
+
+// overrideDiscount is how much an overridden price takes off the line at
+// list price. Overrides above the list price take nothing off.
+func overrideDiscount(item models.SaleItem) float64 {
+	if !item.PriceOverridden || item.UnitPrice >= item.ListPrice {
+		return 0
+	}
+	return roundMoney((item.ListPrice - item.UnitPrice) * float64(item.Quantity))
+}
+
+// discountLimit is the largest discount percentage the role may give
+func discountLimit(tx *gorm.DB, businessID uint, role string) (float64, error) {
+	var limit models.DiscountLimit
+	err := tx.Where("business_id = ? AND role = ?", businessID, role).Limit(1).Find(&limit).Error
+	if err != nil {
+		return 0, err
+	}
+	if limit.ID != 0 {
+		return limit.MaxPercent, nil
+	}
+	return models.DefaultDiscountLimits[role], nil
+}
+
+// Approval failures are counted per approver email. Once an email has
+// failed approvalMaxFailures times within approvalFailureWindow, further
+// approvals with it are refused until the window has passed, so staff
+// passwords cannot be guessed at the till.
+const (
+	approvalMaxFailures   = 5
+	approvalFailureWindow = 15 * time.Minute
+)
+
+var approvalFailures = struct {
+	sync.Mutex
+	byEmail map[string][]time.Time
+}{byEmail: make(map[string][]time.Time)}
+
+// recentApprovalFailures drops failures older than the window and returns
+// how many are left for the email
+func recentApprovalFailures(email string, now time.Time) int {
+	approvalFailures.Lock()
+	defer approvalFailures.Unlock()
+	kept := approvalFailures.byEmail[email][:0]
+	for _, at := range approvalFailures.byEmail[email] {
+		if now.Sub(at) < approvalFailureWindow {
+			kept = append(kept, at)
+		}
+	}
+	if len(kept) == 0 {
+		delete(approvalFailures.byEmail, email)
+	} else {
+		approvalFailures.byEmail[email] = kept
+	}
+	return len(kept)
+}
+
+func recordApprovalFailure(email string, now time.Time) {
+	approvalFailures.Lock()
+	defer approvalFailures.Unlock()
+	approvalFailures.byEmail[email] = append(approvalFailures.byEmail[email], now)
+}
+
+// dummyPasswordHash is checked against when the approver does not exist,
+// so an unknown email takes as long to refuse as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkDiscountLimits makes sure the cashier may give the sale's discounts,
+// or that a manager has approved them. Only signed-in cashiers may give
+// discounts. Every failed approval gets the same error, so the response
+// does not tell whether the email, the password or the approver's role was
+// wrong.
+func checkDiscountLimits(tx *gorm.DB, sale *models.Sale, items []models.SaleItem, cashier *models.User, approval *DiscountApproval) error {
+	percent := largestDiscountPercent(sale, items)
+	if percent <= 0 {
+		return nil
+	}
+	if cashier == nil {
+		return newSaleError(401, "Sign in to give discounts")
+	}
+
+	limit, err := discountLimit(tx, sale.BusinessID, cashier.Role)
+	if err != nil {
+		return err
+	}
+	if percent <= limit+0.005 {
+		return nil
+	}
+
+	if approval == nil || approval.Email == "" {
+		return newSaleError(403, "A discount of %.1f%% needs a manager's approval", percent)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(approval.Email))
+	now := time.Now()
+	if recentApprovalFailures(email, now) >= approvalMaxFailures {
+		utils.WarningLogger("Discount approval by %s refused after repeated failures", email)
+		return newSaleError(429, "Too many failed approvals, please try again later")
+	}
+	refused := func() error {
+		recordApprovalFailure(email, now)
+		utils.WarningLogger("Failed discount approval by %s for cashier %d", email, cashier.ID)
+		return newSaleError(403, "Discount approval failed")
+	}
+
+	var approver models.User
+	if err := tx.Where("email = ? AND business_id = ?", email, sale.BusinessID).Limit(1).Find(&approver).Error; err != nil {
+		return err
+	}
+	if approver.ID == 0 {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(approval.Password))
+		return refused()
+	}
+	if bcrypt.CompareHashAndPassword([]byte(approver.Password), []byte(approval.Password)) != nil ||
+		!approver.HasRole(models.RoleManager) {
+		return refused()
+	}
+	approverLimit, err := discountLimit(tx, sale.BusinessID, approver.Role)
+	if err != nil {
+		return err
+	}
+	if percent > approverLimit+0.005 {
+		return refused()
+	}
+
+	utils.InfoLogger("Discount of %.1f%% approved by %s", percent, approver.Email)
+	sale.DiscountApprovedByID = &approver.ID
+	return nil
+}
+
+// GetDiscountLimits lists the largest discount each role may give
+func (im *SalesManagementHandler) GetDiscountLimits(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	limits := make(gin.H, len(models.DefaultDiscountLimits))
+	for _, role := range []string{models.RoleCashier, models.RoleSupervisor, models.RoleManager, models.RoleOwner} {
+		limit, err := discountLimit(im.db, user.BusinessID, role)
+		if err != nil {
+			utils.ErrorLogger("Failed to fetch discount limits: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to fetch discount limits"})
+			return
+		}
+		limits[role] = limit
+	}
+
+	c.JSON(200, limits)
+}
+
+// UpdateDiscountLimit sets the largest discount a role may give
+func (im *SalesManagementHandler) UpdateDiscountLimit(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req struct {
+		Role       string   `json:"role" binding:"required"`
+		MaxPercent *float64 `json:"max_percent" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Role and max_percent are required"})
+		return
+	}
+	role := strings.ToUpper(req.Role)
+	if !models.ValidRole(role) {
+		c.JSON(400, gin.H{"error": "Invalid role"})
+		return
+	}
+	if *req.MaxPercent < 0 || *req.MaxPercent > 100 {
+		c.JSON(400, gin.H{"error": "max_percent must be between 0 and 100"})
+		return
+	}
+
+	var limit models.DiscountLimit
+	if err := im.db.Where("business_id = ? AND role = ?", user.BusinessID, role).
+		FirstOrInit(&limit, models.DiscountLimit{BusinessID: user.BusinessID, Role: role}).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch discount limit: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to update discount limit"})
+		return
+	}
+	limit.MaxPercent = *req.MaxPercent
+	if err := im.db.Save(&limit).Error; err != nil {
+		utils.ErrorLogger("Failed to save discount limit: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to update discount limit"})
+		return
+	}
+
+	utils.InfoLogger("Set %s discount limit to %.1f%%", role, limit.MaxPercent)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    limit,
+	})
+}
+
+// GetDiscountReport totals discounts given over a date range by reason and
+// by cashier, next to the gross sales they were taken from. Voided sales are
+// left out.
+func (im *SalesManagementHandler) GetDiscountReport(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	from, to, err := parseDateRange(c, 30)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	sales := im.db.Table("sales").
+		Where("sales.business_id = ? AND sales.status <> ?", user.BusinessID, models.SaleStatusVoided).
+		Where("sales.created_at >= ? AND sales.created_at < ?", from, to)
+
+	var totals struct {
+		Gross          float64
+		LineDiscount   float64
+		BasketDiscount float64
+		Net            float64
+		Sales          int64
+		Discounted     int64
+	}
+	if err := sales.Session(&gorm.Session{}).
+		Select(`COALESCE(SUM(subtotal), 0) as gross,
+			COALESCE(SUM(discount_total - basket_discount), 0) as line_discount,
+			COALESCE(SUM(basket_discount), 0) as basket_discount,
+			COALESCE(SUM(total_amount), 0) as net,
+			COUNT(*) as sales,
+			COALESCE(SUM(CASE WHEN discount_total > 0 THEN 1 ELSE 0 END), 0) as discounted`).
+		Scan(&totals).Error; err != nil {
+		utils.ErrorLogger("Failed to build discount report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build discount report"})
+		return
+	}
+
+	type reasonRow struct {
+		Reason string  `json:"reason"`
+		Count  int64   `json:"count"`
+		Amount float64 `json:"amount"`
+	}
+	var lineReasons, basketReasons []reasonRow
+	if err := sales.Session(&gorm.Session{}).
+		Joins("JOIN sale_items ON sale_items.sale_id = sales.id").
+		Where("sale_items.discount > 0").
+		Select("sale_items.discount_reason as reason, COUNT(*) as count, SUM(sale_items.discount) as amount").
+		Group("sale_items.discount_reason").
+		Scan(&lineReasons).Error; err != nil {
+		utils.ErrorLogger("Failed to build discount report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build discount report"})
+		return
+	}
+	if err := sales.Session(&gorm.Session{}).
+		Where("sales.basket_discount > 0").
+		Select("sales.basket_discount_reason as reason, COUNT(*) as count, SUM(sales.basket_discount) as amount").
+		Group("sales.basket_discount_reason").
+		Scan(&basketReasons).Error; err != nil {
+		utils.ErrorLogger("Failed to build discount report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build discount report"})
+		return
+	}
+
+	byReason := make(map[string]*reasonRow)
+	reasons := make([]*reasonRow, 0)
+	for _, row := range append(lineReasons, basketReasons...) {
+		r, ok := byReason[row.Reason]
+		if !ok {
+			r = &reasonRow{Reason: row.Reason}
+			byReason[row.Reason] = r
+			reasons = append(reasons, r)
+		}
+		r.Count += row.Count
+		r.Amount = roundMoney(r.Amount + row.Amount)
+	}
+
+	var cashiers []struct {
+		CashierID   *uint   `json:"cashier_id"`
+		CashierName string  `json:"cashier_name"`
+		Sales       int64   `json:"sales"`
+		Gross       float64 `json:"gross"`
+		Discount    float64 `json:"discount"`
+		Approved    int64   `json:"approved"`
+	}
+	if err := sales.Session(&gorm.Session{}).
+		Joins("LEFT JOIN users ON users.id = sales.cashier_id").
+		Where("sales.discount_total > 0").
+		Select(`sales.cashier_id, COALESCE(users.full_name, '') as cashier_name, COUNT(*) as sales,
+			SUM(sales.subtotal) as gross, SUM(sales.discount_total) as discount,
+			SUM(CASE WHEN sales.discount_approved_by_id IS NULL THEN 0 ELSE 1 END) as approved`).
+		Group("sales.cashier_id, users.full_name").
+		Order("discount DESC").
+		Scan(&cashiers).Error; err != nil {
+		utils.ErrorLogger("Failed to build discount report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build discount report"})
+		return
+	}
+
+	discountTotal := roundMoney(totals.LineDiscount + totals.BasketDiscount)
+	discountRate := 0.0
+	if totals.Gross > 0 {
+		discountRate = math.Round(discountTotal/totals.Gross*10000) / 100
+	}
+
+	c.JSON(200, gin.H{
+		"from":            from.Format(dateLayout),
+		"to":              to.AddDate(0, 0, -1).Format(dateLayout),
+		"gross_sales":     roundMoney(totals.Gross),
+		"line_discounts":  roundMoney(totals.LineDiscount),
+		"basket_discount": roundMoney(totals.BasketDiscount),
+		"discount_total":  discountTotal,
+		"net_sales":       roundMoney(totals.Net),
+		"discount_rate":   discountRate,
+		"sales":           totals.Sales,
+		"discounted":      totals.Discounted,
+		"by_reason":       reasons,
+		"by_cashier":      cashiers,
+	})
+}
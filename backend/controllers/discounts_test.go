@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestDiscountAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		discount   DiscountRequest
+		amount     float64
+		want       float64
+		wantStatus int
+	}{
+		{"percent", DiscountRequest{Type: models.DiscountPercent, Value: 10, Reason: models.DiscountLoyalCustomer}, 250, 25, 0},
+		{"percent rounds to the cent", DiscountRequest{Type: models.DiscountPercent, Value: 15, Reason: models.DiscountOther}, 33.33, 5, 0},
+		{"fixed", DiscountRequest{Type: models.DiscountFixed, Value: 40, Reason: models.DiscountPriceMatch}, 250, 40, 0},
+		{"whole amount", DiscountRequest{Type: models.DiscountPercent, Value: 100, Reason: models.DiscountStaff}, 80, 80, 0},
+		{"negative", DiscountRequest{Type: models.DiscountFixed, Value: -5, Reason: models.DiscountOther}, 250, 0, 400},
+		{"over 100 percent", DiscountRequest{Type: models.DiscountPercent, Value: 101, Reason: models.DiscountOther}, 250, 0, 400},
+		{"more than the amount", DiscountRequest{Type: models.DiscountFixed, Value: 300, Reason: models.DiscountOther}, 250, 0, 400},
+		{"unknown type", DiscountRequest{Type: "BOGOF", Value: 5, Reason: models.DiscountOther}, 250, 0, 400},
+		{"unknown reason", DiscountRequest{Type: models.DiscountFixed, Value: 5, Reason: "FRIEND"}, 250, 0, 400},
+	}
+	for _, tt := range tests {
+		got, err := discountAmount(&tt.discount, tt.amount)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: discount %.2f, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyBasketDiscount(t *testing.T) {
+	items := []models.SaleItem{{LineTotal: 10}, {LineTotal: 10}, {LineTotal: 10}}
+	sale := models.Sale{}
+	discount := DiscountRequest{Type: "fixed", Value: 10, Reason: "bulk_purchase"}
+	if err := applyBasketDiscount(&sale, items, &discount); err != nil {
+		t.Fatalf("applyBasketDiscount: %v", err)
+	}
+
+	if sale.BasketDiscount != 10 || sale.BasketDiscountType != models.DiscountFixed || sale.BasketDiscountReason != models.DiscountBulkPurchase {
+		t.Errorf("sale discount = %.2f %s %s, want 10.00 FIXED BULK_PURCHASE", sale.BasketDiscount, sale.BasketDiscountType, sale.BasketDiscountReason)
+	}
+	// The last line takes the rounding so the shares add up exactly
+	var shares, total float64
+	for i, want := range []float64{3.33, 3.33, 3.34} {
+		if items[i].BasketDiscount != want {
+			t.Errorf("item %d share = %.2f, want %.2f", i, items[i].BasketDiscount, want)
+		}
+		shares += items[i].BasketDiscount
+		total += items[i].LineTotal
+	}
+	if roundMoney(shares) != 10 || roundMoney(total) != 20 {
+		t.Errorf("shares add up to %.2f leaving %.2f, want 10.00 leaving 20.00", shares, total)
+	}
+}
+
+func TestLargestDiscountPercent(t *testing.T) {
+	tests := []struct {
+		name  string
+		sale  models.Sale
+		items []models.SaleItem
+		want  float64
+	}{
+		{
+			name:  "no discount",
+			sale:  models.Sale{Subtotal: 100},
+			items: []models.SaleItem{{Quantity: 1, ListPrice: 100, UnitPrice: 100, GrossAmount: 100}},
+			want:  0,
+		},
+		{
+			name: "deepest line",
+			sale: models.Sale{Subtotal: 300, DiscountTotal: 30},
+			items: []models.SaleItem{
+				{Quantity: 1, ListPrice: 100, UnitPrice: 100, GrossAmount: 100, Discount: 25},
+				{Quantity: 2, ListPrice: 100, UnitPrice: 100, GrossAmount: 200, Discount: 5},
+			},
+			want: 25,
+		},
+		{
+			name:  "line and basket discounts stack",
+			sale:  models.Sale{Subtotal: 100, DiscountTotal: 10},
+			items: []models.SaleItem{{Quantity: 1, ListPrice: 100, UnitPrice: 100, GrossAmount: 100, Discount: 5, BasketDiscount: 5}},
+			want:  10,
+		},
+		{
+			name:  "price cut by override",
+			sale:  models.Sale{Subtotal: 20},
+			items: []models.SaleItem{{Quantity: 2, ListPrice: 100, UnitPrice: 10, GrossAmount: 20, PriceOverridden: true}},
+			want:  90,
+		},
+		{
+			name:  "override and discount",
+			sale:  models.Sale{Subtotal: 80, DiscountTotal: 8},
+			items: []models.SaleItem{{Quantity: 1, ListPrice: 100, UnitPrice: 80, GrossAmount: 80, Discount: 8, PriceOverridden: true}},
+			want:  28,
+		},
+		{
+			name:  "price raised by override",
+			sale:  models.Sale{Subtotal: 120},
+			items: []models.SaleItem{{Quantity: 1, ListPrice: 100, UnitPrice: 120, GrossAmount: 120, PriceOverridden: true}},
+			want:  0,
+		},
+		{
+			name:  "promotion is not a discount",
+			sale:  models.Sale{Subtotal: 50},
+			items: []models.SaleItem{{Quantity: 1, ListPrice: 100, UnitPrice: 50, GrossAmount: 50}},
+			want:  0,
+		},
+	}
+	for _, tt := range tests {
+		if got := largestDiscountPercent(&tt.sale, tt.items); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: %.2f%%, want %.2f%%", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDiscountLimits(t *testing.T) {
+	db := dryRunDB(t)
+	cashier := &models.User{ID: 1, Role: models.RoleCashier}
+	supervisor := &models.User{ID: 2, Role: models.RoleSupervisor}
+
+	// Each case sells one item listed at 100 for price, less discount
+	tests := []struct {
+		name       string
+		price      float64
+		discount   float64
+		cashier    *models.User
+		approval   *DiscountApproval
+		wantStatus int
+	}{
+		{"no discount needs no sign-in", 100, 0, nil, nil, 0},
+		{"signed out", 100, 5, nil, nil, 401},
+		{"within the cashier limit", 100, 5, cashier, nil, 0},
+		{"over the cashier limit", 100, 10, cashier, nil, 403},
+		{"within the supervisor limit", 100, 10, supervisor, nil, 0},
+		{"override within the supervisor limit", 90, 0, supervisor, nil, 0},
+		{"override over the supervisor limit", 10, 0, supervisor, nil, 403},
+		{"override and discount over the supervisor limit", 90, 9, supervisor, nil, 403},
+		{"unknown approver", 100, 10, cashier, &DiscountApproval{Email: "nobody@example.com", Password: "secret"}, 403},
+	}
+	for _, tt := range tests {
+		sale := models.Sale{Subtotal: tt.price, DiscountTotal: tt.discount}
+		items := []models.SaleItem{{
+			Quantity:        1,
+			ListPrice:       100,
+			UnitPrice:       tt.price,
+			GrossAmount:     tt.price,
+			Discount:        tt.discount,
+			PriceOverridden: tt.price != 100,
+		}}
+		err := checkDiscountLimits(db, &sale, items, tt.cashier, tt.approval)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+		}
+	}
+}
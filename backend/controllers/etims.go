@@ -0,0 +1,524 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/etims"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// kraPinPattern matches a KRA PIN: A for individuals or P for companies, nine
+// digits and a check letter
+var kraPinPattern = regexp.MustCompile(`^[AP][0-9]{9}[A-Z]$`)
+
+func validKRAPin(pin string) bool {
+	return kraPinPattern.MatchString(pin)
+}
+
+// etimsTaxTypes are the tax type letters eTIMS accepts
+const etimsTaxTypes = "ABCDE"
+
+// etimsPaymentTypes maps the till's payment methods to eTIMS payment codes
+var etimsPaymentTypes = map[string]string{
+	"CASH":        etims.PaymentCash,
+	"MPESA":       etims.PaymentMobileMoney,
+	"CREDIT":      etims.PaymentCredit,
+	"POINTS":      etims.PaymentOther,
+	"CREDIT_NOTE": etims.PaymentOther,
+}
+
+// etimsPaymentType reports a split sale under the tender that paid most of it
+func etimsPaymentType(sale *models.Sale) string {
+	method, largest := sale.PaymentMethod, 0.0
+	for _, payment := range saleTenders(sale) {
+		if payment.Amount > largest {
+			method, largest = payment.Method, payment.Amount
+		}
+	}
+	return etimsPaymentTypes[method]
+}
+
+// queueEtimsInvoice adds a sale to the eTIMS queue if its business reports
+// sales to KRA. An invoice that cannot be built, for example because a
+// product has no item classification code, is queued as failed so the sale
+// still goes through and the problem shows up in the queue.
+func queueEtimsInvoice(tx *gorm.DB, sale *models.Sale) error {
+	var business models.Business
+	if err := tx.First(&business, sale.BusinessID).Error; err != nil {
+		return err
+	}
+	if !business.EtimsEnabled || !business.VATRegistered {
+		return nil
+	}
+
+	number, err := nextEtimsInvoiceNumber(tx, sale.BusinessID, business.EtimsBranchID)
+	if err != nil {
+		return err
+	}
+	invoice := models.EtimsInvoice{
+		SaleID:        sale.ID,
+		BusinessID:    sale.BusinessID,
+		BranchID:      business.EtimsBranchID,
+		InvoiceNumber: number,
+		Status:        models.EtimsStatusPending,
+	}
+	if err := setEtimsPayload(tx, &invoice, business, sale); err != nil {
+		invoice.Status = models.EtimsStatusFailed
+		invoice.LastError = err.Error()
+		utils.WarningLogger("eTIMS invoice for sale %s cannot be sent: %v", sale.SaleNumber, err)
+	} else {
+		now := time.Now()
+		invoice.NextAttemptAt = &now
+	}
+	return tx.Create(&invoice).Error
+}
+
+// nextEtimsInvoiceNumber takes the branch's next invoice number. The counter
+// stays locked until the transaction ends, so sales rung up at the same time
+// get consecutive numbers, and a sale that is rolled back gives its number
+// back.
+func nextEtimsInvoiceNumber(tx *gorm.DB, businessID uint, branchID string) (uint, error) {
+	counter := models.EtimsInvoiceCounter{BusinessID: businessID, BranchID: branchID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("business_id = ? AND branch_id = ?", businessID, branchID).
+		First(&counter).Error; err != nil {
+		return 0, err
+	}
+	counter.LastNumber++
+	if err := tx.Model(&models.EtimsInvoiceCounter{}).
+		Where("business_id = ? AND branch_id = ?", businessID, branchID).
+		Update("last_number", counter.LastNumber).Error; err != nil {
+		return 0, err
+	}
+	return counter.LastNumber, nil
+}
+
+func setEtimsPayload(tx *gorm.DB, invoice *models.EtimsInvoice, business models.Business, sale *models.Sale) error {
+	// The invoice number belongs to the branch it was taken from, even if
+	// the business has moved branch since
+	business.EtimsBranchID = invoice.BranchID
+	payload, err := buildEtimsInvoice(tx, business, sale, invoice.InvoiceNumber)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	invoice.Payload = string(body)
+	return nil
+}
+
+// buildEtimsInvoice turns a recorded sale into an eTIMS sales invoice. eTIMS
+// reports taxable amounts including VAT, so each line's taxable amount is
+// its total and the tax is the VAT within it.
+func buildEtimsInvoice(tx *gorm.DB, business models.Business, sale *models.Sale, invoiceNumber uint) (*etims.SalesInvoice, error) {
+	if !validKRAPin(business.KRAPin) {
+		return nil, fmt.Errorf("business KRA PIN %q is not valid", business.KRAPin)
+	}
+	if len(sale.Items) == 0 {
+		return nil, errors.New("sale has no items")
+	}
+
+	productIDs := make([]uint, 0, len(sale.Items))
+	for _, item := range sale.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var products []models.Product
+	if err := tx.Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	productByID := make(map[uint]models.Product, len(products))
+	for _, product := range products {
+		productByID[product.ID] = product
+	}
+
+	soldAt := sale.CreatedAt.Format("20060102150405")
+	invoice := &etims.SalesInvoice{
+		TIN:           business.KRAPin,
+		BranchID:      business.EtimsBranchID,
+		InvoiceNumber: invoiceNumber,
+		CustomerTIN:   sale.CustomerPIN,
+		CustomerName:  sale.CustomerName,
+		SalesType:     "N",
+		ReceiptType:   etims.ReceiptSale,
+		PaymentType:   etimsPaymentType(sale),
+		SalesStatus:   "02",
+		ConfirmedAt:   soldAt,
+		SalesDate:     sale.CreatedAt.Format("20060102"),
+		ItemCount:     len(sale.Items),
+		Remark:        sale.SaleNumber,
+		Receipt: etims.InvoiceBuyer{
+			CustomerTIN:   sale.CustomerPIN,
+			CustomerPhone: sale.CustomerPhone,
+			PublishedAt:   soldAt,
+			BuyerAccepted: "N",
+			TraderName:    business.Name,
+		},
+	}
+
+	taxable := make(map[string]float64)
+	taxes := make(map[string]float64)
+	rates := make(map[string]float64)
+	for i, item := range sale.Items {
+		product := productByID[item.ProductID]
+		if product.ItemClassCode == "" {
+			return nil, fmt.Errorf("product %q has no item classification code", item.ProductName)
+		}
+		taxType := item.TaxCode
+		if len(taxType) != 1 || !strings.Contains(etimsTaxTypes, taxType) {
+			return nil, fmt.Errorf("tax code %q on %q is not an eTIMS tax type", item.TaxCode, item.ProductName)
+		}
+
+		discount := roundMoney(item.Discount + item.BasketDiscount)
+		discountRate := 0.0
+		if item.GrossAmount > 0 {
+			discountRate = roundMoney(discount / item.GrossAmount * 100)
+		}
+		invoice.Items = append(invoice.Items, etims.InvoiceItem{
+			Sequence:       i + 1,
+			ItemCode:       fmt.Sprintf("KE%s%07d", business.EtimsBranchID, product.ID),
+			ClassCode:      product.ItemClassCode,
+			Name:           item.ProductName,
+			Barcode:        product.Barcode,
+			PackageUnit:    "NT",
+			Packages:       float64(item.Quantity),
+			QuantityUnit:   "U",
+			Quantity:       float64(item.Quantity),
+			UnitPrice:      item.UnitPrice,
+			SupplyAmount:   item.GrossAmount,
+			DiscountRate:   discountRate,
+			DiscountAmount: discount,
+			TaxType:        taxType,
+			TaxableAmount:  item.LineTotal,
+			TaxAmount:      item.TaxAmount,
+			TotalAmount:    item.LineTotal,
+		})
+
+		taxable[taxType] += item.LineTotal
+		taxes[taxType] += item.TaxAmount
+		rates[taxType] = item.TaxRate
+		invoice.TotalTaxable += item.LineTotal
+		invoice.TotalTax += item.TaxAmount
+		invoice.TotalAmount += item.LineTotal
+	}
+
+	invoice.TaxableAmountA, invoice.TaxAmountA, invoice.TaxRateA = roundMoney(taxable["A"]), roundMoney(taxes["A"]), rates["A"]
+	invoice.TaxableAmountB, invoice.TaxAmountB, invoice.TaxRateB = roundMoney(taxable["B"]), roundMoney(taxes["B"]), rates["B"]
+	invoice.TaxableAmountC, invoice.TaxAmountC, invoice.TaxRateC = roundMoney(taxable["C"]), roundMoney(taxes["C"]), rates["C"]
+	invoice.TaxableAmountD, invoice.TaxAmountD, invoice.TaxRateD = roundMoney(taxable["D"]), roundMoney(taxes["D"]), rates["D"]
+	invoice.TaxableAmountE, invoice.TaxAmountE, invoice.TaxRateE = roundMoney(taxable["E"]), roundMoney(taxes["E"]), rates["E"]
+	invoice.TotalTaxable = roundMoney(invoice.TotalTaxable)
+	invoice.TotalTax = roundMoney(invoice.TotalTax)
+	invoice.TotalAmount = roundMoney(invoice.TotalAmount)
+	return invoice, nil
+}
+
+// EtimsWorker submits queued invoices in the background. Each invoice is
+// claimed with a conditional update before it is sent, so two workers never
+// send the same invoice at once, and a retry reuses the stored payload so
+// eTIMS recognises an invoice it has already signed.
+type EtimsWorker struct {
+	db        *gorm.DB
+	transport etims.Transport
+	config    etims.Config
+	interval  time.Duration
+	// claimTimeout is how long a claimed invoice may stay SUBMITTING before
+	// it is assumed abandoned
+	claimTimeout time.Duration
+}
+
+const (
+	etimsBatchSize    = 20
+	etimsMaxBackoff   = time.Hour
+	etimsFirstBackoff = 30 * time.Second
+)
+
+func NewEtimsWorker(db *gorm.DB, transport etims.Transport, config etims.Config) *EtimsWorker {
+	return &EtimsWorker{
+		db:           db,
+		transport:    transport,
+		config:       config,
+		interval:     15 * time.Second,
+		claimTimeout: 5 * time.Minute,
+	}
+}
+
+// Run submits due invoices until the context is cancelled
+func (w *EtimsWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.submitDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *EtimsWorker) submitDue(ctx context.Context) {
+	now := time.Now()
+	var due []models.EtimsInvoice
+	if err := w.db.Where("(status IN ? AND next_attempt_at <= ?) OR (status = ? AND claimed_at < ?)",
+		[]string{models.EtimsStatusPending, models.EtimsStatusFailed}, now,
+		models.EtimsStatusSubmitting, now.Add(-w.claimTimeout)).
+		Order("id").Limit(etimsBatchSize).Find(&due).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch due eTIMS invoices: %v", err)
+		return
+	}
+
+	for _, invoice := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if !w.claim(&invoice, now) {
+			continue
+		}
+		w.submit(ctx, &invoice)
+	}
+}
+
+// claim marks an invoice as being submitted, only if it is still in the
+// state it was read in
+func (w *EtimsWorker) claim(invoice *models.EtimsInvoice, now time.Time) bool {
+	claimedAt := now
+	result := w.db.Model(&models.EtimsInvoice{}).
+		Where("id = ? AND status = ? AND attempts = ?", invoice.ID, invoice.Status, invoice.Attempts).
+		Updates(map[string]interface{}{
+			"status":     models.EtimsStatusSubmitting,
+			"attempts":   invoice.Attempts + 1,
+			"claimed_at": claimedAt,
+		})
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to claim eTIMS invoice %d: %v", invoice.ID, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+	invoice.Status = models.EtimsStatusSubmitting
+	invoice.Attempts++
+	invoice.ClaimedAt = &claimedAt
+	return true
+}
+
+func (w *EtimsWorker) submit(ctx context.Context, invoice *models.EtimsInvoice) {
+	var payload etims.SalesInvoice
+	if err := json.Unmarshal([]byte(invoice.Payload), &payload); err != nil {
+		w.fail(invoice, fmt.Errorf("stored payload is invalid: %v", err), false)
+		return
+	}
+
+	receipt, err := w.transport.SubmitSale(ctx, payload)
+	if err != nil {
+		var rejected *etims.RejectedError
+		w.fail(invoice, err, !errors.As(err, &rejected))
+		return
+	}
+
+	if err := w.recordReceipt(invoice, payload, *receipt); err != nil {
+		// The next attempt sends the same invoice number and gets the same
+		// receipt back
+		utils.ErrorLogger("Failed to store eTIMS receipt for invoice %d: %v", invoice.ID, err)
+		w.fail(invoice, err, true)
+		return
+	}
+	utils.InfoLogger("eTIMS signed sale %d as receipt %d", invoice.SaleID, receipt.ReceiptNumber)
+}
+
+func (w *EtimsWorker) recordReceipt(invoice *models.EtimsInvoice, payload etims.SalesInvoice, receipt etims.ReceiptData) error {
+	now := time.Now()
+	qrData := etims.QRData(w.config, payload, receipt)
+
+	return w.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.EtimsInvoice{}).Where("id = ?", invoice.ID).Updates(map[string]interface{}{
+			"status":            models.EtimsStatusSubmitted,
+			"last_error":        "",
+			"next_attempt_at":   nil,
+			"receipt_number":    receipt.ReceiptNumber,
+			"internal_data":     receipt.InternalData,
+			"receipt_signature": receipt.ReceiptSignature,
+			"sdc_id":            receipt.SDCID,
+			"mrc_number":        receipt.MRCNumber,
+			"qr_data":           qrData,
+			"submitted_at":      now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Sale{}).Where("id = ?", invoice.SaleID).Updates(map[string]interface{}{
+			"etims_invoice_number": fmt.Sprintf("%s/%d", receipt.SDCID, receipt.ReceiptNumber),
+			"etims_internal_data":  receipt.InternalData,
+			"etims_signature":      receipt.ReceiptSignature,
+			"etims_qr_data":        qrData,
+			"etims_submitted_at":   now,
+		}).Error
+	})
+}
+
+// fail records a failed attempt. Temporary failures are retried with
+// exponential backoff; rejected invoices wait for someone to fix the cause
+// and retry them.
+func (w *EtimsWorker) fail(invoice *models.EtimsInvoice, err error, retry bool) {
+	updates := map[string]interface{}{
+		"status":          models.EtimsStatusFailed,
+		"last_error":      err.Error(),
+		"next_attempt_at": nil,
+	}
+	if retry {
+		backoff := time.Duration(float64(etimsFirstBackoff) * math.Pow(2, float64(invoice.Attempts-1)))
+		if backoff > etimsMaxBackoff {
+			backoff = etimsMaxBackoff
+		}
+		updates["next_attempt_at"] = time.Now().Add(backoff)
+		utils.WarningLogger("eTIMS invoice %d failed, retrying in %s: %v", invoice.ID, backoff, err)
+	} else {
+		utils.ErrorLogger("eTIMS invoice %d failed: %v", invoice.ID, err)
+	}
+
+	if dbErr := w.db.Model(&models.EtimsInvoice{}).
+		Where("id = ? AND status = ?", invoice.ID, models.EtimsStatusSubmitting).
+		Updates(updates).Error; dbErr != nil {
+		utils.ErrorLogger("Failed to record eTIMS failure for invoice %d: %v", invoice.ID, dbErr)
+	}
+}
+
+// GetEtimsInvoices lists the eTIMS queue, optionally filtered by status
+func (im *SalesManagementHandler) GetEtimsInvoices(c *gin.Context) {
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch eTIMS invoices"})
+		return
+	}
+
+	query := im.db.Omit("payload").Where("business_id = ?", businessID)
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invoices []models.EtimsInvoice
+	if err := query.Order("id DESC").Limit(200).Find(&invoices).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch eTIMS invoices: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch eTIMS invoices"})
+		return
+	}
+
+	c.JSON(200, invoices)
+}
+
+// RetryEtimsInvoice queues a failed invoice again. Invoices eTIMS refused
+// are rebuilt from the sale, picking up fixes such as a product's missing
+// classification code; the invoice number stays the same.
+func (im *SalesManagementHandler) RetryEtimsInvoice(c *gin.Context) {
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to retry eTIMS invoice"})
+		return
+	}
+
+	var invoice models.EtimsInvoice
+	if err := im.db.Where("business_id = ?", businessID).First(&invoice, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "eTIMS invoice not found"})
+		return
+	}
+	if invoice.Status != models.EtimsStatusFailed {
+		c.JSON(409, gin.H{"error": "Only failed invoices can be retried"})
+		return
+	}
+
+	var business models.Business
+	if err := im.db.First(&business, businessID).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch business %d: %v", businessID, err)
+		c.JSON(500, gin.H{"error": "Failed to retry eTIMS invoice"})
+		return
+	}
+	var sale models.Sale
+	if err := im.db.Preload("Items").Preload("Payments").First(&sale, invoice.SaleID).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch sale %d: %v", invoice.SaleID, err)
+		c.JSON(500, gin.H{"error": "Failed to retry eTIMS invoice"})
+		return
+	}
+	if err := setEtimsPayload(im.db, &invoice, business, &sale); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	result := im.db.Model(&models.EtimsInvoice{}).
+		Where("id = ? AND status = ?", invoice.ID, models.EtimsStatusFailed).
+		Updates(map[string]interface{}{
+			"status":          models.EtimsStatusPending,
+			"payload":         invoice.Payload,
+			"next_attempt_at": now,
+		})
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to retry eTIMS invoice %d: %v", invoice.ID, result.Error)
+		c.JSON(500, gin.H{"error": "Failed to retry eTIMS invoice"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": "The invoice is already being submitted"})
+		return
+	}
+
+	utils.InfoLogger("Queued eTIMS invoice %d for another attempt", invoice.ID)
+	c.JSON(200, gin.H{
+		"success": true,
+		"message": "Invoice queued for submission",
+	})
+}
+
+// GetEtimsPayload shows the eTIMS invoice a sale was or would be sent as.
+// A sale not yet queued has no invoice number, so its preview carries 0
+// rather than a number another sale may be given.
+func (im *SalesManagementHandler) GetEtimsPayload(c *gin.Context) {
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build eTIMS invoice"})
+		return
+	}
+
+	var sale models.Sale
+	if err := im.db.Preload("Items").Preload("Payments").Where("business_id = ?", businessID).First(&sale, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Sale not found"})
+		return
+	}
+
+	var invoice models.EtimsInvoice
+	if err := im.db.Where("sale_id = ?", sale.ID).First(&invoice).Error; err == nil && invoice.Payload != "" {
+		c.Data(200, "application/json; charset=utf-8", []byte(invoice.Payload))
+		return
+	}
+
+	var business models.Business
+	if err := im.db.First(&business, businessID).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch business %d: %v", businessID, err)
+		c.JSON(500, gin.H{"error": "Failed to build eTIMS invoice"})
+		return
+	}
+	payload, err := buildEtimsInvoice(im.db, business, &sale, 0)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, payload)
+}
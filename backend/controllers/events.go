@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// heartbeatInterval keeps idle streams from being closed by proxies
+const heartbeatInterval = 25 * time.Second
+
+// streamTicketTTL is how long a stream ticket can wait to be used
+const streamTicketTTL = time.Minute
+
+// saleBusinessID is the business a sale rung up by cashier belongs to: the
+// cashier's, or the shop's for sales made without signing in
+func saleBusinessID(db *gorm.DB, cashier *models.User) (uint, error) {
+	if cashier != nil && cashier.BusinessID != 0 {
+		return cashier.BusinessID, nil
+	}
+	return defaultBusinessID(db)
+}
+
+// withEvents runs fn in a transaction and, once it commits, sends the
+// events it queued to the business's dashboards
+func withEvents(db *gorm.DB, businessID uint, fn func(tx *gorm.DB) error) error {
+	ctx, outbox := events.WithOutbox(context.Background(), businessID)
+	if err := db.WithContext(ctx).Transaction(fn); err != nil {
+		return err
+	}
+	outbox.Flush()
+	return nil
+}
+
+// saleEvent is the summary of a sale sent to dashboards
+func saleEvent(sale *models.Sale) gin.H {
+	return gin.H{
+		"sale_id":        sale.ID,
+		"sale_number":    sale.SaleNumber,
+		"total_amount":   sale.TotalAmount,
+		"amount_paid":    sale.AmountPaid,
+		"payment_method": sale.PaymentMethod,
+		"cashier_id":     sale.CashierID,
+		"customer_name":  sale.CustomerName,
+		"items":          len(sale.Items),
+		"created_at":     sale.CreatedAt,
+	}
+}
+
+// queueStockChanged tells dashboards a product's stock level has changed
+func queueStockChanged(tx *gorm.DB, productName string, inventory models.Inventory) {
+	events.Queue(tx.Statement.Context, events.StockChanged, gin.H{
+		"product_id":          inventory.ProductID,
+		"product_name":        productName,
+		"quantity":            inventory.Quantity,
+		"low_stock_threshold": inventory.LowStockThreshold,
+	})
+}
+
+type EventHandler struct {
+	db *gorm.DB
+}
+
+func NewEventHandler(db *gorm.DB) *EventHandler {
+	return &EventHandler{db: db}
+}
+
+// IssueStreamTicket gives the signed-in user a single-use ticket for opening
+// the event stream from a browser, as /events?ticket=...
+func (eh *EventHandler) IssueStreamTicket(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		utils.ErrorLogger("Failed to generate stream ticket: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to issue stream ticket"})
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	now := time.Now()
+	ticket := models.StreamTicket{
+		TokenHash: utils.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: now.Add(streamTicketTTL),
+	}
+	if err := eh.db.Create(&ticket).Error; err != nil {
+		utils.ErrorLogger("Failed to save stream ticket: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to issue stream ticket"})
+		return
+	}
+	// Clear out tickets that can no longer be used
+	if err := eh.db.Where("expires_at < ?", now.Add(-time.Hour)).Delete(&models.StreamTicket{}).Error; err != nil {
+		utils.WarningLogger("Failed to remove old stream tickets: %v", err)
+	}
+
+	c.JSON(200, gin.H{
+		"ticket":     token,
+		"expires_at": ticket.ExpiresAt,
+	})
+}
+
+// StreamEvents pushes the signed-in user's business events to the client as
+// Server-Sent Events until it disconnects. The optional types parameter,
+// a comma separated list, limits the stream to those event types.
+func (eh *EventHandler) StreamEvents(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	wanted := make(map[string]bool)
+	for _, t := range strings.Split(c.Query("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			wanted[t] = true
+		}
+	}
+
+	stream, stop := events.Subscribe(user.BusinessID)
+	defer stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprintf(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+	utils.InfoLogger("User %d subscribed to events of business %d", user.ID, user.BusinessID)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			utils.InfoLogger("User %d unsubscribed from events", user.ID)
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprintf(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			if len(wanted) > 0 && !wanted[event.Type] {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				utils.ErrorLogger("Failed to encode %s event: %v", event.Type, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryRunDB returns a database that builds queries without running them, so
+// every lookup finds nothing. It stands in for tx where a test only needs
+// the no-rows case, such as a business with no custom settings.
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "test:test@tcp(127.0.0.1:0)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening dry-run database: %v", err)
+	}
+	return db
+}
+
+// statusOf is the HTTP status a sale error would be reported with, or 0 for
+// nil and 500 for any other error
+func statusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se *saleError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return 500
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -8,8 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/OAthooh/BiasharaTrack.git/events"
 	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/OAthooh/BiasharaTrack.git/utils"
 	"github.com/gin-gonic/gin"
@@ -17,11 +20,12 @@ import (
 )
 
 type InventoryManagementHandler struct {
-	db *gorm.DB
+	db     *gorm.DB
+	search *productSearch
 }
 
 func NewInventoryManagementHandler(db *gorm.DB) *InventoryManagementHandler {
-	return &InventoryManagementHandler{db: db}
+	return &InventoryManagementHandler{db: db, search: newProductSearch(db)}
 }
 
 func (im *InventoryManagementHandler) CreateProduct(c *gin.Context) {
@@ -80,6 +84,7 @@ func (im *InventoryManagementHandler) CreateProduct(c *gin.Context) {
 		Name:        c.Request.FormValue("name"),
 		Description: c.Request.FormValue("description"),
 		Category:    c.Request.FormValue("category"),
+		Aliases:     c.Request.FormValue("aliases"),
 		Barcode:     c.Request.FormValue("barcode"),
 		PhotoPath:   imagePath,
 	}
@@ -93,6 +98,47 @@ func (im *InventoryManagementHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	// Parse optional cost price used to value stock and write-offs
+	if costPrice := c.Request.FormValue("cost_price"); costPrice != "" {
+		cost, err := strconv.ParseFloat(costPrice, 64)
+		if err != nil || cost < 0 {
+			c.JSON(400, gin.H{"error": "Invalid cost price format"})
+			return
+		}
+		product.CostPrice = cost
+	}
+
+	// Parse optional supplier details used for reorder suggestions
+	if supplierID := c.Request.FormValue("supplier_id"); supplierID != "" {
+		id, err := strconv.ParseUint(supplierID, 10, 64)
+		if err != nil {
+			utils.ErrorLogger("Invalid supplier id format: %v", err)
+			c.JSON(400, gin.H{"error": "Invalid supplier id format"})
+			return
+		}
+		sid := uint(id)
+		product.SupplierID = &sid
+	}
+	if taxClassID := c.Request.FormValue("tax_class_id"); taxClassID != "" {
+		id, err := strconv.ParseUint(taxClassID, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid tax class id format"})
+			return
+		}
+		tid := uint(id)
+		product.TaxClassID = &tid
+	}
+	product.ItemClassCode = strings.TrimSpace(c.Request.FormValue("item_class_code"))
+	product.PackSize = 1
+	if packSize := c.Request.FormValue("pack_size"); packSize != "" {
+		p, err := strconv.Atoi(packSize)
+		if err != nil || p < 1 {
+			c.JSON(400, gin.H{"error": "Pack size must be a positive whole number"})
+			return
+		}
+		product.PackSize = p
+	}
+
 	// Parse quantity
 	var quantity int
 	if q, err := strconv.Atoi(c.Request.FormValue("quantity")); err == nil {
@@ -121,8 +167,29 @@ func (im *InventoryManagementHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	// Stock changes are sent to the dashboards of the user's business
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	if product.TaxClassID != nil {
+		ok, err := taxClassExists(im.db, businessID, *product.TaxClassID)
+		if err != nil {
+			utils.ErrorLogger("Failed to check tax class %d: %v", *product.TaxClassID, err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		if !ok {
+			c.JSON(400, gin.H{"error": "Tax class not found"})
+			return
+		}
+	}
+	ctx, outbox := events.WithOutbox(context.Background(), businessID)
+
 	// Start transaction
-	tx := im.db.Begin()
+	tx := im.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
 		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
 		c.JSON(500, gin.H{"error": "Internal server error"})
@@ -165,19 +232,12 @@ func (im *InventoryManagementHandler) CreateProduct(c *gin.Context) {
 	}
 
 	// Check if initial quantity is below threshold and create alert if needed
-	if quantity <= threshold {
-		alert := models.LowStockAlert{
-			ProductID: product.ID,
-			AlertMessage: fmt.Sprintf("Low stock alert for %s: %d units remaining (threshold: %d)",
-				product.Name, quantity, threshold),
-			Resolved:  false,
-			CreatedAt: time.Now(),
-		}
-
-		if err := im.db.Create(&alert).Error; err != nil {
-			utils.ErrorLogger("Failed to create low stock alert: %v", err)
-		}
+	db := im.db.WithContext(ctx)
+	queueStockChanged(db, product.Name, inventory)
+	if err := syncLowStockAlert(db, product.Name, inventory); err != nil {
+		utils.ErrorLogger("Failed to create low stock alert: %v", err)
 	}
+	outbox.Flush()
 
 	c.JSON(200, gin.H{
 		"success": true,
@@ -194,8 +254,17 @@ func (im *InventoryManagementHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	// Stock changes are sent to the dashboards of the user's business
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	ctx, outbox := events.WithOutbox(context.Background(), businessID)
+
 	// Start transaction
-	tx := im.db.Begin()
+	tx := im.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
 		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
 		c.JSON(500, gin.H{"error": "Failed to start transaction"})
@@ -226,15 +295,50 @@ func (im *InventoryManagementHandler) UpdateProduct(c *gin.Context) {
 	if category, ok := input["category"].(string); ok {
 		product.Category = category
 	}
+	if aliases, ok := input["aliases"].(string); ok {
+		product.Aliases = aliases
+	}
 	if price, ok := input["price"].(float64); ok {
 		product.Price = price
 	}
+	if costPrice, ok := input["cost_price"].(float64); ok && costPrice >= 0 {
+		product.CostPrice = costPrice
+	}
 	if barcode, ok := input["barcode"].(string); ok {
 		product.Barcode = barcode
 	}
 	if photoPath, ok := input["photo_path"].(string); ok {
 		product.PhotoPath = photoPath
 	}
+	if supplierID, ok := input["supplier_id"].(float64); ok {
+		sid := uint(supplierID)
+		product.SupplierID = &sid
+	}
+	if taxClassID, ok := input["tax_class_id"].(float64); ok {
+		tid := uint(taxClassID)
+		exists, err := taxClassExists(tx, businessID, tid)
+		if err != nil {
+			tx.Rollback()
+			utils.ErrorLogger("Failed to check tax class %d: %v", tid, err)
+			c.JSON(500, gin.H{"error": "Failed to update product"})
+			return
+		}
+		if !exists {
+			tx.Rollback()
+			c.JSON(400, gin.H{"error": "Tax class not found"})
+			return
+		}
+		product.TaxClassID = &tid
+	}
+	if classCode, ok := input["item_class_code"].(string); ok {
+		product.ItemClassCode = strings.TrimSpace(classCode)
+	}
+	if packSize, ok := input["pack_size"].(float64); ok && packSize >= 1 {
+		product.PackSize = int(packSize)
+	}
+	if archived, ok := input["archived"].(bool); ok {
+		product.Archived = archived
+	}
 
 	product.UpdatedAt = time.Now()
 
@@ -245,11 +349,24 @@ func (im *InventoryManagementHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
-	// Handle quantity changes
+	// Handle quantity changes. Stock leaving for any reason other than a
+	// sale or a stock count correction goes through a write-off instead.
 	if quantityChange, ok := input["quantity_change"].(float64); ok {
+		changeType, _ := input["change_type"].(string)
+		if changeType != models.MovementPurchase && changeType != models.MovementAdjustment {
+			tx.Rollback()
+			c.JSON(400, gin.H{"error": "change_type must be PURCHASE or ADJUSTMENT; use a write-off to remove spoiled or lost stock"})
+			return
+		}
+		if changeType == models.MovementPurchase && quantityChange <= 0 {
+			tx.Rollback()
+			c.JSON(400, gin.H{"error": "A purchase must add stock"})
+			return
+		}
+
 		stockMovement := models.StockMovement{
 			ProductID:      product.ID,
-			ChangeType:     input["change_type"].(string),
+			ChangeType:     changeType,
 			QuantityChange: int(quantityChange),
 			Note:           "Product details updated",
 			CreatedAt:      time.Now(),
@@ -286,6 +403,13 @@ func (im *InventoryManagementHandler) UpdateProduct(c *gin.Context) {
 				return
 			}
 		}
+
+		queueStockChanged(tx, product.Name, inventory)
+
+		// Raise, refresh or resolve the product's low stock alert
+		if err := syncLowStockAlert(tx, product.Name, inventory); err != nil {
+			utils.ErrorLogger("Failed to update low stock alert: %v", err)
+		}
 	}
 
 	if err := tx.Commit().Error; err != nil {
@@ -293,23 +417,7 @@ func (im *InventoryManagementHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Failed to update product"})
 		return
 	}
-
-	// Check for low stock alert
-	var inventory models.Inventory
-	if err := im.db.Where("product_id = ?", product.ID).First(&inventory).Error; err == nil {
-		if inventory.Quantity <= inventory.LowStockThreshold {
-			alert := models.LowStockAlert{
-				ProductID: product.ID,
-				AlertMessage: fmt.Sprintf("Low stock alert for %s: Current quantity (%d) is at or below threshold (%d)",
-					product.Name, inventory.Quantity, inventory.LowStockThreshold),
-				Resolved:  false,
-				CreatedAt: time.Now(),
-			}
-			if err := im.db.Create(&alert).Error; err != nil {
-				utils.ErrorLogger("Failed to create low stock alert: %v", err)
-			}
-		}
-	}
+	outbox.Flush()
 
 	utils.InfoLogger("Successfully updated product %s", id)
 	c.JSON(200, gin.H{"message": "Product updated successfully"})
@@ -353,34 +461,6 @@ func (im *InventoryManagementHandler) GetProduct(c *gin.Context) {
 	c.JSON(200, response)
 }
 
-func (im *InventoryManagementHandler) GetAllProducts(c *gin.Context) {
-	var products []models.Product
-	var result []gin.H
-
-	if err := im.db.Find(&products).Error; err != nil {
-		utils.ErrorLogger("Failed to fetch products: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to get products"})
-		return
-	}
-
-	for _, product := range products {
-		var inventory models.Inventory
-		if err := im.db.Where("product_id = ?", product.ID).First(&inventory).Error; err != nil {
-			inventory.Quantity = 0
-		}
-
-		result = append(result, gin.H{
-			"product":  product,
-			"quantity": inventory.Quantity,
-		})
-	}
-
-	utils.InfoLogger("Successfully fetched all products")
-	c.JSON(200, result)
-}
-
-
-
 func isAllowedImageType(file multipart.File) bool {
 	buffer := make([]byte, 512)
 	_, err := file.Read(buffer)
@@ -408,16 +488,19 @@ func (im *InventoryManagementHandler) GetLowStockAlerts(c *gin.Context) {
 		StockThreshold  int    `json:"stock_threshold"`
 	}
 
-	// Using MySQL compatible syntax
-	if err := im.db.Table("low_stock_alerts").
+	query := im.db.Table("low_stock_alerts").
 		Select("low_stock_alerts.*, products.name as product_name, inventory.quantity as current_quantity, inventory.low_stock_threshold as stock_threshold").
 		Joins("JOIN products ON low_stock_alerts.product_id = products.id").
 		Joins("JOIN inventory ON products.id = inventory.product_id").
-		Where("low_stock_alerts.id IN (?)",
-			im.db.Table("low_stock_alerts").
-				Select("MAX(id)").
-				Group("product_id")).
-		Find(&alerts).Error; err != nil {
+		Where("low_stock_alerts.status <> ?", models.AlertStatusResolved)
+
+	// Snoozed alerts come back on their own once the snooze runs out
+	if c.Query("include_snoozed") != "true" {
+		query = query.Where("(low_stock_alerts.status <> ? OR low_stock_alerts.snoozed_until <= ?)",
+			models.AlertStatusSnoozed, time.Now())
+	}
+
+	if err := query.Order("low_stock_alerts.created_at DESC").Find(&alerts).Error; err != nil {
 		utils.ErrorLogger("Failed to fetch alerts: %v", err)
 		c.JSON(500, gin.H{"error": "Failed to fetch alerts"})
 		return
@@ -465,31 +548,3 @@ func (im *InventoryManagementHandler) LookupBarcode(c *gin.Context) {
 		"data":    product,
 	})
 }
-
-func (im *InventoryManagementHandler) SearchProducts(c *gin.Context) {
-	query := c.Query("q")
-	if query == "" {
-		c.JSON(400, gin.H{"error": "Search query is required"})
-		return
-	}
-
-	utils.InfoLogger("Searching products with query: %s", query)
-
-	var products []struct {
-		models.Product
-		Quantity int `json:"quantity"`
-	}
-	err := im.db.Table("products").
-		Select("products.*, inventory.quantity").
-		Joins("left join inventory on inventory.product_id = products.id").
-		Where("products.name LIKE ? OR products.description LIKE ? OR products.barcode LIKE ?", 
-		"%"+query+"%", "%"+query+"%", "%"+query+"%").
-		Find(&products).Error
-	if err != nil {
-		utils.ErrorLogger("Failed to search products: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to search products"})
-		return
-	}
-
-	c.JSON(200, products)
-}
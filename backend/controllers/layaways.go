@@ -0,0 +1,515 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/mpesa"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LayawayRequest struct {
+	Products         []SellRequest     `json:"products" binding:"required"`
+	Discount         *DiscountRequest  `json:"discount"`
+	DiscountApproval *DiscountApproval `json:"discount_approval"`
+	CustomerName     string            `json:"customer_name" binding:"required"`
+	CustomerPhone    string            `json:"customer_phone" binding:"required"`
+	// Deposit is the first instalment, paid when the order is opened
+	Deposit LayawayDepositRequest `json:"deposit" binding:"required"`
+}
+
+type LayawayDepositRequest struct {
+	Method    string  `json:"method" binding:"required"`
+	Amount    float64 `json:"amount" binding:"required"`
+	Reference string  `json:"reference"`
+}
+
+type CancelLayawayRequest struct {
+	Reason string `json:"reason" binding:"required"`
+	// RefundMethod pays back what the customer does not forfeit, CASH or
+	// MPESA
+	RefundMethod    string `json:"refund_method"`
+	RefundReference string `json:"refund_reference"`
+	// WaiveForfeit refunds everything; only managers may waive it
+	WaiveForfeit bool `json:"waive_forfeit"`
+}
+
+// layawayMethod checks a deposit or refund is taken in cash or M-Pesa
+func layawayMethod(method string) (string, error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method != models.PaymentCash && method != models.PaymentMpesa {
+		return "", newSaleError(400, "Layaway payments must be CASH or MPESA")
+	}
+	return method, nil
+}
+
+// CreateLayaway opens a layaway order with its first deposit and reserves
+// the stock until it is collected, cancelled or falls due
+func (im *SalesManagementHandler) CreateLayaway(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req LayawayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Products, customer name and phone, and a deposit are required"})
+		return
+	}
+	if len(req.Products) == 0 {
+		c.JSON(400, gin.H{"error": "At least one product is required"})
+		return
+	}
+	customerPhone, err := mpesa.NormalizePhoneNumber(req.CustomerPhone)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Customer phone number %s is not a valid Kenyan number", req.CustomerPhone)})
+		return
+	}
+
+	saleData := SaleData{
+		Products:      req.Products,
+		Discount:      req.Discount,
+		CustomerName:  strings.TrimSpace(req.CustomerName),
+		CustomerPhone: customerPhone,
+	}
+	payload, err := json.Marshal(saleData)
+	if err != nil {
+		utils.ErrorLogger("Failed to encode layaway: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create layaway"})
+		return
+	}
+
+	var layaway models.Layaway
+	err = withEvents(im.db, user.BusinessID, func(tx *gorm.DB) error {
+		var business models.Business
+		if err := tx.First(&business, user.BusinessID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		sale := models.Sale{BusinessID: user.BusinessID, CreatedAt: now}
+		items, err := priceSale(tx, &sale, saleData, &user)
+		if err != nil {
+			return err
+		}
+		if err := checkDiscountLimits(tx, &sale, items, &user, req.DiscountApproval); err != nil {
+			return err
+		}
+
+		minDeposit := roundMoney(sale.TotalAmount * business.LayawayMinDepositPercent / 100)
+		if req.Deposit.Amount < minDeposit && !sameAmount(req.Deposit.Amount, minDeposit) {
+			return newSaleError(400, "The first deposit must be at least %.2f", minDeposit)
+		}
+		if req.Deposit.Amount > sale.TotalAmount && !sameAmount(req.Deposit.Amount, sale.TotalAmount) {
+			return newSaleError(400, "Deposit of %.2f is more than the order total of %.2f", req.Deposit.Amount, sale.TotalAmount)
+		}
+
+		layaway = models.Layaway{
+			// Placeholder until the ID is known; it only needs to be unique
+			LayawayNumber: utils.GenerateUUID(),
+			BusinessID:    user.BusinessID,
+			CreatedByID:   user.ID,
+			CustomerName:  saleData.CustomerName,
+			CustomerPhone: saleData.CustomerPhone,
+			Status:        models.LayawayOpen,
+			Payload:       string(payload),
+			Subtotal:      sale.Subtotal,
+			DiscountTotal: sale.DiscountTotal,
+			TaxTotal:      sale.TaxTotal,
+			TotalAmount:   sale.TotalAmount,
+			BalanceDue:    sale.TotalAmount,
+			DueDate:       now.AddDate(0, 0, business.LayawayTermDays),
+		}
+
+		needed := make(map[uint]int)
+		for _, item := range items {
+			layaway.Items = append(layaway.Items, models.LayawayItem{
+				ProductID:     item.ProductID,
+				ProductName:   item.ProductName,
+				Quantity:      item.Quantity,
+				ListPrice:     item.ListPrice,
+				UnitPrice:     item.UnitPrice,
+				GrossAmount:   item.GrossAmount,
+				Discount:      roundMoney(item.Discount + item.BasketDiscount),
+				TaxCode:       item.TaxCode,
+				TaxRate:       item.TaxRate,
+				TaxableAmount: item.TaxableAmount,
+				TaxAmount:     item.TaxAmount,
+				LineTotal:     item.LineTotal,
+			})
+			needed[item.ProductID] += item.Quantity
+		}
+
+		// Lock the stock so two orders cannot reserve the same units
+		for _, item := range layaway.Items {
+			quantity, ok := needed[item.ProductID]
+			if !ok {
+				continue
+			}
+			delete(needed, item.ProductID)
+
+			var inventory models.Inventory
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("product_id = ?", item.ProductID).
+				First(&inventory).Error; err != nil {
+				return newSaleError(404, "Product %d not found in inventory", item.ProductID)
+			}
+			reserved, err := reservedStock(tx, item.ProductID, now, nil, nil)
+			if err != nil {
+				return err
+			}
+			if available := inventory.Quantity - reserved; available < quantity {
+				return newSaleError(409, "Only %d of %s can be reserved", max(available, 0), item.ProductName)
+			}
+		}
+
+		if err := tx.Create(&layaway).Error; err != nil {
+			return err
+		}
+		layaway.LayawayNumber = fmt.Sprintf("L%s-%06d", now.Format("20060102"), layaway.ID)
+		if err := tx.Model(&layaway).Update("layaway_number", layaway.LayawayNumber).Error; err != nil {
+			return err
+		}
+
+		return payLayaway(tx, &layaway, req.Deposit, &user)
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d opened layaway %s for %s", user.ID, layaway.LayawayNumber, layaway.CustomerName)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    layaway,
+	})
+}
+
+// RecordLayawayDeposit takes an instalment towards a layaway. The deposit
+// that clears the balance releases the goods as a completed sale.
+func (im *SalesManagementHandler) RecordLayawayDeposit(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req LayawayDepositRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Method and amount are required"})
+		return
+	}
+
+	var layaway models.Layaway
+	err := withEvents(im.db, user.BusinessID, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Items").Preload("Payments").
+			Where("business_id = ?", user.BusinessID).
+			First(&layaway, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Layaway not found")
+		}
+		if layaway.Status != models.LayawayOpen {
+			return newSaleError(409, "Layaway %s is %s", layaway.LayawayNumber, strings.ToLower(layaway.Status))
+		}
+		if req.Amount > layaway.BalanceDue && !sameAmount(req.Amount, layaway.BalanceDue) {
+			return newSaleError(400, "The balance on layaway %s is only %.2f", layaway.LayawayNumber, layaway.BalanceDue)
+		}
+		return payLayaway(tx, &layaway, req, &user)
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("Deposit of %.2f taken on layaway %s, balance %.2f", req.Amount, layaway.LayawayNumber, layaway.BalanceDue)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    layaway,
+	})
+}
+
+// payLayaway records a deposit on a locked layaway and completes it once
+// it is paid off
+func payLayaway(tx *gorm.DB, layaway *models.Layaway, deposit LayawayDepositRequest, user *models.User) error {
+	method, err := layawayMethod(deposit.Method)
+	if err != nil {
+		return err
+	}
+	if deposit.Amount <= 0 {
+		return newSaleError(400, "Deposit must be positive")
+	}
+
+	now := time.Now()
+	shiftID, err := shiftAt(tx, user.ID, now)
+	if err != nil {
+		return err
+	}
+	payment := models.LayawayPayment{
+		LayawayID:    layaway.ID,
+		BusinessID:   layaway.BusinessID,
+		ShiftID:      shiftID,
+		Kind:         models.LayawayDeposit,
+		Method:       method,
+		Amount:       roundMoney(deposit.Amount),
+		Reference:    strings.TrimSpace(deposit.Reference),
+		ReceivedByID: user.ID,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		return err
+	}
+	layaway.Payments = append(layaway.Payments, payment)
+
+	layaway.AmountPaid = roundMoney(layaway.AmountPaid + payment.Amount)
+	layaway.BalanceDue = roundMoney(layaway.TotalAmount - layaway.AmountPaid)
+	if sameAmount(layaway.BalanceDue, 0) {
+		layaway.BalanceDue = 0
+		if err := completeLayaway(tx, layaway, user); err != nil {
+			return err
+		}
+	}
+	return tx.Omit(clause.Associations).Save(layaway).Error
+}
+
+// completeLayaway sells a paid-off layaway through the normal sale path, so
+// its stock, receipts and reports work like any other sale. The sale is
+// made from the layaway's own lines and totals rather than priced again,
+// and the deposits, already taken, pay for it.
+func completeLayaway(tx *gorm.DB, layaway *models.Layaway, user *models.User) error {
+	var saleData SaleData
+	if err := json.Unmarshal([]byte(layaway.Payload), &saleData); err != nil {
+		return err
+	}
+	// Items line up with the requested products, whose notes they take, in
+	// the order they were created
+	sort.Slice(layaway.Items, func(i, j int) bool { return layaway.Items[i].ID < layaway.Items[j].ID })
+
+	saleData.Payments = layawayTenders(layaway.Payments)
+	saleData.ReferenceNumber = layaway.LayawayNumber
+	saleData.layaway = layaway
+
+	sale, err := recordSale(tx, saleData, user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	layaway.Status = models.LayawayCompleted
+	layaway.SaleID = &sale.ID
+	layaway.CompletedAt = &now
+	utils.InfoLogger("Layaway %s paid off and released as sale %s", layaway.LayawayNumber, sale.SaleNumber)
+	return nil
+}
+
+// layawayTenders pays for a completed layaway with its deposits, one tender
+// per method in the order the methods were first used
+func layawayTenders(payments []models.LayawayPayment) []PaymentRequest {
+	paid := make(map[string]float64)
+	var methods []string
+	for _, payment := range payments {
+		if payment.Kind != models.LayawayDeposit {
+			continue
+		}
+		if _, ok := paid[payment.Method]; !ok {
+			methods = append(methods, payment.Method)
+		}
+		paid[payment.Method] += payment.Amount
+	}
+	tenders := make([]PaymentRequest, 0, len(methods))
+	for _, method := range methods {
+		amount := roundMoney(paid[method])
+		tenders = append(tenders, PaymentRequest{Method: method, Amount: &amount})
+	}
+	return tenders
+}
+
+// layawaySaleItems makes the sale items for a paid-off layaway from its
+// stored lines, and gives the sale the layaway's totals.
+func layawaySaleItems(tx *gorm.DB, sale *models.Sale, saleData SaleData) ([]models.SaleItem, error) {
+	layaway := saleData.layaway
+	taxes, err := loadTaxRules(tx, sale.BusinessID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]models.SaleItem, 0, len(layaway.Items))
+	for i, line := range layaway.Items {
+		var product models.Product
+		if err := tx.First(&product, line.ProductID).Error; err != nil {
+			return nil, newSaleError(404, "Product %d not found in inventory", line.ProductID)
+		}
+
+		item := layawaySaleItem(line, product, taxes)
+		item.CreatedAt = sale.CreatedAt
+		if i < len(saleData.Products) {
+			item.Note = saleData.Products[i].Note
+		}
+		items = append(items, item)
+	}
+
+	sale.Subtotal = layaway.Subtotal
+	sale.DiscountTotal = layaway.DiscountTotal
+	sale.TaxTotal = layaway.TaxTotal
+	sale.TotalAmount = layaway.TotalAmount
+	return items, nil
+}
+
+// layawaySaleItem makes the sale item for one layaway line at its agreed
+// total. A line from before the VAT was kept has it worked out within that
+// total by the product's tax class.
+func layawaySaleItem(line models.LayawayItem, product models.Product, taxes *taxRules) models.SaleItem {
+	unitCost := product.CostPrice
+	item := models.SaleItem{
+		ProductID:     line.ProductID,
+		ProductName:   line.ProductName,
+		Quantity:      line.Quantity,
+		ListPrice:     line.ListPrice,
+		UnitPrice:     line.UnitPrice,
+		GrossAmount:   line.GrossAmount,
+		Discount:      line.Discount,
+		TaxCode:       line.TaxCode,
+		TaxRate:       line.TaxRate,
+		TaxableAmount: line.TaxableAmount,
+		TaxAmount:     line.TaxAmount,
+		LineTotal:     line.LineTotal,
+		UnitCost:      &unitCost,
+	}
+	if line.GrossAmount == 0 {
+		item.ListPrice = product.Price
+		item.GrossAmount = roundMoney(line.UnitPrice * float64(line.Quantity))
+		taxes.apply(&item, product.TaxClassID)
+		if item.TaxRate > 0 && !sameAmount(item.LineTotal, line.LineTotal) {
+			item.LineTotal = line.LineTotal
+			item.TaxAmount = roundMoney(line.LineTotal * item.TaxRate / (100 + item.TaxRate))
+			item.TaxableAmount = roundMoney(line.LineTotal - item.TaxAmount)
+		}
+	}
+	return item
+}
+
+// CancelLayaway cancels an open layaway and releases its stock. The
+// business keeps its forfeit share of the deposits and the rest is refunded.
+func (im *SalesManagementHandler) CancelLayaway(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req CancelLayawayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "A reason is required"})
+		return
+	}
+	if req.WaiveForfeit && !user.HasRole(models.RoleManager) {
+		c.JSON(403, gin.H{"error": "Only a manager can waive the forfeit"})
+		return
+	}
+
+	var layaway models.Layaway
+	err := im.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Items").Preload("Payments").
+			Where("business_id = ?", user.BusinessID).
+			First(&layaway, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Layaway not found")
+		}
+		if layaway.Status != models.LayawayOpen {
+			return newSaleError(409, "Layaway %s is %s", layaway.LayawayNumber, strings.ToLower(layaway.Status))
+		}
+
+		var business models.Business
+		if err := tx.First(&business, layaway.BusinessID).Error; err != nil {
+			return err
+		}
+		forfeit := roundMoney(layaway.AmountPaid * business.LayawayForfeitPercent / 100)
+		if req.WaiveForfeit {
+			forfeit = 0
+		}
+		refund := roundMoney(layaway.AmountPaid - forfeit)
+
+		now := time.Now()
+		if refund > 0 {
+			method, err := layawayMethod(req.RefundMethod)
+			if err != nil {
+				return err
+			}
+			shiftID, err := shiftAt(tx, user.ID, now)
+			if err != nil {
+				return err
+			}
+			payment := models.LayawayPayment{
+				LayawayID:    layaway.ID,
+				BusinessID:   layaway.BusinessID,
+				ShiftID:      shiftID,
+				Kind:         models.LayawayRefund,
+				Method:       method,
+				Amount:       refund,
+				Reference:    strings.TrimSpace(req.RefundReference),
+				ReceivedByID: user.ID,
+			}
+			if err := tx.Create(&payment).Error; err != nil {
+				return err
+			}
+			layaway.Payments = append(layaway.Payments, payment)
+		}
+
+		layaway.Status = models.LayawayCancelled
+		layaway.CancelledAt = &now
+		layaway.CancelledByID = &user.ID
+		layaway.CancelReason = strings.TrimSpace(req.Reason)
+		layaway.ForfeitedAmount = forfeit
+		layaway.RefundedAmount = refund
+		layaway.AmountPaid = forfeit
+		layaway.BalanceDue = 0
+		return tx.Omit(clause.Associations).Save(&layaway).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("Layaway %s cancelled by user %d: %.2f refunded, %.2f forfeited",
+		layaway.LayawayNumber, user.ID, layaway.RefundedAmount, layaway.ForfeitedAmount)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    layaway,
+	})
+}
+
+// GetLayaways lists layaway orders, open ones by default
+func (im *SalesManagementHandler) GetLayaways(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	query := im.db.Preload("Items").Preload("Payments").Where("business_id = ?", user.BusinessID)
+	status := strings.ToUpper(c.DefaultQuery("status", models.LayawayOpen))
+	if status != "ALL" {
+		query = query.Where("status = ?", status)
+	}
+	if customer := strings.TrimSpace(c.Query("customer")); customer != "" {
+		query = query.Where("customer_name LIKE ? OR customer_phone LIKE ?", "%"+customer+"%", "%"+customer+"%")
+	}
+	if c.Query("overdue") == "true" {
+		query = query.Where("status = ? AND due_date < ?", models.LayawayOpen, time.Now())
+	}
+
+	var layaways []models.Layaway
+	if err := query.Order("created_at DESC").Find(&layaways).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch layaways: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch layaways"})
+		return
+	}
+
+	c.JSON(200, layaways)
+}
+
+// GetLayaway returns one layaway with its items and payments
+func (im *SalesManagementHandler) GetLayaway(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var layaway models.Layaway
+	if err := im.db.Preload("Items").Preload("Payments").
+		Where("business_id = ?", user.BusinessID).
+		First(&layaway, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Layaway not found"})
+		return
+	}
+
+	c.JSON(200, layaway)
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestLayawayTenders(t *testing.T) {
+	deposit := func(method string, amount float64) models.LayawayPayment {
+		return models.LayawayPayment{Kind: models.LayawayDeposit, Method: method, Amount: amount}
+	}
+	payments := []models.LayawayPayment{
+		deposit(models.PaymentMpesa, 200),
+		deposit(models.PaymentCash, 100),
+		deposit(models.PaymentCash, 50.5),
+		{Kind: models.LayawayRefund, Method: models.PaymentCash, Amount: 30},
+	}
+
+	tenders := layawayTenders(payments)
+	if len(tenders) != 2 || tenders[0].Method != models.PaymentMpesa || *tenders[0].Amount != 200 ||
+		tenders[1].Method != models.PaymentCash || *tenders[1].Amount != 150.5 {
+		t.Fatalf("tenders = %+v, want MPESA 200.00 then CASH 150.50", tenders)
+	}
+
+	// The deposits pay for the completed sale exactly, with no change
+	sale, method, change, err := salePayments(SaleData{Payments: tenders}, 350.5)
+	if err != nil {
+		t.Fatalf("salePayments: %v", err)
+	}
+	if got := describePayments(sale); got != "MPESA 200.00, CASH 150.50" || method != models.PaymentSplit || change != 0 {
+		t.Errorf("sale paid %s as %s with %.2f change, want MPESA 200.00, CASH 150.50 as SPLIT with none", got, method, change)
+	}
+}
+
+func TestLayawaySaleItem(t *testing.T) {
+	standard := models.TaxClass{Code: models.TaxCodeStandard, Rate: models.StandardVATRate}
+	inclusive := &taxRules{registered: true, pricesIncluded: true, standard: standard}
+	exclusive := &taxRules{registered: true, standard: standard}
+	product := models.Product{ID: 7, Price: 120, CostPrice: 80}
+
+	tests := []struct {
+		name        string
+		line        models.LayawayItem
+		taxes       *taxRules
+		wantList    float64
+		wantGross   float64
+		wantCode    string
+		wantTaxable float64
+		wantTax     float64
+		wantTotal   float64
+	}{
+		{
+			name:  "line agreed with its VAT",
+			line:  models.LayawayItem{ProductID: 7, Quantity: 2, ListPrice: 110, UnitPrice: 100, GrossAmount: 200, Discount: 10, TaxCode: models.TaxCodeStandard, TaxRate: 16, TaxableAmount: 163.79, TaxAmount: 26.21, LineTotal: 190},
+			taxes: inclusive, wantList: 110, wantGross: 200, wantCode: models.TaxCodeStandard, wantTaxable: 163.79, wantTax: 26.21, wantTotal: 190,
+		},
+		{
+			name:  "older line has VAT worked out within its total",
+			line:  models.LayawayItem{ProductID: 7, Quantity: 1, UnitPrice: 116, LineTotal: 116},
+			taxes: inclusive, wantList: 120, wantGross: 116, wantCode: models.TaxCodeStandard, wantTaxable: 100, wantTax: 16, wantTotal: 116,
+		},
+		{
+			name:  "older line keeps its total when prices exclude VAT",
+			line:  models.LayawayItem{ProductID: 7, Quantity: 1, UnitPrice: 100, LineTotal: 100},
+			taxes: exclusive, wantList: 120, wantGross: 100, wantCode: models.TaxCodeStandard, wantTaxable: 86.21, wantTax: 13.79, wantTotal: 100,
+		},
+		{
+			name:  "older line for a business not registered for VAT",
+			line:  models.LayawayItem{ProductID: 7, Quantity: 2, UnitPrice: 50, LineTotal: 100},
+			taxes: &taxRules{}, wantList: 120, wantGross: 100, wantTaxable: 100, wantTotal: 100,
+		},
+	}
+	for _, tt := range tests {
+		item := layawaySaleItem(tt.line, product, tt.taxes)
+		if item.ListPrice != tt.wantList || item.GrossAmount != tt.wantGross || item.TaxCode != tt.wantCode ||
+			item.TaxableAmount != tt.wantTaxable || item.TaxAmount != tt.wantTax || item.LineTotal != tt.wantTotal {
+			t.Errorf("%s: list %.2f gross %.2f %q taxable %.2f tax %.2f total %.2f, want %.2f %.2f %q %.2f %.2f %.2f", tt.name,
+				item.ListPrice, item.GrossAmount, item.TaxCode, item.TaxableAmount, item.TaxAmount, item.LineTotal,
+				tt.wantList, tt.wantGross, tt.wantCode, tt.wantTaxable, tt.wantTax, tt.wantTotal)
+		}
+		if item.UnitCost == nil || *item.UnitCost != 80 {
+			t.Errorf("%s: unit cost %v, want the product's cost price", tt.name, item.UnitCost)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// syncLowStockAlert keeps the single open alert for a product in step with its
+// inventory. It raises an alert when stock is at or below the threshold,
+// refreshes the existing one instead of adding another, tracks how long the
+// product sat at zero and resolves the alert once stock recovers.
+func syncLowStockAlert(tx *gorm.DB, productName string, inventory models.Inventory) error {
+	now := time.Now()
+
+	var alert models.LowStockAlert
+	err := tx.Where("product_id = ? AND status <> ?", inventory.ProductID, models.AlertStatusResolved).
+		Order("id DESC").
+		First(&alert).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	hasOpen := err == nil
+
+	if inventory.Quantity > inventory.LowStockThreshold {
+		if !hasOpen {
+			return nil
+		}
+		resolveLowStockAlert(&alert, now, fmt.Sprintf("Restocked to %d units", inventory.Quantity))
+		if err := tx.Save(&alert).Error; err != nil {
+			return err
+		}
+		events.Queue(tx.Statement.Context, events.AlertResolved, alert)
+		return nil
+	}
+
+	message := fmt.Sprintf("Low stock alert for %s: %d units remaining (threshold: %d)",
+		productName, inventory.Quantity, inventory.LowStockThreshold)
+
+	if !hasOpen {
+		productID := inventory.ProductID
+		alert = models.LowStockAlert{
+			ProductID:      inventory.ProductID,
+			OpenKey:        &productID,
+			AlertMessage:   message,
+			Status:         models.AlertStatusOpen,
+			LowestQuantity: inventory.Quantity,
+		}
+		if inventory.Quantity <= 0 {
+			alert.OutOfStockSince = &now
+		}
+		if err := tx.Create(&alert).Error; err != nil {
+			return err
+		}
+		events.Queue(tx.Statement.Context, events.AlertRaised, alert)
+		return nil
+	}
+
+	alert.AlertMessage = message
+	if inventory.Quantity < alert.LowestQuantity {
+		alert.LowestQuantity = inventory.Quantity
+	}
+	if inventory.Quantity <= 0 && alert.OutOfStockSince == nil {
+		alert.OutOfStockSince = &now
+	} else if inventory.Quantity > 0 && alert.OutOfStockSince != nil {
+		alert.OutOfStockSeconds += int64(now.Sub(*alert.OutOfStockSince).Seconds())
+		alert.OutOfStockSince = nil
+	}
+	return tx.Save(&alert).Error
+}
+
+// resolveLowStockAlert closes an alert, folding any running out-of-stock
+// period into the total and releasing the open-alert slot for the product.
+func resolveLowStockAlert(alert *models.LowStockAlert, at time.Time, note string) {
+	if alert.OutOfStockSince != nil {
+		alert.OutOfStockSeconds += int64(at.Sub(*alert.OutOfStockSince).Seconds())
+		alert.OutOfStockSince = nil
+	}
+	alert.Status = models.AlertStatusResolved
+	alert.Resolved = true
+	alert.ResolvedAt = &at
+	alert.ResolutionNote = note
+	alert.OpenKey = nil
+}
+
+func (im *InventoryManagementHandler) AcknowledgeLowStockAlert(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	id := c.Param("id")
+
+	var alert models.LowStockAlert
+	if err := im.db.First(&alert, id).Error; err != nil {
+		utils.WarningLogger("Low stock alert not found: %v", err)
+		c.JSON(404, gin.H{"error": "Alert not found"})
+		return
+	}
+	if alert.Status == models.AlertStatusResolved {
+		c.JSON(400, gin.H{"error": "Alert is already resolved"})
+		return
+	}
+
+	now := time.Now()
+	alert.Status = models.AlertStatusAcknowledged
+	alert.AcknowledgedAt = &now
+	alert.AcknowledgedByID = &user.ID
+	alert.SnoozedUntil = nil
+
+	if err := im.db.Save(&alert).Error; err != nil {
+		utils.ErrorLogger("Failed to acknowledge alert %s: %v", id, err)
+		c.JSON(500, gin.H{"error": "Failed to acknowledge alert"})
+		return
+	}
+
+	utils.InfoLogger("User %d acknowledged low stock alert %s", user.ID, id)
+	c.JSON(200, alert)
+}
+
+func (im *InventoryManagementHandler) SnoozeLowStockAlert(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	id := c.Param("id")
+
+	var input struct {
+		Minutes int        `json:"minutes"`
+		Until   *time.Time `json:"until"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ErrorLogger("Failed to parse snooze request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	now := time.Now()
+	var until time.Time
+	switch {
+	case input.Until != nil:
+		until = *input.Until
+	case input.Minutes > 0:
+		until = now.Add(time.Duration(input.Minutes) * time.Minute)
+	default:
+		c.JSON(400, gin.H{"error": "Either minutes or until is required"})
+		return
+	}
+	if !until.After(now) {
+		c.JSON(400, gin.H{"error": "Snooze time must be in the future"})
+		return
+	}
+
+	var alert models.LowStockAlert
+	if err := im.db.First(&alert, id).Error; err != nil {
+		utils.WarningLogger("Low stock alert not found: %v", err)
+		c.JSON(404, gin.H{"error": "Alert not found"})
+		return
+	}
+	if alert.Status == models.AlertStatusResolved {
+		c.JSON(400, gin.H{"error": "Alert is already resolved"})
+		return
+	}
+
+	alert.Status = models.AlertStatusSnoozed
+	alert.SnoozedUntil = &until
+	alert.SnoozedByID = &user.ID
+
+	if err := im.db.Save(&alert).Error; err != nil {
+		utils.ErrorLogger("Failed to snooze alert %s: %v", id, err)
+		c.JSON(500, gin.H{"error": "Failed to snooze alert"})
+		return
+	}
+
+	utils.InfoLogger("User %d snoozed low stock alert %s until %s", user.ID, id, until.Format(time.RFC3339))
+	c.JSON(200, alert)
+}
+
+func (im *InventoryManagementHandler) ResolveLowStockAlert(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	id := c.Param("id")
+
+	var input struct {
+		Note string `json:"note"`
+	}
+	// The note is optional, so an empty body is fine
+	_ = c.ShouldBindJSON(&input)
+
+	var alert models.LowStockAlert
+	if err := im.db.First(&alert, id).Error; err != nil {
+		utils.WarningLogger("Low stock alert not found: %v", err)
+		c.JSON(404, gin.H{"error": "Alert not found"})
+		return
+	}
+	if alert.Status == models.AlertStatusResolved {
+		c.JSON(400, gin.H{"error": "Alert is already resolved"})
+		return
+	}
+
+	note := input.Note
+	if note == "" {
+		note = "Resolved manually"
+	}
+	resolveLowStockAlert(&alert, time.Now(), note)
+	alert.ResolvedByID = &user.ID
+
+	if err := im.db.Save(&alert).Error; err != nil {
+		utils.ErrorLogger("Failed to resolve alert %s: %v", id, err)
+		c.JSON(500, gin.H{"error": "Failed to resolve alert"})
+		return
+	}
+
+	utils.InfoLogger("User %d resolved low stock alert %s", user.ID, id)
+	c.JSON(200, alert)
+}
+
+// GetLowStockAlertHistory lists resolved alerts, optionally for one product,
+// with how long each stayed open and how long the product was out of stock.
+func (im *InventoryManagementHandler) GetLowStockAlertHistory(c *gin.Context) {
+	var alerts []struct {
+		models.LowStockAlert
+		ProductName string `json:"product_name"`
+	}
+
+	query := im.db.Table("low_stock_alerts").
+		Select("low_stock_alerts.*, products.name as product_name").
+		Joins("JOIN products ON low_stock_alerts.product_id = products.id").
+		Where("low_stock_alerts.status = ?", models.AlertStatusResolved).
+		Order("low_stock_alerts.resolved_at DESC")
+
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("low_stock_alerts.product_id = ?", productID)
+	}
+
+	if err := query.Find(&alerts).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch alert history: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch alert history"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(alerts))
+	for _, alert := range alerts {
+		var openSeconds int64
+		if alert.ResolvedAt != nil {
+			openSeconds = int64(alert.ResolvedAt.Sub(alert.CreatedAt).Seconds())
+		}
+		result = append(result, gin.H{
+			"alert":                alert.LowStockAlert,
+			"product_name":         alert.ProductName,
+			"open_seconds":         openSeconds,
+			"out_of_stock_seconds": alert.OutOfStockSeconds,
+		})
+	}
+
+	c.JSON(200, result)
+}
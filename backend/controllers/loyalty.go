@@ -0,0 +1,621 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/mpesa"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoyaltyHandler struct {
+	db *gorm.DB
+}
+
+func NewLoyaltyHandler(db *gorm.DB) *LoyaltyHandler {
+	return &LoyaltyHandler{db: db}
+}
+
+type LoyaltyRuleRequest struct {
+	Kind       string  `json:"kind" binding:"required"`
+	Label      string  `json:"label"`
+	Category   string  `json:"category"`
+	Weekday    *int    `json:"weekday"`
+	Date       string  `json:"date"`
+	Multiplier float64 `json:"multiplier" binding:"required"`
+}
+
+type LoyaltyAdjustmentRequest struct {
+	Points int    `json:"points" binding:"required"`
+	Note   string `json:"note" binding:"required"`
+}
+
+// earnMultipliers returns the category multipliers and the multiplier for
+// the day of at. When several rules match, the largest wins.
+func earnMultipliers(tx *gorm.DB, businessID uint, at time.Time) (map[string]float64, float64, error) {
+	var rules []models.LoyaltyRule
+	if err := tx.Where("business_id = ? AND active = ?", businessID, true).Find(&rules).Error; err != nil {
+		return nil, 0, err
+	}
+
+	categories := make(map[string]float64)
+	day := 1.0
+	for _, rule := range rules {
+		switch rule.Kind {
+		case models.LoyaltyRuleCategory:
+			key := strings.ToLower(rule.Category)
+			categories[key] = math.Max(categories[key], rule.Multiplier)
+		case models.LoyaltyRuleWeekday:
+			if rule.Weekday != nil && time.Weekday(*rule.Weekday) == at.Weekday() {
+				day = math.Max(day, rule.Multiplier)
+			}
+		case models.LoyaltyRuleDate:
+			if rule.Date != nil && rule.Date.Format(dateLayout) == at.Format(dateLayout) {
+				day = math.Max(day, rule.Multiplier)
+			}
+		}
+	}
+	return categories, day, nil
+}
+
+// addPoints credits points to a customer as a new batch that expires after
+// the business's expiry period
+func addPoints(tx *gorm.DB, business *models.Business, entry models.LoyaltyEntry) (*models.LoyaltyEntry, error) {
+	entry.BusinessID = business.ID
+	entry.Remaining = entry.Points
+	if business.LoyaltyExpiryDays > 0 {
+		expires := time.Now().AddDate(0, 0, business.LoyaltyExpiryDays)
+		entry.ExpiresAt = &expires
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Customer{}).Where("id = ?", entry.CustomerID).
+		Update("loyalty_points", gorm.Expr("loyalty_points + ?", entry.Points)).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// takePoints takes up to entry's points off a customer, using up the
+// batches that expire soonest first. It records and returns the points
+// actually taken, which is fewer when the balance is short.
+func takePoints(tx *gorm.DB, entry models.LoyaltyEntry) (int, error) {
+	var batches []models.LoyaltyEntry
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("customer_id = ? AND remaining > 0", entry.CustomerID).
+		Order("expires_at IS NULL").Order("expires_at").Order("id").
+		Find(&batches).Error; err != nil {
+		return 0, err
+	}
+
+	wanted, taken := entry.Points, 0
+	for i := range batches {
+		if taken >= wanted {
+			break
+		}
+		used := min(batches[i].Remaining, wanted-taken)
+		if err := tx.Model(&batches[i]).Update("remaining", batches[i].Remaining-used).Error; err != nil {
+			return 0, err
+		}
+		taken += used
+	}
+	if taken == 0 {
+		return 0, nil
+	}
+
+	entry.Points = -taken
+	if err := tx.Create(&entry).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&models.Customer{}).Where("id = ?", entry.CustomerID).
+		Update("loyalty_points", gorm.Expr("loyalty_points - ?", taken)).Error; err != nil {
+		return 0, err
+	}
+	return taken, nil
+}
+
+// expireCustomerPoints expires the customer's batches that are past their
+// expiry date
+func expireCustomerPoints(tx *gorm.DB, customerID uint, now time.Time) error {
+	var batches []models.LoyaltyEntry
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("customer_id = ? AND remaining > 0 AND expires_at <= ?", customerID, now).
+		Order("id").
+		Find(&batches).Error; err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		expired := models.LoyaltyEntry{
+			BusinessID: batch.BusinessID,
+			CustomerID: customerID,
+			Kind:       models.LoyaltyExpire,
+			Points:     -batch.Remaining,
+			Note:       fmt.Sprintf("Points added on %s expired", batch.CreatedAt.Format(dateLayout)),
+		}
+		if err := tx.Create(&expired).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&batch).Update("remaining", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Customer{}).Where("id = ?", customerID).
+			Update("loyalty_points", gorm.Expr("loyalty_points - ?", batch.Remaining)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redeemPoints pays for a POINTS tender out of the sale customer's points,
+// noting the points used on the payment
+func redeemPoints(tx *gorm.DB, sale *models.Sale, payment *models.SalePayment) error {
+	var business models.Business
+	if err := tx.First(&business, sale.BusinessID).Error; err != nil {
+		return err
+	}
+	if !business.LoyaltyEnabled || business.LoyaltyPointValue <= 0 {
+		return newSaleError(400, "Loyalty points are not accepted")
+	}
+	if sale.CustomerID == nil {
+		return newSaleError(400, "Points can only be redeemed for a customer; give their phone number")
+	}
+
+	points := int(math.Ceil(payment.Amount/business.LoyaltyPointValue - 1e-9))
+	if points < business.LoyaltyMinRedeemPoints {
+		return newSaleError(400, "At least %d points must be redeemed at a time", business.LoyaltyMinRedeemPoints)
+	}
+
+	var customer models.Customer
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&customer, *sale.CustomerID).Error; err != nil {
+		return err
+	}
+	if err := expireCustomerPoints(tx, customer.ID, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.First(&customer, customer.ID).Error; err != nil {
+		return err
+	}
+	if customer.LoyaltyPoints < points {
+		return newSaleError(400, "Customer has %d points but %d are needed for %.2f", customer.LoyaltyPoints, points, payment.Amount)
+	}
+
+	if _, err := takePoints(tx, models.LoyaltyEntry{
+		BusinessID: sale.BusinessID,
+		CustomerID: customer.ID,
+		Kind:       models.LoyaltyRedeem,
+		Points:     points,
+		SaleID:     &sale.ID,
+		Note:       fmt.Sprintf("Redeemed on sale %s", sale.SaleNumber),
+	}); err != nil {
+		return err
+	}
+	payment.Reference = fmt.Sprintf("%d points", points)
+	return nil
+}
+
+// earnPoints credits the sale customer with the points the sale earns. No
+// points are earned on the part of the sale paid for with points.
+func earnPoints(tx *gorm.DB, sale *models.Sale) error {
+	if sale.CustomerID == nil || sale.TotalAmount <= 0 {
+		return nil
+	}
+	var business models.Business
+	if err := tx.First(&business, sale.BusinessID).Error; err != nil {
+		return err
+	}
+	if !business.LoyaltyEnabled || business.LoyaltyPointsPerShilling <= 0 {
+		return nil
+	}
+
+	share := 1 - tenderTotal(sale, models.PaymentPoints)/sale.TotalAmount
+	if share <= 0 {
+		return nil
+	}
+
+	categories, dayMultiplier, err := earnMultipliers(tx, sale.BusinessID, sale.CreatedAt)
+	if err != nil {
+		return err
+	}
+	productIDs := make([]uint, 0, len(sale.Items))
+	for _, item := range sale.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var products []models.Product
+	if err := tx.Select("id", "category").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+		return err
+	}
+	categoryOf := make(map[uint]string, len(products))
+	for _, product := range products {
+		categoryOf[product.ID] = strings.ToLower(product.Category)
+	}
+
+	var earned float64
+	for _, item := range sale.Items {
+		multiplier := 1.0
+		if m, ok := categories[categoryOf[item.ProductID]]; ok {
+			multiplier = m
+		}
+		earned += item.LineTotal * share * business.LoyaltyPointsPerShilling * multiplier
+	}
+	points := int(math.Floor(earned*dayMultiplier + 1e-9))
+	if points <= 0 {
+		return nil
+	}
+
+	_, err = addPoints(tx, &business, models.LoyaltyEntry{
+		CustomerID: *sale.CustomerID,
+		Kind:       models.LoyaltyEarn,
+		Points:     points,
+		SaleID:     &sale.ID,
+		Note:       fmt.Sprintf("Earned on sale %s", sale.SaleNumber),
+	})
+	return err
+}
+
+// refundPoints gives a return's refund back to the customer as points
+func refundPoints(tx *gorm.DB, sale *models.Sale, saleReturn *models.SaleReturn) error {
+	var business models.Business
+	if err := tx.First(&business, sale.BusinessID).Error; err != nil {
+		return err
+	}
+	if business.LoyaltyPointValue <= 0 {
+		return newSaleError(400, "Loyalty points are not accepted")
+	}
+
+	points := int(math.Round(saleReturn.RefundAmount / business.LoyaltyPointValue))
+	if points > 0 {
+		if _, err := addPoints(tx, &business, models.LoyaltyEntry{
+			CustomerID:   *sale.CustomerID,
+			Kind:         models.LoyaltyRefund,
+			Points:       points,
+			SaleID:       &sale.ID,
+			SaleReturnID: &saleReturn.ID,
+			CreatedByID:  &saleReturn.ProcessedByID,
+			Note:         fmt.Sprintf("Refunded on return %s", saleReturn.ReturnNumber),
+		}); err != nil {
+			return err
+		}
+	}
+	saleReturn.RefundReference = fmt.Sprintf("%d points", points)
+	return nil
+}
+
+// reverseEarnedPoints takes back the points a sale earned on goods since
+// returned. It works from the sale's running returned total so repeated
+// partial returns take back the right amount overall. Points already spent
+// cannot be taken back.
+func reverseEarnedPoints(tx *gorm.DB, sale *models.Sale, saleReturn *models.SaleReturn) error {
+	if sale.CustomerID == nil || sale.TotalAmount <= 0 {
+		return nil
+	}
+
+	var totals struct {
+		Earned   int
+		Reversed int
+	}
+	if err := tx.Model(&models.LoyaltyEntry{}).
+		Select("COALESCE(SUM(CASE WHEN kind = ? THEN points ELSE 0 END), 0) AS earned, "+
+			"COALESCE(SUM(CASE WHEN kind = ? THEN -points ELSE 0 END), 0) AS reversed",
+			models.LoyaltyEarn, models.LoyaltyReverse).
+		Where("sale_id = ?", sale.ID).
+		Scan(&totals).Error; err != nil {
+		return err
+	}
+	if totals.Earned <= 0 {
+		return nil
+	}
+
+	due := int(math.Round(float64(totals.Earned)*math.Min(sale.ReturnedTotal/sale.TotalAmount, 1))) - totals.Reversed
+	if due <= 0 {
+		return nil
+	}
+	_, err := takePoints(tx, models.LoyaltyEntry{
+		BusinessID:   sale.BusinessID,
+		CustomerID:   *sale.CustomerID,
+		Kind:         models.LoyaltyReverse,
+		Points:       due,
+		SaleID:       &sale.ID,
+		SaleReturnID: &saleReturn.ID,
+		Note:         fmt.Sprintf("Taken back on return %s", saleReturn.ReturnNumber),
+	})
+	return err
+}
+
+// GetLoyaltyBalance looks a customer's points up by phone number, expiring
+// any that are due first
+func (lh *LoyaltyHandler) GetLoyaltyBalance(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	phone, err := mpesa.NormalizePhoneNumber(c.Query("phone"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A valid phone number is required"})
+		return
+	}
+
+	var business models.Business
+	if err := lh.db.First(&business, user.BusinessID).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch business %d: %v", user.BusinessID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch loyalty balance"})
+		return
+	}
+
+	var customer *models.Customer
+	err = lh.db.Transaction(func(tx *gorm.DB) error {
+		found, err := findCustomerByPhone(tx, user.BusinessID, phone)
+		if err != nil || found == nil {
+			return err
+		}
+		if err := expireCustomerPoints(tx, found.ID, time.Now()); err != nil {
+			return err
+		}
+		customer = &models.Customer{}
+		return tx.First(customer, found.ID).Error
+	})
+	if err != nil {
+		utils.ErrorLogger("Failed to fetch loyalty balance: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch loyalty balance"})
+		return
+	}
+	if customer == nil {
+		c.JSON(404, gin.H{"error": "No customer has this phone number"})
+		return
+	}
+
+	var next struct {
+		Points    int
+		ExpiresAt *time.Time
+	}
+	if err := lh.db.Model(&models.LoyaltyEntry{}).
+		Select("remaining AS points, expires_at").
+		Where("customer_id = ? AND remaining > 0 AND expires_at IS NOT NULL", customer.ID).
+		Order("expires_at").
+		Limit(1).
+		Scan(&next).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch loyalty balance: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch loyalty balance"})
+		return
+	}
+
+	response := gin.H{
+		"customer_id": customer.ID,
+		"name":        customer.Name,
+		"phone":       customer.Phone,
+		"points":      customer.LoyaltyPoints,
+		"value":       roundMoney(float64(customer.LoyaltyPoints) * business.LoyaltyPointValue),
+	}
+	if next.ExpiresAt != nil {
+		response["next_expiry"] = gin.H{"points": next.Points, "expires_at": next.ExpiresAt}
+	}
+	c.JSON(200, response)
+}
+
+// GetLoyaltyLedger lists a customer's points entries, newest first
+func (lh *LoyaltyHandler) GetLoyaltyLedger(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var customer models.Customer
+	if err := lh.db.Where("business_id = ?", user.BusinessID).First(&customer, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	var entries []models.LoyaltyEntry
+	if err := lh.db.Where("customer_id = ?", customer.ID).
+		Order("created_at DESC").Order("id DESC").
+		Find(&entries).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch loyalty ledger for customer %d: %v", customer.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch loyalty ledger"})
+		return
+	}
+
+	c.JSON(200, gin.H{"customer_id": customer.ID, "points": customer.LoyaltyPoints, "entries": entries})
+}
+
+// AdjustLoyaltyPoints adds or takes off points by hand, for example to make
+// good a missed purchase
+func (lh *LoyaltyHandler) AdjustLoyaltyPoints(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req LoyaltyAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Note) == "" {
+		c.JSON(400, gin.H{"error": "Points and a note are required"})
+		return
+	}
+
+	var customer models.Customer
+	err := lh.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&customer, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Customer not found")
+		}
+		if customer.MergedIntoID != nil {
+			return newSaleError(409, "Customer was merged into customer %d", *customer.MergedIntoID)
+		}
+
+		entry := models.LoyaltyEntry{
+			BusinessID:  user.BusinessID,
+			CustomerID:  customer.ID,
+			Kind:        models.LoyaltyAdjust,
+			Points:      req.Points,
+			Note:        strings.TrimSpace(req.Note),
+			CreatedByID: &user.ID,
+		}
+		if req.Points > 0 {
+			var business models.Business
+			if err := tx.First(&business, user.BusinessID).Error; err != nil {
+				return err
+			}
+			if _, err := addPoints(tx, &business, entry); err != nil {
+				return err
+			}
+		} else {
+			if customer.LoyaltyPoints < -req.Points {
+				return newSaleError(400, "Customer only has %d points", customer.LoyaltyPoints)
+			}
+			entry.Points = -req.Points
+			if _, err := takePoints(tx, entry); err != nil {
+				return err
+			}
+		}
+		return tx.First(&customer, customer.ID).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d adjusted customer %d's points by %d", user.ID, customer.ID, req.Points)
+	c.JSON(200, gin.H{"success": true, "data": customer})
+}
+
+func (lh *LoyaltyHandler) GetLoyaltyRules(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var rules []models.LoyaltyRule
+	if err := lh.db.Where("business_id = ? AND active = ?", user.BusinessID, true).
+		Order("kind").Order("id").
+		Find(&rules).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch loyalty rules: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch loyalty rules"})
+		return
+	}
+
+	c.JSON(200, rules)
+}
+
+func (lh *LoyaltyHandler) CreateLoyaltyRule(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req LoyaltyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Kind and multiplier are required"})
+		return
+	}
+	if req.Multiplier <= 0 {
+		c.JSON(400, gin.H{"error": "Multiplier must be positive"})
+		return
+	}
+
+	rule := models.LoyaltyRule{
+		BusinessID:  user.BusinessID,
+		Kind:        strings.ToUpper(req.Kind),
+		Label:       strings.TrimSpace(req.Label),
+		Multiplier:  req.Multiplier,
+		Active:      true,
+		CreatedByID: user.ID,
+	}
+	switch rule.Kind {
+	case models.LoyaltyRuleCategory:
+		rule.Category = strings.TrimSpace(req.Category)
+		if rule.Category == "" {
+			c.JSON(400, gin.H{"error": "A category rule needs a category"})
+			return
+		}
+	case models.LoyaltyRuleWeekday:
+		if req.Weekday == nil || *req.Weekday < 0 || *req.Weekday > 6 {
+			c.JSON(400, gin.H{"error": "A weekday rule needs a weekday from 0 (Sunday) to 6 (Saturday)"})
+			return
+		}
+		rule.Weekday = req.Weekday
+	case models.LoyaltyRuleDate:
+		date, err := time.ParseInLocation(dateLayout, req.Date, time.Now().Location())
+		if err != nil {
+			c.JSON(400, gin.H{"error": "A date rule needs a date, expected YYYY-MM-DD"})
+			return
+		}
+		rule.Date = &date
+	default:
+		c.JSON(400, gin.H{"error": "Kind must be CATEGORY, WEEKDAY or DATE"})
+		return
+	}
+
+	if err := lh.db.Create(&rule).Error; err != nil {
+		utils.ErrorLogger("Failed to create loyalty rule: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create loyalty rule"})
+		return
+	}
+
+	utils.InfoLogger("User %d created %s loyalty rule %d", user.ID, rule.Kind, rule.ID)
+	c.JSON(201, gin.H{"success": true, "data": rule})
+}
+
+func (lh *LoyaltyHandler) EndLoyaltyRule(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	result := lh.db.Model(&models.LoyaltyRule{}).
+		Where("id = ? AND business_id = ? AND active = ?", c.Param("id"), user.BusinessID, true).
+		Update("active", false)
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to end loyalty rule: %v", result.Error)
+		c.JSON(500, gin.H{"error": "Failed to end loyalty rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Loyalty rule not found"})
+		return
+	}
+
+	utils.InfoLogger("User %d ended loyalty rule %s", user.ID, c.Param("id"))
+	c.JSON(200, gin.H{"success": true})
+}
+
+// LoyaltyExpirer expires points that are past their expiry date
+type LoyaltyExpirer struct {
+	db       *gorm.DB
+	interval time.Duration
+}
+
+func NewLoyaltyExpirer(db *gorm.DB) *LoyaltyExpirer {
+	return &LoyaltyExpirer{db: db, interval: time.Hour}
+}
+
+// Run expires points until the context is cancelled
+func (e *LoyaltyExpirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.expireDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LoyaltyExpirer) expireDue() {
+	now := time.Now()
+	var customerIDs []uint
+	if err := e.db.Model(&models.LoyaltyEntry{}).
+		Where("remaining > 0 AND expires_at <= ?", now).
+		Distinct().Pluck("customer_id", &customerIDs).Error; err != nil {
+		utils.ErrorLogger("Failed to find expired loyalty points: %v", err)
+		return
+	}
+
+	for _, customerID := range customerIDs {
+		err := e.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Customer{}, customerID).Error; err != nil {
+				return err
+			}
+			return expireCustomerPoints(tx, customerID, now)
+		})
+		if err != nil {
+			utils.ErrorLogger("Failed to expire loyalty points for customer %d: %v", customerID, err)
+		}
+	}
+	if len(customerIDs) > 0 {
+		utils.InfoLogger("Expired loyalty points for %d customers", len(customerIDs))
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 
+	"github.com/OAthooh/BiasharaTrack.git/events"
 	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/OAthooh/BiasharaTrack.git/mpesa"
 	"github.com/OAthooh/BiasharaTrack.git/utils"
@@ -61,6 +62,16 @@ func (h *MpesaHandler) InitiatePayment(c *gin.Context) {
 		return
 	}
 
+	// The push is recorded before its callback arrives, so it must fit
+	if len(req.PhoneNumber) > 15 || len(req.Reference) > 50 || len(req.Description) > 100 {
+		c.JSON(400, gin.H{
+			"success": false,
+			"message": "Invalid request payload",
+			"error":   "Phone number, reference and description must be at most 15, 50 and 100 characters",
+		})
+		return
+	}
+
 	utils.InfoLogger("Initiating STK push for phone: %s, amount: %.2f", req.PhoneNumber, req.Amount)
 
 	// Log the request for debugging
@@ -86,6 +97,26 @@ func (h *MpesaHandler) InitiatePayment(c *gin.Context) {
 	// Log the response for debugging
 	fmt.Printf("M-PESA Response: %+v\n", resp)
 
+	// Record the push so its callback can be matched to the business
+	businessID, err := businessIDFor(c, h.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business for payment %s: %v", resp.CheckoutRequestID, err)
+	}
+	pending := models.MpesaTransaction{
+		ID:                utils.GenerateUUID(),
+		BusinessID:        businessID,
+		MerchantRequestID: resp.MerchantRequestID,
+		CheckoutRequestID: resp.CheckoutRequestID,
+		Amount:            req.Amount,
+		PhoneNumber:       req.PhoneNumber,
+		Reference:         req.Reference,
+		Description:       req.Description,
+		Status:            "PENDING",
+	}
+	if err := h.db.Create(&pending).Error; err != nil {
+		utils.ErrorLogger("Failed to record payment %s: %v", resp.CheckoutRequestID, err)
+	}
+
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "Payment initiated successfully",
@@ -114,14 +145,26 @@ func (h *MpesaHandler) HandleCallback(c *gin.Context) {
 	// Extract the STK callback data
 	stkCallback := callback.Body.STKCallback
 
-	// Create transaction record
-	transaction := models.MpesaTransaction{
-		ID:                utils.GenerateUUID(),
-		MerchantRequestID: stkCallback.MerchantRequestID,
-		CheckoutRequestID: stkCallback.CheckoutRequestID,
-		ResultCode:        stkCallback.ResultCode,
-		Status:            "PENDING",
+	// Only callbacks for pushes this server started are accepted, so a
+	// forged callback cannot mark anything paid
+	var transaction models.MpesaTransaction
+	if err := h.db.Where("checkout_request_id = ?", stkCallback.CheckoutRequestID).First(&transaction).Error; err != nil {
+		utils.WarningLogger("Ignoring M-Pesa callback for unknown checkout request %s", stkCallback.CheckoutRequestID)
+		c.JSON(200, gin.H{
+			"status":  "ignored",
+			"message": "Unknown checkout request",
+		})
+		return
+	}
+	if transaction.Status != "PENDING" || transaction.MerchantRequestID != stkCallback.MerchantRequestID {
+		utils.WarningLogger("Ignoring repeated M-Pesa callback for checkout request %s", stkCallback.CheckoutRequestID)
+		c.JSON(200, gin.H{
+			"status":  "ignored",
+			"message": "Callback already processed",
+		})
+		return
 	}
+	transaction.ResultCode = stkCallback.ResultCode
 
 	// Process successful transaction
 	if stkCallback.ResultCode == 0 {
@@ -135,8 +178,8 @@ func (h *MpesaHandler) HandleCallback(c *gin.Context) {
 					transaction.Amount = amount
 				}
 			case "MpesaReceiptNumber":
-				if receipt, ok := item.Value.(string); ok {
-					transaction.ReceiptNumber = receipt
+				if receipt, ok := item.Value.(string); ok && receipt != "" {
+					transaction.ReceiptNumber = &receipt
 				}
 			case "TransactionDate":
 				if date, ok := item.Value.(string); ok {
@@ -153,12 +196,14 @@ func (h *MpesaHandler) HandleCallback(c *gin.Context) {
 	}
 
 	// Save transaction to database
-	if err := h.db.Create(&transaction).Error; err != nil {
+	if err := h.db.Save(&transaction).Error; err != nil {
 		utils.ErrorLogger("Failed to save transaction: %v", err)
 		c.JSON(500, gin.H{"error": "Failed to process callback"})
 		return
 	}
 
+	h.publishCallback(&transaction)
+
 	utils.InfoLogger("Successfully processed M-Pesa callback for transaction: %s", transaction.ID)
 	c.JSON(200, gin.H{
 		"status":  "success",
@@ -166,6 +211,27 @@ func (h *MpesaHandler) HandleCallback(c *gin.Context) {
 	})
 }
 
+// publishCallback tells the dashboards of the business that started the
+// STK push how it ended
+func (h *MpesaHandler) publishCallback(transaction *models.MpesaTransaction) {
+	if transaction.BusinessID == 0 {
+		return
+	}
+	eventType := events.PaymentConfirmed
+	if transaction.Status != "SUCCESS" {
+		eventType = events.PaymentFailed
+	}
+	events.Publish(transaction.BusinessID, eventType, gin.H{
+		"checkout_request_id": transaction.CheckoutRequestID,
+		"merchant_request_id": transaction.MerchantRequestID,
+		"result_code":         transaction.ResultCode,
+		"status":              transaction.Status,
+		"amount":              transaction.Amount,
+		"receipt_number":      transaction.ReceiptNumber,
+		"phone_number":        transaction.PhoneNumber,
+	})
+}
+
 // Add this new endpoint
 func (h *MpesaHandler) GetPaymentStatus(c *gin.Context) {
 	reference := c.Param("reference")
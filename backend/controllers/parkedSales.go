@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activeParkedStatuses are the statuses of a parked sale still waiting to be
+// finished
+var activeParkedStatuses = []string{models.ParkedSaleParked, models.ParkedSaleResumed}
+
+type ParkedSaleHandler struct {
+	db *gorm.DB
+	// hold is how long a parked sale, and any stock it reserves, is kept
+	// before it expires
+	hold time.Duration
+}
+
+func NewParkedSaleHandler(db *gorm.DB, hold time.Duration) *ParkedSaleHandler {
+	return &ParkedSaleHandler{db: db, hold: hold}
+}
+
+type ParkSaleRequest struct {
+	SaleData
+	// Till names the counter the sale was parked at, so each till can list
+	// its own baskets
+	Till  string `json:"till"`
+	Label string `json:"label"`
+	// ReserveStock holds the items back from other sales until the parked
+	// sale is finished or expires
+	ReserveStock bool `json:"reserve_stock"`
+}
+
+// reservedStock is how much of a product parked sales and open layaways
+// are holding back. A layaway's reservation lapses on its due date, like a
+// parked sale's at its expiry. The parked sale or layaway a sale is
+// completing is left out, since its reservation is what the sale takes.
+func reservedStock(tx *gorm.DB, productID uint, at time.Time, parked *models.ParkedSale, layaway *models.Layaway) (int, error) {
+	parkedQuery := tx.Table("parked_sale_items").
+		Select("COALESCE(SUM(parked_sale_items.quantity), 0)").
+		Joins("JOIN parked_sales ON parked_sales.id = parked_sale_items.parked_sale_id").
+		Where("parked_sale_items.product_id = ?", productID).
+		Where("parked_sales.reserved = ? AND parked_sales.status IN ? AND parked_sales.expires_at > ?", true, activeParkedStatuses, at)
+	if parked != nil {
+		parkedQuery = parkedQuery.Where("parked_sales.id <> ?", parked.ID)
+	}
+	var reservedParked int
+	if err := parkedQuery.Scan(&reservedParked).Error; err != nil {
+		return 0, err
+	}
+
+	layawayQuery := tx.Table("layaway_items").
+		Select("COALESCE(SUM(layaway_items.quantity), 0)").
+		Joins("JOIN layaways ON layaways.id = layaway_items.layaway_id").
+		Where("layaway_items.product_id = ? AND layaways.status = ? AND layaways.due_date > ?", productID, models.LayawayOpen, at)
+	if layaway != nil {
+		layawayQuery = layawayQuery.Where("layaways.id <> ?", layaway.ID)
+	}
+	var reservedLayaways int
+	if err := layawayQuery.Scan(&reservedLayaways).Error; err != nil {
+		return 0, err
+	}
+
+	return reservedParked + reservedLayaways, nil
+}
+
+// claimParkedSale locks a parked sale that a sale is completing. A parked
+// sale past its expiry can still be completed, but its reservation has
+// lapsed, so the stock is checked like any other sale's.
+func claimParkedSale(tx *gorm.DB, id, businessID uint) (*models.ParkedSale, error) {
+	var parked models.ParkedSale
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("business_id = ?", businessID).
+		First(&parked, id).Error; err != nil {
+		return nil, newSaleError(404, "Parked sale %d not found", id)
+	}
+	switch parked.Status {
+	case models.ParkedSaleParked, models.ParkedSaleResumed, models.ParkedSaleExpired:
+	default:
+		return nil, newSaleError(409, "Parked sale %d is %s", id, strings.ToLower(parked.Status))
+	}
+	return &parked, nil
+}
+
+// completeParkedSale marks a parked sale as sold, which releases its
+// reservation
+func completeParkedSale(tx *gorm.DB, parked *models.ParkedSale, saleID uint) error {
+	now := time.Now()
+	parked.Status = models.ParkedSaleCompleted
+	parked.SaleID = &saleID
+	parked.ClosedAt = &now
+	return tx.Omit(clause.Associations).Save(parked).Error
+}
+
+// parkedItems is what a parked basket holds of each product, in the order
+// the products were first rung up
+func parkedItems(items []models.SaleItem) []models.ParkedSaleItem {
+	var parked []models.ParkedSaleItem
+	lines := make(map[uint]int)
+	for _, item := range items {
+		i, ok := lines[item.ProductID]
+		if !ok {
+			i = len(parked)
+			lines[item.ProductID] = i
+			parked = append(parked, models.ParkedSaleItem{
+				ProductID:   item.ProductID,
+				ProductName: item.ProductName,
+			})
+		}
+		parked[i].Quantity += item.Quantity
+	}
+	return parked
+}
+
+// ParkSale puts a basket aside so the cashier can serve the next customer.
+// The basket is priced to check it, but nothing is sold until it is resumed
+// and recorded with its parked_sale_id.
+func (ph *ParkedSaleHandler) ParkSale(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req ParkSaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format. Expected JSON with sale data"})
+		return
+	}
+	if len(req.Products) == 0 {
+		c.JSON(400, gin.H{"error": "At least one product is required"})
+		return
+	}
+
+	// Approvals are given when the sale is completed, and the completed
+	// sale gets its own idempotency key
+	saleData := req.SaleData
+	saleData.DiscountApproval = nil
+	saleData.IdempotencyKey = ""
+	saleData.ParkedSaleID = nil
+	saleData.TotalAmount = nil
+	payload, err := json.Marshal(saleData)
+	if err != nil {
+		utils.ErrorLogger("Failed to encode parked sale: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to park sale"})
+		return
+	}
+
+	now := time.Now()
+	parked := models.ParkedSale{
+		BusinessID: user.BusinessID,
+		CashierID:  user.ID,
+		Till:       strings.TrimSpace(req.Till),
+		Label:      strings.TrimSpace(req.Label),
+		Payload:    string(payload),
+		Reserved:   req.ReserveStock,
+		Status:     models.ParkedSaleParked,
+		ExpiresAt:  now.Add(ph.hold),
+	}
+
+	err = ph.db.Transaction(func(tx *gorm.DB) error {
+		sale := models.Sale{BusinessID: user.BusinessID, CreatedAt: now}
+		items, err := priceSale(tx, &sale, saleData, &user)
+		if err != nil {
+			return err
+		}
+		parked.Total = sale.TotalAmount
+
+		parked.Items = parkedItems(items)
+		for i := range parked.Items {
+			item := &parked.Items[i]
+			if !parked.Reserved {
+				continue
+			}
+
+			// Lock the stock so two tills cannot reserve the same units
+			var inventory models.Inventory
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("product_id = ?", item.ProductID).
+				First(&inventory).Error; err != nil {
+				return newSaleError(404, "Product %d not found in inventory", item.ProductID)
+			}
+			reserved, err := reservedStock(tx, item.ProductID, now, nil, nil)
+			if err != nil {
+				return err
+			}
+			if available := inventory.Quantity - reserved; available < item.Quantity {
+				return newSaleError(409, "Only %d of %s can be reserved", max(available, 0), item.ProductName)
+			}
+		}
+
+		return tx.Create(&parked).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d parked sale %d at till %q", user.ID, parked.ID, parked.Till)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    parked,
+	})
+}
+
+// GetParkedSales lists the parked sales still waiting to be finished,
+// optionally for one till
+func (ph *ParkedSaleHandler) GetParkedSales(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	query := ph.db.Preload("Items").Where("business_id = ?", user.BusinessID)
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status IN ?", activeParkedStatuses)
+	}
+	if till := c.Query("till"); till != "" {
+		query = query.Where("till = ?", till)
+	}
+
+	var parked []models.ParkedSale
+	if err := query.Order("created_at DESC").Find(&parked).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch parked sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch parked sales"})
+		return
+	}
+
+	c.JSON(200, parked)
+}
+
+// ResumeParkedSale hands a parked basket back to a till. It returns the sale
+// request to finish and record as usual; the parked sale is kept, with its
+// reservation extended, until that sale is recorded.
+func (ph *ParkedSaleHandler) ResumeParkedSale(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var parked models.ParkedSale
+	err := ph.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&parked, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Parked sale not found")
+		}
+
+		now := time.Now()
+		switch {
+		case parked.Status == models.ParkedSaleResumed && parked.ResumedByID != nil && *parked.ResumedByID != user.ID &&
+			!user.HasRole(models.RoleSupervisor):
+			return newSaleError(409, "Parked sale %d has already been resumed at another till", parked.ID)
+		case parked.Status != models.ParkedSaleParked && parked.Status != models.ParkedSaleResumed:
+			return newSaleError(409, "Parked sale %d is %s", parked.ID, strings.ToLower(parked.Status))
+		case !parked.ExpiresAt.After(now):
+			return newSaleError(409, "Parked sale %d has expired", parked.ID)
+		}
+
+		parked.Status = models.ParkedSaleResumed
+		parked.ResumedByID = &user.ID
+		parked.ResumedAt = &now
+		parked.ExpiresAt = now.Add(ph.hold)
+		return tx.Omit(clause.Associations).Save(&parked).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	var saleData SaleData
+	if err := json.Unmarshal([]byte(parked.Payload), &saleData); err != nil {
+		utils.ErrorLogger("Failed to decode parked sale %d: %v", parked.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to resume parked sale"})
+		return
+	}
+	saleData.ParkedSaleID = &parked.ID
+
+	utils.InfoLogger("User %d resumed parked sale %d", user.ID, parked.ID)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    parked,
+		"sale":    saleData,
+	})
+}
+
+// CancelParkedSale drops a parked basket the customer no longer wants,
+// releasing any stock it reserved
+func (ph *ParkedSaleHandler) CancelParkedSale(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var parked models.ParkedSale
+	err := ph.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&parked, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Parked sale not found")
+		}
+		if parked.Status != models.ParkedSaleParked && parked.Status != models.ParkedSaleResumed {
+			return newSaleError(409, "Parked sale %d is %s", parked.ID, strings.ToLower(parked.Status))
+		}
+
+		now := time.Now()
+		parked.Status = models.ParkedSaleCancelled
+		parked.ClosedAt = &now
+		return tx.Omit(clause.Associations).Save(&parked).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d cancelled parked sale %d", user.ID, parked.ID)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    parked,
+	})
+}
+
+// ParkedSaleExpirer expires parked sales nobody came back for, which
+// releases the stock they reserved
+type ParkedSaleExpirer struct {
+	db       *gorm.DB
+	interval time.Duration
+}
+
+func NewParkedSaleExpirer(db *gorm.DB) *ParkedSaleExpirer {
+	return &ParkedSaleExpirer{db: db, interval: time.Minute}
+}
+
+// Run expires overdue parked sales until the context is cancelled
+func (e *ParkedSaleExpirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.expireDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *ParkedSaleExpirer) expireDue() {
+	now := time.Now()
+	result := e.db.Model(&models.ParkedSale{}).
+		Where("status IN ? AND expires_at <= ?", activeParkedStatuses, now).
+		Updates(map[string]interface{}{
+			"status":    models.ParkedSaleExpired,
+			"closed_at": now,
+		})
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to expire parked sales: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		utils.InfoLogger("Expired %d parked sales", result.RowsAffected)
+	}
+}
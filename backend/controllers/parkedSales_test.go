@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestParkedItems(t *testing.T) {
+	items := []models.SaleItem{
+		{ProductID: 7, ProductName: "Sukari 2kg", Quantity: 2},
+		{ProductID: 9, ProductName: "Maziwa", Quantity: 1},
+		// The same product rung up again at an override price
+		{ProductID: 7, ProductName: "Sukari 2kg", Quantity: 3, PriceOverridden: true},
+	}
+	got := parkedItems(items)
+
+	want := []models.ParkedSaleItem{
+		{ProductID: 7, ProductName: "Sukari 2kg", Quantity: 5},
+		{ProductID: 9, ProductName: "Maziwa", Quantity: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parkedItems = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].ProductID != want[i].ProductID || got[i].ProductName != want[i].ProductName || got[i].Quantity != want[i].Quantity {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got := parkedItems(nil); len(got) != 0 {
+		t.Errorf("parkedItems(nil) = %+v, want no lines", got)
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentRequest is one tender towards a sale. Cash may be more than what is
+// owed, and the difference is given back as change. A CREDIT tender without
+// an amount takes whatever the other tenders leave unpaid, a POINTS tender
+// pays with the customer's loyalty points and a CREDIT_NOTE tender spends
+// the credit note whose number is given as the reference.
+type PaymentRequest struct {
+	Method    string   `json:"method"`
+	Amount    *float64 `json:"amount"`
+	Reference string   `json:"reference"`
+}
+
+var tenderMethods = map[string]bool{
+	models.PaymentCash:       true,
+	models.PaymentMpesa:      true,
+	models.PaymentCredit:     true,
+	models.PaymentPoints:     true,
+	models.PaymentCreditNote: true,
+}
+
+// salePayments works out how a sale of the given total was paid. It returns
+// the payments, the sale's payment method and the change due. Requests
+// without a payments list are treated as a single tender of payment_method,
+// with amount_paid as the deposit on a credit sale.
+func salePayments(saleData SaleData, total float64) ([]models.SalePayment, string, float64, error) {
+	if len(saleData.Payments) == 0 {
+		return singleTender(saleData, total)
+	}
+
+	var payments []models.SalePayment
+	var paid, cash float64
+	credit, openCredit := -1, false
+	for _, req := range saleData.Payments {
+		method := strings.ToUpper(strings.TrimSpace(req.Method))
+		if !tenderMethods[method] {
+			return nil, "", 0, newSaleError(400, "Payment method must be CASH, MPESA, CREDIT, POINTS or CREDIT_NOTE")
+		}
+		payment := models.SalePayment{Method: method, Reference: strings.TrimSpace(req.Reference)}
+
+		if method == models.PaymentCredit {
+			if credit >= 0 {
+				return nil, "", 0, newSaleError(400, "Only one CREDIT payment is allowed per sale")
+			}
+			credit = len(payments)
+			if req.Amount == nil {
+				openCredit = true
+				payments = append(payments, payment)
+				continue
+			}
+		}
+		if req.Amount == nil || *req.Amount <= 0 {
+			return nil, "", 0, newSaleError(400, "Each %s payment needs a positive amount", method)
+		}
+
+		payment.Amount = roundMoney(*req.Amount)
+		payment.Tendered = payment.Amount
+		paid += payment.Amount
+		if method == models.PaymentCash {
+			cash += payment.Amount
+		}
+		payments = append(payments, payment)
+	}
+	paid = roundMoney(paid)
+
+	// An open credit tender takes the remainder, if there is one
+	if openCredit {
+		if remainder := roundMoney(total - paid); remainder > 0 {
+			payments[credit].Amount = remainder
+			paid = total
+		} else {
+			payments = append(payments[:credit], payments[credit+1:]...)
+			credit = -1
+		}
+	}
+
+	if paid < total && !sameAmount(paid, total) {
+		return nil, "", 0, newSaleError(400, "Payments of %.2f do not cover the sale total of %.2f; add a CREDIT payment for the balance", paid, total)
+	}
+
+	// Only cash can be overpaid, and the excess comes back as change
+	change := roundMoney(paid - total)
+	if change <= 0 || sameAmount(paid, total) {
+		change = 0
+	}
+	if change > 0 {
+		if credit >= 0 {
+			return nil, "", 0, newSaleError(400, "Payments exceed the sale total of %.2f by %.2f; nothing is left to put on credit", total, change)
+		}
+		if change > cash && !sameAmount(change, cash) {
+			return nil, "", 0, newSaleError(400, "Payments exceed the sale total of %.2f by %.2f; only cash can be overpaid", total, change)
+		}
+		owed := change
+		for i := len(payments) - 1; i >= 0 && owed > 0; i-- {
+			if payments[i].Method != models.PaymentCash {
+				continue
+			}
+			taken := owed
+			if payments[i].Amount < taken {
+				taken = payments[i].Amount
+			}
+			payments[i].Amount = roundMoney(payments[i].Amount - taken)
+			owed = roundMoney(owed - taken)
+		}
+
+		// Cash given back in full as change paid for nothing
+		kept := payments[:0]
+		for _, payment := range payments {
+			if payment.Amount > 0 {
+				kept = append(kept, payment)
+			}
+		}
+		payments = kept
+	}
+
+	return payments, paymentMethodOf(payments), change, nil
+}
+
+// singleTender handles the original one-method sale request
+func singleTender(saleData SaleData, total float64) ([]models.SalePayment, string, float64, error) {
+	method := strings.ToUpper(strings.TrimSpace(saleData.PaymentMethod))
+	if !tenderMethods[method] {
+		return nil, "", 0, newSaleError(400, "Payment method must be CASH, MPESA, CREDIT, POINTS or CREDIT_NOTE")
+	}
+	if method != models.PaymentCredit {
+		return []models.SalePayment{{
+			Method:    method,
+			Amount:    total,
+			Tendered:  total,
+			Reference: saleData.ReferenceNumber,
+		}}, method, 0, nil
+	}
+
+	if saleData.AmountPaid < 0 || saleData.AmountPaid > total {
+		return nil, "", 0, newSaleError(400, "Amount paid must be between 0 and the sale total of %.2f", total)
+	}
+	deposit := roundMoney(saleData.AmountPaid)
+	var payments []models.SalePayment
+	if deposit > 0 {
+		payments = append(payments, models.SalePayment{Method: models.PaymentCash, Amount: deposit, Tendered: deposit})
+	}
+	if remainder := roundMoney(total - deposit); remainder > 0 {
+		payments = append(payments, models.SalePayment{Method: models.PaymentCredit, Amount: remainder})
+	}
+	return payments, method, 0, nil
+}
+
+// paymentMethodOf names a sale's payment method from its tenders
+func paymentMethodOf(payments []models.SalePayment) string {
+	method := models.PaymentCash
+	for i, payment := range payments {
+		if i > 0 && payment.Method != method {
+			return models.PaymentSplit
+		}
+		method = payment.Method
+	}
+	return method
+}
+
+// saleTenders returns what a sale was paid with. Sales recorded before
+// payments were itemised are described by their payment method, with any
+// deposit on a credit sale taken in cash.
+func saleTenders(sale *models.Sale) []models.SalePayment {
+	if len(sale.Payments) > 0 {
+		return sale.Payments
+	}
+	switch sale.PaymentMethod {
+	case models.PaymentCredit:
+		var tenders []models.SalePayment
+		if sale.AmountPaid > 0 {
+			tenders = append(tenders, models.SalePayment{Method: models.PaymentCash, Amount: sale.AmountPaid, Tendered: sale.AmountPaid})
+		}
+		return append(tenders, models.SalePayment{Method: models.PaymentCredit, Amount: roundMoney(sale.TotalAmount - sale.AmountPaid)})
+	case models.PaymentCash, models.PaymentMpesa:
+		return []models.SalePayment{{Method: sale.PaymentMethod, Amount: sale.AmountPaid, Tendered: sale.AmountPaid}}
+	}
+	return nil
+}
+
+// tenderTotal adds up what a sale was paid with the given method
+func tenderTotal(sale *models.Sale, method string) float64 {
+	var total float64
+	for _, payment := range saleTenders(sale) {
+		if payment.Method == method {
+			total += payment.Amount
+		}
+	}
+	return roundMoney(total)
+}
+
+// linkMpesaPayment ties an MPESA tender to the confirmed STK push its
+// reference names, by checkout request ID or M-Pesa receipt number, so the
+// receipt shows the number M-Pesa issued. Tenders paid outside an STK push
+// are left unlinked.
+func linkMpesaPayment(tx *gorm.DB, sale *models.Sale, payment *models.SalePayment) error {
+	reference := strings.TrimSpace(payment.Reference)
+	if reference == "" {
+		return nil
+	}
+
+	var transaction models.MpesaTransaction
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("business_id = ? AND status = ? AND (checkout_request_id = ? OR receipt_number = ?)",
+			sale.BusinessID, "SUCCESS", reference, reference).
+		First(&transaction).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var used int64
+	if err := tx.Model(&models.SalePayment{}).Where("mpesa_transaction_id = ?", transaction.ID).Count(&used).Error; err != nil {
+		return err
+	}
+	if used > 0 {
+		return newSaleError(409, "M-Pesa payment %s has already been used for another sale", reference)
+	}
+	if transaction.Amount < payment.Amount && !sameAmount(transaction.Amount, payment.Amount) {
+		return newSaleError(400, "M-Pesa payment %s was for %.2f, less than the %.2f tendered", reference, transaction.Amount, payment.Amount)
+	}
+
+	payment.MpesaTransactionID = &transaction.ID
+	if transaction.ReceiptNumber != nil {
+		payment.Reference = *transaction.ReceiptNumber
+	}
+	return nil
+}
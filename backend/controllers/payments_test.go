@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+// describePayments lists payments as "METHOD amount" pairs for comparison
+func describePayments(payments []models.SalePayment) string {
+	parts := make([]string, 0, len(payments))
+	for _, payment := range payments {
+		parts = append(parts, fmt.Sprintf("%s %.2f", payment.Method, payment.Amount))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestSalePayments(t *testing.T) {
+	amount := func(a float64) *float64 { return &a }
+	tender := func(method string, a *float64) PaymentRequest {
+		return PaymentRequest{Method: method, Amount: a}
+	}
+
+	tests := []struct {
+		name       string
+		sale       SaleData
+		total      float64
+		wantStatus int
+		want       string
+		wantMethod string
+		wantChange float64
+	}{
+		{name: "single tender", sale: SaleData{PaymentMethod: "mpesa"}, total: 100, want: "MPESA 100.00", wantMethod: models.PaymentMpesa},
+		{name: "credit with a deposit", sale: SaleData{PaymentMethod: "CREDIT", AmountPaid: 30}, total: 100, want: "CASH 30.00, CREDIT 70.00", wantMethod: models.PaymentCredit},
+		{name: "credit without a deposit", sale: SaleData{PaymentMethod: "CREDIT"}, total: 100, want: "CREDIT 100.00", wantMethod: models.PaymentCredit},
+		{name: "deposit over the total", sale: SaleData{PaymentMethod: "CREDIT", AmountPaid: 120}, total: 100, wantStatus: 400},
+		{name: "unknown method", sale: SaleData{PaymentMethod: "CHEQUE"}, total: 100, wantStatus: 400},
+		{name: "split", sale: SaleData{Payments: []PaymentRequest{tender("cash", amount(60)), tender("MPESA", amount(40))}}, total: 100, want: "CASH 60.00, MPESA 40.00", wantMethod: models.PaymentSplit},
+		{name: "one tender in the list", sale: SaleData{Payments: []PaymentRequest{tender("MPESA", amount(100))}}, total: 100, want: "MPESA 100.00", wantMethod: models.PaymentMpesa},
+		{name: "change from cash", sale: SaleData{Payments: []PaymentRequest{tender("MPESA", amount(40)), tender("CASH", amount(100))}}, total: 100, want: "MPESA 40.00, CASH 60.00", wantMethod: models.PaymentSplit, wantChange: 40},
+		{name: "cash given back in full is dropped", sale: SaleData{Payments: []PaymentRequest{tender("MPESA", amount(100)), tender("CASH", amount(20))}}, total: 100, want: "MPESA 100.00", wantMethod: models.PaymentMpesa, wantChange: 20},
+		{name: "non-cash overpaid", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(10)), tender("MPESA", amount(110))}}, total: 100, wantStatus: 400},
+		{name: "short", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(60)), tender("MPESA", amount(30))}}, total: 100, wantStatus: 400},
+		{name: "open credit takes the balance", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(25)), tender("CREDIT", nil)}}, total: 100, want: "CASH 25.00, CREDIT 75.00", wantMethod: models.PaymentSplit},
+		{name: "open credit with nothing left", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(100)), tender("CREDIT", nil)}}, total: 100, want: "CASH 100.00", wantMethod: models.PaymentCash},
+		{name: "credit and change", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(120)), tender("CREDIT", amount(10))}}, total: 100, wantStatus: 400},
+		{name: "two credit tenders", sale: SaleData{Payments: []PaymentRequest{tender("CREDIT", amount(50)), tender("CREDIT", nil)}}, total: 100, wantStatus: 400},
+		{name: "zero amount", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(0)), tender("MPESA", amount(100))}}, total: 100, wantStatus: 400},
+		{name: "missing amount", sale: SaleData{Payments: []PaymentRequest{tender("MPESA", nil)}}, total: 100, wantStatus: 400},
+		{name: "cents add up", sale: SaleData{Payments: []PaymentRequest{tender("CASH", amount(33.33)), tender("MPESA", amount(66.67))}}, total: 100, want: "CASH 33.33, MPESA 66.67", wantMethod: models.PaymentSplit},
+	}
+	for _, tt := range tests {
+		payments, method, change, err := salePayments(tt.sale, tt.total)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := describePayments(payments); got != tt.want || method != tt.wantMethod || change != tt.wantChange {
+			t.Errorf("%s: %s as %s with %.2f change, want %s as %s with %.2f change",
+				tt.name, got, method, change, tt.want, tt.wantMethod, tt.wantChange)
+		}
+	}
+}
+
+func TestPaymentMethodOf(t *testing.T) {
+	tests := []struct {
+		methods []string
+		want    string
+	}{
+		{nil, models.PaymentCash},
+		{[]string{models.PaymentMpesa}, models.PaymentMpesa},
+		{[]string{models.PaymentCredit, models.PaymentCredit}, models.PaymentCredit},
+		{[]string{models.PaymentCash, models.PaymentMpesa}, models.PaymentSplit},
+	}
+	for _, tt := range tests {
+		var payments []models.SalePayment
+		for _, method := range tt.methods {
+			payments = append(payments, models.SalePayment{Method: method})
+		}
+		if got := paymentMethodOf(payments); got != tt.want {
+			t.Errorf("paymentMethodOf(%v) = %s, want %s", tt.methods, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// priceOverrideRole is the least privileged role allowed to change a price
+// at the till. A price cut below the list price still has to fit within
+// the role's discount limit.
+const priceOverrideRole = models.RoleSupervisor
+
+// roundMoney rounds an amount to whole cents
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// sameAmount reports whether two amounts agree to the cent
+func sameAmount(a, b float64) bool {
+	return math.Abs(roundMoney(a)-roundMoney(b)) < 0.005
+}
+
+// activePromotions loads the business's promotions running at t
+func activePromotions(tx *gorm.DB, businessID uint, t time.Time) ([]models.Promotion, error) {
+	var promotions []models.Promotion
+	err := tx.Where("business_id = ? AND starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", businessID, t, t).
+		Find(&promotions).Error
+	return promotions, err
+}
+
+// bestPromotion returns the promotion giving the lowest unit price for
+// quantity units of product, or nil when none applies or none beats the
+// catalogue price.
+func bestPromotion(promotions []models.Promotion, product models.Product, quantity int, t time.Time) (*models.Promotion, float64) {
+	var best *models.Promotion
+	bestPrice := product.Price
+	for i := range promotions {
+		if !promotions[i].Applies(product, quantity, t) {
+			continue
+		}
+		price := roundMoney(math.Max(promotions[i].UnitPrice(product.Price), 0))
+		if price < bestPrice {
+			best, bestPrice = &promotions[i], price
+		}
+	}
+	return best, bestPrice
+}
+
+// priceSaleItem sets the item's list price, unit price and line total from
+// the catalogue and active promotions, applying the requested override when
+// the cashier is allowed to make it.
+func priceSaleItem(item *models.SaleItem, product models.Product, req SellRequest, promotions []models.Promotion, cashier *models.User, at time.Time) error {
+	item.ListPrice = product.Price
+	item.UnitPrice = product.Price
+	if promotion, price := bestPromotion(promotions, product, req.Quantity, at); promotion != nil {
+		item.PromotionID = &promotion.ID
+		item.UnitPrice = price
+	}
+
+	if req.UnitPrice != nil && !sameAmount(*req.UnitPrice, item.UnitPrice) {
+		if cashier == nil || !cashier.HasRole(priceOverrideRole) {
+			return newSaleError(403, "You are not allowed to change the price of %s", product.Name)
+		}
+		if *req.UnitPrice < 0 {
+			return newSaleError(400, "Price for %s must not be negative", product.Name)
+		}
+		reason := strings.TrimSpace(req.OverrideReason)
+		if reason == "" {
+			return newSaleError(400, "A reason is required to change the price of %s", product.Name)
+		}
+		item.UnitPrice = roundMoney(*req.UnitPrice)
+		item.PromotionID = nil
+		item.PriceOverridden = true
+		item.OverrideReason = reason
+		item.OverriddenByID = &cashier.ID
+	}
+
+	item.GrossAmount = roundMoney(item.UnitPrice * float64(req.Quantity))
+	item.LineTotal = item.GrossAmount
+	if req.Amount != nil && !sameAmount(*req.Amount, item.LineTotal) {
+		return &saleError{
+			status:  409,
+			message: "Price for " + product.Name + " has changed, please review the sale",
+		}
+	}
+	return nil
+}
+
+type PromotionRequest struct {
+	Name        string     `json:"name" binding:"required"`
+	ProductID   *uint      `json:"product_id"`
+	Category    string     `json:"category"`
+	PercentOff  float64    `json:"percent_off"`
+	FixedPrice  *float64   `json:"fixed_price"`
+	MinQuantity int        `json:"min_quantity"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+}
+
+func (im *SalesManagementHandler) CreatePromotion(c *gin.Context) {
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorLogger("Failed to parse promotion request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if (req.ProductID == nil) == (req.Category == "") {
+		c.JSON(400, gin.H{"error": "Set either product_id or category"})
+		return
+	}
+	if (req.FixedPrice == nil) == (req.PercentOff == 0) {
+		c.JSON(400, gin.H{"error": "Set either percent_off or fixed_price"})
+		return
+	}
+	if req.PercentOff < 0 || req.PercentOff > 100 {
+		c.JSON(400, gin.H{"error": "percent_off must be between 0 and 100"})
+		return
+	}
+	if req.FixedPrice != nil && *req.FixedPrice < 0 {
+		c.JSON(400, gin.H{"error": "fixed_price must not be negative"})
+		return
+	}
+	if req.ProductID != nil {
+		if err := im.db.First(&models.Product{}, *req.ProductID).Error; err != nil {
+			c.JSON(404, gin.H{"error": "Product not found"})
+			return
+		}
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	promotion := models.Promotion{
+		BusinessID:  user.BusinessID,
+		Name:        req.Name,
+		ProductID:   req.ProductID,
+		Category:    req.Category,
+		PercentOff:  req.PercentOff,
+		FixedPrice:  req.FixedPrice,
+		MinQuantity: 1,
+		StartsAt:    time.Now(),
+		EndsAt:      req.EndsAt,
+		CreatedByID: &user.ID,
+	}
+	if req.MinQuantity > 0 {
+		promotion.MinQuantity = req.MinQuantity
+	}
+	if req.StartsAt != nil {
+		promotion.StartsAt = *req.StartsAt
+	}
+	if promotion.EndsAt != nil && !promotion.EndsAt.After(promotion.StartsAt) {
+		c.JSON(400, gin.H{"error": "ends_at must be after starts_at"})
+		return
+	}
+
+	if err := im.db.Create(&promotion).Error; err != nil {
+		utils.ErrorLogger("Failed to create promotion: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	utils.InfoLogger("Created promotion %s", promotion.Name)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    promotion,
+	})
+}
+
+// GetPromotions lists the business's promotions, only the running ones when
+// active=true.
+func (im *SalesManagementHandler) GetPromotions(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var promotions []models.Promotion
+	var err error
+	if c.Query("active") == "true" {
+		promotions, err = activePromotions(im.db, user.BusinessID, time.Now())
+	} else {
+		err = im.db.Where("business_id = ?", user.BusinessID).
+			Order("starts_at DESC").
+			Find(&promotions).Error
+	}
+	if err != nil {
+		utils.ErrorLogger("Failed to fetch promotions: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch promotions"})
+		return
+	}
+
+	c.JSON(200, promotions)
+}
+
+// EndPromotion stops a promotion now. Past sales keep the price they got.
+func (im *SalesManagementHandler) EndPromotion(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var promotion models.Promotion
+	if err := im.db.Where("business_id = ?", user.BusinessID).First(&promotion, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	now := time.Now()
+	if promotion.EndsAt != nil && !promotion.EndsAt.After(now) {
+		c.JSON(400, gin.H{"error": "Promotion has already ended"})
+		return
+	}
+	promotion.EndsAt = &now
+	if err := im.db.Save(&promotion).Error; err != nil {
+		utils.ErrorLogger("Failed to end promotion %d: %v", promotion.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to end promotion"})
+		return
+	}
+
+	utils.InfoLogger("Ended promotion %s", promotion.Name)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    promotion,
+	})
+}
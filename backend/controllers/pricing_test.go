@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestPriceSaleItem(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	productID := uint(7)
+	product := models.Product{ID: productID, Name: "Sukari 2kg", Price: 250, Category: "Groceries"}
+	halfPrice := models.Promotion{ID: 1, ProductID: &productID, PercentOff: 50, MinQuantity: 1, StartsAt: now.Add(-time.Hour)}
+	fixed := 230.0
+	bulk := models.Promotion{ID: 2, Category: "Groceries", FixedPrice: &fixed, MinQuantity: 3, StartsAt: now.Add(-time.Hour)}
+	ended := now.Add(-time.Minute)
+	expired := models.Promotion{ID: 3, ProductID: &productID, PercentOff: 80, MinQuantity: 1, StartsAt: now.Add(-time.Hour), EndsAt: &ended}
+
+	cashier := &models.User{ID: 1, Role: models.RoleCashier}
+	supervisor := &models.User{ID: 2, Role: models.RoleSupervisor}
+	price := func(p float64) *float64 { return &p }
+
+	tests := []struct {
+		name          string
+		req           SellRequest
+		promotions    []models.Promotion
+		cashier       *models.User
+		wantStatus    int
+		wantUnit      float64
+		wantTotal     float64
+		wantPromotion *uint
+		wantOverride  bool
+	}{
+		{name: "list price", req: SellRequest{Quantity: 2}, wantUnit: 250, wantTotal: 500},
+		{name: "best promotion", req: SellRequest{Quantity: 3}, promotions: []models.Promotion{bulk, halfPrice}, wantUnit: 125, wantTotal: 375, wantPromotion: &halfPrice.ID},
+		{name: "promotion below its minimum", req: SellRequest{Quantity: 2}, promotions: []models.Promotion{bulk}, wantUnit: 250, wantTotal: 500},
+		{name: "ended promotion", req: SellRequest{Quantity: 1}, promotions: []models.Promotion{expired}, wantUnit: 250, wantTotal: 250},
+		{name: "expected amount matches", req: SellRequest{Quantity: 2, Amount: price(500)}, wantUnit: 250, wantTotal: 500},
+		{name: "price changed since the till priced it", req: SellRequest{Quantity: 2, Amount: price(480)}, wantStatus: 409},
+		{name: "override", req: SellRequest{Quantity: 2, UnitPrice: price(240.004), OverrideReason: " Price match "}, cashier: supervisor, wantUnit: 240, wantTotal: 480, wantOverride: true},
+		{name: "override replaces the promotion", req: SellRequest{Quantity: 1, UnitPrice: price(200), OverrideReason: "Damaged box"}, promotions: []models.Promotion{halfPrice}, cashier: supervisor, wantUnit: 200, wantTotal: 200, wantOverride: true},
+		{name: "unchanged price is not an override", req: SellRequest{Quantity: 1, UnitPrice: price(250)}, cashier: cashier, wantUnit: 250, wantTotal: 250},
+		{name: "cashier cannot override", req: SellRequest{Quantity: 1, UnitPrice: price(200), OverrideReason: "Friend"}, cashier: cashier, wantStatus: 403},
+		{name: "signed out cannot override", req: SellRequest{Quantity: 1, UnitPrice: price(200), OverrideReason: "Friend"}, wantStatus: 403},
+		{name: "override needs a reason", req: SellRequest{Quantity: 1, UnitPrice: price(200), OverrideReason: "  "}, cashier: supervisor, wantStatus: 400},
+		{name: "negative override", req: SellRequest{Quantity: 1, UnitPrice: price(-1), OverrideReason: "Typo"}, cashier: supervisor, wantStatus: 400},
+	}
+	for _, tt := range tests {
+		var item models.SaleItem
+		err := priceSaleItem(&item, product, tt.req, tt.promotions, tt.cashier, now)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if item.ListPrice != 250 || item.UnitPrice != tt.wantUnit || item.LineTotal != tt.wantTotal || item.GrossAmount != tt.wantTotal {
+			t.Errorf("%s: list %.2f unit %.2f gross %.2f total %.2f, want 250.00 %.2f %.2f %.2f",
+				tt.name, item.ListPrice, item.UnitPrice, item.GrossAmount, item.LineTotal, tt.wantUnit, tt.wantTotal, tt.wantTotal)
+		}
+		if (item.PromotionID == nil) != (tt.wantPromotion == nil) || (item.PromotionID != nil && *item.PromotionID != *tt.wantPromotion) {
+			t.Errorf("%s: promotion %v, want %v", tt.name, item.PromotionID, tt.wantPromotion)
+		}
+		if item.PriceOverridden != tt.wantOverride {
+			t.Errorf("%s: overridden %v, want %v", tt.name, item.PriceOverridden, tt.wantOverride)
+		}
+		if tt.wantOverride && (item.OverriddenByID == nil || *item.OverriddenByID != tt.cashier.ID || item.OverrideReason == "" || item.OverrideReason[0] == ' ') {
+			t.Errorf("%s: override by %v for %q, want user %d with a trimmed reason", tt.name, item.OverriddenByID, item.OverrideReason, tt.cashier.ID)
+		}
+	}
+}
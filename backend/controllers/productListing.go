@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxProductPageSize = 500
+
+// productSortColumns maps the sort parameter to the column it orders by
+var productSortColumns = map[string]string{
+	"id":         "products.id",
+	"name":       "products.name",
+	"price":      "products.price",
+	"quantity":   "COALESCE(inventory.quantity, 0)",
+	"created_at": "products.created_at",
+	"updated_at": "products.updated_at",
+}
+
+type productRow struct {
+	models.Product
+	Quantity          int `json:"quantity"`
+	LowStockThreshold int `json:"low_stock_threshold"`
+}
+
+// productCursor marks the last row of a page. It is sent to clients as an
+// opaque base64 string.
+type productCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// GetAllProducts lists products with their stock level in a single query.
+// Results can be filtered, sorted and trimmed to selected fields. Passing
+// limit turns on cursor pagination; the cursor for the next page is sent in
+// the X-Next-Cursor header so the body stays a plain list. ETag and
+// Last-Modified let clients poll cheaply with conditional requests.
+func (im *InventoryManagementHandler) GetAllProducts(c *gin.Context) {
+	sortParam := c.DefaultQuery("sort", "id")
+	desc := strings.HasPrefix(sortParam, "-")
+	sortKey := strings.TrimPrefix(sortParam, "-")
+	sortColumn, ok := productSortColumns[sortKey]
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid sort field"})
+		return
+	}
+
+	var fields []string
+	if f := c.Query("fields"); f != "" {
+		fields = strings.Split(f, ",")
+	}
+
+	base := im.db.Table("products").
+		Joins("LEFT JOIN inventory ON inventory.product_id = products.id")
+	base, err := applyProductFilters(base, c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validators for conditional requests cover the whole filtered set, so any
+	// product or stock change invalidates every page
+	var stats struct {
+		Total            int64
+		ProductsUpdated  *time.Time
+		InventoryUpdated *time.Time
+	}
+	if err := base.Session(&gorm.Session{}).
+		Select("COUNT(*) as total, MAX(products.updated_at) as products_updated, MAX(inventory.last_updated) as inventory_updated").
+		Scan(&stats).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch product listing stats: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to get products"})
+		return
+	}
+
+	var lastModified time.Time
+	if stats.ProductsUpdated != nil {
+		lastModified = *stats.ProductsUpdated
+	}
+	if stats.InventoryUpdated != nil && stats.InventoryUpdated.After(lastModified) {
+		lastModified = *stats.InventoryUpdated
+	}
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d", c.Request.URL.RawQuery, stats.Total, lastModified.UnixNano())))
+	etag := `W/"` + hex.EncodeToString(hash[:8]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Total-Count", strconv.FormatInt(stats.Total, 10))
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	query := base.Session(&gorm.Session{}).
+		Select("products.*, COALESCE(inventory.quantity, 0) as quantity, COALESCE(inventory.low_stock_threshold, 0) as low_stock_threshold").
+		Order(sortColumn + " " + direction).
+		Order("products.id " + direction)
+
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.JSON(400, gin.H{"error": "Limit must be a positive number"})
+			return
+		}
+		if limit > maxProductPageSize {
+			limit = maxProductPageSize
+		}
+
+		if cursor := c.Query("cursor"); cursor != "" {
+			query, err = applyProductCursor(query, cursor, sortKey, sortColumn, desc)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		// Fetch one extra row to find out whether there is another page
+		query = query.Limit(limit + 1)
+	}
+
+	var rows []productRow
+	if err := query.Scan(&rows).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch products: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to get products"})
+		return
+	}
+
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+		c.Header("X-Next-Cursor", encodeProductCursor(rows[len(rows)-1], sortKey))
+	}
+
+	result := make([]gin.H, 0, len(rows))
+	for _, row := range rows {
+		var product interface{} = row.Product
+		if len(fields) > 0 {
+			product = selectProductFields(row, fields)
+		}
+		result = append(result, gin.H{
+			"product":  product,
+			"quantity": row.Quantity,
+		})
+	}
+
+	utils.InfoLogger("Successfully fetched %d products", len(result))
+	c.JSON(200, result)
+}
+
+// applyProductFilters narrows a products/inventory query by the category,
+// stock_status, min_price, max_price and archived query parameters.
+func applyProductFilters(query *gorm.DB, c *gin.Context) (*gorm.DB, error) {
+	if category := c.Query("category"); category != "" {
+		query = query.Where("products.category = ?", category)
+	}
+
+	switch c.Query("stock_status") {
+	case "":
+	case "out":
+		query = query.Where("COALESCE(inventory.quantity, 0) <= 0")
+	case "low":
+		query = query.Where("inventory.quantity > 0 AND inventory.quantity <= inventory.low_stock_threshold")
+	case "ok":
+		query = query.Where("inventory.quantity > inventory.low_stock_threshold")
+	default:
+		return nil, errors.New("stock_status must be low, out or ok")
+	}
+
+	if v := c.Query("min_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("invalid min_price")
+		}
+		query = query.Where("products.price >= ?", price)
+	}
+	if v := c.Query("max_price"); v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errors.New("invalid max_price")
+		}
+		query = query.Where("products.price <= ?", price)
+	}
+
+	switch c.DefaultQuery("archived", "false") {
+	case "false":
+		query = query.Where("products.archived = ?", false)
+	case "true":
+		query = query.Where("products.archived = ?", true)
+	case "all":
+	default:
+		return nil, errors.New("archived must be true, false or all")
+	}
+
+	return query, nil
+}
+
+// applyProductCursor continues a listing after the row the cursor points at.
+func applyProductCursor(query *gorm.DB, encoded, sortKey, sortColumn string, desc bool) (*gorm.DB, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != sortKey {
+		return nil, errors.New("invalid cursor for this sort order")
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if sortKey == "id" {
+		return query.Where("products.id "+op+" ?", cursor.ID), nil
+	}
+
+	var value interface{}
+	switch sortKey {
+	case "name":
+		value = cursor.Value
+	case "price":
+		value, err = strconv.ParseFloat(cursor.Value, 64)
+	case "quantity":
+		value, err = strconv.Atoi(cursor.Value)
+	case "created_at", "updated_at":
+		value, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	}
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return query.Where(
+		"("+sortColumn+" "+op+" ? OR ("+sortColumn+" = ? AND products.id "+op+" ?))",
+		value, value, cursor.ID,
+	), nil
+}
+
+func encodeProductCursor(row productRow, sortKey string) string {
+	cursor := productCursor{Sort: sortKey, ID: row.ID}
+	switch sortKey {
+	case "name":
+		cursor.Value = row.Name
+	case "price":
+		cursor.Value = strconv.FormatFloat(row.Price, 'f', -1, 64)
+	case "quantity":
+		cursor.Value = strconv.Itoa(row.Quantity)
+	case "created_at":
+		cursor.Value = row.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = row.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// selectProductFields trims a product to the requested fields. The id is
+// always included so clients can tell rows apart.
+func selectProductFields(row productRow, fields []string) gin.H {
+	all := gin.H{
+		"id":                  row.ID,
+		"name":                row.Name,
+		"description":         row.Description,
+		"category":            row.Category,
+		"price":               row.Price,
+		"cost_price":          row.CostPrice,
+		"barcode":             row.Barcode,
+		"photo_path":          row.PhotoPath,
+		"supplier_id":         row.SupplierID,
+		"pack_size":           row.PackSize,
+		"archived":            row.Archived,
+		"low_stock_threshold": row.LowStockThreshold,
+		"created_at":          row.CreatedAt,
+		"updated_at":          row.UpdatedAt,
+	}
+
+	selected := gin.H{"id": row.ID}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
+
+// notModified reports whether the client's cached copy, identified by
+// If-None-Match or If-Modified-Since, is still current.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			if strings.TrimSpace(candidate) == etag || strings.TrimSpace(candidate) == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
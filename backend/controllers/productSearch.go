@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/search"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// salesBoostWindow is how far back sales count towards ranking
+	salesBoostWindow = 30 * 24 * time.Hour
+	// salesBoostTTL is how long sales-based ranking is cached
+	salesBoostTTL = 10 * time.Minute
+)
+
+// productSearch keeps the product search index in memory. The index is
+// rebuilt whenever the product catalogue changes, which is detected cheaply
+// from the product count and latest update time.
+type productSearch struct {
+	db *gorm.DB
+
+	mu        sync.RWMutex
+	index     *search.Index
+	version   string
+	boost     map[uint]float64
+	boostedAt time.Time
+}
+
+func newProductSearch(db *gorm.DB) *productSearch {
+	return &productSearch{db: db}
+}
+
+// current returns an up-to-date index and sales boost, rebuilding either one
+// if it is stale.
+func (ps *productSearch) current() (*search.Index, map[uint]float64, error) {
+	var stats struct {
+		Total       int64
+		LastUpdated *time.Time
+	}
+	if err := ps.db.Model(&models.Product{}).
+		Select("COUNT(*) as total, MAX(updated_at) as last_updated").
+		Where("archived = ?", false).
+		Scan(&stats).Error; err != nil {
+		return nil, nil, err
+	}
+	version := strconv.FormatInt(stats.Total, 10)
+	if stats.LastUpdated != nil {
+		version += "|" + strconv.FormatInt(stats.LastUpdated.UnixNano(), 10)
+	}
+
+	ps.mu.RLock()
+	index, boost := ps.index, ps.boost
+	fresh := ps.version == version && index != nil
+	boostFresh := time.Since(ps.boostedAt) < salesBoostTTL
+	ps.mu.RUnlock()
+	if fresh && boostFresh {
+		return index, boost, nil
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.version != version || ps.index == nil {
+		var products []models.Product
+		if err := ps.db.Where("archived = ?", false).Find(&products).Error; err != nil {
+			return nil, nil, err
+		}
+		docs := make([]search.Document, 0, len(products))
+		for _, p := range products {
+			docs = append(docs, search.Document{
+				ID:          p.ID,
+				Name:        p.Name,
+				Description: p.Description,
+				Barcode:     p.Barcode,
+				Category:    p.Category,
+				Aliases:     search.ParseWords(p.Aliases),
+			})
+		}
+		ps.index = search.NewIndex(docs)
+		ps.version = version
+		utils.InfoLogger("Rebuilt product search index with %d products", len(docs))
+	}
+
+	if time.Since(ps.boostedAt) >= salesBoostTTL {
+		boost, err := ps.loadSalesBoost()
+		if err != nil {
+			return nil, nil, err
+		}
+		ps.boost = boost
+		ps.boostedAt = time.Now()
+	}
+
+	return ps.index, ps.boost, nil
+}
+
+// loadSalesBoost turns recent units sold into a ranking multiplier. The log
+// keeps a best seller from burying a much better text match.
+func (ps *productSearch) loadSalesBoost() (map[uint]float64, error) {
+	var rows []struct {
+		ProductID uint
+		Units     int
+	}
+	if err := ps.db.Table("sale_items").
+		Select("product_id, SUM(quantity - returned_quantity) as units").
+		Where("created_at >= ?", time.Now().Add(-salesBoostWindow)).
+		Group("product_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	boost := make(map[uint]float64, len(rows))
+	for _, row := range rows {
+		if row.Units > 0 {
+			boost[row.ProductID] = 1 + 0.15*math.Log1p(float64(row.Units))
+		}
+	}
+	return boost, nil
+}
+
+// SearchProducts finds products by name, description, barcode, category and
+// aliases. It tolerates typos, completes the last word as the user types,
+// expands the business's synonyms and ranks by relevance and recent sales.
+func (im *InventoryManagementHandler) SearchProducts(c *gin.Context) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(400, gin.H{"error": "Search query is required"})
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	utils.InfoLogger("Searching products with query: %s", query)
+
+	index, boost, err := im.search.current()
+	if err != nil {
+		utils.ErrorLogger("Failed to load search index: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to search products"})
+		return
+	}
+	synonyms, err := loadSynonyms(im.db, businessID)
+	if err != nil {
+		utils.ErrorLogger("Failed to load search synonyms: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	hits := index.Search(query, synonyms, boost, limit)
+
+	type result struct {
+		models.Product
+		Quantity int     `json:"quantity"`
+		Score    float64 `json:"score"`
+	}
+	results := make([]result, 0, len(hits))
+	if len(hits) == 0 {
+		c.JSON(200, results)
+		return
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var rows []struct {
+		models.Product
+		Quantity int
+	}
+	if err := im.db.Table("products").
+		Select("products.*, COALESCE(inventory.quantity, 0) as quantity").
+		Joins("LEFT JOIN inventory ON inventory.product_id = products.id").
+		Where("products.id IN ?", ids).
+		Scan(&rows).Error; err != nil {
+		utils.ErrorLogger("Failed to search products: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	byID := make(map[uint]int, len(rows))
+	for i, row := range rows {
+		byID[row.ID] = i
+	}
+	for _, hit := range hits {
+		i, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, result{
+			Product:  rows[i].Product,
+			Quantity: rows[i].Quantity,
+			Score:    math.Round(hit.Score*1000) / 1000,
+		})
+	}
+
+	c.JSON(200, results)
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Profit is worked out on sales net of VAT, since the VAT collected is owed
+// to KRA, and on the cost copied onto each item when it was sold. Returned
+// units come off both revenue and cost.
+const (
+	netItemSales = "CASE WHEN sale_items.quantity > 0 THEN (sale_items.line_total - sale_items.tax_amount) * " +
+		"(sale_items.quantity - sale_items.returned_quantity) / sale_items.quantity ELSE 0 END"
+	netItemCost = "COALESCE(sale_items.unit_cost, 0) * (sale_items.quantity - sale_items.returned_quantity)"
+)
+
+type marginLine struct {
+	Key           string  `json:"key"`
+	Label         string  `json:"label,omitempty"`
+	Units         int64   `json:"units"`
+	Revenue       float64 `json:"revenue"`
+	Cost          float64 `json:"cost"`
+	GrossProfit   float64 `json:"gross_profit"`
+	MarginPercent float64 `json:"margin_percent"`
+}
+
+// finish rounds the line's money and works out its gross profit and margin
+func (l *marginLine) finish() {
+	l.Revenue = roundMoney(l.Revenue)
+	l.Cost = roundMoney(l.Cost)
+	l.GrossProfit = roundMoney(l.Revenue - l.Cost)
+	l.MarginPercent = 0
+	if l.Revenue != 0 {
+		l.MarginPercent = roundMoney(l.GrossProfit / l.Revenue * 100)
+	}
+}
+
+// marginBreakdown groups the items in query by the key expression, labelling
+// each group with the label expression
+func marginBreakdown(query *gorm.DB, key, label, order string) ([]marginLine, error) {
+	var rows []struct {
+		GroupKey string
+		Label    string
+		Units    int64
+		Revenue  float64
+		Cost     float64
+	}
+	if err := query.
+		Select(key + " AS group_key, " + label + " AS label, " +
+			"COALESCE(SUM(" + netItemUnits + "), 0) AS units, " +
+			"COALESCE(SUM(" + netItemSales + "), 0) AS revenue, " +
+			"COALESCE(SUM(" + netItemCost + "), 0) AS cost").
+		Group("group_key").
+		Order(order).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]marginLine, 0, len(rows))
+	for _, row := range rows {
+		line := marginLine{Key: row.GroupKey, Label: row.Label, Units: row.Units, Revenue: row.Revenue, Cost: row.Cost}
+		line.finish()
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// GetProfitReport gives revenue, cost of goods sold, gross profit and margin
+// for the requested range, broken down by product, category, cashier and
+// period.
+func (im *SalesManagementHandler) GetProfitReport(c *gin.Context) {
+	period := c.DefaultQuery("period", "month")
+	if !validPeriod(period) {
+		c.JSON(400, gin.H{"error": "Period must be day, week or month"})
+		return
+	}
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	byProduct, err := marginBreakdown(im.analyticsItems(businessID, from, to),
+		"sale_items.product_id", "MAX(sale_items.product_name)", "revenue DESC")
+	if err != nil {
+		utils.ErrorLogger("Failed to build profit report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build profit report"})
+		return
+	}
+	byCategory, err := marginBreakdown(im.analyticsItems(businessID, from, to).
+		Joins("LEFT JOIN products ON products.id = sale_items.product_id"),
+		"COALESCE(NULLIF(products.category, ''), 'Uncategorised')", "''", "revenue DESC")
+	if err != nil {
+		utils.ErrorLogger("Failed to build profit report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build profit report"})
+		return
+	}
+	byCashier, err := marginBreakdown(im.analyticsItems(businessID, from, to).
+		Joins("LEFT JOIN users ON users.id = sales.cashier_id"),
+		"COALESCE(sales.cashier_id, 0)", "COALESCE(MAX(users.full_name), '')", "revenue DESC")
+	if err != nil {
+		utils.ErrorLogger("Failed to build profit report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build profit report"})
+		return
+	}
+	byPeriod, err := marginBreakdown(im.analyticsItems(businessID, from, to),
+		"DATE_FORMAT(sales.created_at, '"+periodFormats[period]+"')", "''", "group_key")
+	if err != nil {
+		utils.ErrorLogger("Failed to build profit report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build profit report"})
+		return
+	}
+
+	total := marginLine{Key: "total"}
+	for _, line := range byProduct {
+		total.Units += line.Units
+		total.Revenue += line.Revenue
+		total.Cost += line.Cost
+	}
+	total.finish()
+
+	utils.InfoLogger("Successfully built profit report for %d products", len(byProduct))
+	c.JSON(200, gin.H{
+		"from":        from.Format(dateLayout),
+		"to":          to.AddDate(0, 0, -1).Format(dateLayout),
+		"period":      period,
+		"total":       total,
+		"by_product":  byProduct,
+		"by_category": byCategory,
+		"by_cashier":  byCashier,
+		"by_period":   byPeriod,
+	})
+}
+
+// GetBelowCostSales lists the products sold for less than they cost, after
+// discounts, with the money lost on them. It also shows whether the current
+// selling price is still below the current cost price.
+func (im *SalesManagementHandler) GetBelowCostSales(c *gin.Context) {
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	var products []struct {
+		ProductID      uint    `json:"product_id"`
+		ProductName    string  `json:"product_name"`
+		Sales          int64   `json:"sales"`
+		Units          int64   `json:"units"`
+		Revenue        float64 `json:"revenue"`
+		Cost           float64 `json:"cost"`
+		Loss           float64 `json:"loss"`
+		Price          float64 `json:"current_price"`
+		CostPrice      float64 `json:"current_cost_price"`
+		PriceBelowCost bool    `json:"price_below_cost" gorm:"-"`
+	}
+	if err := im.analyticsItems(businessID, from, to).
+		Joins("LEFT JOIN products ON products.id = sale_items.product_id").
+		Where("sale_items.quantity > sale_items.returned_quantity").
+		Where("sale_items.line_total - sale_items.tax_amount < COALESCE(sale_items.unit_cost, 0) * sale_items.quantity").
+		Select("sale_items.product_id, MAX(sale_items.product_name) AS product_name, COUNT(DISTINCT sales.id) AS sales, " +
+			"SUM(" + netItemUnits + ") AS units, SUM(" + netItemSales + ") AS revenue, SUM(" + netItemCost + ") AS cost, " +
+			"COALESCE(MAX(products.price), 0) AS price, COALESCE(MAX(products.cost_price), 0) AS cost_price").
+		Group("sale_items.product_id").
+		Order("SUM(" + netItemCost + ") - SUM(" + netItemSales + ") DESC").
+		Scan(&products).Error; err != nil {
+		utils.ErrorLogger("Failed to find sales below cost: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to find sales below cost"})
+		return
+	}
+
+	var totalLoss float64
+	for i := range products {
+		p := &products[i]
+		p.Revenue = roundMoney(p.Revenue)
+		p.Cost = roundMoney(p.Cost)
+		p.Loss = roundMoney(p.Cost - p.Revenue)
+		p.PriceBelowCost = p.Price < p.CostPrice
+		totalLoss += p.Loss
+	}
+
+	c.JSON(200, gin.H{
+		"from":       from.Format(dateLayout),
+		"to":         to.AddDate(0, 0, -1).Format(dateLayout),
+		"total_loss": roundMoney(totalLoss),
+		"products":   products,
+	})
+}
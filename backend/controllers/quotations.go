@@ -0,0 +1,464 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/mpesa"
+	"github.com/OAthooh/BiasharaTrack.git/receipt"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultQuoteValidity is how many days a quote holds when no date is given
+const defaultQuoteValidity = 14
+
+type QuotationRequest struct {
+	Products []SellRequest `json:"products" binding:"required"`
+	// Discount is taken off the whole quote
+	Discount         *DiscountRequest  `json:"discount"`
+	DiscountApproval *DiscountApproval `json:"discount_approval"`
+	CustomerName     string            `json:"customer_name" binding:"required"`
+	CustomerPhone    string            `json:"customer_phone"`
+	CustomerEmail    string            `json:"customer_email"`
+	CustomerPIN      string            `json:"customer_pin"`
+	// ValidUntil is the last day the prices hold (YYYY-MM-DD)
+	ValidUntil string `json:"valid_until"`
+	Notes      string `json:"notes"`
+}
+
+type QuotationStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// ConvertQuotationRequest is how the customer pays for an accepted quote
+type ConvertQuotationRequest struct {
+	PaymentMethod    string            `json:"payment_method"`
+	Payments         []PaymentRequest  `json:"payments"`
+	ReferenceNumber  string            `json:"reference_number"`
+	AmountPaid       float64           `json:"amount_paid"`
+	DiscountApproval *DiscountApproval `json:"discount_approval"`
+	IdempotencyKey   string            `json:"idempotency_key"`
+	// AcceptCurrentPrices sells at today's prices when they differ from
+	// the quote, instead of stopping for the quote to be reviewed
+	AcceptCurrentPrices bool `json:"accept_current_prices"`
+}
+
+// quoteExpired reports whether the quote's last valid day has passed
+func quoteExpired(quote *models.Quotation, now time.Time) bool {
+	return !now.Before(quote.ValidUntil.AddDate(0, 0, 1))
+}
+
+// expireQuotations marks open quotes past their validity as expired
+func expireQuotations(db *gorm.DB, businessID uint) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return db.Model(&models.Quotation{}).
+		Where("business_id = ? AND status IN ? AND valid_until < ?", businessID,
+			[]string{models.QuotationDraft, models.QuotationSent}, today).
+		Update("status", models.QuotationExpired).Error
+}
+
+// claimQuotation locks the accepted quote a sale is converting
+func claimQuotation(tx *gorm.DB, id, businessID uint) (*models.Quotation, error) {
+	var quote models.Quotation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("business_id = ?", businessID).
+		First(&quote, id).Error; err != nil {
+		return nil, newSaleError(404, "Quotation %d not found", id)
+	}
+	if quote.Status != models.QuotationAccepted {
+		return nil, newSaleError(409, "Quotation %s is %s; only accepted quotes can be sold", quote.QuoteNumber, strings.ToLower(quote.Status))
+	}
+	return &quote, nil
+}
+
+// convertQuotation marks a quote as sold
+func convertQuotation(tx *gorm.DB, quote *models.Quotation, saleID uint) error {
+	now := time.Now()
+	quote.Status = models.QuotationConverted
+	quote.SaleID = &saleID
+	quote.ConvertedAt = &now
+	return tx.Omit(clause.Associations).Save(quote).Error
+}
+
+// CreateQuotation prices a quote for a customer the way the till would
+// price the sale, without touching stock
+func (im *SalesManagementHandler) CreateQuotation(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req QuotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Products and customer name are required"})
+		return
+	}
+	if len(req.Products) == 0 {
+		c.JSON(400, gin.H{"error": "At least one product is required"})
+		return
+	}
+	customerPIN := strings.ToUpper(strings.TrimSpace(req.CustomerPIN))
+	if customerPIN != "" && !validKRAPin(customerPIN) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Customer PIN %s is not a valid KRA PIN", customerPIN)})
+		return
+	}
+	customerPhone := strings.TrimSpace(req.CustomerPhone)
+	if customerPhone != "" {
+		phone, err := mpesa.NormalizePhoneNumber(customerPhone)
+		if err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Customer phone number %s is not a valid Kenyan number", customerPhone)})
+			return
+		}
+		customerPhone = phone
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	validUntil := today.AddDate(0, 0, defaultQuoteValidity)
+	if req.ValidUntil != "" {
+		t, err := time.ParseInLocation(dateLayout, req.ValidUntil, now.Location())
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid valid_until date, expected YYYY-MM-DD"})
+			return
+		}
+		if t.Before(today) {
+			c.JSON(400, gin.H{"error": "valid_until must not be in the past"})
+			return
+		}
+		validUntil = t
+	}
+
+	saleData := SaleData{
+		Products:      req.Products,
+		Discount:      req.Discount,
+		CustomerName:  strings.TrimSpace(req.CustomerName),
+		CustomerPhone: customerPhone,
+		CustomerPIN:   customerPIN,
+	}
+	payload, err := json.Marshal(saleData)
+	if err != nil {
+		utils.ErrorLogger("Failed to encode quotation: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create quotation"})
+		return
+	}
+
+	quote := models.Quotation{
+		// Placeholder until the ID is known; it only needs to be unique
+		QuoteNumber:   utils.GenerateUUID(),
+		BusinessID:    user.BusinessID,
+		CreatedByID:   user.ID,
+		CustomerName:  saleData.CustomerName,
+		CustomerPhone: saleData.CustomerPhone,
+		CustomerEmail: strings.TrimSpace(req.CustomerEmail),
+		CustomerPIN:   customerPIN,
+		Notes:         strings.TrimSpace(req.Notes),
+		Status:        models.QuotationDraft,
+		ValidUntil:    validUntil,
+		Payload:       string(payload),
+	}
+
+	err = im.db.Transaction(func(tx *gorm.DB) error {
+		sale := models.Sale{BusinessID: user.BusinessID, CreatedAt: now}
+		items, err := priceSale(tx, &sale, saleData, &user)
+		if err != nil {
+			return err
+		}
+		if err := checkDiscountLimits(tx, &sale, items, &user, req.DiscountApproval); err != nil {
+			return err
+		}
+
+		quote.Subtotal = sale.Subtotal
+		quote.DiscountTotal = sale.DiscountTotal
+		quote.TaxTotal = sale.TaxTotal
+		quote.TotalAmount = sale.TotalAmount
+		for _, item := range items {
+			quote.Items = append(quote.Items, models.QuotationItem{
+				ProductID:     item.ProductID,
+				ProductName:   item.ProductName,
+				Quantity:      item.Quantity,
+				UnitPrice:     item.UnitPrice,
+				GrossAmount:   item.GrossAmount,
+				Discount:      roundMoney(item.Discount + item.BasketDiscount),
+				TaxCode:       item.TaxCode,
+				TaxRate:       item.TaxRate,
+				TaxableAmount: item.TaxableAmount,
+				TaxAmount:     item.TaxAmount,
+				LineTotal:     item.LineTotal,
+			})
+		}
+
+		if err := tx.Create(&quote).Error; err != nil {
+			return err
+		}
+		quote.QuoteNumber = fmt.Sprintf("Q%s-%06d", now.Format("20060102"), quote.ID)
+		return tx.Model(&quote).Update("quote_number", quote.QuoteNumber).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d created quotation %s for %s", user.ID, quote.QuoteNumber, quote.CustomerName)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    quote,
+	})
+}
+
+// GetQuotations lists quotes created in a date range
+func (im *SalesManagementHandler) GetQuotations(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	from, to, err := parseDateRange(c, 30)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := expireQuotations(im.db, user.BusinessID); err != nil {
+		utils.ErrorLogger("Failed to expire quotations: %v", err)
+	}
+
+	query := im.db.Preload("Items").
+		Where("business_id = ? AND created_at >= ? AND created_at < ?", user.BusinessID, from, to)
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if customer := strings.TrimSpace(c.Query("customer")); customer != "" {
+		query = query.Where("customer_name LIKE ? OR customer_phone LIKE ?", "%"+customer+"%", "%"+customer+"%")
+	}
+
+	var quotes []models.Quotation
+	if err := query.Order("created_at DESC").Find(&quotes).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch quotations: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch quotations"})
+		return
+	}
+
+	c.JSON(200, quotes)
+}
+
+func (im *SalesManagementHandler) findQuotation(c *gin.Context) (*models.Quotation, bool) {
+	user, _ := middleware.CurrentUser(c)
+
+	if err := expireQuotations(im.db, user.BusinessID); err != nil {
+		utils.ErrorLogger("Failed to expire quotations: %v", err)
+	}
+
+	var quote models.Quotation
+	if err := im.db.Preload("Items").
+		Where("business_id = ?", user.BusinessID).
+		First(&quote, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return nil, false
+	}
+	return &quote, true
+}
+
+// GetQuotation returns one quote with its items
+func (im *SalesManagementHandler) GetQuotation(c *gin.Context) {
+	quote, ok := im.findQuotation(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, quote)
+}
+
+// GetQuotationPDF renders a quote as an A4 PDF, titled as a proforma
+// invoice with ?proforma=true
+func (im *SalesManagementHandler) GetQuotationPDF(c *gin.Context) {
+	quote, ok := im.findQuotation(c)
+	if !ok {
+		return
+	}
+
+	var business models.Business
+	if err := im.db.First(&business, quote.BusinessID).Error; err != nil {
+		utils.ErrorLogger("Failed to load business %d: %v", quote.BusinessID, err)
+		c.JSON(500, gin.H{"error": "Failed to render quotation"})
+		return
+	}
+
+	q := receipt.Quote{
+		Business: receipt.Business{
+			Name:    business.Name,
+			Address: business.Address,
+			Phone:   business.Phone,
+			KRAPin:  business.KRAPin,
+		},
+		Number:        quote.QuoteNumber,
+		Date:          quote.CreatedAt,
+		ValidUntil:    quote.ValidUntil,
+		Customer:      quote.CustomerName,
+		CustomerPhone: quote.CustomerPhone,
+		CustomerEmail: quote.CustomerEmail,
+		CustomerPIN:   quote.CustomerPIN,
+		Subtotal:      quote.Subtotal,
+		Discount:      quote.DiscountTotal,
+		Total:         quote.TotalAmount,
+		Notes:         quote.Notes,
+		Proforma:      c.Query("proforma") == "true",
+	}
+	var preparer models.User
+	if err := im.db.First(&preparer, quote.CreatedByID).Error; err == nil {
+		q.PreparedBy = preparer.FullName
+	}
+
+	taxes := make(map[string]*receipt.Tax)
+	for _, item := range quote.Items {
+		q.Items = append(q.Items, receipt.Item{
+			Name:      item.ProductName,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Discount:  item.Discount,
+			Total:     item.LineTotal,
+			TaxCode:   item.TaxCode,
+		})
+		if item.TaxCode == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%.2f", item.TaxCode, item.TaxRate)
+		tax, ok := taxes[key]
+		if !ok {
+			tax = &receipt.Tax{Code: item.TaxCode, Rate: item.TaxRate}
+			taxes[key] = tax
+		}
+		tax.Taxable = roundMoney(tax.Taxable + item.TaxableAmount)
+		tax.Tax = roundMoney(tax.Tax + item.TaxAmount)
+	}
+	for _, tax := range taxes {
+		q.Taxes = append(q.Taxes, *tax)
+	}
+	sort.Slice(q.Taxes, func(i, j int) bool { return q.Taxes[i].Code < q.Taxes[j].Code })
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%s.pdf", strings.ToLower(quote.QuoteNumber)))
+	c.Data(200, "application/pdf", receipt.QuotePDF(q))
+}
+
+// UpdateQuotationStatus records the quote being sent to, accepted by or
+// turned down by the customer
+func (im *SalesManagementHandler) UpdateQuotationStatus(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req QuotationStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Status is required"})
+		return
+	}
+	status := strings.ToUpper(req.Status)
+
+	var quote models.Quotation
+	err := im.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&quote, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Quotation not found")
+		}
+
+		now := time.Now()
+		open := quote.Status == models.QuotationDraft || quote.Status == models.QuotationSent
+		if open && quoteExpired(&quote, now) {
+			quote.Status = models.QuotationExpired
+			if err := tx.Model(&quote).Update("status", quote.Status).Error; err != nil {
+				return err
+			}
+			return newSaleError(409, "Quotation %s expired on %s", quote.QuoteNumber, quote.ValidUntil.Format(dateLayout))
+		}
+
+		switch status {
+		case models.QuotationSent:
+			if !open {
+				return newSaleError(409, "Quotation %s is %s", quote.QuoteNumber, strings.ToLower(quote.Status))
+			}
+			quote.SentAt = &now
+		case models.QuotationAccepted:
+			if !open {
+				return newSaleError(409, "Quotation %s is %s", quote.QuoteNumber, strings.ToLower(quote.Status))
+			}
+			quote.AcceptedAt = &now
+		case models.QuotationRejected:
+			if !open && quote.Status != models.QuotationAccepted {
+				return newSaleError(409, "Quotation %s is %s", quote.QuoteNumber, strings.ToLower(quote.Status))
+			}
+			quote.RejectedAt = &now
+		default:
+			return newSaleError(400, "Status must be SENT, ACCEPTED or REJECTED")
+		}
+		quote.Status = status
+		return tx.Omit(clause.Associations).Save(&quote).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("Quotation %s marked %s by user %d", quote.QuoteNumber, quote.Status, user.ID)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    quote,
+	})
+}
+
+// ConvertQuotation sells an accepted quote through the same path as a till
+// sale. Stock is checked again, and the sale stops with a conflict if any
+// price or the total has moved since the quote, unless the customer has
+// agreed to today's prices.
+func (im *SalesManagementHandler) ConvertQuotation(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req ConvertQuotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format. Expected JSON with payment details"})
+		return
+	}
+	if req.PaymentMethod == "" && len(req.Payments) == 0 {
+		c.JSON(400, gin.H{"error": "Payment method is required"})
+		return
+	}
+
+	var quote models.Quotation
+	if err := im.db.Preload("Items").
+		Where("business_id = ?", user.BusinessID).
+		First(&quote, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Quotation not found"})
+		return
+	}
+
+	var saleData SaleData
+	if err := json.Unmarshal([]byte(quote.Payload), &saleData); err != nil {
+		utils.ErrorLogger("Failed to decode quotation %s: %v", quote.QuoteNumber, err)
+		c.JSON(500, gin.H{"error": "Failed to convert quotation"})
+		return
+	}
+	// Items are stored in the order they were requested
+	for i := range saleData.Products {
+		saleData.Products[i].Amount = nil
+		if !req.AcceptCurrentPrices && i < len(quote.Items) {
+			amount := quote.Items[i].GrossAmount
+			saleData.Products[i].Amount = &amount
+		}
+	}
+	saleData.TotalAmount = nil
+	if !req.AcceptCurrentPrices {
+		total := quote.TotalAmount
+		saleData.TotalAmount = &total
+	}
+	saleData.PaymentMethod = req.PaymentMethod
+	saleData.Payments = req.Payments
+	saleData.ReferenceNumber = req.ReferenceNumber
+	saleData.AmountPaid = req.AmountPaid
+	saleData.DiscountApproval = req.DiscountApproval
+	saleData.IdempotencyKey = req.IdempotencyKey
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		saleData.IdempotencyKey = key
+	}
+	saleData.QuotationID = &quote.ID
+
+	utils.InfoLogger("Converting quotation %s to a sale", quote.QuoteNumber)
+	processSales(saleData, im, c)
+}
@@ -0,0 +1,279 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/receipt"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReprintRequest struct {
+	Reason string `json:"reason"`
+}
+
+// receiptFormat reads the format and size query parameters, defaulting to
+// an 80 mm PDF
+func receiptFormat(c *gin.Context) (string, error) {
+	format := strings.ToLower(c.DefaultQuery("format", "pdf"))
+	size := strings.ToLower(c.Query("size"))
+	switch format {
+	case "pdf":
+		switch size {
+		case "", "80mm":
+			return models.ReceiptPDF80mm, nil
+		case "a4":
+			return models.ReceiptPDFA4, nil
+		}
+		return "", fmt.Errorf("PDF receipts come in a4 or 80mm")
+	case "escpos":
+		switch size {
+		case "", "80mm":
+			return models.ReceiptESCPOS80mm, nil
+		case "58mm":
+			return models.ReceiptESCPOS58mm, nil
+		}
+		return "", fmt.Errorf("ESC/POS receipts come in 58mm or 80mm")
+	case "sms":
+		return models.ReceiptSMS, nil
+	}
+	return "", fmt.Errorf("format must be pdf, escpos or sms")
+}
+
+// GetSaleReceipt issues the original receipt for a sale. Once a receipt has
+// been printed, further copies go through ReprintReceipt so they are marked
+// as duplicates. SMS receipts can be sent as well as a printed one.
+func (im *SalesManagementHandler) GetSaleReceipt(c *gin.Context) {
+	im.issueReceipt(c, false)
+}
+
+// ReprintReceipt issues a numbered duplicate of a sale's receipt
+func (im *SalesManagementHandler) ReprintReceipt(c *gin.Context) {
+	im.issueReceipt(c, true)
+}
+
+func (im *SalesManagementHandler) issueReceipt(c *gin.Context, reprint bool) {
+	user, _ := middleware.CurrentUser(c)
+
+	format, err := receiptFormat(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var req ReprintRequest
+	if reprint && c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	var sale models.Sale
+	if err := im.db.Preload("Items").Preload("Payments").
+		Where("business_id = ?", user.BusinessID).
+		First(&sale, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Sale not found"})
+		return
+	}
+
+	var record models.ReceiptPrint
+	err = im.db.Transaction(func(tx *gorm.DB) error {
+		// Holding the sale row stops two tills both printing the original
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Sale{}, sale.ID).Error; err != nil {
+			return err
+		}
+
+		// Copies are numbered by the paper receipts printed before;
+		// receipts sent by SMS do not count
+		var printed int64
+		if err := tx.Model(&models.ReceiptPrint{}).Where("sale_id = ? AND format <> ?", sale.ID, models.ReceiptSMS).Count(&printed).Error; err != nil {
+			return err
+		}
+		if !reprint && printed > 0 && format != models.ReceiptSMS {
+			return newSaleError(409, "The receipt for sale %s has already been printed; reprint it as a duplicate", sale.SaleNumber)
+		}
+
+		record = models.ReceiptPrint{
+			SaleID:      sale.ID,
+			BusinessID:  sale.BusinessID,
+			Format:      format,
+			PrintedByID: &user.ID,
+			Reason:      strings.TrimSpace(req.Reason),
+		}
+		if reprint {
+			record.Copy = int(printed)
+			if record.Copy == 0 {
+				record.Copy = 1
+			}
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	r, err := buildReceipt(im.db, &sale)
+	if err != nil {
+		utils.ErrorLogger("Failed to build receipt for sale %d: %v", sale.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to build receipt"})
+		return
+	}
+	r.Copy = record.Copy
+
+	if reprint {
+		utils.InfoLogger("Receipt for sale %s reprinted as copy %d by user %d", sale.SaleNumber, record.Copy, user.ID)
+	}
+
+	filename := strings.ToLower(sale.SaleNumber)
+	switch format {
+	case models.ReceiptPDFA4:
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%s.pdf", filename))
+		c.Data(200, "application/pdf", receipt.PDF(*r, receipt.PageA4))
+	case models.ReceiptPDF80mm:
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%s.pdf", filename))
+		c.Data(200, "application/pdf", receipt.PDF(*r, receipt.Page80mm))
+	case models.ReceiptESCPOS58mm:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.bin", filename))
+		c.Data(200, "application/octet-stream", receipt.ESCPOS(*r, receipt.Width58mm))
+	case models.ReceiptESCPOS80mm:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.bin", filename))
+		c.Data(200, "application/octet-stream", receipt.ESCPOS(*r, receipt.Width80mm))
+	case models.ReceiptSMS:
+		c.JSON(200, gin.H{
+			"success": true,
+			"data": gin.H{
+				"phone": sale.CustomerPhone,
+				"text":  receipt.SMS(*r),
+			},
+		})
+	}
+}
+
+// GetReceiptPrints lists the receipts issued for a sale
+func (im *SalesManagementHandler) GetReceiptPrints(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var prints []models.ReceiptPrint
+	if err := im.db.Where("business_id = ? AND sale_id = ?", user.BusinessID, c.Param("id")).
+		Order("id").Find(&prints).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch receipt prints: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch receipt prints"})
+		return
+	}
+
+	c.JSON(200, prints)
+}
+
+// buildReceipt gathers what a sale's receipt shows. It prints the sale as
+// it was made; returns have their own documents.
+func buildReceipt(db *gorm.DB, sale *models.Sale) (*receipt.Receipt, error) {
+	var business models.Business
+	if err := db.First(&business, sale.BusinessID).Error; err != nil {
+		return nil, err
+	}
+
+	r := &receipt.Receipt{
+		Business: receipt.Business{
+			Name:    business.Name,
+			Address: business.Address,
+			Phone:   business.Phone,
+			KRAPin:  business.KRAPin,
+		},
+		SaleNumber:  sale.SaleNumber,
+		Date:        sale.CreatedAt,
+		Customer:    sale.CustomerName,
+		CustomerPIN: sale.CustomerPIN,
+		Subtotal:    sale.Subtotal,
+		Discount:    sale.DiscountTotal,
+		Total:       sale.TotalAmount,
+		Change:      sale.ChangeDue,
+		BalanceDue:  sale.BalanceDue,
+	}
+	if sale.CashierID != nil {
+		var cashier models.User
+		if err := db.First(&cashier, *sale.CashierID).Error; err == nil {
+			r.Cashier = cashier.FullName
+		}
+	}
+
+	taxes := make(map[string]*receipt.Tax)
+	for _, item := range sale.Items {
+		r.Items = append(r.Items, receipt.Item{
+			Name:      item.ProductName,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Discount:  roundMoney(item.Discount + item.BasketDiscount),
+			Total:     item.LineTotal,
+			TaxCode:   item.TaxCode,
+		})
+		if item.TaxCode == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%.2f", item.TaxCode, item.TaxRate)
+		tax, ok := taxes[key]
+		if !ok {
+			tax = &receipt.Tax{Code: item.TaxCode, Rate: item.TaxRate}
+			taxes[key] = tax
+		}
+		tax.Taxable = roundMoney(tax.Taxable + item.TaxableAmount)
+		tax.Tax = roundMoney(tax.Tax + item.TaxAmount)
+	}
+	for _, tax := range taxes {
+		r.Taxes = append(r.Taxes, *tax)
+	}
+	sort.Slice(r.Taxes, func(i, j int) bool { return r.Taxes[i].Code < r.Taxes[j].Code })
+
+	// M-Pesa tenders show the receipt number from M-Pesa's confirmation,
+	// never a reference typed at the till
+	var transactionIDs []string
+	for _, payment := range sale.Payments {
+		if payment.MpesaTransactionID != nil {
+			transactionIDs = append(transactionIDs, *payment.MpesaTransactionID)
+		}
+	}
+	confirmed := make(map[string]string, len(transactionIDs))
+	if len(transactionIDs) > 0 {
+		var transactions []models.MpesaTransaction
+		if err := db.Where("id IN ?", transactionIDs).Find(&transactions).Error; err != nil {
+			return nil, err
+		}
+		for _, transaction := range transactions {
+			if transaction.ReceiptNumber != nil {
+				confirmed[transaction.ID] = *transaction.ReceiptNumber
+			}
+		}
+	}
+
+	for _, payment := range saleTenders(sale) {
+		reference := payment.Reference
+		if payment.Method == models.PaymentMpesa {
+			reference = ""
+			if payment.MpesaTransactionID != nil {
+				reference = confirmed[*payment.MpesaTransactionID]
+			}
+		}
+		r.Payments = append(r.Payments, receipt.Payment{
+			Method:    payment.Method,
+			Amount:    payment.Amount,
+			Tendered:  payment.Tendered,
+			Reference: reference,
+		})
+	}
+
+	if sale.EtimsInvoiceNumber != "" {
+		r.Etims = &receipt.Etims{
+			InvoiceNumber: sale.EtimsInvoiceNumber,
+			InternalData:  sale.EtimsInternalData,
+			Signature:     sale.EtimsSignature,
+			QRData:        sale.EtimsQRData,
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,627 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReturnLineRequest struct {
+	SaleItemID  uint   `json:"sale_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required"`
+	Disposition string `json:"disposition"`
+	// WriteOffReason applies to WRITE_OFF lines and defaults to DAMAGED
+	WriteOffReason string `json:"write_off_reason"`
+}
+
+type ReturnRequest struct {
+	Items        []ReturnLineRequest `json:"items" binding:"required"`
+	Reason       string              `json:"reason"`
+	RefundMethod string              `json:"refund_method"`
+	// ShiftID is the open shift whose drawer pays out a cash refund; see
+	// refundShift for the default
+	ShiftID *uint `json:"shift_id"`
+}
+
+type VoidRequest struct {
+	Reason       string `json:"reason" binding:"required"`
+	RefundMethod string `json:"refund_method"`
+	ShiftID      *uint  `json:"shift_id"`
+}
+
+// ReturnSale takes back some or all of the goods on a sale. Each line is
+// either restocked or written off, and the customer is refunded whatever
+// they paid beyond what they still owe.
+func (im *SalesManagementHandler) ReturnSale(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req ReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorLogger("Failed to parse return request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Items) == 0 {
+		c.JSON(400, gin.H{"error": "At least one item must be returned"})
+		return
+	}
+
+	im.runReturn(c, user, func(tx *gorm.DB, sale *models.Sale) (*models.SaleReturn, error) {
+		return processReturn(tx, sale, req.Items, user, req.Reason, req.RefundMethod, req.ShiftID, false)
+	})
+}
+
+// VoidSale cancels a sale made today, restocking everything on it and
+// refunding the customer in full.
+func (im *SalesManagementHandler) VoidSale(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req VoidRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorLogger("Failed to parse void request: %v", err)
+		c.JSON(400, gin.H{"error": "A reason is required to void a sale"})
+		return
+	}
+
+	im.runReturn(c, user, func(tx *gorm.DB, sale *models.Sale) (*models.SaleReturn, error) {
+		if sale.Status != models.SaleStatusCompleted {
+			return nil, newSaleError(400, "Only sales with no returns can be voided")
+		}
+		now := time.Now()
+		y1, m1, d1 := sale.CreatedAt.Date()
+		y2, m2, d2 := now.Date()
+		if y1 != y2 || m1 != m2 || d1 != d2 {
+			return nil, newSaleError(400, "Only sales made today can be voided; record a return instead")
+		}
+
+		lines := make([]ReturnLineRequest, 0, len(sale.Items))
+		for _, item := range sale.Items {
+			lines = append(lines, ReturnLineRequest{
+				SaleItemID:  item.ID,
+				Quantity:    item.Quantity,
+				Disposition: models.ReturnRestock,
+			})
+		}
+		saleReturn, err := processReturn(tx, sale, lines, user, req.Reason, req.RefundMethod, req.ShiftID, true)
+		if err != nil {
+			return nil, err
+		}
+
+		sale.Status = models.SaleStatusVoided
+		sale.VoidedAt = &now
+		sale.VoidedByID = &user.ID
+		if err := tx.Omit(clause.Associations).Save(sale).Error; err != nil {
+			return nil, err
+		}
+		return saleReturn, nil
+	})
+}
+
+// runReturn loads and locks the sale named in the URL, runs fn in a
+// transaction and writes the response.
+func (im *SalesManagementHandler) runReturn(c *gin.Context, user models.User, fn func(tx *gorm.DB, sale *models.Sale) (*models.SaleReturn, error)) {
+	ctx, outbox := events.WithOutbox(context.Background(), user.BusinessID)
+	tx := im.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
+		c.JSON(500, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var sale models.Sale
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Items").
+		Preload("Payments").
+		Where("business_id = ?", user.BusinessID).
+		First(&sale, c.Param("id")).Error; err != nil {
+		tx.Rollback()
+		c.JSON(404, gin.H{"error": "Sale not found"})
+		return
+	}
+
+	saleReturn, err := fn(tx, &sale)
+	if err != nil {
+		tx.Rollback()
+		respondSaleError(c, err)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit return: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to record return"})
+		return
+	}
+	outbox.Flush()
+
+	utils.InfoLogger("Recorded return %s against sale %s", saleReturn.ReturnNumber, sale.SaleNumber)
+	c.JSON(200, gin.H{
+		"message": "Return recorded successfully",
+		"return":  saleReturn,
+		"sale":    sale,
+	})
+}
+
+// processReturn takes lines back from sale inside tx. Returned value first
+// comes off any balance the customer still owes; the rest is refunded by
+// refundMethod, which defaults to the way the sale was paid, from the drawer
+// of the shift refundShift picks.
+func processReturn(tx *gorm.DB, sale *models.Sale, lines []ReturnLineRequest, user models.User, reason, refundMethod string, shiftID *uint, void bool) (*models.SaleReturn, error) {
+	if sale.Status == models.SaleStatusVoided || sale.Status == models.SaleStatusReturned {
+		return nil, newSaleError(400, "Sale %s has already been fully returned", sale.SaleNumber)
+	}
+
+	saleReturn := models.SaleReturn{
+		// Placeholder until the ID is known; it only needs to be unique
+		ReturnNumber:  utils.GenerateUUID(),
+		BusinessID:    sale.BusinessID,
+		SaleID:        sale.ID,
+		Void:          void,
+		Reason:        strings.TrimSpace(reason),
+		ProcessedByID: user.ID,
+		RefundStatus:  models.RefundCompleted,
+	}
+	shiftID, err := refundShift(tx, sale, shiftID, user)
+	if err != nil {
+		return nil, err
+	}
+	saleReturn.ShiftID = shiftID
+	if err := tx.Create(&saleReturn).Error; err != nil {
+		return nil, err
+	}
+	saleReturn.ReturnNumber = fmt.Sprintf("R%s-%06d", time.Now().Format("20060102"), saleReturn.ID)
+
+	returnItems, err := returnLines(sale, lines)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[uint]*models.SaleItem, len(sale.Items))
+	for i := range sale.Items {
+		items[sale.Items[i].ID] = &sale.Items[i]
+	}
+
+	for i, returnItem := range returnItems {
+		item := items[returnItem.SaleItemID]
+		returnItem.SaleReturnID = saleReturn.ID
+
+		movementID, err := restockReturn(tx, item, returnItem.Quantity, saleReturn.ReturnNumber)
+		if err != nil {
+			return nil, err
+		}
+		returnItem.StockMovementID = &movementID
+
+		if returnItem.Disposition == models.ReturnWriteOff {
+			writeOff, err := writeOffReturn(tx, item, lines[i], user, saleReturn.ReturnNumber)
+			if err != nil {
+				return nil, err
+			}
+			returnItem.WriteOffID = &writeOff.ID
+		}
+
+		if err := tx.Create(&returnItem).Error; err != nil {
+			return nil, err
+		}
+		saleReturn.Items = append(saleReturn.Items, returnItem)
+		saleReturn.ReturnedAmount += returnItem.Amount
+
+		item.ReturnedQuantity += returnItem.Quantity
+		if err := tx.Omit(clause.Associations).Save(item).Error; err != nil {
+			return nil, err
+		}
+	}
+	saleReturn.ReturnedAmount = roundMoney(saleReturn.ReturnedAmount)
+	saleReturn.RefundAmount = refundDue(sale, saleReturn.ReturnedAmount)
+
+	if saleReturn.RefundAmount > 0 {
+		refunded, err := refundedByMethod(tx, sale.ID)
+		if err != nil {
+			return nil, err
+		}
+		method, err := refundMethodFor(sale, refundMethod, saleReturn.RefundAmount, refunded)
+		if err != nil {
+			return nil, err
+		}
+		saleReturn.RefundMethod = &method
+
+		now := time.Now()
+		switch method {
+		case models.RefundMpesaReversal:
+			// Reversals are made on the M-Pesa portal and confirmed later
+			saleReturn.RefundStatus = models.RefundPending
+		case models.RefundCreditNote:
+			note := models.CreditNote{
+				Number:        "CN" + strings.TrimPrefix(saleReturn.ReturnNumber, "R"),
+				BusinessID:    sale.BusinessID,
+				SaleReturnID:  saleReturn.ID,
+				CustomerName:  sale.CustomerName,
+				CustomerPhone: sale.CustomerPhone,
+				Amount:        saleReturn.RefundAmount,
+				Balance:       saleReturn.RefundAmount,
+			}
+			if err := tx.Create(&note).Error; err != nil {
+				return nil, err
+			}
+			saleReturn.RefundReference = note.Number
+			saleReturn.RefundedAt = &now
+		case models.RefundPoints:
+			if err := refundPoints(tx, sale, &saleReturn); err != nil {
+				return nil, err
+			}
+			saleReturn.RefundedAt = &now
+		default:
+			saleReturn.RefundedAt = &now
+		}
+	}
+
+	if err := tx.Omit(clause.Associations).Save(&saleReturn).Error; err != nil {
+		return nil, err
+	}
+
+	sale.ReturnedTotal = roundMoney(sale.ReturnedTotal + saleReturn.ReturnedAmount)
+	sale.RefundedTotal = roundMoney(sale.RefundedTotal + saleReturn.RefundAmount)
+	updateSaleBalance(sale)
+	sale.Status = models.SaleStatusReturned
+	for _, item := range sale.Items {
+		if item.ReturnedQuantity < item.Quantity {
+			sale.Status = models.SaleStatusPartiallyReturned
+			break
+		}
+	}
+	if err := tx.Omit(clause.Associations).Save(sale).Error; err != nil {
+		return nil, err
+	}
+	if err := reverseEarnedPoints(tx, sale, &saleReturn); err != nil {
+		return nil, err
+	}
+
+	// Take what the return cleared off the customer's credit account,
+	// shared across the returned lines by value
+	cleared := roundMoney(saleReturn.ReturnedAmount - saleReturn.RefundAmount)
+	if cleared > 0 && tenderTotal(sale, models.PaymentCredit) > 0 {
+		allocated := 0.0
+		for i, returnItem := range saleReturn.Items {
+			share := cleared - allocated
+			if i < len(saleReturn.Items)-1 && saleReturn.ReturnedAmount > 0 {
+				share = roundMoney(cleared * returnItem.Amount / saleReturn.ReturnedAmount)
+			}
+			allocated = roundMoney(allocated + share)
+
+			creditTx := models.CreditTransaction{
+				SaleID:       &sale.ID,
+				CustomerID:   sale.CustomerID,
+				ProductID:    returnItem.ProductID,
+				Name:         sale.CustomerName,
+				PhoneNumber:  sale.CustomerPhone,
+				Quantity:     -returnItem.Quantity,
+				CreditAmount: -roundMoney(share),
+				BalanceDue:   sale.BalanceDue,
+				Status:       "PENDING",
+			}
+			if err := tx.Create(&creditTx).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &saleReturn, nil
+}
+
+// returnLines checks the requested lines against what is left to return on
+// the sale and values them. Lines are valued from cumulative totals, so
+// repeated partial returns add up to the line total exactly.
+func returnLines(sale *models.Sale, lines []ReturnLineRequest) ([]models.SaleReturnItem, error) {
+	items := make(map[uint]models.SaleItem, len(sale.Items))
+	returned := make(map[uint]int, len(sale.Items))
+	for _, item := range sale.Items {
+		items[item.ID] = item
+		returned[item.ID] = item.ReturnedQuantity
+	}
+
+	returnItems := make([]models.SaleReturnItem, 0, len(lines))
+	for _, line := range lines {
+		item, ok := items[line.SaleItemID]
+		if !ok {
+			return nil, newSaleError(400, "Item %d is not on sale %s", line.SaleItemID, sale.SaleNumber)
+		}
+		remaining := item.Quantity - returned[item.ID]
+		if line.Quantity <= 0 || line.Quantity > remaining {
+			return nil, newSaleError(400, "Only %d of %s can be returned", remaining, item.ProductName)
+		}
+
+		disposition := strings.ToUpper(line.Disposition)
+		if disposition == "" {
+			disposition = models.ReturnRestock
+		}
+		if disposition != models.ReturnRestock && disposition != models.ReturnWriteOff {
+			return nil, newSaleError(400, "Disposition must be RESTOCK or WRITE_OFF")
+		}
+
+		perUnit := item.LineTotal / float64(item.Quantity)
+		amount := roundMoney(perUnit*float64(returned[item.ID]+line.Quantity)) -
+			roundMoney(perUnit*float64(returned[item.ID]))
+		returned[item.ID] += line.Quantity
+
+		returnItems = append(returnItems, models.SaleReturnItem{
+			SaleItemID:  item.ID,
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Quantity:    line.Quantity,
+			Amount:      roundMoney(amount),
+			Disposition: disposition,
+		})
+	}
+	return returnItems, nil
+}
+
+// refundDue is how much of the returned value goes back to the customer.
+// Goods taken back on credit first clear what is still owed.
+func refundDue(sale *models.Sale, returned float64) float64 {
+	owed := math.Max(sale.BalanceDue, 0)
+	return roundMoney(returned - math.Min(returned, owed))
+}
+
+// refundedByMethod totals what earlier returns of the sale refunded, by
+// refund method
+func refundedByMethod(tx *gorm.DB, saleID uint) (map[string]float64, error) {
+	var rows []struct {
+		RefundMethod string
+		Total        float64
+	}
+	if err := tx.Model(&models.SaleReturn{}).
+		Select("refund_method, SUM(refund_amount) AS total").
+		Where("sale_id = ? AND refund_method IS NOT NULL", saleID).
+		Group("refund_method").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	refunded := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		refunded[row.RefundMethod] = roundMoney(row.Total)
+	}
+	return refunded, nil
+}
+
+// refundTenders is the tender each refund method gives money back to. A
+// credit note can refund any tender, since store credit never leaves the
+// shop; the others are limited to what was paid with their tender, so a
+// return cannot turn store credit or points into cash.
+var refundTenders = map[string]string{
+	models.RefundCash:          models.PaymentCash,
+	models.RefundMpesaReversal: models.PaymentMpesa,
+	models.RefundPoints:        models.PaymentPoints,
+}
+
+// refundMethodFor checks that amount can be refunded by the requested
+// method, defaulting to the way the customer paid. refunded holds what
+// earlier returns of the sale already refunded by each method.
+func refundMethodFor(sale *models.Sale, requested string, amount float64, refunded map[string]float64) (string, error) {
+	method := strings.ToUpper(requested)
+	if method == "" {
+		method = models.RefundCash
+		switch sale.PaymentMethod {
+		case models.PaymentMpesa:
+			method = models.RefundMpesaReversal
+		case models.PaymentPoints:
+			method = models.RefundPoints
+		case models.PaymentCreditNote:
+			method = models.RefundCreditNote
+		}
+	}
+
+	switch method {
+	case models.RefundCreditNote:
+		return method, nil
+	case models.RefundCash, models.RefundMpesaReversal, models.RefundPoints:
+	default:
+		return "", newSaleError(400, "Refund method must be CASH, MPESA_REVERSAL, CREDIT_NOTE or POINTS")
+	}
+	if method == models.RefundPoints && sale.CustomerID == nil {
+		return "", newSaleError(400, "Only sales to a customer can be refunded as points")
+	}
+
+	tender := refundTenders[method]
+	left := math.Max(roundMoney(tenderTotal(sale, tender)-refunded[method]), 0)
+	if amount > left && !sameAmount(amount, left) {
+		return "", newSaleError(400, "Only %.2f paid by %s is left to refund as %s; refund %.2f as a CREDIT_NOTE instead",
+			left, tender, method, amount)
+	}
+	return method, nil
+}
+
+// restockReturn puts returned units back into inventory and records the
+// movement.
+func restockReturn(tx *gorm.DB, item *models.SaleItem, quantity int, returnNumber string) (uint, error) {
+	var inventory models.Inventory
+	if err := tx.Where("product_id = ?", item.ProductID).First(&inventory).Error; err != nil {
+		return 0, err
+	}
+	inventory.Quantity += quantity
+	inventory.LastUpdated = time.Now()
+	if err := tx.Save(&inventory).Error; err != nil {
+		return 0, err
+	}
+
+	movement := models.StockMovement{
+		ProductID:      item.ProductID,
+		ChangeType:     models.MovementReturn,
+		QuantityChange: quantity,
+		Note:           fmt.Sprintf("Return %s", returnNumber),
+		CreatedAt:      time.Now(),
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return 0, err
+	}
+	queueStockChanged(tx, item.ProductName, inventory)
+
+	if err := syncLowStockAlert(tx, item.ProductName, inventory); err != nil {
+		utils.ErrorLogger("Failed to update low stock alert for product %d: %v", item.ProductID, err)
+	}
+	return movement.ID, nil
+}
+
+// writeOffReturn writes off returned units that cannot be sold again. The
+// units have just been restocked, so the write-off is approved straight away
+// and shows up in shrinkage reports like any other.
+func writeOffReturn(tx *gorm.DB, item *models.SaleItem, line ReturnLineRequest, user models.User, returnNumber string) (*models.StockWriteOff, error) {
+	reason := strings.ToUpper(line.WriteOffReason)
+	if reason == "" {
+		reason = models.WriteOffDamaged
+	}
+	if !writeOffReasons[reason] {
+		return nil, newSaleError(400, "Write-off reason must be one of EXPIRED, DAMAGED, THEFT or INTERNAL_USE")
+	}
+
+	var product models.Product
+	if err := tx.First(&product, item.ProductID).Error; err != nil {
+		return nil, err
+	}
+
+	writeOff := models.StockWriteOff{
+		ProductID:    item.ProductID,
+		Quantity:     line.Quantity,
+		Reason:       reason,
+		Note:         fmt.Sprintf("Returned on %s", returnNumber),
+		UnitCost:     product.CostPrice,
+		TotalCost:    product.CostPrice * float64(line.Quantity),
+		Status:       models.WriteOffPending,
+		RecordedByID: user.ID,
+		ReviewedByID: &user.ID,
+	}
+	if err := tx.Create(&writeOff).Error; err != nil {
+		return nil, err
+	}
+	if err := applyWriteOff(tx, &writeOff, item.ProductName); err != nil {
+		return nil, err
+	}
+	return &writeOff, nil
+}
+
+// CompleteRefund records the reference of an M-Pesa reversal once it has
+// been made.
+func (im *SalesManagementHandler) CompleteRefund(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req struct {
+		Reference string `json:"reference" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "The reversal reference is required"})
+		return
+	}
+
+	var saleReturn models.SaleReturn
+	if err := im.db.Where("business_id = ?", user.BusinessID).First(&saleReturn, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Return not found"})
+		return
+	}
+	if saleReturn.RefundStatus != models.RefundPending {
+		c.JSON(400, gin.H{"error": "Refund is not pending"})
+		return
+	}
+
+	now := time.Now()
+	saleReturn.RefundStatus = models.RefundCompleted
+	saleReturn.RefundReference = strings.TrimSpace(req.Reference)
+	saleReturn.RefundedAt = &now
+	if err := im.db.Omit(clause.Associations).Save(&saleReturn).Error; err != nil {
+		utils.ErrorLogger("Failed to complete refund for return %d: %v", saleReturn.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to complete refund"})
+		return
+	}
+
+	utils.InfoLogger("Completed refund for return %s", saleReturn.ReturnNumber)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    saleReturn,
+	})
+}
+
+// GetSaleReturns lists returns and voids, newest first, optionally for one
+// sale or only those with a refund still pending.
+func (im *SalesManagementHandler) GetSaleReturns(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	query := im.db.Preload("Items").
+		Where("business_id = ?", user.BusinessID).
+		Order("created_at DESC")
+	if v := c.Query("sale_id"); v != "" {
+		saleID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid sale id"})
+			return
+		}
+		query = query.Where("sale_id = ?", saleID)
+	}
+	if c.Query("refund_status") == models.RefundPending {
+		query = query.Where("refund_status = ?", models.RefundPending)
+	}
+
+	var returns []models.SaleReturn
+	if err := query.Find(&returns).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch returns: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(200, returns)
+}
+
+// redeemCreditNote spends store credit on a sale. The note is locked while
+// its balance is checked and reduced, so it cannot be spent twice at once.
+func redeemCreditNote(tx *gorm.DB, sale *models.Sale, payment *models.SalePayment) error {
+	number := strings.ToUpper(strings.TrimSpace(payment.Reference))
+	if number == "" {
+		return newSaleError(400, "Give the credit note number as the CREDIT_NOTE payment's reference")
+	}
+
+	var note models.CreditNote
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("number = ? AND business_id = ?", number, sale.BusinessID).
+		First(&note).Error; err != nil {
+		return newSaleError(404, "Credit note %s not found", number)
+	}
+	if note.Balance < payment.Amount && !sameAmount(note.Balance, payment.Amount) {
+		return newSaleError(400, "Credit note %s has only %.2f left", note.Number, note.Balance)
+	}
+
+	note.Balance = math.Max(roundMoney(note.Balance-payment.Amount), 0)
+	if err := tx.Model(&note).Update("balance", note.Balance).Error; err != nil {
+		return err
+	}
+	payment.Reference = note.Number
+	return nil
+}
+
+// GetCreditNotes lists credit notes, optionally for one customer's phone
+func (im *SalesManagementHandler) GetCreditNotes(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	query := im.db.Where("business_id = ?", user.BusinessID).Order("created_at DESC")
+	if phone := c.Query("phone"); phone != "" {
+		query = query.Where("customer_phone = ?", phone)
+	}
+
+	var notes []models.CreditNote
+	if err := query.Find(&notes).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch credit notes: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch credit notes"})
+		return
+	}
+
+	c.JSON(200, notes)
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func returnTestSale() *models.Sale {
+	return &models.Sale{
+		SaleNumber: "S20240301-000001",
+		Items: []models.SaleItem{
+			{ID: 1, ProductID: 10, ProductName: "Sukari 2kg", Quantity: 3, LineTotal: 100},
+			{ID: 2, ProductID: 11, ProductName: "Maziwa", Quantity: 2, LineTotal: 120, ReturnedQuantity: 1},
+		},
+	}
+}
+
+func TestReturnLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []ReturnLineRequest
+		wantStatus  int
+		wantAmounts []float64
+	}{
+		{"one unit", []ReturnLineRequest{{SaleItemID: 1, Quantity: 1}}, 0, []float64{33.33}},
+		{"whole line", []ReturnLineRequest{{SaleItemID: 1, Quantity: 3}}, 0, []float64{100}},
+		// Split across lines, the units still add up to the line total
+		{"units one at a time", []ReturnLineRequest{{SaleItemID: 1, Quantity: 1}, {SaleItemID: 1, Quantity: 1}, {SaleItemID: 1, Quantity: 1}}, 0, []float64{33.33, 33.34, 33.33}},
+		{"what is left after an earlier return", []ReturnLineRequest{{SaleItemID: 2, Quantity: 1}}, 0, []float64{60}},
+		{"more than is left", []ReturnLineRequest{{SaleItemID: 2, Quantity: 2}}, 400, nil},
+		{"more than is left across lines", []ReturnLineRequest{{SaleItemID: 1, Quantity: 2}, {SaleItemID: 1, Quantity: 2}}, 400, nil},
+		{"nothing", []ReturnLineRequest{{SaleItemID: 1, Quantity: 0}}, 400, nil},
+		{"not on the sale", []ReturnLineRequest{{SaleItemID: 9, Quantity: 1}}, 400, nil},
+		{"unknown disposition", []ReturnLineRequest{{SaleItemID: 1, Quantity: 1, Disposition: "BIN"}}, 400, nil},
+	}
+	for _, tt := range tests {
+		sale := returnTestSale()
+		got, err := returnLines(sale, tt.lines)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if len(got) != len(tt.wantAmounts) {
+			t.Errorf("%s: %d lines, want %d", tt.name, len(got), len(tt.wantAmounts))
+			continue
+		}
+		for i, line := range got {
+			if line.Amount != tt.wantAmounts[i] {
+				t.Errorf("%s: line %d amount %.2f, want %.2f", tt.name, i, line.Amount, tt.wantAmounts[i])
+			}
+		}
+		if sale.Items[0].ReturnedQuantity != 0 || sale.Items[1].ReturnedQuantity != 1 {
+			t.Errorf("%s: returnLines changed the sale's returned quantities", tt.name)
+		}
+	}
+}
+
+func TestReturnLinesDisposition(t *testing.T) {
+	got, err := returnLines(returnTestSale(), []ReturnLineRequest{
+		{SaleItemID: 1, Quantity: 1},
+		{SaleItemID: 1, Quantity: 1, Disposition: "write_off"},
+	})
+	if err != nil {
+		t.Fatalf("returnLines: %v", err)
+	}
+	if got[0].Disposition != models.ReturnRestock || got[1].Disposition != models.ReturnWriteOff {
+		t.Errorf("dispositions %s and %s, want RESTOCK and WRITE_OFF", got[0].Disposition, got[1].Disposition)
+	}
+	if got[1].ProductID != 10 || got[1].ProductName != "Sukari 2kg" {
+		t.Errorf("line = %+v, want the sale item's product", got[1])
+	}
+}
+
+func TestRefundDue(t *testing.T) {
+	tests := []struct {
+		balanceDue, returned, want float64
+	}{
+		{0, 100, 100},
+		{40, 100, 60},
+		{150, 100, 0},
+		{-5, 100, 100},
+	}
+	for _, tt := range tests {
+		if got := refundDue(&models.Sale{BalanceDue: tt.balanceDue}, tt.returned); got != tt.want {
+			t.Errorf("refundDue with %.2f owed on %.2f returned = %.2f, want %.2f", tt.balanceDue, tt.returned, got, tt.want)
+		}
+	}
+}
+
+func TestRefundMethodFor(t *testing.T) {
+	customerID := uint(5)
+	paid := func(method string, payments ...models.SalePayment) *models.Sale {
+		return &models.Sale{PaymentMethod: method, CustomerID: &customerID, Payments: payments}
+	}
+	cash := func(amount float64) models.SalePayment {
+		return models.SalePayment{Method: models.PaymentCash, Amount: amount}
+	}
+	mpesa := func(amount float64) models.SalePayment {
+		return models.SalePayment{Method: models.PaymentMpesa, Amount: amount}
+	}
+	points := func(amount float64) models.SalePayment {
+		return models.SalePayment{Method: models.PaymentPoints, Amount: amount}
+	}
+	note := func(amount float64) models.SalePayment {
+		return models.SalePayment{Method: models.PaymentCreditNote, Amount: amount}
+	}
+
+	tests := []struct {
+		name       string
+		sale       *models.Sale
+		requested  string
+		amount     float64
+		refunded   map[string]float64
+		want       string
+		wantStatus int
+	}{
+		{"cash by default", paid(models.PaymentCash, cash(100)), "", 100, nil, models.RefundCash, 0},
+		{"M-Pesa by default", paid(models.PaymentMpesa, mpesa(100)), "", 100, nil, models.RefundMpesaReversal, 0},
+		{"points by default", paid(models.PaymentPoints, points(100)), "", 100, nil, models.RefundPoints, 0},
+		{"credit note by default", paid(models.PaymentCreditNote, note(100)), "", 100, nil, models.RefundCreditNote, 0},
+		{"credit note for a cash sale", paid(models.PaymentCash, cash(100)), "credit_note", 100, nil, models.RefundCreditNote, 0},
+		{"store credit cannot become cash", paid(models.PaymentCreditNote, note(100)), models.RefundCash, 100, nil, "", 400},
+		{"points cannot become cash", paid(models.PaymentPoints, points(100)), models.RefundCash, 50, nil, "", 400},
+		{"cash cannot become points", paid(models.PaymentCash, cash(100)), models.RefundPoints, 50, nil, "", 400},
+		{"cash up to the cash paid", paid(models.PaymentSplit, cash(40), note(60)), models.RefundCash, 40, nil, models.RefundCash, 0},
+		{"cash beyond the cash paid", paid(models.PaymentSplit, cash(40), note(60)), models.RefundCash, 40.01, nil, "", 400},
+		{"split sale defaults to cash", paid(models.PaymentSplit, cash(40), mpesa(60)), "", 100, nil, "", 400},
+		{"reversal within the M-Pesa paid", paid(models.PaymentSplit, cash(40), mpesa(60)), models.RefundMpesaReversal, 60, nil, models.RefundMpesaReversal, 0},
+		{"reversal beyond the M-Pesa paid", paid(models.PaymentSplit, cash(40), mpesa(60)), models.RefundMpesaReversal, 70, nil, "", 400},
+		{"reversal of a cash sale", paid(models.PaymentCash, cash(100)), models.RefundMpesaReversal, 10, nil, "", 400},
+		{"cash already refunded", paid(models.PaymentCash, cash(100)), models.RefundCash, 30, map[string]float64{models.RefundCash: 80}, "", 400},
+		{"cash left after a refund", paid(models.PaymentCash, cash(100)), models.RefundCash, 20, map[string]float64{models.RefundCash: 80}, models.RefundCash, 0},
+		{"points need a customer", &models.Sale{PaymentMethod: models.PaymentPoints, Payments: []models.SalePayment{points(10)}}, "", 10, nil, "", 400},
+		{"unknown method", paid(models.PaymentCash, cash(100)), "VOUCHER", 10, nil, "", 400},
+	}
+	for _, tt := range tests {
+		got, err := refundMethodFor(tt.sale, tt.requested, tt.amount, tt.refunded)
+		if status := statusOf(err); status != tt.wantStatus {
+			t.Errorf("%s: status %d (%v), want %d", tt.name, status, err, tt.wantStatus)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: method %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
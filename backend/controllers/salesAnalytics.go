@@ -0,0 +1,465 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Sales analytics are worked out net of returns and leave voided sales out.
+// Each expression below is summed in SQL so the dashboards stay fast however
+// many sales there are.
+const (
+	netSaleRevenue = "sales.total_amount - sales.returned_total"
+	netItemUnits   = "sale_items.quantity - sale_items.returned_quantity"
+	netItemRevenue = "CASE WHEN sale_items.quantity > 0 THEN sale_items.line_total * " +
+		"(sale_items.quantity - sale_items.returned_quantity) / sale_items.quantity ELSE 0 END"
+
+	defaultRankLimit = 10
+	maxRankLimit     = 100
+)
+
+// periodFormats are the MySQL DATE_FORMAT patterns giving the same labels as
+// periodKey
+var periodFormats = map[string]string{
+	"day":   "%Y-%m-%d",
+	"week":  "%x-W%v",
+	"month": "%Y-%m",
+}
+
+// analyticsSales starts a query over the business's sales made in [from, to)
+func (im *SalesManagementHandler) analyticsSales(businessID uint, from, to time.Time) *gorm.DB {
+	return im.db.Table("sales").
+		Where("sales.business_id = ? AND sales.status <> ?", businessID, models.SaleStatusVoided).
+		Where("sales.created_at >= ? AND sales.created_at < ?", from, to)
+}
+
+// analyticsItems starts a query over the items of the sales analyticsSales
+// covers
+func (im *SalesManagementHandler) analyticsItems(businessID uint, from, to time.Time) *gorm.DB {
+	return im.db.Table("sale_items").
+		Joins("JOIN sales ON sales.id = sale_items.sale_id").
+		Where("sales.business_id = ? AND sales.status <> ?", businessID, models.SaleStatusVoided).
+		Where("sales.created_at >= ? AND sales.created_at < ?", from, to)
+}
+
+// analyticsRange resolves the business and date range of an analytics
+// request, answering it with an error when either cannot be had
+func analyticsRange(c *gin.Context, db *gorm.DB) (uint, time.Time, time.Time, bool) {
+	from, to, err := parseDateRange(c, 30)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return 0, time.Time{}, time.Time{}, false
+	}
+	businessID, err := businessIDFor(c, db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build sales analytics"})
+		return 0, time.Time{}, time.Time{}, false
+	}
+	return businessID, from, to, true
+}
+
+type trendPoint struct {
+	Period  string  `json:"period"`
+	Sales   int64   `json:"sales"`
+	Units   int64   `json:"units"`
+	Revenue float64 `json:"revenue"`
+}
+
+// GetSalesTrend totals revenue, sales and units sold by day, week or month.
+// Periods without sales are included with zero totals so charts have no gaps.
+func (im *SalesManagementHandler) GetSalesTrend(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if !validPeriod(period) {
+		c.JSON(400, gin.H{"error": "Period must be day, week or month"})
+		return
+	}
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+	label := "DATE_FORMAT(sales.created_at, '" + periodFormats[period] + "')"
+
+	var sales []struct {
+		Period  string
+		Sales   int64
+		Revenue float64
+	}
+	if err := im.analyticsSales(businessID, from, to).
+		Select(label + " AS period, COUNT(*) AS sales, COALESCE(SUM(" + netSaleRevenue + "), 0) AS revenue").
+		Group("period").
+		Scan(&sales).Error; err != nil {
+		utils.ErrorLogger("Failed to build sales trend: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build sales trend"})
+		return
+	}
+
+	var units []struct {
+		Period string
+		Units  int64
+	}
+	if err := im.analyticsItems(businessID, from, to).
+		Select(label + " AS period, COALESCE(SUM(" + netItemUnits + "), 0) AS units").
+		Group("period").
+		Scan(&units).Error; err != nil {
+		utils.ErrorLogger("Failed to build sales trend: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build sales trend"})
+		return
+	}
+
+	var points []*trendPoint
+	byPeriod := make(map[string]*trendPoint)
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		key := periodKey(day, period)
+		if byPeriod[key] == nil {
+			byPeriod[key] = &trendPoint{Period: key}
+			points = append(points, byPeriod[key])
+		}
+	}
+	for _, row := range sales {
+		if point := byPeriod[row.Period]; point != nil {
+			point.Sales = row.Sales
+			point.Revenue = roundMoney(row.Revenue)
+		}
+	}
+	for _, row := range units {
+		if point := byPeriod[row.Period]; point != nil {
+			point.Units = row.Units
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"from":   from.Format(dateLayout),
+		"to":     to.AddDate(0, 0, -1).Format(dateLayout),
+		"period": period,
+		"points": points,
+	})
+}
+
+// GetProductPerformance ranks products by revenue or units sold. rank=bottom
+// lists the slowest sellers first, including active products that did not
+// sell at all.
+func (im *SalesManagementHandler) GetProductPerformance(c *gin.Context) {
+	rank := c.DefaultQuery("rank", "top")
+	if rank != "top" && rank != "bottom" {
+		c.JSON(400, gin.H{"error": "rank must be top or bottom"})
+		return
+	}
+	by := c.DefaultQuery("by", "revenue")
+	if by != "revenue" && by != "units" {
+		c.JSON(400, gin.H{"error": "by must be revenue or units"})
+		return
+	}
+	limit := defaultRankLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(400, gin.H{"error": "Limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxRankLimit)
+	}
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	sold := im.analyticsItems(businessID, from, to).
+		Select("sale_items.product_id, COUNT(DISTINCT sales.id) AS sales, SUM(" + netItemUnits + ") AS units, SUM(" + netItemRevenue + ") AS revenue").
+		Group("sale_items.product_id")
+
+	query := im.db.Table("products").
+		Select("products.id AS product_id, products.name AS product_name, COALESCE(products.category, '') AS category, "+
+			"COALESCE(sold.sales, 0) AS sales, COALESCE(sold.units, 0) AS units, COALESCE(sold.revenue, 0) AS revenue").
+		Joins("LEFT JOIN (?) AS sold ON sold.product_id = products.id", sold)
+	direction := " DESC"
+	if rank == "bottom" {
+		direction = " ASC"
+		query = query.Where("products.archived = ?", false)
+	} else {
+		query = query.Where("sold.product_id IS NOT NULL")
+	}
+
+	var products []struct {
+		ProductID   uint    `json:"product_id"`
+		ProductName string  `json:"product_name"`
+		Category    string  `json:"category,omitempty"`
+		Sales       int64   `json:"sales"`
+		Units       int64   `json:"units"`
+		Revenue     float64 `json:"revenue"`
+	}
+	if err := query.Order(by + direction).Order("products.id").
+		Limit(limit).
+		Scan(&products).Error; err != nil {
+		utils.ErrorLogger("Failed to rank products: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to rank products"})
+		return
+	}
+	for i := range products {
+		products[i].Revenue = roundMoney(products[i].Revenue)
+	}
+
+	c.JSON(200, gin.H{
+		"from":     from.Format(dateLayout),
+		"to":       to.AddDate(0, 0, -1).Format(dateLayout),
+		"rank":     rank,
+		"by":       by,
+		"products": products,
+	})
+}
+
+// GetSalesByCategory totals revenue and units by product category, with each
+// category's share of revenue
+func (im *SalesManagementHandler) GetSalesByCategory(c *gin.Context) {
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	var categories []struct {
+		Category     string  `json:"category"`
+		Sales        int64   `json:"sales"`
+		Units        int64   `json:"units"`
+		Revenue      float64 `json:"revenue"`
+		SharePercent float64 `json:"share_percent" gorm:"-"`
+	}
+	if err := im.analyticsItems(businessID, from, to).
+		Joins("LEFT JOIN products ON products.id = sale_items.product_id").
+		Select("COALESCE(NULLIF(products.category, ''), 'Uncategorised') AS category, COUNT(DISTINCT sales.id) AS sales, " +
+			"SUM(" + netItemUnits + ") AS units, SUM(" + netItemRevenue + ") AS revenue").
+		Group("category").
+		Order("revenue DESC").
+		Scan(&categories).Error; err != nil {
+		utils.ErrorLogger("Failed to total sales by category: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to total sales by category"})
+		return
+	}
+
+	var total float64
+	for _, category := range categories {
+		total += category.Revenue
+	}
+	for i := range categories {
+		if total > 0 {
+			categories[i].SharePercent = roundMoney(categories[i].Revenue / total * 100)
+		}
+		categories[i].Revenue = roundMoney(categories[i].Revenue)
+	}
+
+	c.JSON(200, gin.H{
+		"from":       from.Format(dateLayout),
+		"to":         to.AddDate(0, 0, -1).Format(dateLayout),
+		"revenue":    roundMoney(total),
+		"categories": categories,
+	})
+}
+
+type heatmapCell struct {
+	Weekday int     `json:"weekday"`
+	Day     string  `json:"day"`
+	Hour    int     `json:"hour"`
+	Sales   int64   `json:"sales"`
+	Revenue float64 `json:"revenue"`
+}
+
+// GetSalesHeatmap counts sales and revenue for each hour of each weekday.
+// Every one of the 168 cells is listed, Sunday (weekday 0) first.
+func (im *SalesManagementHandler) GetSalesHeatmap(c *gin.Context) {
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	var rows []struct {
+		Weekday int
+		Hour    int
+		Sales   int64
+		Revenue float64
+	}
+	if err := im.analyticsSales(businessID, from, to).
+		Select("DAYOFWEEK(sales.created_at) - 1 AS weekday, HOUR(sales.created_at) AS hour, " +
+			"COUNT(*) AS sales, COALESCE(SUM(" + netSaleRevenue + "), 0) AS revenue").
+		Group("weekday, hour").
+		Scan(&rows).Error; err != nil {
+		utils.ErrorLogger("Failed to build sales heatmap: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build sales heatmap"})
+		return
+	}
+
+	cells := make([]heatmapCell, 7*24)
+	for i := range cells {
+		cells[i] = heatmapCell{Weekday: i / 24, Day: time.Weekday(i / 24).String(), Hour: i % 24}
+	}
+	for _, row := range rows {
+		if row.Weekday < 0 || row.Weekday > 6 || row.Hour < 0 || row.Hour > 23 {
+			continue
+		}
+		cell := &cells[row.Weekday*24+row.Hour]
+		cell.Sales = row.Sales
+		cell.Revenue = roundMoney(row.Revenue)
+	}
+
+	c.JSON(200, gin.H{
+		"from":  from.Format(dateLayout),
+		"to":    to.AddDate(0, 0, -1).Format(dateLayout),
+		"cells": cells,
+	})
+}
+
+// GetPaymentMix totals what was paid with each tender. Split sales count
+// towards every method they used; sales from before tenders were recorded
+// count towards their payment method.
+func (im *SalesManagementHandler) GetPaymentMix(c *gin.Context) {
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	tendered := im.analyticsSales(businessID, from, to).
+		Joins("JOIN sale_payments ON sale_payments.sale_id = sales.id").
+		Select("sale_payments.method, sales.id AS sale_id, sale_payments.amount")
+	legacy := im.analyticsSales(businessID, from, to).
+		Where("NOT EXISTS (SELECT 1 FROM sale_payments WHERE sale_payments.sale_id = sales.id)").
+		Select("sales.payment_method AS method, sales.id AS sale_id, sales.total_amount AS amount")
+
+	var methods []struct {
+		Method       string  `json:"method"`
+		Sales        int64   `json:"sales"`
+		Amount       float64 `json:"amount"`
+		SharePercent float64 `json:"share_percent" gorm:"-"`
+	}
+	if err := im.db.Table("((?) UNION ALL (?)) AS tenders", tendered, legacy).
+		Select("method, COUNT(DISTINCT sale_id) AS sales, SUM(amount) AS amount").
+		Group("method").
+		Order("amount DESC").
+		Scan(&methods).Error; err != nil {
+		utils.ErrorLogger("Failed to build payment mix: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build payment mix"})
+		return
+	}
+
+	var total float64
+	for _, method := range methods {
+		total += method.Amount
+	}
+	for i := range methods {
+		if total > 0 {
+			methods[i].SharePercent = roundMoney(methods[i].Amount / total * 100)
+		}
+		methods[i].Amount = roundMoney(methods[i].Amount)
+	}
+
+	c.JSON(200, gin.H{
+		"from":    from.Format(dateLayout),
+		"to":      to.AddDate(0, 0, -1).Format(dateLayout),
+		"total":   roundMoney(total),
+		"methods": methods,
+	})
+}
+
+type salesSummary struct {
+	From               string  `json:"from"`
+	To                 string  `json:"to"`
+	Sales              int64   `json:"sales"`
+	Units              int64   `json:"units"`
+	Revenue            float64 `json:"revenue"`
+	DiscountTotal      float64 `json:"discount_total"`
+	TaxTotal           float64 `json:"tax_total"`
+	AverageBasketValue float64 `json:"average_basket_value"`
+	AverageBasketUnits float64 `json:"average_basket_units"`
+}
+
+// summarizeSales works out the headline figures for sales made in [from, to)
+func (im *SalesManagementHandler) summarizeSales(businessID uint, from, to time.Time) (salesSummary, error) {
+	summary := salesSummary{From: from.Format(dateLayout), To: to.AddDate(0, 0, -1).Format(dateLayout)}
+
+	var totals struct {
+		Sales         int64
+		Revenue       float64
+		DiscountTotal float64
+		TaxTotal      float64
+	}
+	if err := im.analyticsSales(businessID, from, to).
+		Select("COUNT(*) AS sales, COALESCE(SUM(" + netSaleRevenue + "), 0) AS revenue, " +
+			"COALESCE(SUM(sales.discount_total), 0) AS discount_total, COALESCE(SUM(sales.tax_total), 0) AS tax_total").
+		Scan(&totals).Error; err != nil {
+		return summary, err
+	}
+	if err := im.analyticsItems(businessID, from, to).
+		Select("COALESCE(SUM(" + netItemUnits + "), 0)").
+		Scan(&summary.Units).Error; err != nil {
+		return summary, err
+	}
+
+	summary.Sales = totals.Sales
+	summary.Revenue = roundMoney(totals.Revenue)
+	summary.DiscountTotal = roundMoney(totals.DiscountTotal)
+	summary.TaxTotal = roundMoney(totals.TaxTotal)
+	if totals.Sales > 0 {
+		summary.AverageBasketValue = roundMoney(totals.Revenue / float64(totals.Sales))
+		summary.AverageBasketUnits = roundMoney(float64(summary.Units) / float64(totals.Sales))
+	}
+	return summary, nil
+}
+
+// percentChange is the change from previous to current as a percentage, or
+// nil when there is nothing to compare against
+func percentChange(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := roundMoney((current - previous) / previous * 100)
+	return &change
+}
+
+// GetSalesSummary gives the headline figures and average basket for the
+// requested range next to those of the period before it. compare=year
+// compares with the same dates a year earlier instead.
+func (im *SalesManagementHandler) GetSalesSummary(c *gin.Context) {
+	compare := c.DefaultQuery("compare", "previous")
+	if compare != "previous" && compare != "year" {
+		c.JSON(400, gin.H{"error": "compare must be previous or year"})
+		return
+	}
+	businessID, from, to, ok := analyticsRange(c, im.db)
+	if !ok {
+		return
+	}
+
+	days := int(to.Sub(from).Round(24*time.Hour) / (24 * time.Hour))
+	prevFrom, prevTo := from.AddDate(0, 0, -days), from
+	if compare == "year" {
+		prevFrom, prevTo = from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0)
+	}
+
+	current, err := im.summarizeSales(businessID, from, to)
+	if err != nil {
+		utils.ErrorLogger("Failed to summarize sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to summarize sales"})
+		return
+	}
+	previous, err := im.summarizeSales(businessID, prevFrom, prevTo)
+	if err != nil {
+		utils.ErrorLogger("Failed to summarize sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to summarize sales"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"compare":  compare,
+		"current":  current,
+		"previous": previous,
+		"change": gin.H{
+			"sales_percent":                percentChange(float64(current.Sales), float64(previous.Sales)),
+			"units_percent":                percentChange(float64(current.Units), float64(previous.Units)),
+			"revenue_percent":              percentChange(current.Revenue, previous.Revenue),
+			"average_basket_value_percent": percentChange(current.AverageBasketValue, previous.AverageBasketValue),
+			"average_basket_units_percent": percentChange(current.AverageBasketUnits, previous.AverageBasketUnits),
+		},
+	})
+}
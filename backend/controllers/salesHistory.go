@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/export"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxSalesPageSize = 500
+
+// saleCursor marks the last sale of a page. Sales are listed newest first.
+type saleCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
+}
+
+// applySaleFilters narrows a sales query by the from and to dates and the
+// cashier_id, payment_method, product_id, category, customer and status
+// query parameters. A payment method matches split sales that took it as
+// one of their tenders.
+func applySaleFilters(query *gorm.DB, c *gin.Context, businessID uint) (*gorm.DB, error) {
+	query = query.Where("sales.business_id = ?", businessID)
+
+	if c.Query("from") != "" || c.Query("to") != "" {
+		from, to, err := parseDateRange(c, 30)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("sales.created_at >= ? AND sales.created_at < ?", from, to)
+	}
+
+	if v := c.Query("cashier_id"); v != "" {
+		cashierID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid cashier_id")
+		}
+		query = query.Where("sales.cashier_id = ?", cashierID)
+	}
+
+	if v := c.Query("payment_method"); v != "" {
+		method := strings.ToUpper(v)
+		if !tenderMethods[method] && method != models.PaymentSplit {
+			return nil, errors.New("payment_method must be CASH, MPESA, CREDIT, POINTS or SPLIT")
+		}
+		query = query.Where("sales.payment_method = ? OR EXISTS (SELECT 1 FROM sale_payments WHERE sale_payments.sale_id = sales.id AND sale_payments.method = ?)",
+			method, method)
+	}
+
+	if v := c.Query("product_id"); v != "" {
+		productID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid product_id")
+		}
+		query = query.Where("EXISTS (SELECT 1 FROM sale_items WHERE sale_items.sale_id = sales.id AND sale_items.product_id = ?)", productID)
+	}
+
+	if category := c.Query("category"); category != "" {
+		query = query.Where("EXISTS (SELECT 1 FROM sale_items JOIN products ON products.id = sale_items.product_id WHERE sale_items.sale_id = sales.id AND products.category = ?)",
+			category)
+	}
+
+	if customer := strings.TrimSpace(c.Query("customer")); customer != "" {
+		query = query.Where("sales.customer_name LIKE ? OR sales.customer_phone LIKE ?", "%"+customer+"%", "%"+customer+"%")
+	}
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("sales.status = ?", strings.ToUpper(status))
+	}
+
+	return query, nil
+}
+
+// FetchSalesHistory lists sales, newest first, narrowed by the filters in
+// applySaleFilters. Like the product listing, passing limit turns on cursor
+// pagination with the next cursor in the X-Next-Cursor header, and
+// X-Total-Count counts the whole filtered set.
+func (im *SalesManagementHandler) FetchSalesHistory(c *gin.Context) {
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch sales history"})
+		return
+	}
+
+	base, err := applySaleFilters(im.db.Model(&models.Sale{}), c, businessID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		utils.ErrorLogger("Failed to count sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch sales history"})
+		return
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+
+	query := base.Session(&gorm.Session{}).
+		Preload("Items").Preload("Payments").
+		Order("sales.created_at DESC").Order("sales.id DESC")
+
+	limit := 0
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.JSON(400, gin.H{"error": "Limit must be a positive number"})
+			return
+		}
+		if limit > maxSalesPageSize {
+			limit = maxSalesPageSize
+		}
+
+		if encoded := c.Query("cursor"); encoded != "" {
+			cursor, err := decodeSaleCursor(encoded)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			query = query.Where("(sales.created_at < ? OR (sales.created_at = ? AND sales.id < ?))",
+				cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+		// Fetch one extra row to find out whether there is another page
+		query = query.Limit(limit + 1)
+	}
+
+	var sales []models.Sale
+	if err := query.Find(&sales).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch sales history: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch sales history"})
+		return
+	}
+
+	if limit > 0 && len(sales) > limit {
+		sales = sales[:limit]
+		last := sales[len(sales)-1]
+		c.Header("X-Next-Cursor", encodeSaleCursor(saleCursor{CreatedAt: last.CreatedAt, ID: last.ID}))
+	}
+
+	utils.InfoLogger("Successfully fetched %d of %d sales", len(sales), total)
+	c.JSON(200, sales)
+}
+
+// GetSalesHistoryTotals adds up the sales matching the history filters
+func (im *SalesManagementHandler) GetSalesHistoryTotals(c *gin.Context) {
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to total sales"})
+		return
+	}
+
+	query, err := applySaleFilters(im.db.Model(&models.Sale{}), c, businessID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var totals struct {
+		Count         int64   `json:"count"`
+		Subtotal      float64 `json:"subtotal"`
+		DiscountTotal float64 `json:"discount_total"`
+		TaxTotal      float64 `json:"tax_total"`
+		TotalAmount   float64 `json:"total_amount"`
+		AmountPaid    float64 `json:"amount_paid"`
+		BalanceDue    float64 `json:"balance_due"`
+		ReturnedTotal float64 `json:"returned_total"`
+		RefundedTotal float64 `json:"refunded_total"`
+	}
+	if err := query.Select(`COUNT(*) AS count,
+		COALESCE(SUM(sales.subtotal), 0) AS subtotal,
+		COALESCE(SUM(sales.discount_total), 0) AS discount_total,
+		COALESCE(SUM(sales.tax_total), 0) AS tax_total,
+		COALESCE(SUM(sales.total_amount), 0) AS total_amount,
+		COALESCE(SUM(sales.amount_paid), 0) AS amount_paid,
+		COALESCE(SUM(sales.balance_due), 0) AS balance_due,
+		COALESCE(SUM(sales.returned_total), 0) AS returned_total,
+		COALESCE(SUM(sales.refunded_total), 0) AS refunded_total`).
+		Scan(&totals).Error; err != nil {
+		utils.ErrorLogger("Failed to total sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to total sales"})
+		return
+	}
+	totals.Subtotal = roundMoney(totals.Subtotal)
+	totals.DiscountTotal = roundMoney(totals.DiscountTotal)
+	totals.TaxTotal = roundMoney(totals.TaxTotal)
+	totals.TotalAmount = roundMoney(totals.TotalAmount)
+	totals.AmountPaid = roundMoney(totals.AmountPaid)
+	totals.BalanceDue = roundMoney(totals.BalanceDue)
+	totals.ReturnedTotal = roundMoney(totals.ReturnedTotal)
+	totals.RefundedTotal = roundMoney(totals.RefundedTotal)
+
+	c.JSON(200, totals)
+}
+
+// saleExportRow is one sale item with the sale it belongs to
+type saleExportRow struct {
+	SaleNumber    string
+	CreatedAt     time.Time
+	Cashier       string
+	CustomerName  string
+	CustomerPhone string
+	PaymentMethod string
+	Status        string
+	ProductName   string
+	Category      string
+	Quantity      int
+	UnitPrice     float64
+	Discount      float64
+	TaxAmount     float64
+	LineTotal     float64
+	SaleTotal     float64
+	AmountPaid    float64
+	BalanceDue    float64
+}
+
+var saleExportHeader = []string{
+	"Sale number", "Date", "Cashier", "Customer", "Phone", "Payment method", "Status",
+	"Product", "Category", "Quantity", "Unit price", "Discount", "VAT", "Line total",
+	"Sale total", "Amount paid", "Balance due",
+}
+
+// ExportSalesHistory streams the filtered sales as CSV or XLSX, one row per
+// item. Rows are read from the database and written to the response one at
+// a time, so a year of sales does not have to fit in memory.
+func (im *SalesManagementHandler) ExportSalesHistory(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", "csv"))
+	if format != "csv" && format != "xlsx" {
+		c.JSON(400, gin.H{"error": "format must be csv or xlsx"})
+		return
+	}
+
+	businessID, err := businessIDFor(c, im.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to export sales"})
+		return
+	}
+
+	query, err := applySaleFilters(im.db.Table("sales"), c, businessID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := query.
+		Select(`sales.sale_number, sales.created_at, COALESCE(users.full_name, '') AS cashier,
+			sales.customer_name, sales.customer_phone, sales.payment_method, sales.status,
+			sale_items.product_name, COALESCE(products.category, '') AS category, sale_items.quantity, sale_items.unit_price,
+			sale_items.discount + sale_items.basket_discount AS discount, sale_items.tax_amount,
+			sale_items.line_total, sales.total_amount AS sale_total, sales.amount_paid, sales.balance_due`).
+		Joins("JOIN sale_items ON sale_items.sale_id = sales.id").
+		Joins("LEFT JOIN users ON users.id = sales.cashier_id").
+		Joins("LEFT JOIN products ON products.id = sale_items.product_id").
+		Order("sales.created_at").Order("sales.id").Order("sale_items.id").
+		Rows()
+	if err != nil {
+		utils.ErrorLogger("Failed to export sales: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to export sales"})
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("sales-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	var write func(row *saleExportRow) error
+	var finish func() error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(saleExportHeader); err != nil {
+			utils.ErrorLogger("Failed to write sales export: %v", err)
+			return
+		}
+		write = func(row *saleExportRow) error {
+			return w.Write([]string{
+				export.CSVText(row.SaleNumber), row.CreatedAt.Format("2006-01-02 15:04:05"), export.CSVText(row.Cashier),
+				export.CSVText(row.CustomerName), export.CSVText(row.CustomerPhone), row.PaymentMethod, row.Status,
+				export.CSVText(row.ProductName), export.CSVText(row.Category), strconv.Itoa(row.Quantity),
+				money(row.UnitPrice), money(row.Discount), money(row.TaxAmount), money(row.LineTotal),
+				money(row.SaleTotal), money(row.AmountPaid), money(row.BalanceDue),
+			})
+		}
+		finish = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		x, err := export.NewXLSXWriter(c.Writer, "Sales")
+		if err != nil {
+			utils.ErrorLogger("Failed to write sales export: %v", err)
+			return
+		}
+		header := make([]interface{}, len(saleExportHeader))
+		for i, title := range saleExportHeader {
+			header[i] = title
+		}
+		if err := x.WriteRow(header...); err != nil {
+			utils.ErrorLogger("Failed to write sales export: %v", err)
+			return
+		}
+		write = func(row *saleExportRow) error {
+			return x.WriteRow(
+				row.SaleNumber, row.CreatedAt, row.Cashier,
+				row.CustomerName, row.CustomerPhone, row.PaymentMethod, row.Status,
+				row.ProductName, row.Category, row.Quantity,
+				row.UnitPrice, row.Discount, row.TaxAmount, row.LineTotal,
+				row.SaleTotal, row.AmountPaid, row.BalanceDue,
+			)
+		}
+		finish = x.Close
+	}
+
+	count := 0
+	for rows.Next() {
+		var row saleExportRow
+		if err := im.db.ScanRows(rows, &row); err != nil {
+			utils.ErrorLogger("Failed to read sale for export: %v", err)
+			return
+		}
+		if err := write(&row); err != nil {
+			utils.ErrorLogger("Failed to write sales export: %v", err)
+			return
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		utils.ErrorLogger("Failed to read sales for export: %v", err)
+		return
+	}
+	if err := finish(); err != nil {
+		utils.ErrorLogger("Failed to finish sales export: %v", err)
+		return
+	}
+
+	utils.InfoLogger("Exported %d sale items as %s", count, format)
+}
+
+func encodeSaleCursor(cursor saleCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSaleCursor(encoded string) (saleCursor, error) {
+	var cursor saleCursor
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(raw, &cursor) != nil || cursor.ID == 0 {
+		return cursor, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+func money(amount float64) string {
+	return strconv.FormatFloat(roundMoney(amount), 'f', 2, 64)
+}
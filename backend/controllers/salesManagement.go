@@ -1,14 +1,23 @@
 package controllers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
 	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/OAthooh/BiasharaTrack.git/utils"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SalesManagementHandler struct {
@@ -21,21 +30,64 @@ func NewSalesManagementHandler(db *gorm.DB) *SalesManagementHandler {
 
 // Define the structure for a single sell request
 type SellRequest struct {
-	ProductID uint    `json:"product_id" binding:"required"`
-	Quantity  int     `json:"quantity" binding:"required"`
-	Note      string  `json:"note"`
-	Amount    float64 `json:"amount"`
+	ProductID uint   `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required"`
+	Note      string `json:"note"`
+	// Amount is the line total before discounts the client expects; the
+	// sale is rejected if it differs from the price worked out on the server
+	Amount *float64 `json:"amount"`
+	// UnitPrice overrides the catalogue price. Only staff allowed to change
+	// prices may send it, with a reason.
+	UnitPrice      *float64         `json:"unit_price"`
+	OverrideReason string           `json:"override_reason"`
+	Discount       *DiscountRequest `json:"discount"`
 }
 
 // Define the structure for the sale data from the front end
 type SaleData struct {
-	Products         []SellRequest `json:"products" binding:"required"`
-	PaymentMethod    string        `json:"payment_method"`
-	CustomerName     string        `json:"customer_name"`
-	CustomerPhone    string        `json:"customer_phone"`
-	ReferenceNumber  string        `json:"reference_number"`
-	AmountPaid       float64       `json:"amount_paid"`
-	RemainingBalance float64       `json:"remaining_balance"`
+	Products []SellRequest `json:"products" binding:"required"`
+	// PaymentMethod pays the whole sale with one tender; Payments splits it
+	// across several and takes precedence
+	PaymentMethod string           `json:"payment_method"`
+	Payments      []PaymentRequest `json:"payments"`
+	// CustomerID picks a customer from the directory; otherwise the
+	// customer is looked up, or added, by CustomerPhone
+	CustomerID    *uint  `json:"customer_id"`
+	CustomerName  string `json:"customer_name"`
+	CustomerPhone string `json:"customer_phone"`
+	// CustomerPIN is the buyer's KRA PIN for a tax invoice
+	CustomerPIN     string `json:"customer_pin"`
+	ReferenceNumber string `json:"reference_number"`
+	// TotalAmount is the sale total after discounts the client expects,
+	// checked like Amount
+	TotalAmount *float64 `json:"total_amount"`
+	// Discount is taken off the whole basket
+	Discount *DiscountRequest `json:"discount"`
+	// DiscountApproval lets a manager allow discounts above the cashier's
+	// limit
+	DiscountApproval *DiscountApproval `json:"discount_approval"`
+	// AmountPaid is the deposit on a single tender credit sale
+	AmountPaid float64 `json:"amount_paid"`
+	// RemainingBalance is ignored; the balance is worked out from the total
+	RemainingBalance float64 `json:"remaining_balance"`
+	// IdempotencyKey identifies the submission; it can also be sent in the
+	// Idempotency-Key header
+	IdempotencyKey string `json:"idempotency_key"`
+	// ParkedSaleID completes a parked sale, releasing its reserved stock to
+	// this sale
+	ParkedSaleID *uint `json:"parked_sale_id"`
+	// QuotationID sells an accepted quotation
+	QuotationID *uint `json:"quotation_id"`
+
+	// recordedAt is when a sale made offline was rung up
+	recordedAt time.Time
+	// scheduledFor is when a standing order sale falls due. No till takes
+	// the money for it, so it is not counted in anyone's shift.
+	scheduledFor time.Time
+	// layaway is the paid-off layaway the sale completes. Its lines and
+	// totals were agreed when it was opened, and its deposits pay for the
+	// sale.
+	layaway *models.Layaway
 }
 
 func (im *SalesManagementHandler) SellProducts(c *gin.Context) {
@@ -57,6 +109,10 @@ func (im *SalesManagementHandler) SellProducts(c *gin.Context) {
 		return
 	}
 
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		saleData.IdempotencyKey = key
+	}
+
 	// Validate the products array is not empty
 	if len(saleData.Products) == 0 {
 		utils.ErrorLogger("Empty products array received")
@@ -65,33 +121,66 @@ func (im *SalesManagementHandler) SellProducts(c *gin.Context) {
 	}
 
 	// validate payment method and sale type
-	if saleData.PaymentMethod == "" {
+	if saleData.PaymentMethod == "" && len(saleData.Payments) == 0 {
 		utils.ErrorLogger("Incomplete sale request in payment method or sale type")
 		c.JSON(400, gin.H{"error": "Payment method is required"})
 		return
 	}
 
-	// Validate credit sale requirements
-	if strings.ToUpper(saleData.PaymentMethod) == "CREDIT" {
-		if saleData.CustomerName == "" || saleData.CustomerPhone == "" {
-			utils.WarningLogger("Incomplete credit sale request for product %d", saleData.Products[0].ProductID)
-			c.JSON(400, gin.H{"error": fmt.Sprintf("Name and phone number are required for credit sales for product %d", saleData.Products[0].ProductID)})
-			return
-		}
-	}
-
-	utils.InfoLogger("Processing sale for product %s", saleData.PaymentMethod)
+	utils.InfoLogger("Processing sale paid by %s", saleData.PaymentMethod)
 
 	processSales(saleData, im, c)
 }
 
 func processSales(saleData SaleData, im *SalesManagementHandler, c *gin.Context) {
-	// Start transaction
-	tx := im.db.Begin()
+	var cashier *models.User
+	if user, ok := middleware.CurrentUser(c); ok {
+		cashier = &user
+	}
+
+	sale, replayed, err := recordSaleOnce(im.db, saleData, cashier)
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	if replayed {
+		utils.InfoLogger("Returned sale %s for repeated idempotency key", sale.SaleNumber)
+	} else {
+		utils.InfoLogger("Successfully processed sale %s", sale.SaleNumber)
+	}
+	c.JSON(200, gin.H{
+		"message":  "Sales recorded successfully",
+		"sale":     sale,
+		"replayed": replayed,
+	})
+}
+
+// recordSaleOnce records a sale in its own transaction. A sale whose
+// idempotency key has been seen before is not recorded again; the sale made
+// the first time is returned with replayed set.
+func recordSaleOnce(db *gorm.DB, saleData SaleData, cashier *models.User) (*models.Sale, bool, error) {
+	saleData.IdempotencyKey = strings.TrimSpace(saleData.IdempotencyKey)
+	if len(saleData.IdempotencyKey) > 64 {
+		return nil, false, newSaleError(400, "Idempotency key must be at most 64 characters")
+	}
+	if saleData.IdempotencyKey != "" {
+		if sale, err := findIdempotentSale(db, saleData, cashier); sale != nil || err != nil {
+			return sale, sale != nil, err
+		}
+	}
+
+	businessID, err := saleBusinessID(db, cashier)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		return nil, false, newSaleError(500, "Failed to complete sales")
+	}
+	ctx, outbox := events.WithOutbox(context.Background(), businessID)
+
+	tx := db.WithContext(ctx).Begin()
 	if tx.Error != nil {
 		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
-		c.JSON(500, gin.H{"error": "Failed to start transaction"})
-		return
+		return nil, false, newSaleError(500, "Failed to start transaction")
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -99,138 +188,464 @@ func processSales(saleData SaleData, im *SalesManagementHandler, c *gin.Context)
 		}
 	}()
 
-	for _, sellRequest := range saleData.Products {
+	sale, err := recordSale(tx, saleData, cashier)
+	if err != nil {
+		tx.Rollback()
+		// A request with the same key may have been recorded in the meantime
+		if saleData.IdempotencyKey != "" {
+			if existing, findErr := findIdempotentSale(db, saleData, cashier); existing != nil || findErr != nil {
+				return existing, existing != nil, findErr
+			}
+		}
+		return nil, false, err
+	}
 
-		// Get current inventory
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit transaction: %v", err)
+		return nil, false, newSaleError(500, "Failed to complete sales")
+	}
+	outbox.Flush()
+	return sale, false, nil
+}
+
+// findIdempotentSale returns the sale already recorded with saleData's
+// idempotency key, or nil if there is none
+func findIdempotentSale(db *gorm.DB, saleData SaleData, cashier *models.User) (*models.Sale, error) {
+	var sale models.Sale
+	err := db.Preload("Items").Preload("Payments").
+		Where("idempotency_key = ?", saleData.IdempotencyKey).
+		First(&sale).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if (cashier != nil && sale.BusinessID != cashier.BusinessID) || sale.RequestHash != saleRequestHash(saleData) {
+		return nil, newSaleError(409, "Idempotency key %s was already used for a different sale", saleData.IdempotencyKey)
+	}
+	return &sale, nil
+}
+
+// saleRequestHash fingerprints what a sale request asks for. The discount
+// approval is left out so no password goes into the hash.
+func saleRequestHash(saleData SaleData) string {
+	saleData.DiscountApproval = nil
+	body, _ := json.Marshal(struct {
+		SaleData
+		RecordedAt time.Time `json:"recorded_at"`
+	}{saleData, saleData.recordedAt})
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// saleError is a sale failure with the HTTP status and message to send back
+type saleError struct {
+	status  int
+	message string
+}
+
+func (e *saleError) Error() string {
+	return e.message
+}
+
+func newSaleError(status int, format string, args ...interface{}) error {
+	return &saleError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// respondSaleError writes err as the JSON error response for a failed sale
+func respondSaleError(c *gin.Context, err error) {
+	var se *saleError
+	if errors.As(err, &se) {
+		c.JSON(se.status, gin.H{"error": se.message})
+		return
+	}
+	utils.ErrorLogger("Failed to record sale: %v", err)
+	c.JSON(500, gin.H{"error": "Failed to complete sales"})
+}
+
+// recordSale writes a sale with its items inside tx: it deducts stock,
+// records stock movements and credit entries and keeps low stock alerts
+// current. Validation failures are returned as *saleError. The caller owns
+// the transaction and must roll it back on error.
+func recordSale(tx *gorm.DB, saleData SaleData, cashier *models.User) (*models.Sale, error) {
+	customerPIN := strings.ToUpper(strings.TrimSpace(saleData.CustomerPIN))
+	if customerPIN != "" && !validKRAPin(customerPIN) {
+		return nil, newSaleError(400, "Customer PIN %s is not a valid KRA PIN", customerPIN)
+	}
+
+	paymentMethod := requestedPaymentMethod(saleData)
+	if !tenderMethods[paymentMethod] && paymentMethod != models.PaymentSplit {
+		return nil, newSaleError(400, "Payment method must be CASH, MPESA, CREDIT or POINTS")
+	}
+
+	at := time.Now()
+	if !saleData.recordedAt.IsZero() {
+		at = saleData.recordedAt
+	}
+	if !saleData.scheduledFor.IsZero() {
+		at = saleData.scheduledFor
+	}
+
+	sale := models.Sale{
+		// Placeholder until the ID is known; it only needs to be unique
+		SaleNumber:      utils.GenerateUUID(),
+		CustomerName:    saleData.CustomerName,
+		CustomerPhone:   saleData.CustomerPhone,
+		CustomerPIN:     customerPIN,
+		PaymentMethod:   paymentMethod,
+		ReferenceNumber: saleData.ReferenceNumber,
+		Status:          models.SaleStatusCompleted,
+		CreatedAt:       at,
+	}
+	if saleData.IdempotencyKey != "" {
+		key := saleData.IdempotencyKey
+		sale.IdempotencyKey = &key
+		sale.RequestHash = saleRequestHash(saleData)
+	}
+	if !saleData.recordedAt.IsZero() {
+		now := time.Now()
+		sale.SyncedAt = &now
+	}
+	if saleData.layaway != nil {
+		sale.LayawayID = &saleData.layaway.ID
+	}
+	if cashier != nil {
+		sale.CashierID = &cashier.ID
+	}
+	if cashier != nil && saleData.scheduledFor.IsZero() {
+		shiftID, err := shiftAt(tx, cashier.ID, at)
+		if err != nil {
+			return nil, err
+		}
+		sale.ShiftID = shiftID
+	}
+	businessID, err := saleBusinessID(tx, cashier)
+	if err != nil {
+		return nil, err
+	}
+	sale.BusinessID = businessID
+
+	customer, err := resolveCustomer(tx, sale.BusinessID, saleData)
+	if err != nil {
+		return nil, err
+	}
+	if customer != nil {
+		sale.CustomerID = &customer.ID
+		sale.CustomerPhone = customer.Phone
+		if sale.CustomerName == "" {
+			sale.CustomerName = customer.Name
+		}
+		if sale.CustomerPIN == "" {
+			sale.CustomerPIN = customer.KRAPin
+		}
+	}
+
+	if err := tx.Create(&sale).Error; err != nil {
+		return nil, err
+	}
+	sale.SaleNumber = fmt.Sprintf("S%s-%06d", at.Format("20060102"), sale.ID)
+
+	// Price every line and check the discounts before touching any stock
+	var items []models.SaleItem
+	if saleData.layaway != nil {
+		items, err = layawaySaleItems(tx, &sale, saleData)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		items, err = priceSale(tx, &sale, saleData, cashier)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDiscountLimits(tx, &sale, items, cashier, saleData.DiscountApproval); err != nil {
+			return nil, err
+		}
+	}
+
+	var parked *models.ParkedSale
+	if saleData.ParkedSaleID != nil {
+		if parked, err = claimParkedSale(tx, *saleData.ParkedSaleID, sale.BusinessID); err != nil {
+			return nil, err
+		}
+	}
+	var quote *models.Quotation
+	if saleData.QuotationID != nil {
+		if quote, err = claimQuotation(tx, *saleData.QuotationID, sale.BusinessID); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range items {
+		// Lock the stock so two tills cannot sell the same units
 		var inventory models.Inventory
-		if err := tx.Where("product_id = ?", sellRequest.ProductID).First(&inventory).Error; err != nil {
-			tx.Rollback()
-			utils.ErrorLogger("Product not found in inventory: product_id= %d %v", sellRequest.ProductID, err)
-			c.JSON(404, gin.H{"error": fmt.Sprintf("Product %d not found in inventory", sellRequest.ProductID)})
-			return
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ?", item.ProductID).
+			First(&inventory).Error; err != nil {
+			utils.ErrorLogger("Product not found in inventory: product_id= %d %v", item.ProductID, err)
+			return nil, newSaleError(404, "Product %d not found in inventory", item.ProductID)
 		}
 
-		// Check if we have enough stock
-		if inventory.Quantity < sellRequest.Quantity {
-			tx.Rollback()
-			utils.WarningLogger("Insufficient stock for product %d. Requested: %d, Available: %d",
-				sellRequest.ProductID, sellRequest.Quantity, inventory.Quantity)
-			c.JSON(400, gin.H{"error": fmt.Sprintf("Insufficient stock for product %d", sellRequest.ProductID)})
-			return
+		// Check if we have enough stock, leaving what other parked sales
+		// have reserved
+		reserved, err := reservedStock(tx, item.ProductID, time.Now(), parked, saleData.layaway)
+		if err != nil {
+			return nil, err
+		}
+		if inventory.Quantity-reserved < item.Quantity {
+			utils.WarningLogger("Insufficient stock for product %d. Requested: %d, Available: %d, Reserved: %d",
+				item.ProductID, item.Quantity, inventory.Quantity, reserved)
+			return nil, newSaleError(400, "Insufficient stock for product %d", item.ProductID)
 		}
 
 		// Update inventory
-		inventory.Quantity -= sellRequest.Quantity
+		inventory.Quantity -= item.Quantity
 		inventory.LastUpdated = time.Now()
-		if err := tx.Save(&inventory).Error; err != nil {
-			tx.Rollback()
-			utils.ErrorLogger("Failed to update inventory for product %d: %v", sellRequest.ProductID, err)
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to update inventory for product %d", sellRequest.ProductID)})
-			return
+		if err := tx.Model(&inventory).Updates(map[string]interface{}{
+			"quantity":     gorm.Expr("quantity - ?", item.Quantity),
+			"last_updated": inventory.LastUpdated,
+		}).Error; err != nil {
+			utils.ErrorLogger("Failed to update inventory for product %d: %v", item.ProductID, err)
+			return nil, newSaleError(500, "Failed to update inventory for product %d", item.ProductID)
 		}
 
 		// Record stock movement
 		stockMovement := models.StockMovement{
-			ProductID:      sellRequest.ProductID,
-			ChangeType:     saleData.PaymentMethod,
-			QuantityChange: -sellRequest.Quantity,
-			Note:           sellRequest.Note,
-			CreatedAt:      time.Now(),
+			ProductID:      item.ProductID,
+			ChangeType:     models.MovementSale,
+			QuantityChange: -item.Quantity,
+			Note:           fmt.Sprintf("Sale %s", sale.SaleNumber),
+			CreatedAt:      at,
+		}
+		if item.Note != "" {
+			stockMovement.Note += ": " + item.Note
 		}
 
 		if err := tx.Create(&stockMovement).Error; err != nil {
-			tx.Rollback()
-			utils.ErrorLogger("Failed to create stock movement for product %d: %v", sellRequest.ProductID, err)
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to record stock movement for product %d", sellRequest.ProductID)})
-			return
+			utils.ErrorLogger("Failed to create stock movement for product %d: %v", item.ProductID, err)
+			return nil, newSaleError(500, "Failed to record stock movement for product %d", item.ProductID)
+		}
+
+		// Record the sale item
+		item.SaleID = sale.ID
+		if err := tx.Create(&item).Error; err != nil {
+			utils.ErrorLogger("Failed to create sale item for product %d: %v", item.ProductID, err)
+			return nil, newSaleError(500, "Failed to record sales transaction for product %d", item.ProductID)
 		}
+		sale.Items = append(sale.Items, item)
+		queueStockChanged(tx, item.ProductName, inventory)
+
+		// Check for low stock alert
+		if inventory.Quantity <= inventory.LowStockThreshold {
+			if err := syncLowStockAlert(tx, item.ProductName, inventory); err != nil {
+				utils.ErrorLogger("Failed to create low stock alert for product %d: %v", item.ProductID, err)
+			}
+		}
+	}
+
+	payments, paymentMethod, change, err := salePayments(saleData, sale.TotalAmount)
+	if err != nil {
+		return nil, err
+	}
+	sale.PaymentMethod = paymentMethod
+	sale.ChangeDue = change
+	var credit float64
+	for _, payment := range payments {
+		if payment.Method == models.PaymentCredit {
+			credit += payment.Amount
+		}
+	}
+	credit = roundMoney(credit)
+	sale.AmountPaid = roundMoney(sale.TotalAmount - credit)
+	if credit > 0 && (sale.CustomerName == "" || sale.CustomerPhone == "") {
+		return nil, newSaleError(400, "Name and phone number are required for credit sales")
+	}
+	updateSaleBalance(&sale)
+
+	if err := tx.Omit(clause.Associations).Save(&sale).Error; err != nil {
+		utils.ErrorLogger("Failed to update sale %d: %v", sale.ID, err)
+		return nil, newSaleError(500, "Failed to complete sales")
+	}
+
+	for _, payment := range payments {
+		payment.SaleID = sale.ID
+		switch payment.Method {
+		case models.PaymentPoints:
+			if err := redeemPoints(tx, &sale, &payment); err != nil {
+				return nil, err
+			}
+		case models.PaymentCreditNote:
+			if err := redeemCreditNote(tx, &sale, &payment); err != nil {
+				return nil, err
+			}
+		case models.PaymentMpesa:
+			if err := linkMpesaPayment(tx, &sale, &payment); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			utils.ErrorLogger("Failed to record %s payment for sale %d: %v", payment.Method, sale.ID, err)
+			return nil, newSaleError(500, "Failed to record payment")
+		}
+		sale.Payments = append(sale.Payments, payment)
+	}
+
+	// Put the unpaid part on the customer's account, shared across the
+	// items by value
+	if credit > 0 {
+		allocated := 0.0
+		for i, item := range sale.Items {
+			share := credit - allocated
+			if i < len(sale.Items)-1 && sale.TotalAmount > 0 {
+				share = roundMoney(credit * item.LineTotal / sale.TotalAmount)
+			}
+			allocated = roundMoney(allocated + share)
 
-		// Handle credit sale
-		if strings.ToUpper(saleData.PaymentMethod) == "CREDIT" {
 			creditTx := models.CreditTransaction{
-				ProductID:    sellRequest.ProductID,
-				Name:         saleData.CustomerName,
-				PhoneNumber:  saleData.CustomerPhone,
-				Quantity:     sellRequest.Quantity,
-				CreditAmount: sellRequest.Amount,
-				BalanceDue:   saleData.RemainingBalance,
-				Status:       "unpaid",
+				SaleID:       &sale.ID,
+				CustomerID:   sale.CustomerID,
+				ProductID:    item.ProductID,
+				Name:         sale.CustomerName,
+				PhoneNumber:  sale.CustomerPhone,
+				Quantity:     item.Quantity,
+				CreditAmount: roundMoney(share),
+				BalanceDue:   sale.BalanceDue,
+				Status:       "PENDING",
 			}
 
 			if err := tx.Create(&creditTx).Error; err != nil {
-				tx.Rollback()
-				utils.ErrorLogger("Failed to create credit transaction for product %d: %v", sellRequest.ProductID, err)
-				c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to record credit transaction for product %d", sellRequest.ProductID)})
-				return
+				utils.ErrorLogger("Failed to create credit transaction for product %d: %v", item.ProductID, err)
+				return nil, newSaleError(500, "Failed to record credit transaction for product %d", item.ProductID)
 			}
 		}
+	}
 
-		// Record sales transaction
-		salesTransaction := models.SalesTransaction{
-			ProductID:       sellRequest.ProductID,
-			Quantity:        sellRequest.Quantity,
-			TotalAmount:     sellRequest.Amount,
-			PaymentMethod:   saleData.PaymentMethod,
-			CustomerName:    saleData.CustomerName,
-			CustomerPhone:   saleData.CustomerPhone,
-			ReferenceNumber: saleData.ReferenceNumber,
-			CreatedAt:       time.Now(),
-			UpdatedAt:       time.Now(),
-		}
+	if err := earnPoints(tx, &sale); err != nil {
+		utils.ErrorLogger("Failed to award loyalty points for sale %s: %v", sale.SaleNumber, err)
+		return nil, newSaleError(500, "Failed to complete sales")
+	}
 
-		if err := tx.Create(&salesTransaction).Error; err != nil {
-			tx.Rollback()
-			utils.ErrorLogger("Failed to create sales transaction for product %d: %v", sellRequest.ProductID, err)
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to record sales transaction for product %d", sellRequest.ProductID)})
-			return
+	if parked != nil {
+		if err := completeParkedSale(tx, parked, sale.ID); err != nil {
+			utils.ErrorLogger("Failed to complete parked sale %d: %v", parked.ID, err)
+			return nil, newSaleError(500, "Failed to complete sales")
 		}
+	}
 
-		// Check for low stock alert
-		if inventory.Quantity <= inventory.LowStockThreshold {
-			var product models.Product
-			if err := tx.First(&product, sellRequest.ProductID).Error; err == nil {
-				alert := models.LowStockAlert{
-					ProductID:    sellRequest.ProductID,
-					AlertMessage: fmt.Sprintf("Product stock is low. Current quantity: %d", inventory.Quantity),
-					Resolved:     false,
-					CreatedAt:    time.Now(),
-				}
-
-				if err := tx.Create(&alert).Error; err != nil {
-					utils.ErrorLogger("Failed to create low stock alert for product %d: %v", sellRequest.ProductID, err)
-				}
-			}
+	if quote != nil {
+		if err := convertQuotation(tx, quote, sale.ID); err != nil {
+			utils.ErrorLogger("Failed to convert quotation %s: %v", quote.QuoteNumber, err)
+			return nil, newSaleError(500, "Failed to complete sales")
 		}
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		utils.ErrorLogger("Failed to commit transaction: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to complete sales"})
-		return
+	if err := queueEtimsInvoice(tx, &sale); err != nil {
+		utils.ErrorLogger("Failed to queue eTIMS invoice for sale %s: %v", sale.SaleNumber, err)
+		return nil, newSaleError(500, "Failed to complete sales")
 	}
 
-	utils.InfoLogger("Successfully processed sales")
-	c.JSON(200, gin.H{
-		"message": "Sales recorded successfully",
-	})
+	events.Queue(tx.Statement.Context, events.SaleRecorded, saleEvent(&sale))
+	return &sale, nil
 }
 
-// Fetch sales history
-func (im *SalesManagementHandler) FetchSalesHistory(c *gin.Context) {
-	var salesTransactions []struct {
-		models.SalesTransaction
-		ProductName string `json:"product_name"`
+// priceSale prices the requested items from the catalogue and promotions,
+// takes off the requested discounts and works out VAT, setting the sale's
+// totals. It records nothing, so it can also price a basket that is not
+// being sold yet.
+func priceSale(tx *gorm.DB, sale *models.Sale, saleData SaleData, cashier *models.User) ([]models.SaleItem, error) {
+	promotions, err := activePromotions(tx, sale.BusinessID, sale.CreatedAt)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := im.db.Table("sales_transactions").
-		Select("sales_transactions.*, products.name as product_name").
-		Joins("JOIN products ON sales_transactions.product_id = products.id").
-		Find(&salesTransactions).Error; err != nil {
-		utils.ErrorLogger("Failed to fetch sales history: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to fetch sales history"})
-		return
+	items := make([]models.SaleItem, 0, len(saleData.Products))
+	taxClassIDs := make([]*uint, 0, len(saleData.Products))
+	for _, sellRequest := range saleData.Products {
+		if sellRequest.Quantity <= 0 {
+			return nil, newSaleError(400, "Quantity for product %d must be positive", sellRequest.ProductID)
+		}
+
+		var product models.Product
+		if err := tx.First(&product, sellRequest.ProductID).Error; err != nil {
+			utils.ErrorLogger("Product not found: product_id= %d %v", sellRequest.ProductID, err)
+			return nil, newSaleError(404, "Product %d not found in inventory", sellRequest.ProductID)
+		}
+
+		unitCost := product.CostPrice
+		item := models.SaleItem{
+			ProductID:   sellRequest.ProductID,
+			ProductName: product.Name,
+			Quantity:    sellRequest.Quantity,
+			UnitCost:    &unitCost,
+			Note:        sellRequest.Note,
+			CreatedAt:   sale.CreatedAt,
+		}
+		if err := priceSaleItem(&item, product, sellRequest, promotions, cashier, sale.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := applyLineDiscount(&item, sellRequest.Discount); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		taxClassIDs = append(taxClassIDs, product.TaxClassID)
+	}
+
+	if err := applyBasketDiscount(sale, items, saleData.Discount); err != nil {
+		return nil, err
+	}
+
+	// VAT is worked out on what is left after discounts
+	taxes, err := loadTaxRules(tx, sale.BusinessID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		taxes.apply(&items[i], taxClassIDs[i])
+	}
+
+	for _, item := range items {
+		sale.Subtotal += item.GrossAmount
+		sale.DiscountTotal += item.Discount + item.BasketDiscount
+		sale.TaxTotal += item.TaxAmount
+		sale.TotalAmount += item.LineTotal
+	}
+	sale.Subtotal = roundMoney(sale.Subtotal)
+	sale.DiscountTotal = roundMoney(sale.DiscountTotal)
+	sale.TaxTotal = roundMoney(sale.TaxTotal)
+	sale.TotalAmount = roundMoney(sale.TotalAmount)
+	if saleData.TotalAmount != nil && !sameAmount(*saleData.TotalAmount, sale.TotalAmount) {
+		return nil, newSaleError(409, "Sale total is %.2f, not %.2f, please review the sale", sale.TotalAmount, *saleData.TotalAmount)
 	}
+	return items, nil
+}
 
-	utils.InfoLogger("Successfully fetched sales history")
-	c.JSON(200, salesTransactions)
+// requestedPaymentMethod is the payment method a sale starts with, before
+// its payments are checked against the total
+func requestedPaymentMethod(saleData SaleData) string {
+	if len(saleData.Payments) == 0 {
+		return strings.ToUpper(strings.TrimSpace(saleData.PaymentMethod))
+	}
+	method := strings.ToUpper(strings.TrimSpace(saleData.Payments[0].Method))
+	for _, payment := range saleData.Payments[1:] {
+		if strings.ToUpper(strings.TrimSpace(payment.Method)) != method {
+			return models.PaymentSplit
+		}
+	}
+	return method
+}
+
+// updateSaleBalance works out what is still owed on a sale, net of returns
+// and refunds, and sets its payment status to match.
+func updateSaleBalance(sale *models.Sale) {
+	netTotal := sale.TotalAmount - sale.ReturnedTotal
+	netPaid := sale.AmountPaid - sale.RefundedTotal
+	sale.BalanceDue = math.Max(roundMoney(netTotal-netPaid), 0)
+	switch {
+	case sale.BalanceDue <= 0:
+		sale.PaymentStatus = models.PaymentStatusPaid
+	case netPaid > 0:
+		sale.PaymentStatus = models.PaymentStatusPartial
+	default:
+		sale.PaymentStatus = models.PaymentStatusUnpaid
+	}
 }
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxSyncBatch is the most sales one sync request may carry
+	maxSyncBatch = 100
+	// maxOfflineAge is how old an offline sale may be when it is synced
+	maxOfflineAge = 30 * 24 * time.Hour
+	// clockSkew allows for till clocks running a little fast
+	clockSkew = 5 * time.Minute
+)
+
+// Sync result statuses
+const (
+	SyncRecorded  = "RECORDED"
+	SyncDuplicate = "DUPLICATE"
+	SyncConflict  = "CONFLICT"
+	SyncFailed    = "FAILED"
+)
+
+// OfflineSale is a sale rung up while the till was offline
+type OfflineSale struct {
+	SaleData
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type SyncSalesRequest struct {
+	Sales []OfflineSale `json:"sales" binding:"required"`
+}
+
+type syncResult struct {
+	IdempotencyKey string `json:"idempotency_key"`
+	Status         string `json:"status"`
+	SaleID         uint   `json:"sale_id,omitempty"`
+	SaleNumber     string `json:"sale_number,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// SyncSales records a batch of sales made offline, oldest first, each in its
+// own transaction. Every sale needs an idempotency key, so a batch sent again
+// after a lost response reports the sales it already recorded as duplicates
+// instead of selling the stock twice. A sale that cannot be recorded now,
+// for example because the stock has since run out, is reported as a
+// conflict and the rest of the batch carries on.
+func (im *SalesManagementHandler) SyncSales(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req SyncSalesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format. Expected JSON with a list of sales"})
+		return
+	}
+	if len(req.Sales) == 0 || len(req.Sales) > maxSyncBatch {
+		c.JSON(400, gin.H{"error": "Send between 1 and 100 sales per sync"})
+		return
+	}
+
+	sales := req.Sales
+	sort.SliceStable(sales, func(i, j int) bool {
+		return sales[i].RecordedAt.Before(sales[j].RecordedAt)
+	})
+
+	now := time.Now()
+	results := make([]syncResult, 0, len(sales))
+	counts := make(map[string]int)
+	for _, offline := range sales {
+		result := syncResult{IdempotencyKey: strings.TrimSpace(offline.IdempotencyKey)}
+
+		switch {
+		case result.IdempotencyKey == "":
+			result.Status, result.Error = SyncFailed, "Idempotency key is required"
+		case offline.RecordedAt.IsZero():
+			result.Status, result.Error = SyncFailed, "recorded_at is required"
+		case offline.RecordedAt.After(now.Add(clockSkew)):
+			result.Status, result.Error = SyncFailed, "recorded_at is in the future"
+		case offline.RecordedAt.Before(now.Add(-maxOfflineAge)):
+			result.Status, result.Error = SyncFailed, "Sale is too old to sync"
+		case len(offline.Products) == 0:
+			result.Status, result.Error = SyncFailed, "At least one product is required"
+		default:
+			saleData := offline.SaleData
+			saleData.recordedAt = offline.RecordedAt
+			sale, replayed, err := recordSaleOnce(im.db, saleData, &user)
+			switch {
+			case err != nil:
+				result.Status, result.Error = SyncFailed, err.Error()
+				var se *saleError
+				if errors.As(err, &se) && se.status < 500 {
+					result.Status = SyncConflict
+				} else {
+					utils.ErrorLogger("Failed to sync offline sale %s: %v", result.IdempotencyKey, err)
+					result.Error = "Failed to record sale"
+				}
+			case replayed:
+				result.Status, result.SaleID, result.SaleNumber = SyncDuplicate, sale.ID, sale.SaleNumber
+			default:
+				result.Status, result.SaleID, result.SaleNumber = SyncRecorded, sale.ID, sale.SaleNumber
+			}
+		}
+
+		counts[result.Status]++
+		results = append(results, result)
+	}
+
+	utils.InfoLogger("Synced %d offline sales for user %d: %d recorded, %d duplicates, %d conflicts, %d failed",
+		len(sales), user.ID, counts[SyncRecorded], counts[SyncDuplicate], counts[SyncConflict], counts[SyncFailed])
+	c.JSON(200, gin.H{
+		"success":    true,
+		"recorded":   counts[SyncRecorded],
+		"duplicates": counts[SyncDuplicate],
+		"conflicts":  counts[SyncConflict],
+		"failed":     counts[SyncFailed],
+		"results":    results,
+	})
+}
@@ -0,0 +1,642 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ShiftHandler struct {
+	db *gorm.DB
+}
+
+func NewShiftHandler(db *gorm.DB) *ShiftHandler {
+	return &ShiftHandler{db: db}
+}
+
+type OpenShiftRequest struct {
+	OpeningFloat *float64 `json:"opening_float" binding:"required"`
+	Note         string   `json:"note"`
+}
+
+type CloseShiftRequest struct {
+	CountedCash *float64 `json:"counted_cash" binding:"required"`
+	Note        string   `json:"note"`
+}
+
+type CashMovementRequest struct {
+	Type       string  `json:"type" binding:"required"`
+	Amount     float64 `json:"amount" binding:"required"`
+	Reason     string  `json:"reason" binding:"required"`
+	SupplierID *uint   `json:"supplier_id"`
+}
+
+// shiftAt finds the shift a cashier had open at a given time, so sales and
+// refunds land in the drawer they were paid from. Sales synced from offline
+// tills fall in the shift open when they were rung up.
+func shiftAt(tx *gorm.DB, cashierID uint, at time.Time) (*uint, error) {
+	var shift models.Shift
+	err := tx.Where("cashier_id = ? AND opened_at <= ?", cashierID, at).
+		Where("closed_at IS NULL OR closed_at >= ?", at).
+		Order("opened_at DESC").
+		First(&shift).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &shift.ID, nil
+}
+
+func openShiftFor(db *gorm.DB, cashierID uint) (*models.Shift, error) {
+	var shift models.Shift
+	err := db.Where("cashier_id = ? AND status = ?", cashierID, models.ShiftOpen).First(&shift).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &shift, nil
+}
+
+// refundShift picks the shift whose drawer holds the cash a return of sale
+// pays out: the shift asked for, which must be open; otherwise the sale's
+// own shift while it is still open, then the open shift of the sale's
+// cashier and last that of the user processing the return. It is nil when
+// none of them has a shift open.
+func refundShift(tx *gorm.DB, sale *models.Sale, requested *uint, user models.User) (*uint, error) {
+	if requested != nil {
+		var shift models.Shift
+		if err := tx.Where("id = ? AND business_id = ? AND status = ?", *requested, sale.BusinessID, models.ShiftOpen).
+			First(&shift).Error; err != nil {
+			return nil, newSaleError(400, "Shift %d is not open", *requested)
+		}
+		return &shift.ID, nil
+	}
+
+	if sale.ShiftID != nil {
+		var shift models.Shift
+		err := tx.Where("id = ? AND status = ?", *sale.ShiftID, models.ShiftOpen).First(&shift).Error
+		if err == nil {
+			return &shift.ID, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	cashiers := []uint{user.ID}
+	if sale.CashierID != nil {
+		cashiers = []uint{*sale.CashierID, user.ID}
+	}
+	for _, cashierID := range cashiers {
+		shift, err := openShiftFor(tx, cashierID)
+		if err != nil {
+			return nil, err
+		}
+		if shift != nil {
+			return &shift.ID, nil
+		}
+	}
+	return nil, nil
+}
+
+func (sh *ShiftHandler) OpenShift(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req OpenShiftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Opening float is required"})
+		return
+	}
+	if *req.OpeningFloat < 0 {
+		c.JSON(400, gin.H{"error": "Opening float cannot be negative"})
+		return
+	}
+
+	existing, err := openShiftFor(sh.db, user.ID)
+	if err != nil {
+		utils.ErrorLogger("Failed to check open shifts: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to open shift"})
+		return
+	}
+	if existing != nil {
+		c.JSON(409, gin.H{"error": "You already have an open shift; close it first"})
+		return
+	}
+
+	shift := models.Shift{
+		BusinessID:   user.BusinessID,
+		CashierID:    user.ID,
+		Status:       models.ShiftOpen,
+		OpeningFloat: roundMoney(*req.OpeningFloat),
+		OpenedAt:     time.Now(),
+		OpenNote:     strings.TrimSpace(req.Note),
+	}
+	if err := sh.db.Create(&shift).Error; err != nil {
+		utils.ErrorLogger("Failed to open shift: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to open shift"})
+		return
+	}
+
+	utils.InfoLogger("User %d opened shift %d with a float of %.2f", user.ID, shift.ID, shift.OpeningFloat)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    shift,
+	})
+}
+
+// GetCurrentShift returns the user's open shift with its running totals
+func (sh *ShiftHandler) GetCurrentShift(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	shift, err := openShiftFor(sh.db, user.ID)
+	if err != nil {
+		utils.ErrorLogger("Failed to fetch open shift: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+	if shift == nil {
+		c.JSON(404, gin.H{"error": "No open shift"})
+		return
+	}
+
+	report, err := buildZReport(sh.db, shift)
+	if err != nil {
+		utils.ErrorLogger("Failed to summarise shift %d: %v", shift.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch shift"})
+		return
+	}
+	c.JSON(200, report)
+}
+
+// RecordCashMovement records cash put into or paid out of the drawer
+func (sh *ShiftHandler) RecordCashMovement(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req CashMovementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Type, amount and reason are required"})
+		return
+	}
+	movementType := strings.ToUpper(req.Type)
+	if movementType != models.CashIn && movementType != models.CashOut {
+		c.JSON(400, gin.H{"error": "Type must be CASH_IN or CASH_OUT"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(400, gin.H{"error": "Amount must be positive"})
+		return
+	}
+
+	tx := sh.db.Begin()
+	if tx.Error != nil {
+		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
+		c.JSON(500, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var shift models.Shift
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("cashier_id = ? AND status = ?", user.ID, models.ShiftOpen).
+		First(&shift).Error; err != nil {
+		tx.Rollback()
+		c.JSON(409, gin.H{"error": "Open a shift before moving cash"})
+		return
+	}
+
+	movement := models.CashMovement{
+		ShiftID:      shift.ID,
+		BusinessID:   shift.BusinessID,
+		Type:         movementType,
+		Amount:       roundMoney(req.Amount),
+		Reason:       strings.TrimSpace(req.Reason),
+		SupplierID:   req.SupplierID,
+		RecordedByID: user.ID,
+	}
+	if movementType == models.CashOut {
+		cash, err := drawerCash(tx, &shift)
+		if err != nil {
+			tx.Rollback()
+			utils.ErrorLogger("Failed to work out drawer cash for shift %d: %v", shift.ID, err)
+			c.JSON(500, gin.H{"error": "Failed to record cash movement"})
+			return
+		}
+		if movement.Amount > cash.Expected && !sameAmount(movement.Amount, cash.Expected) {
+			tx.Rollback()
+			c.JSON(400, gin.H{"error": fmt.Sprintf("The drawer should only hold %.2f", cash.Expected)})
+			return
+		}
+	}
+
+	if err := tx.Create(&movement).Error; err != nil {
+		tx.Rollback()
+		utils.ErrorLogger("Failed to record cash movement: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to record cash movement"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit transaction: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to record cash movement"})
+		return
+	}
+
+	utils.InfoLogger("Recorded %s of %.2f in shift %d: %s", movement.Type, movement.Amount, shift.ID, movement.Reason)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    movement,
+	})
+}
+
+// CloseShift closes the user's own open shift with the counted cash
+func (sh *ShiftHandler) CloseShift(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	sh.closeShift(c, user, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("cashier_id = ? AND status = ?", user.ID, models.ShiftOpen)
+	})
+}
+
+// CloseShiftFor lets a supervisor close another cashier's shift
+func (sh *ShiftHandler) CloseShiftFor(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+	sh.closeShift(c, user, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ? AND business_id = ?", c.Param("id"), user.BusinessID)
+	})
+}
+
+func (sh *ShiftHandler) closeShift(c *gin.Context, user models.User, find func(tx *gorm.DB) *gorm.DB) {
+	var req CloseShiftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Counted cash is required"})
+		return
+	}
+	if *req.CountedCash < 0 {
+		c.JSON(400, gin.H{"error": "Counted cash cannot be negative"})
+		return
+	}
+
+	tx := sh.db.Begin()
+	if tx.Error != nil {
+		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
+		c.JSON(500, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var shift models.Shift
+	if err := find(tx.Clauses(clause.Locking{Strength: "UPDATE"})).First(&shift).Error; err != nil {
+		tx.Rollback()
+		c.JSON(404, gin.H{"error": "Open shift not found"})
+		return
+	}
+	if shift.Status != models.ShiftOpen {
+		tx.Rollback()
+		c.JSON(409, gin.H{"error": "Shift is already closed"})
+		return
+	}
+
+	cash, err := drawerCash(tx, &shift)
+	if err != nil {
+		tx.Rollback()
+		utils.ErrorLogger("Failed to work out drawer cash for shift %d: %v", shift.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to close shift"})
+		return
+	}
+
+	now := time.Now()
+	counted := roundMoney(*req.CountedCash)
+	shift.Status = models.ShiftClosed
+	shift.ClosedAt = &now
+	shift.ClosedByID = &user.ID
+	shift.CloseNote = strings.TrimSpace(req.Note)
+	shift.CountedCash = &counted
+	shift.ExpectedCash = cash.Expected
+	shift.Variance = roundMoney(counted - cash.Expected)
+	if err := tx.Omit(clause.Associations).Save(&shift).Error; err != nil {
+		tx.Rollback()
+		utils.ErrorLogger("Failed to close shift %d: %v", shift.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to close shift"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit transaction: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to close shift"})
+		return
+	}
+
+	if shift.Variance != 0 {
+		utils.WarningLogger("Shift %d closed with a variance of %.2f", shift.ID, shift.Variance)
+	}
+	utils.InfoLogger("User %d closed shift %d", user.ID, shift.ID)
+
+	report, err := buildZReport(sh.db, &shift)
+	if err != nil {
+		utils.ErrorLogger("Failed to build Z-report for shift %d: %v", shift.ID, err)
+		c.JSON(200, gin.H{"success": true, "data": shift})
+		return
+	}
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
+
+// GetShifts lists the business's shifts opened in a date range
+func (sh *ShiftHandler) GetShifts(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	from, to, err := parseDateRange(c, 7)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := sh.db.Where("business_id = ? AND opened_at >= ? AND opened_at < ?", user.BusinessID, from, to)
+	if status := strings.ToUpper(c.Query("status")); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if cashierID := c.Query("cashier_id"); cashierID != "" {
+		query = query.Where("cashier_id = ?", cashierID)
+	}
+
+	var shifts []models.Shift
+	if err := query.Order("opened_at DESC").Find(&shifts).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch shifts: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch shifts"})
+		return
+	}
+
+	c.JSON(200, shifts)
+}
+
+// GetZReport summarises a shift. Cashiers can see their own shifts;
+// supervisors and above can see anyone's.
+func (sh *ShiftHandler) GetZReport(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var shift models.Shift
+	if err := sh.db.Where("business_id = ?", user.BusinessID).First(&shift, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Shift not found"})
+		return
+	}
+	if shift.CashierID != user.ID && !user.HasRole(models.RoleSupervisor) {
+		c.JSON(403, gin.H{"error": "You can only view your own shifts"})
+		return
+	}
+
+	report, err := buildZReport(sh.db, &shift)
+	if err != nil {
+		utils.ErrorLogger("Failed to build Z-report for shift %d: %v", shift.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to build Z-report"})
+		return
+	}
+	c.JSON(200, report)
+}
+
+type drawerTotals struct {
+	OpeningFloat float64 `json:"opening_float"`
+	CashSales    float64 `json:"cash_sales"`
+	CashIn       float64 `json:"cash_in"`
+	CashOut      float64 `json:"cash_out"`
+	CashRefunds  float64 `json:"cash_refunds"`
+	// Layaway deposits taken and refunds paid out in cash
+	LayawayDeposits float64  `json:"layaway_deposits"`
+	LayawayRefunds  float64  `json:"layaway_refunds"`
+	Expected        float64  `json:"expected"`
+	Counted         *float64 `json:"counted,omitempty"`
+	Variance        *float64 `json:"variance,omitempty"`
+}
+
+// drawerCash works out how much cash should be in a shift's drawer: the
+// float, plus cash taken for sales and layaways and put in, less cash paid
+// out and refunded. A sale that completes a layaway was paid for by its
+// deposits, which count in the shifts that took them.
+func drawerCash(tx *gorm.DB, shift *models.Shift) (*drawerTotals, error) {
+	cash := &drawerTotals{OpeningFloat: shift.OpeningFloat}
+
+	if err := tx.Table("sale_payments").
+		Select("COALESCE(SUM(sale_payments.amount), 0)").
+		Joins("JOIN sales ON sales.id = sale_payments.sale_id").
+		Where("sales.shift_id = ? AND sales.layaway_id IS NULL AND sale_payments.method = ?", shift.ID, models.PaymentCash).
+		Scan(&cash.CashSales).Error; err != nil {
+		return nil, err
+	}
+
+	var layawayPayments []struct {
+		Kind  string
+		Total float64
+	}
+	if err := tx.Model(&models.LayawayPayment{}).
+		Select("kind, SUM(amount) AS total").
+		Where("shift_id = ? AND method = ?", shift.ID, models.PaymentCash).
+		Group("kind").
+		Scan(&layawayPayments).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range layawayPayments {
+		if p.Kind == models.LayawayDeposit {
+			cash.LayawayDeposits = roundMoney(p.Total)
+		} else {
+			cash.LayawayRefunds = roundMoney(p.Total)
+		}
+	}
+
+	var movements []struct {
+		Type  string
+		Total float64
+	}
+	if err := tx.Model(&models.CashMovement{}).
+		Select("type, SUM(amount) AS total").
+		Where("shift_id = ?", shift.ID).
+		Group("type").
+		Scan(&movements).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range movements {
+		if m.Type == models.CashIn {
+			cash.CashIn = roundMoney(m.Total)
+		} else {
+			cash.CashOut = roundMoney(m.Total)
+		}
+	}
+
+	if err := tx.Model(&models.SaleReturn{}).
+		Select("COALESCE(SUM(refund_amount), 0)").
+		Where("shift_id = ? AND refund_method = ?", shift.ID, models.RefundCash).
+		Scan(&cash.CashRefunds).Error; err != nil {
+		return nil, err
+	}
+
+	cash.settle(shift)
+	return cash, nil
+}
+
+// settle works out the cash expected in the drawer from its totals and,
+// once the shift's cash has been counted, how far out the count is
+func (cash *drawerTotals) settle(shift *models.Shift) {
+	cash.CashSales = roundMoney(cash.CashSales)
+	cash.CashRefunds = roundMoney(cash.CashRefunds)
+	cash.Expected = roundMoney(cash.OpeningFloat + cash.CashSales + cash.LayawayDeposits + cash.CashIn -
+		cash.CashOut - cash.CashRefunds - cash.LayawayRefunds)
+	if shift.CountedCash != nil {
+		variance := roundMoney(*shift.CountedCash - cash.Expected)
+		cash.Counted = shift.CountedCash
+		cash.Variance = &variance
+	}
+}
+
+type tenderSummary struct {
+	Method string  `json:"method"`
+	Count  int     `json:"count"`
+	Amount float64 `json:"amount"`
+}
+
+type zReport struct {
+	Shift   models.Shift `json:"shift"`
+	Cashier string       `json:"cashier"`
+	Sales   struct {
+		Count     int     `json:"count"`
+		Gross     float64 `json:"gross"`
+		Discounts float64 `json:"discounts"`
+		Net       float64 `json:"net"`
+		Tax       float64 `json:"tax"`
+	} `json:"sales"`
+	Tenders     []tenderSummary `json:"tenders"`
+	ChangeGiven float64         `json:"change_given"`
+	Returns     struct {
+		Count    int             `json:"count"`
+		Amount   float64         `json:"amount"`
+		Refunded []tenderSummary `json:"refunded"`
+	} `json:"returns"`
+	Voids struct {
+		Count  int     `json:"count"`
+		Amount float64 `json:"amount"`
+	} `json:"voids"`
+	// NetTakings is net sales less returns and voids
+	NetTakings float64               `json:"net_takings"`
+	Cash       *drawerTotals         `json:"cash"`
+	Movements  []models.CashMovement `json:"movements"`
+}
+
+// buildZReport summarises a shift from its recorded sales, returns, voids
+// and drawer movements
+func buildZReport(db *gorm.DB, shift *models.Shift) (*zReport, error) {
+	report := &zReport{Shift: *shift}
+
+	var cashier models.User
+	if err := db.First(&cashier, shift.CashierID).Error; err == nil {
+		report.Cashier = cashier.FullName
+	}
+
+	var sales []models.Sale
+	if err := db.Preload("Payments").Where("shift_id = ?", shift.ID).Find(&sales).Error; err != nil {
+		return nil, err
+	}
+	report.addSales(sales)
+
+	var returns []models.SaleReturn
+	if err := db.Where("shift_id = ?", shift.ID).Find(&returns).Error; err != nil {
+		return nil, err
+	}
+	report.addReturns(returns)
+
+	cash, err := drawerCash(db, shift)
+	if err != nil {
+		return nil, err
+	}
+	report.Cash = cash
+
+	if err := db.Where("shift_id = ?", shift.ID).Order("id").Find(&report.Movements).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// addSales totals the sales rung up in the shift and what they were paid
+// with. Voided sales stay in; addReturns takes them back off.
+func (report *zReport) addSales(sales []models.Sale) {
+	tenders := make(map[string]*tenderSummary)
+	for i := range sales {
+		sale := &sales[i]
+		report.Sales.Count++
+		report.Sales.Gross += sale.Subtotal
+		report.Sales.Discounts += sale.DiscountTotal
+		report.Sales.Net += sale.TotalAmount
+		report.Sales.Tax += sale.TaxTotal
+		report.ChangeGiven += sale.ChangeDue
+		for _, payment := range saleTenders(sale) {
+			tender, ok := tenders[payment.Method]
+			if !ok {
+				tender = &tenderSummary{Method: payment.Method}
+				tenders[payment.Method] = tender
+			}
+			tender.Count++
+			tender.Amount += payment.Amount
+		}
+	}
+	report.Sales.Gross = roundMoney(report.Sales.Gross)
+	report.Sales.Discounts = roundMoney(report.Sales.Discounts)
+	report.Sales.Net = roundMoney(report.Sales.Net)
+	report.Sales.Tax = roundMoney(report.Sales.Tax)
+	report.ChangeGiven = roundMoney(report.ChangeGiven)
+	report.Tenders = sortTenders(tenders)
+}
+
+// addReturns totals the returns and voids refunded from the shift's drawer,
+// whichever shift the sales were made in, and works out the net takings
+func (report *zReport) addReturns(returns []models.SaleReturn) {
+	refunds := make(map[string]*tenderSummary)
+	for _, r := range returns {
+		if r.Void {
+			report.Voids.Count++
+			report.Voids.Amount += r.ReturnedAmount
+		} else {
+			report.Returns.Count++
+			report.Returns.Amount += r.ReturnedAmount
+		}
+		if r.RefundMethod == nil || r.RefundAmount <= 0 {
+			continue
+		}
+		refund, ok := refunds[*r.RefundMethod]
+		if !ok {
+			refund = &tenderSummary{Method: *r.RefundMethod}
+			refunds[*r.RefundMethod] = refund
+		}
+		refund.Count++
+		refund.Amount += r.RefundAmount
+	}
+	report.Returns.Amount = roundMoney(report.Returns.Amount)
+	report.Voids.Amount = roundMoney(report.Voids.Amount)
+	report.Returns.Refunded = sortTenders(refunds)
+	report.NetTakings = roundMoney(report.Sales.Net - report.Returns.Amount - report.Voids.Amount)
+}
+
+func sortTenders(tenders map[string]*tenderSummary) []tenderSummary {
+	sorted := make([]tenderSummary, 0, len(tenders))
+	for _, tender := range tenders {
+		tender.Amount = roundMoney(tender.Amount)
+		sorted = append(sorted, *tender)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Method < sorted[j].Method })
+	return sorted
+}
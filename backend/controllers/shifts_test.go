@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestZReportWithVoidAndCashRefund(t *testing.T) {
+	shiftID := uint(3)
+	cashRefund := models.RefundCash
+	cash := func(amount, tendered float64) models.SalePayment {
+		return models.SalePayment{Method: models.PaymentCash, Amount: amount, Tendered: tendered}
+	}
+
+	// A cash sale with change, an M-Pesa sale and a cash sale voided later
+	sales := []models.Sale{
+		{Subtotal: 520, DiscountTotal: 20, TotalAmount: 500, TaxTotal: 68.97, ChangeDue: 100, Payments: []models.SalePayment{cash(500, 600)}},
+		{Subtotal: 300, TotalAmount: 300, TaxTotal: 41.38, Payments: []models.SalePayment{{Method: models.PaymentMpesa, Amount: 300}}},
+		{Subtotal: 200, TotalAmount: 200, TaxTotal: 27.59, Status: models.SaleStatusVoided, Payments: []models.SalePayment{cash(200, 200)}},
+	}
+	// The void, a cash refund for a return and a return put on a credit
+	// note, all paid from this shift's drawer
+	creditNote := models.RefundCreditNote
+	returns := []models.SaleReturn{
+		{ShiftID: &shiftID, Void: true, ReturnedAmount: 200, RefundAmount: 200, RefundMethod: &cashRefund},
+		{ShiftID: &shiftID, ReturnedAmount: 100, RefundAmount: 100, RefundMethod: &cashRefund},
+		{ShiftID: &shiftID, ReturnedAmount: 50, RefundAmount: 50, RefundMethod: &creditNote},
+	}
+
+	report := &zReport{}
+	report.addSales(sales)
+	report.addReturns(returns)
+
+	if report.Sales.Count != 3 || report.Sales.Gross != 1020 || report.Sales.Discounts != 20 || report.Sales.Net != 1000 || report.Sales.Tax != 137.94 {
+		t.Errorf("sales = %+v, want 3 sales of 1020.00 gross, 20.00 off, 1000.00 net and 137.94 tax", report.Sales)
+	}
+	if report.ChangeGiven != 100 {
+		t.Errorf("change given = %.2f, want 100.00", report.ChangeGiven)
+	}
+	wantTenders := []tenderSummary{{models.PaymentCash, 2, 700}, {models.PaymentMpesa, 1, 300}}
+	if len(report.Tenders) != len(wantTenders) || report.Tenders[0] != wantTenders[0] || report.Tenders[1] != wantTenders[1] {
+		t.Errorf("tenders = %+v, want %+v", report.Tenders, wantTenders)
+	}
+	if report.Voids.Count != 1 || report.Voids.Amount != 200 {
+		t.Errorf("voids = %+v, want 1 of 200.00", report.Voids)
+	}
+	if report.Returns.Count != 2 || report.Returns.Amount != 150 {
+		t.Errorf("returns = %d of %.2f, want 2 of 150.00", report.Returns.Count, report.Returns.Amount)
+	}
+	wantRefunds := []tenderSummary{{models.RefundCash, 2, 300}, {models.RefundCreditNote, 1, 50}}
+	if len(report.Returns.Refunded) != len(wantRefunds) || report.Returns.Refunded[0] != wantRefunds[0] || report.Returns.Refunded[1] != wantRefunds[1] {
+		t.Errorf("refunded = %+v, want %+v", report.Returns.Refunded, wantRefunds)
+	}
+	if report.NetTakings != 650 {
+		t.Errorf("net takings = %.2f, want 650.00", report.NetTakings)
+	}
+
+	// The drawer took 700 in cash sales and paid out 300 in cash refunds
+	counted := 1390.0
+	drawer := &drawerTotals{OpeningFloat: 1000, CashSales: 700, CashRefunds: 300}
+	drawer.settle(&models.Shift{ID: shiftID, CountedCash: &counted})
+	if drawer.Expected != 1400 || drawer.Variance == nil || *drawer.Variance != -10 {
+		t.Errorf("drawer expected %.2f with variance %v, want 1400.00 and -10.00", drawer.Expected, drawer.Variance)
+	}
+}
+
+func TestDrawerTotalsSettle(t *testing.T) {
+	drawer := &drawerTotals{
+		OpeningFloat:    500,
+		CashSales:       1200.005,
+		CashIn:          100,
+		CashOut:         250,
+		CashRefunds:     80,
+		LayawayDeposits: 300,
+		LayawayRefunds:  40,
+	}
+	drawer.settle(&models.Shift{})
+	if drawer.Expected != 1730.01 {
+		t.Errorf("expected = %.2f, want 1730.01", drawer.Expected)
+	}
+	if drawer.Counted != nil || drawer.Variance != nil {
+		t.Errorf("an uncounted drawer has counted %v and variance %v, want neither", drawer.Counted, drawer.Variance)
+	}
+}
@@ -0,0 +1,869 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/schedule"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Delivery list statuses for orders that have not run yet on the day
+const (
+	deliveryPending = "PENDING"
+	deliveryPaused  = "PAUSED"
+)
+
+type StandingOrderHandler struct {
+	db *gorm.DB
+}
+
+func NewStandingOrderHandler(db *gorm.DB) *StandingOrderHandler {
+	return &StandingOrderHandler{db: db}
+}
+
+type StandingOrderItemRequest struct {
+	ProductID uint `json:"product_id" binding:"required"`
+	Quantity  int  `json:"quantity" binding:"required"`
+}
+
+type StandingOrderRequest struct {
+	// CustomerID picks a customer from the directory; otherwise the
+	// customer is looked up, or added, by CustomerPhone
+	CustomerID      *uint                      `json:"customer_id"`
+	CustomerName    string                     `json:"customer_name"`
+	CustomerPhone   string                     `json:"customer_phone"`
+	Products        []StandingOrderItemRequest `json:"products" binding:"required"`
+	PaymentMethod   string                     `json:"payment_method" binding:"required"`
+	Frequency       string                     `json:"frequency" binding:"required"`
+	Interval        int                        `json:"interval"`
+	Weekdays        []int                      `json:"weekdays"`
+	StartDate       string                     `json:"start_date"`
+	EndDate         string                     `json:"end_date"`
+	DeliveryAddress string                     `json:"delivery_address"`
+	Notes           string                     `json:"notes"`
+}
+
+type PauseStandingOrderRequest struct {
+	// Until is the last day of the pause, YYYY-MM-DD; without it the order
+	// stays paused until it is resumed
+	Until string `json:"until"`
+}
+
+type SkipStandingOrderRequest struct {
+	Date string `json:"date" binding:"required"`
+}
+
+// standingRule is the schedule a standing order runs on
+func standingRule(order *models.StandingOrder) schedule.Rule {
+	rule := schedule.Rule{
+		Frequency: order.Frequency,
+		Interval:  order.Interval,
+		Start:     order.StartDate,
+		End:       order.EndDate,
+	}
+	for _, part := range strings.Split(order.Weekdays, ",") {
+		if w, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+			rule.Weekdays = append(rule.Weekdays, time.Weekday(w))
+		}
+	}
+	return rule
+}
+
+// pausedOn reports whether the order is paused on the given day
+func pausedOn(order *models.StandingOrder, day time.Time) bool {
+	return order.Status == models.StandingOrderPaused &&
+		(order.PausedUntil == nil || !day.After(*order.PausedUntil))
+}
+
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// applyStandingOrderRequest checks a create or update request and copies it
+// onto order, replacing its items
+func applyStandingOrderRequest(tx *gorm.DB, order *models.StandingOrder, req StandingOrderRequest) error {
+	if len(req.Products) == 0 {
+		return newSaleError(400, "At least one product is required")
+	}
+	order.Items = order.Items[:0]
+	for _, p := range req.Products {
+		if p.Quantity <= 0 {
+			return newSaleError(400, "Quantity for product %d must be positive", p.ProductID)
+		}
+		var product models.Product
+		if err := tx.First(&product, p.ProductID).Error; err != nil {
+			return newSaleError(404, "Product %d not found", p.ProductID)
+		}
+		if product.Archived {
+			return newSaleError(400, "%s is archived and cannot be ordered", product.Name)
+		}
+		order.Items = append(order.Items, models.StandingOrderItem{
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			Quantity:    p.Quantity,
+		})
+	}
+
+	order.PaymentMethod = strings.ToUpper(strings.TrimSpace(req.PaymentMethod))
+	switch order.PaymentMethod {
+	case models.PaymentCash, models.PaymentMpesa, models.PaymentCredit:
+	default:
+		return newSaleError(400, "Payment method must be CASH, MPESA or CREDIT")
+	}
+
+	order.Frequency = strings.ToUpper(strings.TrimSpace(req.Frequency))
+	order.Interval = req.Interval
+	if order.Interval == 0 {
+		order.Interval = 1
+	}
+	weekdays := make([]string, 0, len(req.Weekdays))
+	for _, w := range req.Weekdays {
+		if w < 0 || w > 6 {
+			return newSaleError(400, "Weekdays run from 0 (Sunday) to 6 (Saturday)")
+		}
+		weekdays = append(weekdays, strconv.Itoa(w))
+	}
+	order.Weekdays = strings.Join(weekdays, ",")
+
+	order.StartDate = today()
+	if req.StartDate != "" {
+		start, err := time.ParseInLocation(dateLayout, req.StartDate, time.Now().Location())
+		if err != nil {
+			return newSaleError(400, "Invalid start_date, expected YYYY-MM-DD")
+		}
+		order.StartDate = start
+	}
+	order.EndDate = nil
+	if req.EndDate != "" {
+		end, err := time.ParseInLocation(dateLayout, req.EndDate, time.Now().Location())
+		if err != nil {
+			return newSaleError(400, "Invalid end_date, expected YYYY-MM-DD")
+		}
+		order.EndDate = &end
+	}
+
+	rule := standingRule(order)
+	if !rule.Valid() {
+		return newSaleError(400, "Frequency must be DAILY, WEEKLY or MONTHLY with a positive interval, and end_date must not be before start_date")
+	}
+	next, ok := rule.First(today())
+	if !ok {
+		return newSaleError(400, "The schedule has no delivery dates left")
+	}
+	order.NextRunDate = &next
+
+	order.DeliveryAddress = strings.TrimSpace(req.DeliveryAddress)
+	order.Notes = strings.TrimSpace(req.Notes)
+	return nil
+}
+
+// errStandingOrderInactive is returned by runStandingOrder for an order that
+// is ended or paused on the due date
+var errStandingOrderInactive = errors.New("standing order is not active")
+
+// runStandingOrder rings up a standing order's sale for one due date. A
+// sale that cannot be made, for example for lack of stock, is recorded as
+// a failed run so it shows on the delivery list and can be tried again.
+// Dates that already have a run are left alone unless the run failed. The
+// sale is dated on its due date and is kept out of the order creator's
+// shift, as it is not paid at their till.
+func runStandingOrder(db *gorm.DB, orderID uint, due time.Time, userID *uint) (*models.StandingOrderRun, error) {
+	var owner models.StandingOrder
+	if err := db.Select("id", "business_id").First(&owner, orderID).Error; err != nil {
+		return nil, err
+	}
+	businessID := owner.BusinessID
+
+	var run models.StandingOrderRun
+	err := withEvents(db, businessID, func(tx *gorm.DB) error {
+		var order models.StandingOrder
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+			First(&order, orderID).Error; err != nil {
+			return err
+		}
+		// The order may have been ended or paused since the caller looked
+		if order.Status == models.StandingOrderEnded || pausedOn(&order, due) {
+			return errStandingOrderInactive
+		}
+
+		err := tx.Where("standing_order_id = ? AND due_date = ?", order.ID, due.Format(dateLayout)).First(&run).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if run.ID != 0 && run.Status != models.StandingRunFailed {
+			return nil
+		}
+
+		var cashier models.User
+		if err := tx.First(&cashier, order.CreatedByID).Error; err != nil {
+			return err
+		}
+		saleData := SaleData{
+			PaymentMethod:   order.PaymentMethod,
+			CustomerID:      &order.CustomerID,
+			ReferenceNumber: order.OrderNumber,
+			scheduledFor:    time.Now(),
+		}
+		// A date missed while the server was down is sold on the day it
+		// was due, at the end of that day
+		if due.Before(today()) {
+			saleData.scheduledFor = due.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		for _, item := range order.Items {
+			saleData.Products = append(saleData.Products, SellRequest{
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Note:      fmt.Sprintf("Standing order %s for %s", order.OrderNumber, due.Format(dateLayout)),
+			})
+		}
+
+		sale, err := recordSale(tx, saleData, &cashier)
+		if err != nil {
+			return err
+		}
+
+		run.StandingOrderID = order.ID
+		run.BusinessID = order.BusinessID
+		run.DueDate = due
+		run.Status = models.StandingRunCreated
+		run.SaleID = &sale.ID
+		run.Error = ""
+		run.CreatedByID = userID
+		return tx.Save(&run).Error
+	})
+
+	var se *saleError
+	if err == nil || !errors.As(err, &se) {
+		return &run, err
+	}
+
+	// The sale was refused; keep the reason on a failed run
+	run.StandingOrderID = orderID
+	run.BusinessID = businessID
+	run.DueDate = due
+	run.Status = models.StandingRunFailed
+	run.SaleID = nil
+	run.Error = se.message
+	run.CreatedByID = userID
+	if err := db.Save(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// findStandingOrder loads one of the business's standing orders with its
+// items and customer
+func (sh *StandingOrderHandler) findStandingOrder(c *gin.Context, businessID uint) (*models.StandingOrder, bool) {
+	var order models.StandingOrder
+	if err := sh.db.Preload("Items").Preload("Customer").
+		Where("business_id = ?", businessID).
+		First(&order, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Standing order not found"})
+		return nil, false
+	}
+	return &order, true
+}
+
+func (sh *StandingOrderHandler) CreateStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req StandingOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Products, payment method and frequency are required"})
+		return
+	}
+
+	order := models.StandingOrder{
+		// Placeholder until the ID is known; it only needs to be unique
+		OrderNumber: utils.GenerateUUID(),
+		BusinessID:  user.BusinessID,
+		CreatedByID: user.ID,
+		Status:      models.StandingOrderActive,
+	}
+	err := sh.db.Transaction(func(tx *gorm.DB) error {
+		customer, err := resolveCustomer(tx, user.BusinessID, SaleData{
+			CustomerID:    req.CustomerID,
+			CustomerName:  req.CustomerName,
+			CustomerPhone: req.CustomerPhone,
+		})
+		if err != nil {
+			return err
+		}
+		if customer == nil && strings.TrimSpace(req.CustomerPhone) != "" {
+			return newSaleError(400, "Customer phone number %s is not a valid Kenyan number", strings.TrimSpace(req.CustomerPhone))
+		}
+		if customer == nil {
+			return newSaleError(400, "A customer is required; give customer_id or a phone number")
+		}
+		order.CustomerID = customer.ID
+		order.Customer = customer
+
+		if err := applyStandingOrderRequest(tx, &order, req); err != nil {
+			return err
+		}
+		if err := tx.Omit("Customer").Create(&order).Error; err != nil {
+			return err
+		}
+		order.OrderNumber = fmt.Sprintf("SO%06d", order.ID)
+		return tx.Model(&order).Update("order_number", order.OrderNumber).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d created standing order %s for customer %d", user.ID, order.OrderNumber, order.CustomerID)
+	c.JSON(201, gin.H{"success": true, "data": order})
+}
+
+// UpdateStandingOrder replaces a standing order's items, payment method,
+// schedule and delivery details. Dates that have already run keep their
+// sales.
+func (sh *StandingOrderHandler) UpdateStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req StandingOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Products, payment method and frequency are required"})
+		return
+	}
+
+	var order models.StandingOrder
+	err := sh.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("business_id = ?", user.BusinessID).
+			First(&order, c.Param("id")).Error; err != nil {
+			return newSaleError(404, "Standing order not found")
+		}
+		if order.Status == models.StandingOrderEnded {
+			return newSaleError(409, "Standing order %s has ended", order.OrderNumber)
+		}
+		if err := applyStandingOrderRequest(tx, &order, req); err != nil {
+			return err
+		}
+		if err := tx.Where("standing_order_id = ?", order.ID).Delete(&models.StandingOrderItem{}).Error; err != nil {
+			return err
+		}
+		for i := range order.Items {
+			order.Items[i].StandingOrderID = order.ID
+		}
+		if err := tx.Create(&order.Items).Error; err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Save(&order).Error
+	})
+	if err != nil {
+		respondSaleError(c, err)
+		return
+	}
+
+	utils.InfoLogger("User %d updated standing order %s", user.ID, order.OrderNumber)
+	c.JSON(200, gin.H{"success": true, "data": order})
+}
+
+// GetStandingOrders lists standing orders, optionally for one status or
+// customer
+func (sh *StandingOrderHandler) GetStandingOrders(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	query := sh.db.Preload("Items").Preload("Customer").Where("business_id = ?", user.BusinessID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", strings.ToUpper(status))
+	}
+	if customerID := c.Query("customer_id"); customerID != "" {
+		query = query.Where("customer_id = ?", customerID)
+	}
+
+	var orders []models.StandingOrder
+	if err := query.Order("created_at DESC").Find(&orders).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch standing orders: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch standing orders"})
+		return
+	}
+
+	c.JSON(200, orders)
+}
+
+// GetStandingOrder returns a standing order with its most recent runs
+func (sh *StandingOrderHandler) GetStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	order, ok := sh.findStandingOrder(c, user.BusinessID)
+	if !ok {
+		return
+	}
+	if err := sh.db.Where("standing_order_id = ?", order.ID).
+		Order("due_date DESC").Limit(60).
+		Find(&order.Runs).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch runs of standing order %d: %v", order.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to fetch standing order"})
+		return
+	}
+
+	c.JSON(200, order)
+}
+
+// PauseStandingOrder stops a standing order's sales, until a given day or
+// until it is resumed
+func (sh *StandingOrderHandler) PauseStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req PauseStandingOrderRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	var until *time.Time
+	if req.Until != "" {
+		t, err := time.ParseInLocation(dateLayout, req.Until, time.Now().Location())
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid until date, expected YYYY-MM-DD"})
+			return
+		}
+		if t.Before(today()) {
+			c.JSON(400, gin.H{"error": "until must not be in the past"})
+			return
+		}
+		until = &t
+	}
+
+	order, ok := sh.findStandingOrder(c, user.BusinessID)
+	if !ok {
+		return
+	}
+	if order.Status == models.StandingOrderEnded {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Standing order %s has ended", order.OrderNumber)})
+		return
+	}
+	order.Status = models.StandingOrderPaused
+	order.PausedUntil = until
+	if err := sh.db.Model(order).Select("status", "paused_until").Updates(order).Error; err != nil {
+		utils.ErrorLogger("Failed to pause standing order %d: %v", order.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to pause standing order"})
+		return
+	}
+
+	utils.InfoLogger("User %d paused standing order %s", user.ID, order.OrderNumber)
+	c.JSON(200, gin.H{"success": true, "data": order})
+}
+
+// ResumeStandingOrder restarts a paused standing order from today
+func (sh *StandingOrderHandler) ResumeStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	order, ok := sh.findStandingOrder(c, user.BusinessID)
+	if !ok {
+		return
+	}
+	if order.Status != models.StandingOrderPaused {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Standing order %s is not paused", order.OrderNumber)})
+		return
+	}
+
+	// Days missed while paused are not made up
+	order.Status = models.StandingOrderActive
+	order.PausedUntil = nil
+	if order.NextRunDate != nil && order.NextRunDate.Before(today()) {
+		next, ok := standingRule(order).First(today())
+		if !ok {
+			order.Status = models.StandingOrderEnded
+			order.NextRunDate = nil
+		} else {
+			order.NextRunDate = &next
+		}
+	}
+	if err := sh.db.Model(order).Select("status", "paused_until", "next_run_date").Updates(order).Error; err != nil {
+		utils.ErrorLogger("Failed to resume standing order %d: %v", order.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to resume standing order"})
+		return
+	}
+
+	utils.InfoLogger("User %d resumed standing order %s", user.ID, order.OrderNumber)
+	c.JSON(200, gin.H{"success": true, "data": order})
+}
+
+// SkipStandingOrder skips one coming delivery date
+func (sh *StandingOrderHandler) SkipStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var req SkipStandingOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "A date is required"})
+		return
+	}
+	date, err := time.ParseInLocation(dateLayout, req.Date, time.Now().Location())
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	order, ok := sh.findStandingOrder(c, user.BusinessID)
+	if !ok {
+		return
+	}
+	if order.Status == models.StandingOrderEnded {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Standing order %s has ended", order.OrderNumber)})
+		return
+	}
+	if date.Before(today()) || !standingRule(order).Due(date) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Standing order %s has no coming delivery on %s", order.OrderNumber, req.Date)})
+		return
+	}
+
+	run := models.StandingOrderRun{
+		StandingOrderID: order.ID,
+		BusinessID:      order.BusinessID,
+		DueDate:         date,
+		Status:          models.StandingRunSkipped,
+		CreatedByID:     &user.ID,
+	}
+	result := sh.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+	if result.Error != nil {
+		utils.ErrorLogger("Failed to skip standing order %d: %v", order.ID, result.Error)
+		c.JSON(500, gin.H{"error": "Failed to skip delivery"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("The delivery on %s has already been made or skipped", req.Date)})
+		return
+	}
+
+	utils.InfoLogger("User %d skipped standing order %s on %s", user.ID, order.OrderNumber, req.Date)
+	c.JSON(200, gin.H{"success": true, "data": run})
+}
+
+// EndStandingOrder stops a standing order for good
+func (sh *StandingOrderHandler) EndStandingOrder(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	order, ok := sh.findStandingOrder(c, user.BusinessID)
+	if !ok {
+		return
+	}
+	if order.Status == models.StandingOrderEnded {
+		c.JSON(409, gin.H{"error": fmt.Sprintf("Standing order %s has already ended", order.OrderNumber)})
+		return
+	}
+	now := time.Now()
+	order.Status = models.StandingOrderEnded
+	order.NextRunDate = nil
+	order.PausedUntil = nil
+	order.EndedAt = &now
+	if err := sh.db.Model(order).Select("status", "next_run_date", "paused_until", "ended_at").Updates(order).Error; err != nil {
+		utils.ErrorLogger("Failed to end standing order %d: %v", order.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to end standing order"})
+		return
+	}
+
+	utils.InfoLogger("User %d ended standing order %s", user.ID, order.OrderNumber)
+	c.JSON(200, gin.H{"success": true, "data": order})
+}
+
+// RetryStandingOrderRun tries a failed delivery's sale again, for example
+// once stock has arrived
+func (sh *StandingOrderHandler) RetryStandingOrderRun(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	var run models.StandingOrderRun
+	if err := sh.db.Where("business_id = ?", user.BusinessID).First(&run, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Delivery not found"})
+		return
+	}
+	if run.Status != models.StandingRunFailed {
+		c.JSON(409, gin.H{"error": "Only failed deliveries can be tried again"})
+		return
+	}
+
+	result, err := runStandingOrder(sh.db, run.StandingOrderID, run.DueDate, &user.ID)
+	if errors.Is(err, errStandingOrderInactive) {
+		c.JSON(409, gin.H{"error": "The standing order is paused or has ended"})
+		return
+	}
+	if err != nil {
+		utils.ErrorLogger("Failed to retry standing order run %d: %v", run.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to retry delivery"})
+		return
+	}
+	if result.Status == models.StandingRunFailed {
+		c.JSON(400, gin.H{"error": result.Error, "data": result})
+		return
+	}
+
+	utils.InfoLogger("User %d retried standing order run %d", user.ID, run.ID)
+	c.JSON(200, gin.H{"success": true, "data": result})
+}
+
+type deliveryStop struct {
+	StandingOrderID uint                       `json:"standing_order_id"`
+	OrderNumber     string                     `json:"order_number"`
+	CustomerID      uint                       `json:"customer_id"`
+	CustomerName    string                     `json:"customer_name"`
+	CustomerPhone   string                     `json:"customer_phone"`
+	DeliveryAddress string                     `json:"delivery_address,omitempty"`
+	Notes           string                     `json:"notes,omitempty"`
+	PaymentMethod   string                     `json:"payment_method"`
+	Items           []models.StandingOrderItem `json:"items"`
+	Status          string                     `json:"status"`
+	RunID           *uint                      `json:"run_id,omitempty"`
+	SaleID          *uint                      `json:"sale_id,omitempty"`
+	SaleNumber      string                     `json:"sale_number,omitempty"`
+	TotalAmount     *float64                   `json:"total_amount,omitempty"`
+	Error           string                     `json:"error,omitempty"`
+}
+
+// GetDeliveryList lists the standing order deliveries due on a day, today
+// by default, with what has happened to each and the total of each product
+// to load
+func (sh *StandingOrderHandler) GetDeliveryList(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	date := today()
+	if v := c.Query("date"); v != "" {
+		t, err := time.ParseInLocation(dateLayout, v, time.Now().Location())
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		date = t
+	}
+
+	var runs []models.StandingOrderRun
+	if err := sh.db.Where("business_id = ? AND due_date = ?", user.BusinessID, date.Format(dateLayout)).
+		Find(&runs).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch standing order runs: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build delivery list"})
+		return
+	}
+	runFor := make(map[uint]models.StandingOrderRun, len(runs))
+	orderIDs := make([]uint, 0, len(runs))
+	for _, run := range runs {
+		runFor[run.StandingOrderID] = run
+		orderIDs = append(orderIDs, run.StandingOrderID)
+	}
+
+	var orders []models.StandingOrder
+	if err := sh.db.Preload("Items").Preload("Customer").
+		Where("business_id = ?", user.BusinessID).
+		Where("status <> ? OR id IN ?", models.StandingOrderEnded, append(orderIDs, 0)).
+		Find(&orders).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch standing orders: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build delivery list"})
+		return
+	}
+
+	saleIDs := make([]uint, 0, len(runs))
+	for _, run := range runs {
+		if run.SaleID != nil {
+			saleIDs = append(saleIDs, *run.SaleID)
+		}
+	}
+	var sales []models.Sale
+	if len(saleIDs) > 0 {
+		if err := sh.db.Select("id", "sale_number", "total_amount").Where("id IN ?", saleIDs).Find(&sales).Error; err != nil {
+			utils.ErrorLogger("Failed to fetch standing order sales: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to build delivery list"})
+			return
+		}
+	}
+	saleByID := make(map[uint]models.Sale, len(sales))
+	for _, sale := range sales {
+		saleByID[sale.ID] = sale
+	}
+
+	type loadLine struct {
+		ProductID   uint   `json:"product_id"`
+		ProductName string `json:"product_name"`
+		Quantity    int    `json:"quantity"`
+	}
+	load := make(map[uint]*loadLine)
+
+	stops := []deliveryStop{}
+	for i := range orders {
+		order := &orders[i]
+		run, ran := runFor[order.ID]
+		if !ran && !standingRule(order).Due(date) {
+			continue
+		}
+
+		stop := deliveryStop{
+			StandingOrderID: order.ID,
+			OrderNumber:     order.OrderNumber,
+			CustomerID:      order.CustomerID,
+			DeliveryAddress: order.DeliveryAddress,
+			Notes:           order.Notes,
+			PaymentMethod:   order.PaymentMethod,
+			Items:           order.Items,
+			Status:          deliveryPending,
+		}
+		if order.Customer != nil {
+			stop.CustomerName = order.Customer.Name
+			stop.CustomerPhone = order.Customer.Phone
+		}
+		switch {
+		case ran:
+			stop.Status = run.Status
+			stop.RunID = &run.ID
+			stop.SaleID = run.SaleID
+			stop.Error = run.Error
+			if run.SaleID != nil {
+				sale := saleByID[*run.SaleID]
+				stop.SaleNumber = sale.SaleNumber
+				stop.TotalAmount = &sale.TotalAmount
+			}
+		case pausedOn(order, date):
+			stop.Status = deliveryPaused
+		}
+		stops = append(stops, stop)
+
+		if stop.Status == models.StandingRunSkipped || stop.Status == deliveryPaused {
+			continue
+		}
+		for _, item := range order.Items {
+			if load[item.ProductID] == nil {
+				load[item.ProductID] = &loadLine{ProductID: item.ProductID, ProductName: item.ProductName}
+			}
+			load[item.ProductID].Quantity += item.Quantity
+		}
+	}
+
+	sort.Slice(stops, func(i, j int) bool {
+		if stops[i].DeliveryAddress != stops[j].DeliveryAddress {
+			return stops[i].DeliveryAddress < stops[j].DeliveryAddress
+		}
+		return stops[i].CustomerName < stops[j].CustomerName
+	})
+	loadSheet := make([]loadLine, 0, len(load))
+	for _, line := range load {
+		loadSheet = append(loadSheet, *line)
+	}
+	sort.Slice(loadSheet, func(i, j int) bool { return loadSheet[i].ProductName < loadSheet[j].ProductName })
+
+	c.JSON(200, gin.H{
+		"date":       date.Format(dateLayout),
+		"deliveries": stops,
+		"load_sheet": loadSheet,
+	})
+}
+
+// StandingOrderScheduler rings up standing order sales as they fall due
+type StandingOrderScheduler struct {
+	db       *gorm.DB
+	interval time.Duration
+}
+
+func NewStandingOrderScheduler(db *gorm.DB) *StandingOrderScheduler {
+	return &StandingOrderScheduler{db: db, interval: 10 * time.Minute}
+}
+
+// Run makes due standing order sales until the context is cancelled
+func (s *StandingOrderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.runDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *StandingOrderScheduler) runDue() {
+	day := today()
+	var orderIDs []uint
+	if err := s.db.Model(&models.StandingOrder{}).
+		Where("status IN ? AND next_run_date <= ?",
+			[]string{models.StandingOrderActive, models.StandingOrderPaused}, day.Format(dateLayout)).
+		Pluck("id", &orderIDs).Error; err != nil {
+		utils.ErrorLogger("Failed to find due standing orders: %v", err)
+		return
+	}
+
+	for _, id := range orderIDs {
+		if err := s.advance(id, day); err != nil {
+			utils.ErrorLogger("Failed to run standing order %d: %v", id, err)
+		}
+	}
+}
+
+// advance makes the order's sales for every due date up to day, including
+// any missed while the server was down, and moves it on to its next date.
+// Dates that fall in a pause are passed over.
+func (s *StandingOrderScheduler) advance(orderID uint, day time.Time) error {
+	for {
+		var order models.StandingOrder
+		if err := s.db.First(&order, orderID).Error; err != nil {
+			return err
+		}
+		if order.Status == models.StandingOrderEnded || order.NextRunDate == nil || order.NextRunDate.After(day) {
+			return nil
+		}
+		due := *order.NextRunDate
+
+		if !pausedOn(&order, due) {
+			run, err := runStandingOrder(s.db, order.ID, due, nil)
+			if err != nil && !errors.Is(err, errStandingOrderInactive) {
+				return err
+			}
+			if err == nil && run.Status == models.StandingRunFailed {
+				utils.WarningLogger("Standing order %s could not be sold for %s: %s", order.OrderNumber, due.Format(dateLayout), run.Error)
+			}
+		}
+
+		moved, err := s.moveOn(orderID, due)
+		if err != nil || !moved {
+			return err
+		}
+	}
+}
+
+// moveOn sets the order's next run date to the one after due, ending it when
+// the schedule runs out and lifting a pause that is over. The order is read
+// again under lock, so a pause, end or new schedule saved in the meantime is
+// kept; if the order is no longer due on that date it is left as it is.
+func (s *StandingOrderScheduler) moveOn(orderID uint, due time.Time) (bool, error) {
+	moved := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var order models.StandingOrder
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			return err
+		}
+		if order.Status == models.StandingOrderEnded || order.NextRunDate == nil ||
+			order.NextRunDate.Format(dateLayout) != due.Format(dateLayout) {
+			return nil
+		}
+
+		if order.Status == models.StandingOrderPaused && order.PausedUntil != nil && due.After(*order.PausedUntil) {
+			order.Status = models.StandingOrderActive
+			order.PausedUntil = nil
+		}
+		if next, ok := standingRule(&order).Next(due); ok {
+			order.NextRunDate = &next
+		} else {
+			now := time.Now()
+			order.NextRunDate = nil
+			order.Status = models.StandingOrderEnded
+			order.PausedUntil = nil
+			order.EndedAt = &now
+		}
+		moved = true
+		return tx.Model(&order).Select("next_run_date", "status", "paused_until", "ended_at").Updates(&order).Error
+	})
+	return moved, err
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type SupplierRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Phone        string `json:"phone"`
+	Email        string `json:"email"`
+	LeadTimeDays *int   `json:"lead_time_days"`
+}
+
+func (im *InventoryManagementHandler) CreateSupplier(c *gin.Context) {
+	var req SupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorLogger("Failed to parse supplier request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	supplier := models.Supplier{
+		Name:         req.Name,
+		Phone:        req.Phone,
+		Email:        req.Email,
+		LeadTimeDays: 7,
+	}
+	if req.LeadTimeDays != nil {
+		if *req.LeadTimeDays < 0 {
+			c.JSON(400, gin.H{"error": "Lead time must be non-negative"})
+			return
+		}
+		supplier.LeadTimeDays = *req.LeadTimeDays
+	}
+
+	if err := im.db.Create(&supplier).Error; err != nil {
+		utils.ErrorLogger("Failed to create supplier: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create supplier"})
+		return
+	}
+
+	utils.InfoLogger("Created supplier %s", supplier.Name)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    supplier,
+	})
+}
+
+func (im *InventoryManagementHandler) UpdateSupplier(c *gin.Context) {
+	id := c.Param("id")
+
+	var req SupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorLogger("Failed to parse supplier request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var supplier models.Supplier
+	if err := im.db.First(&supplier, id).Error; err != nil {
+		utils.WarningLogger("Supplier not found: %v", err)
+		c.JSON(404, gin.H{"error": "Supplier not found"})
+		return
+	}
+
+	supplier.Name = req.Name
+	supplier.Phone = req.Phone
+	supplier.Email = req.Email
+	if req.LeadTimeDays != nil {
+		if *req.LeadTimeDays < 0 {
+			c.JSON(400, gin.H{"error": "Lead time must be non-negative"})
+			return
+		}
+		supplier.LeadTimeDays = *req.LeadTimeDays
+	}
+
+	if err := im.db.Save(&supplier).Error; err != nil {
+		utils.ErrorLogger("Failed to update supplier %s: %v", id, err)
+		c.JSON(500, gin.H{"error": "Failed to update supplier"})
+		return
+	}
+
+	utils.InfoLogger("Successfully updated supplier %s", id)
+	c.JSON(200, supplier)
+}
+
+func (im *InventoryManagementHandler) GetAllSuppliers(c *gin.Context) {
+	var suppliers []models.Supplier
+	if err := im.db.Order("name").Find(&suppliers).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch suppliers: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to get suppliers"})
+		return
+	}
+
+	c.JSON(200, suppliers)
+}
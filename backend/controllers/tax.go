@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// taxRules is what a sale needs to know to charge VAT: whether the business
+// is registered, whether its prices include VAT and its tax classes.
+type taxRules struct {
+	registered     bool
+	pricesIncluded bool
+	classes        map[uint]models.TaxClass
+	standard       models.TaxClass
+}
+
+func loadTaxRules(tx *gorm.DB, businessID uint) (*taxRules, error) {
+	var business models.Business
+	if err := tx.First(&business, businessID).Error; err != nil {
+		return nil, err
+	}
+	rules := &taxRules{
+		registered:     business.VATRegistered,
+		pricesIncluded: business.PricesIncludeTax,
+		classes:        make(map[uint]models.TaxClass),
+		standard:       models.TaxClass{Code: models.TaxCodeStandard, Rate: models.StandardVATRate},
+	}
+
+	var classes []models.TaxClass
+	if err := tx.Where("business_id = ?", businessID).Find(&classes).Error; err != nil {
+		return nil, err
+	}
+	for _, class := range classes {
+		rules.classes[class.ID] = class
+		if class.Code == models.TaxCodeStandard {
+			rules.standard = class
+		}
+	}
+	return rules, nil
+}
+
+// apply works out the VAT on a priced and discounted item. Inclusive prices
+// have the VAT taken out of the line total; exclusive prices have it added.
+func (r *taxRules) apply(item *models.SaleItem, taxClassID *uint) {
+	item.TaxableAmount = item.LineTotal
+	if !r.registered {
+		return
+	}
+
+	class := r.standard
+	if taxClassID != nil {
+		if c, ok := r.classes[*taxClassID]; ok {
+			class = c
+		}
+	}
+	item.TaxCode = class.Code
+	if class.Exempt || class.Rate == 0 {
+		return
+	}
+	item.TaxRate = class.Rate
+
+	if r.pricesIncluded {
+		item.TaxAmount = roundMoney(item.LineTotal * class.Rate / (100 + class.Rate))
+		item.TaxableAmount = roundMoney(item.LineTotal - item.TaxAmount)
+		return
+	}
+	item.TaxAmount = roundMoney(item.LineTotal * class.Rate / 100)
+	item.LineTotal = roundMoney(item.LineTotal + item.TaxAmount)
+}
+
+type TaxClassRequest struct {
+	Code   string   `json:"code" binding:"required"`
+	Name   string   `json:"name" binding:"required"`
+	Rate   *float64 `json:"rate" binding:"required"`
+	Exempt bool     `json:"exempt"`
+}
+
+func (bh *BusinessHandler) GetTaxClasses(c *gin.Context) {
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch tax classes"})
+		return
+	}
+
+	var classes []models.TaxClass
+	if err := bh.db.Where("business_id = ?", businessID).Order("code").Find(&classes).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch tax classes: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch tax classes"})
+		return
+	}
+
+	c.JSON(200, classes)
+}
+
+func (bh *BusinessHandler) CreateTaxClass(c *gin.Context) {
+	var req TaxClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Code, name and rate are required"})
+		return
+	}
+	if *req.Rate < 0 || *req.Rate > 100 {
+		c.JSON(400, gin.H{"error": "Rate must be between 0 and 100"})
+		return
+	}
+	if req.Exempt && *req.Rate != 0 {
+		c.JSON(400, gin.H{"error": "Exempt tax classes must have a rate of 0"})
+		return
+	}
+
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create tax class"})
+		return
+	}
+
+	class := models.TaxClass{
+		BusinessID: businessID,
+		Code:       strings.ToUpper(strings.TrimSpace(req.Code)),
+		Name:       req.Name,
+		Rate:       *req.Rate,
+		Exempt:     req.Exempt,
+	}
+	var existing int64
+	if err := bh.db.Model(&models.TaxClass{}).Where("business_id = ? AND code = ?", businessID, class.Code).Count(&existing).Error; err != nil {
+		utils.ErrorLogger("Failed to check tax class %s: %v", class.Code, err)
+		c.JSON(500, gin.H{"error": "Failed to create tax class"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(409, gin.H{"error": "A tax class with this code already exists"})
+		return
+	}
+
+	if err := bh.db.Create(&class).Error; err != nil {
+		utils.ErrorLogger("Failed to create tax class: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create tax class"})
+		return
+	}
+
+	utils.InfoLogger("Created tax class %s at %.2f%%", class.Code, class.Rate)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    class,
+	})
+}
+
+// UpdateTaxClass changes a tax class's name or rate. Sales already made keep
+// the rate they were charged at.
+func (bh *BusinessHandler) UpdateTaxClass(c *gin.Context) {
+	businessID, err := businessIDFor(c, bh.db)
+	if err != nil {
+		utils.ErrorLogger("Failed to resolve business: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to update tax class"})
+		return
+	}
+
+	var class models.TaxClass
+	if err := bh.db.Where("business_id = ?", businessID).First(&class, c.Param("id")).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Tax class not found"})
+		return
+	}
+
+	var input map[string]interface{}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if name, ok := input["name"].(string); ok && strings.TrimSpace(name) != "" {
+		class.Name = name
+	}
+	if rate, ok := input["rate"].(float64); ok {
+		if rate < 0 || rate > 100 {
+			c.JSON(400, gin.H{"error": "Rate must be between 0 and 100"})
+			return
+		}
+		class.Rate = rate
+	}
+	if class.Exempt && class.Rate != 0 {
+		c.JSON(400, gin.H{"error": "Exempt tax classes must have a rate of 0"})
+		return
+	}
+
+	if err := bh.db.Save(&class).Error; err != nil {
+		utils.ErrorLogger("Failed to update tax class %d: %v", class.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to update tax class"})
+		return
+	}
+
+	utils.InfoLogger("Updated tax class %s", class.Code)
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    class,
+	})
+}
+
+type vatLine struct {
+	TaxCode       string  `json:"tax_code"`
+	Name          string  `json:"name"`
+	Rate          float64 `json:"rate"`
+	Exempt        bool    `json:"exempt"`
+	TaxableAmount float64 `json:"taxable_amount"`
+	TaxAmount     float64 `json:"tax_amount"`
+	GrossAmount   float64 `json:"gross_amount"`
+}
+
+// GetVATReport summarises output VAT by tax rate for each day, week or month
+// in a date range, ready for the VAT return. Returned goods are taken off
+// the period the sale was made in.
+func (im *SalesManagementHandler) GetVATReport(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	period := c.DefaultQuery("period", "month")
+	if !validPeriod(period) {
+		c.JSON(400, gin.H{"error": "period must be day, week or month"})
+		return
+	}
+	from, to, err := parseDateRange(c, 30)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var classes []models.TaxClass
+	if err := im.db.Where("business_id = ?", user.BusinessID).Find(&classes).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch tax classes: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build VAT report"})
+		return
+	}
+	classByCode := make(map[string]models.TaxClass, len(classes))
+	for _, class := range classes {
+		classByCode[class.Code] = class
+	}
+
+	var rows []struct {
+		TaxCode          string
+		TaxRate          float64
+		TaxableAmount    float64
+		TaxAmount        float64
+		LineTotal        float64
+		Quantity         int
+		ReturnedQuantity int
+		CreatedAt        time.Time
+	}
+	if err := im.db.Table("sale_items").
+		Select("sale_items.tax_code, sale_items.tax_rate, sale_items.taxable_amount, sale_items.tax_amount, sale_items.line_total, sale_items.quantity, sale_items.returned_quantity, sales.created_at").
+		Joins("JOIN sales ON sales.id = sale_items.sale_id").
+		Where("sales.business_id = ? AND sales.status <> ?", user.BusinessID, models.SaleStatusVoided).
+		Where("sales.created_at >= ? AND sales.created_at < ?", from, to).
+		Scan(&rows).Error; err != nil {
+		utils.ErrorLogger("Failed to build VAT report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build VAT report"})
+		return
+	}
+
+	type periodTotals struct {
+		Period string     `json:"period"`
+		Rates  []*vatLine `json:"rates"`
+		byKey  map[string]*vatLine
+	}
+	periods := make(map[string]*periodTotals)
+	summary := make(map[string]*vatLine)
+
+	add := func(lines map[string]*vatLine, code string, rate float64) *vatLine {
+		key := code + "|" + strconv.FormatFloat(rate, 'f', -1, 64)
+		line, ok := lines[key]
+		if !ok {
+			class := classByCode[code]
+			line = &vatLine{TaxCode: code, Name: class.Name, Rate: rate, Exempt: class.Exempt}
+			if code == "" {
+				line.Name = "Not subject to VAT"
+			}
+			lines[key] = line
+		}
+		return line
+	}
+
+	for _, row := range rows {
+		if row.Quantity <= 0 || row.ReturnedQuantity >= row.Quantity {
+			continue
+		}
+		share := float64(row.Quantity-row.ReturnedQuantity) / float64(row.Quantity)
+
+		key := periodKey(row.CreatedAt, period)
+		p, ok := periods[key]
+		if !ok {
+			p = &periodTotals{Period: key, byKey: make(map[string]*vatLine)}
+			periods[key] = p
+		}
+		for _, line := range []*vatLine{add(p.byKey, row.TaxCode, row.TaxRate), add(summary, row.TaxCode, row.TaxRate)} {
+			line.TaxableAmount += row.TaxableAmount * share
+			line.TaxAmount += row.TaxAmount * share
+			line.GrossAmount += row.LineTotal * share
+		}
+	}
+
+	sortLines := func(lines map[string]*vatLine) []*vatLine {
+		sorted := make([]*vatLine, 0, len(lines))
+		for _, line := range lines {
+			line.TaxableAmount = roundMoney(line.TaxableAmount)
+			line.TaxAmount = roundMoney(line.TaxAmount)
+			line.GrossAmount = roundMoney(line.GrossAmount)
+			sorted = append(sorted, line)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].TaxCode != sorted[j].TaxCode {
+				return sorted[i].TaxCode < sorted[j].TaxCode
+			}
+			return sorted[i].Rate < sorted[j].Rate
+		})
+		return sorted
+	}
+
+	result := make([]*periodTotals, 0, len(periods))
+	for _, p := range periods {
+		p.Rates = sortLines(p.byKey)
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Period < result[j].Period })
+
+	var outputTax, taxableSales, exemptSales float64
+	rates := sortLines(summary)
+	for _, line := range rates {
+		outputTax += line.TaxAmount
+		if line.Exempt || line.TaxCode == "" {
+			exemptSales += line.GrossAmount
+		} else {
+			taxableSales += line.TaxableAmount
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"from":          from.Format(dateLayout),
+		"to":            to.AddDate(0, 0, -1).Format(dateLayout),
+		"period":        period,
+		"output_tax":    roundMoney(outputTax),
+		"taxable_sales": roundMoney(taxableSales),
+		"exempt_sales":  roundMoney(exemptSales),
+		"rates":         rates,
+		"periods":       result,
+	})
+}
+
+// taxClassExists reports whether the tax class is one of the business's own
+func taxClassExists(db *gorm.DB, businessID, taxClassID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.TaxClass{}).Where("id = ? AND business_id = ?", taxClassID, businessID).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+)
+
+func TestTaxRulesApply(t *testing.T) {
+	exempt, zeroRated, reduced, unknown := uint(1), uint(2), uint(3), uint(99)
+	classes := map[uint]models.TaxClass{
+		exempt:    {ID: exempt, Code: models.TaxCodeExempt, Exempt: true},
+		zeroRated: {ID: zeroRated, Code: models.TaxCodeZeroRated},
+		reduced:   {ID: reduced, Code: "E", Rate: 8},
+	}
+	standard := models.TaxClass{Code: models.TaxCodeStandard, Rate: models.StandardVATRate}
+	inclusive := &taxRules{registered: true, pricesIncluded: true, classes: classes, standard: standard}
+	exclusive := &taxRules{registered: true, classes: classes, standard: standard}
+
+	tests := []struct {
+		name        string
+		rules       *taxRules
+		lineTotal   float64
+		class       *uint
+		wantCode    string
+		wantTaxable float64
+		wantTax     float64
+		wantTotal   float64
+	}{
+		{"not registered", &taxRules{}, 116, nil, "", 116, 0, 116},
+		{"inclusive", inclusive, 116, nil, models.TaxCodeStandard, 100, 16, 116},
+		{"inclusive rounds to the cent", inclusive, 99.99, nil, models.TaxCodeStandard, 86.2, 13.79, 99.99},
+		{"exclusive", exclusive, 100, nil, models.TaxCodeStandard, 100, 16, 116},
+		{"exclusive rounds to the cent", exclusive, 10.03, nil, models.TaxCodeStandard, 10.03, 1.6, 11.63},
+		{"exempt", inclusive, 50, &exempt, models.TaxCodeExempt, 50, 0, 50},
+		{"zero-rated", exclusive, 50, &zeroRated, models.TaxCodeZeroRated, 50, 0, 50},
+		{"own rate", inclusive, 108, &reduced, "E", 100, 8, 108},
+		{"unknown class charges the standard rate", exclusive, 50, &unknown, models.TaxCodeStandard, 50, 8, 58},
+	}
+	for _, tt := range tests {
+		item := models.SaleItem{LineTotal: tt.lineTotal}
+		tt.rules.apply(&item, tt.class)
+		if item.TaxCode != tt.wantCode || item.TaxableAmount != tt.wantTaxable || item.TaxAmount != tt.wantTax || item.LineTotal != tt.wantTotal {
+			t.Errorf("%s: code %q taxable %.2f tax %.2f total %.2f, want %q %.2f %.2f %.2f", tt.name,
+				item.TaxCode, item.TaxableAmount, item.TaxAmount, item.LineTotal,
+				tt.wantCode, tt.wantTaxable, tt.wantTax, tt.wantTotal)
+		}
+	}
+}
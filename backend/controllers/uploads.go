@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidImageType = errors.New("invalid file type")
+
+// saveUploadedImage stores the optional image sent in the given form field
+// under uploads/<dir> and returns its URL path, or "" when none was sent.
+func saveUploadedImage(c *gin.Context, field, dir string) (string, error) {
+	file, header, err := c.Request.FormFile(field)
+	if err == http.ErrMissingFile {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if !isAllowedImageType(file) {
+		return "", errInvalidImageType
+	}
+
+	uploadsDir := filepath.Join("uploads", dir)
+	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+		return "", err
+	}
+
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(header.Filename))
+	dst, err := os.Create(filepath.Join(uploadsDir, filename))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, file); err != nil {
+		return "", err
+	}
+
+	return "/uploads/" + dir + "/" + filename, nil
+}
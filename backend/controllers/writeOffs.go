@@ -0,0 +1,418 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/events"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errInsufficientStock = errors.New("insufficient stock")
+
+var writeOffReasons = map[string]bool{
+	models.WriteOffExpired:     true,
+	models.WriteOffDamaged:     true,
+	models.WriteOffTheft:       true,
+	models.WriteOffInternalUse: true,
+}
+
+type WriteOffHandler struct {
+	db *gorm.DB
+	// approvalLimit is the highest value, at cost, that a cashier or
+	// supervisor can write off of one product in a day without a manager
+	// approving it.
+	approvalLimit float64
+}
+
+func NewWriteOffHandler(db *gorm.DB, approvalLimit float64) *WriteOffHandler {
+	return &WriteOffHandler{db: db, approvalLimit: approvalLimit}
+}
+
+// RecordWriteOff records expired, damaged, stolen or internally used stock.
+// It accepts multipart form data so a photo can be attached as evidence.
+func (wh *WriteOffHandler) RecordWriteOff(c *gin.Context) {
+	user, _ := middleware.CurrentUser(c)
+
+	productID, err := strconv.ParseUint(c.PostForm("product_id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product id"})
+		return
+	}
+	quantity, err := strconv.Atoi(c.PostForm("quantity"))
+	if err != nil || quantity <= 0 {
+		c.JSON(400, gin.H{"error": "Quantity must be a positive whole number"})
+		return
+	}
+	reason := strings.ToUpper(c.PostForm("reason"))
+	if !writeOffReasons[reason] {
+		c.JSON(400, gin.H{"error": "Reason must be one of EXPIRED, DAMAGED, THEFT or INTERNAL_USE"})
+		return
+	}
+
+	var product models.Product
+	if err := wh.db.First(&product, productID).Error; err != nil {
+		utils.WarningLogger("Product not found: %v", err)
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+
+	photoPath, err := saveUploadedImage(c, "image", "write-offs")
+	if err != nil {
+		utils.ErrorLogger("Failed to save write-off photo: %v", err)
+		if errors.Is(err, errInvalidImageType) {
+			c.JSON(400, gin.H{"error": "Invalid file type. Only JPEG, PNG and GIF are allowed"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	writeOff := models.StockWriteOff{
+		ProductID:    product.ID,
+		Quantity:     quantity,
+		Reason:       reason,
+		Note:         c.PostForm("note"),
+		PhotoPath:    photoPath,
+		UnitCost:     product.CostPrice,
+		TotalCost:    product.CostPrice * float64(quantity),
+		Status:       models.WriteOffPending,
+		RecordedByID: user.ID,
+	}
+
+	ctx, outbox := events.WithOutbox(context.Background(), user.BusinessID)
+	tx := wh.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
+		c.JSON(500, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Create(&writeOff).Error; err != nil {
+		tx.Rollback()
+		utils.ErrorLogger("Failed to create write-off: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to record write-off"})
+		return
+	}
+
+	// Lock the stock first so two write-offs by the same user for the same
+	// product are totalled one after the other
+	var inventory models.Inventory
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ?", product.ID).
+		First(&inventory).Error; err != nil {
+		tx.Rollback()
+		utils.WarningLogger("Inventory not found for product %d: %v", product.ID, err)
+		c.JSON(404, gin.H{"error": "Product not found in inventory"})
+		return
+	}
+	dailyCost, err := dailyWriteOffCost(tx, user.ID, product.ID, time.Now())
+	if err != nil {
+		tx.Rollback()
+		utils.ErrorLogger("Failed to total write-offs for user %d: %v", user.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to record write-off"})
+		return
+	}
+
+	// Small write-offs, and any recorded by a manager, take effect straight
+	// away. The limit covers everything the user has written off of the
+	// product today, so a loss cannot be split into several small records.
+	if dailyCost <= wh.approvalLimit || user.HasRole(models.RoleManager) {
+		if user.HasRole(models.RoleManager) {
+			writeOff.ReviewedByID = &user.ID
+		}
+		if err := applyWriteOff(tx, &writeOff, product.Name); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errInsufficientStock) {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Insufficient stock for product %d", product.ID)})
+				return
+			}
+			utils.ErrorLogger("Failed to apply write-off %d: %v", writeOff.ID, err)
+			c.JSON(500, gin.H{"error": "Failed to record write-off"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit transaction: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to record write-off"})
+		return
+	}
+	outbox.Flush()
+
+	utils.InfoLogger("Recorded %s write-off %d for product %d (%s)", reason, writeOff.ID, product.ID, writeOff.Status)
+	c.JSON(200, gin.H{
+		"success":           true,
+		"data":              writeOff,
+		"requires_approval": writeOff.Status == models.WriteOffPending,
+	})
+}
+
+func (wh *WriteOffHandler) ApproveWriteOff(c *gin.Context) {
+	wh.reviewWriteOff(c, true)
+}
+
+func (wh *WriteOffHandler) RejectWriteOff(c *gin.Context) {
+	wh.reviewWriteOff(c, false)
+}
+
+func (wh *WriteOffHandler) reviewWriteOff(c *gin.Context, approve bool) {
+	id := c.Param("id")
+	user, _ := middleware.CurrentUser(c)
+
+	var input struct {
+		Note string `json:"note"`
+	}
+	// The review note is optional, so an empty body is fine
+	_ = c.ShouldBindJSON(&input)
+
+	ctx, outbox := events.WithOutbox(context.Background(), user.BusinessID)
+	tx := wh.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		utils.ErrorLogger("Failed to start transaction: %v", tx.Error)
+		c.JSON(500, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Lock the write-off so two reviewers cannot both approve it
+	var writeOff models.StockWriteOff
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Product").
+		First(&writeOff, id).Error; err != nil {
+		tx.Rollback()
+		utils.WarningLogger("Write-off not found: %v", err)
+		c.JSON(404, gin.H{"error": "Write-off not found"})
+		return
+	}
+	if writeOff.Status != models.WriteOffPending {
+		tx.Rollback()
+		c.JSON(400, gin.H{"error": "Write-off has already been reviewed"})
+		return
+	}
+
+	writeOff.ReviewedByID = &user.ID
+	writeOff.ReviewNote = input.Note
+
+	if approve {
+		if err := applyWriteOff(tx, &writeOff, writeOff.Product.Name); err != nil {
+			tx.Rollback()
+			if errors.Is(err, errInsufficientStock) {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Insufficient stock for product %d", writeOff.ProductID)})
+				return
+			}
+			utils.ErrorLogger("Failed to apply write-off %s: %v", id, err)
+			c.JSON(500, gin.H{"error": "Failed to approve write-off"})
+			return
+		}
+	} else {
+		now := time.Now()
+		writeOff.Status = models.WriteOffRejected
+		writeOff.ReviewedAt = &now
+		if err := tx.Omit(clause.Associations).Save(&writeOff).Error; err != nil {
+			tx.Rollback()
+			utils.ErrorLogger("Failed to reject write-off %s: %v", id, err)
+			c.JSON(500, gin.H{"error": "Failed to reject write-off"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		utils.ErrorLogger("Failed to commit transaction: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to review write-off"})
+		return
+	}
+	outbox.Flush()
+
+	utils.InfoLogger("Write-off %s %s by user %d", id, strings.ToLower(writeOff.Status), user.ID)
+	c.JSON(200, writeOff)
+}
+
+// applyWriteOff deducts written-off stock, records the WRITE_OFF movement and
+// marks the write-off approved.
+func applyWriteOff(tx *gorm.DB, writeOff *models.StockWriteOff, productName string) error {
+	var inventory models.Inventory
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("product_id = ?", writeOff.ProductID).
+		First(&inventory).Error; err != nil {
+		return err
+	}
+	if inventory.Quantity < writeOff.Quantity {
+		return errInsufficientStock
+	}
+
+	inventory.Quantity -= writeOff.Quantity
+	inventory.LastUpdated = time.Now()
+	if err := tx.Save(&inventory).Error; err != nil {
+		return err
+	}
+
+	movement := models.StockMovement{
+		ProductID:      writeOff.ProductID,
+		ChangeType:     models.MovementWriteOff,
+		QuantityChange: -writeOff.Quantity,
+		Note:           fmt.Sprintf("Write-off #%d: %s", writeOff.ID, writeOff.Reason),
+		CreatedAt:      time.Now(),
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return err
+	}
+	queueStockChanged(tx, productName, inventory)
+
+	now := time.Now()
+	writeOff.Status = models.WriteOffApproved
+	writeOff.ReviewedAt = &now
+	writeOff.StockMovementID = &movement.ID
+	if err := tx.Omit(clause.Associations).Save(writeOff).Error; err != nil {
+		return err
+	}
+
+	if err := syncLowStockAlert(tx, productName, inventory); err != nil {
+		utils.ErrorLogger("Failed to update low stock alert for product %d: %v", writeOff.ProductID, err)
+	}
+	return nil
+}
+
+// dailyWriteOffCost totals, at cost, the pending and approved write-offs the
+// user has recorded for the product on the day of at, including any just
+// created in the transaction.
+func dailyWriteOffCost(tx *gorm.DB, userID, productID uint, at time.Time) (float64, error) {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	var total float64
+	err := tx.Model(&models.StockWriteOff{}).
+		Where("recorded_by_id = ? AND product_id = ? AND status <> ?", userID, productID, models.WriteOffRejected).
+		Where("created_at >= ? AND created_at < ?", day, day.AddDate(0, 0, 1)).
+		Select("COALESCE(SUM(total_cost), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+func (wh *WriteOffHandler) GetWriteOffs(c *gin.Context) {
+	var writeOffs []struct {
+		models.StockWriteOff
+		ProductName string `json:"product_name"`
+	}
+
+	query := wh.db.Table("stock_write_offs").
+		Select("stock_write_offs.*, products.name as product_name").
+		Joins("JOIN products ON stock_write_offs.product_id = products.id").
+		Order("stock_write_offs.created_at DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("stock_write_offs.status = ?", strings.ToUpper(status))
+	}
+
+	if err := query.Find(&writeOffs).Error; err != nil {
+		utils.ErrorLogger("Failed to fetch write-offs: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to fetch write-offs"})
+		return
+	}
+
+	c.JSON(200, writeOffs)
+}
+
+// GetShrinkageReport totals approved write-offs at cost by reason, product
+// and period for the requested date range. Write-offs count from when they
+// were approved, since stock only leaves the books then.
+func (wh *WriteOffHandler) GetShrinkageReport(c *gin.Context) {
+	from, to, err := parseDateRange(c, 30)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	period := c.DefaultQuery("period", "month")
+	if !validPeriod(period) {
+		c.JSON(400, gin.H{"error": "Period must be day, week or month"})
+		return
+	}
+
+	var writeOffs []struct {
+		ProductID   uint
+		ProductName string
+		Quantity    int
+		Reason      string
+		TotalCost   float64
+		ReviewedAt  time.Time
+	}
+	if err := wh.db.Table("stock_write_offs").
+		Select("stock_write_offs.product_id, products.name as product_name, stock_write_offs.quantity, "+
+			"stock_write_offs.reason, stock_write_offs.total_cost, stock_write_offs.reviewed_at").
+		Joins("JOIN products ON stock_write_offs.product_id = products.id").
+		Where("stock_write_offs.status = ? AND stock_write_offs.reviewed_at >= ? AND stock_write_offs.reviewed_at < ?",
+			models.WriteOffApproved, from, to).
+		Scan(&writeOffs).Error; err != nil {
+		utils.ErrorLogger("Failed to build shrinkage report: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to build shrinkage report"})
+		return
+	}
+
+	type line struct {
+		Key       string  `json:"key"`
+		Label     string  `json:"label,omitempty"`
+		Quantity  int     `json:"quantity"`
+		TotalCost float64 `json:"total_cost"`
+	}
+	byReason := map[string]*line{}
+	byProduct := map[string]*line{}
+	byPeriod := map[string]*line{}
+	add := func(groups map[string]*line, key, label string, quantity int, cost float64) {
+		if groups[key] == nil {
+			groups[key] = &line{Key: key, Label: label}
+		}
+		groups[key].Quantity += quantity
+		groups[key].TotalCost += cost
+	}
+
+	var totalCost float64
+	var totalQuantity int
+	for _, w := range writeOffs {
+		add(byReason, w.Reason, "", w.Quantity, w.TotalCost)
+		add(byProduct, strconv.FormatUint(uint64(w.ProductID), 10), w.ProductName, w.Quantity, w.TotalCost)
+		add(byPeriod, periodKey(w.ReviewedAt, period), "", w.Quantity, w.TotalCost)
+		totalCost += w.TotalCost
+		totalQuantity += w.Quantity
+	}
+
+	flatten := func(groups map[string]*line, byKey bool) []line {
+		lines := make([]line, 0, len(groups))
+		for _, l := range groups {
+			lines = append(lines, *l)
+		}
+		sort.Slice(lines, func(i, j int) bool {
+			if byKey {
+				return lines[i].Key < lines[j].Key
+			}
+			return lines[i].TotalCost > lines[j].TotalCost
+		})
+		return lines
+	}
+
+	c.JSON(200, gin.H{
+		"from":           from.Format(dateLayout),
+		"to":             to.AddDate(0, 0, -1).Format(dateLayout),
+		"period":         period,
+		"total_quantity": totalQuantity,
+		"total_cost":     totalCost,
+		"by_reason":      flatten(byReason, false),
+		"by_product":     flatten(byProduct, false),
+		"by_period":      flatten(byPeriod, true),
+	})
+}
@@ -1,20 +1,451 @@
 package database
 
-import "github.com/OAthooh/BiasharaTrack.git/models"
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/mpesa"
+	"gorm.io/gorm"
+)
 
 func (d *DB) Migrate() error {
 	err := d.DB.AutoMigrate(
+		&models.Business{},
 		&models.User{},
+		&models.StreamTicket{},
+		&models.TaxClass{},
+		&models.Supplier{},
 		&models.Product{},
 		&models.Inventory{},
 		&models.StockMovement{},
 		&models.LowStockAlert{},
 		&models.Category{},
+		&models.Customer{},
+		&models.LoyaltyRule{},
+		&models.LoyaltyEntry{},
 		&models.CreditTransaction{},
 		&models.SalesTransaction{},
+		&models.Sale{},
+		&models.SaleItem{},
+		&models.SalePayment{},
+		&models.MpesaTransaction{},
+		&models.ReceiptPrint{},
+		&models.Shift{},
+		&models.CashMovement{},
+		&models.ParkedSale{},
+		&models.ParkedSaleItem{},
+		&models.Quotation{},
+		&models.QuotationItem{},
+		&models.Layaway{},
+		&models.LayawayItem{},
+		&models.LayawayPayment{},
+		&models.StandingOrder{},
+		&models.StandingOrderItem{},
+		&models.StandingOrderRun{},
+		&models.Promotion{},
+		&models.SaleReturn{},
+		&models.SaleReturnItem{},
+		&models.CreditNote{},
+		&models.DiscountLimit{},
+		&models.EtimsInvoice{},
+		&models.EtimsInvoiceCounter{},
+		&models.StockWriteOff{},
+		&models.SearchSynonym{},
 	)
 	if err != nil {
 		return err
 	}
+	if err := d.ensureBusiness(); err != nil {
+		return err
+	}
+	if err := d.ensureOwner(); err != nil {
+		return err
+	}
+	if err := d.ensureTaxClasses(); err != nil {
+		return err
+	}
+	if err := d.migrateLegacySales(); err != nil {
+		return err
+	}
+	if err := d.settleLegacyCredit(); err != nil {
+		return err
+	}
+	if err := d.backfillGrossAmounts(); err != nil {
+		return err
+	}
+	if err := d.backfillUnitCosts(); err != nil {
+		return err
+	}
+	if err := d.linkCustomers(); err != nil {
+		return err
+	}
+	if err := d.seedEtimsCounters(); err != nil {
+		return err
+	}
+	// Payments without a receipt used to store it as '', which the unique
+	// index allows only once
+	if err := d.DB.Model(&models.MpesaTransaction{}).Where("receipt_number = ''").
+		Update("receipt_number", nil).Error; err != nil {
+		return err
+	}
+	return d.dedupeLowStockAlerts()
+}
+
+// backfillGrossAmounts fills in the gross amount of sale items recorded
+// before discounts existed, when the gross and net amounts were the same.
+func (d *DB) backfillGrossAmounts() error {
+	return d.DB.Model(&models.SaleItem{}).
+		Where("gross_amount = 0 AND discount = 0 AND basket_discount = 0 AND line_total <> 0").
+		Update("gross_amount", gorm.Expr("line_total")).Error
+}
+
+// backfillUnitCosts gives sale items recorded before costs were kept the
+// product's current cost price, the best estimate there is of what they cost.
+func (d *DB) backfillUnitCosts() error {
+	return d.DB.Exec("UPDATE sale_items LEFT JOIN products ON products.id = sale_items.product_id " +
+		"SET sale_items.unit_cost = COALESCE(products.cost_price, 0) WHERE sale_items.unit_cost IS NULL").Error
+}
+
+// seedEtimsCounters starts each branch's eTIMS invoice counter after the
+// highest number it has already sent, from when invoices were numbered by
+// sale. Branches that already have a counter are left alone.
+func (d *DB) seedEtimsCounters() error {
+	var rows []models.EtimsInvoiceCounter
+	if err := d.DB.Model(&models.EtimsInvoice{}).
+		Select("business_id, branch_id, MAX(invoice_number) AS last_number").
+		Group("business_id, branch_id").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		counter := models.EtimsInvoiceCounter{BusinessID: row.BusinessID, BranchID: row.BranchID}
+		if err := d.DB.Where(counter).Attrs(models.EtimsInvoiceCounter{LastNumber: row.LastNumber}).
+			FirstOrCreate(&counter).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkCustomers builds the customer directory from the names and phone
+// numbers typed in on sales and credit before it existed. Numbers written
+// different ways become one customer; those that are not valid Kenyan
+// numbers are left unlinked. Only unlinked rows are looked at, so running
+// it again is a no-op.
+func (d *DB) linkCustomers() error {
+	var rows []struct {
+		BusinessID    uint
+		CustomerPhone string
+		CustomerName  string
+	}
+	if err := d.DB.Table("sales").
+		Select("business_id, customer_phone, MAX(customer_name) AS customer_name").
+		Where("customer_id IS NULL AND customer_phone <> ''").
+		Group("business_id, customer_phone").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		phone, err := mpesa.NormalizePhoneNumber(row.CustomerPhone)
+		if err != nil {
+			continue
+		}
+		customer := models.Customer{BusinessID: row.BusinessID, Phone: phone}
+		if err := d.DB.Where(customer).Attrs(models.Customer{Name: row.CustomerName}).
+			FirstOrCreate(&customer).Error; err != nil {
+			return err
+		}
+		for customer.MergedIntoID != nil {
+			var into models.Customer
+			if err := d.DB.First(&into, *customer.MergedIntoID).Error; err != nil {
+				return err
+			}
+			customer = into
+		}
+		if err := d.DB.Model(&models.Sale{}).
+			Where("business_id = ? AND customer_phone = ? AND customer_id IS NULL", row.BusinessID, row.CustomerPhone).
+			Update("customer_id", customer.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	// Credit follows the customer of the sale it came from
+	return d.DB.Exec("UPDATE credit_transactions JOIN sales ON sales.id = credit_transactions.sale_id " +
+		"SET credit_transactions.customer_id = sales.customer_id " +
+		"WHERE credit_transactions.customer_id IS NULL AND sales.customer_id IS NOT NULL").Error
+}
+
+// migrateLegacySales turns each old SalesTransaction row into a sale with a
+// single item. Migrated rows are remembered on the item, so rows already
+// copied are skipped on later runs. Credit sales start out unpaid;
+// settleLegacyCredit then takes what was paid from their credit records.
+func (d *DB) migrateLegacySales() error {
+	businessID, err := d.firstBusinessID()
+	if err != nil {
+		return err
+	}
+
+	var legacy []struct {
+		models.SalesTransaction
+		ProductName string
+	}
+	if err := d.DB.Table("sales_transactions").
+		Select("sales_transactions.*, products.name as product_name").
+		Joins("LEFT JOIN products ON products.id = sales_transactions.product_id").
+		Where("NOT EXISTS (SELECT 1 FROM sale_items WHERE sale_items.legacy_transaction_id = sales_transactions.id)").
+		Order("sales_transactions.id").
+		Scan(&legacy).Error; err != nil {
+		return err
+	}
+
+	for _, row := range legacy {
+		legacyID := row.ID
+		paymentStatus := models.PaymentStatusPaid
+		amountPaid := row.TotalAmount
+		if row.PaymentMethod == "CREDIT" {
+			paymentStatus = models.PaymentStatusUnpaid
+			amountPaid = 0
+		}
+
+		var unitPrice float64
+		if row.Quantity != 0 {
+			unitPrice = row.TotalAmount / float64(row.Quantity)
+		}
+
+		sale := models.Sale{
+			SaleNumber:      fmt.Sprintf("LEGACY-%06d", row.ID),
+			BusinessID:      businessID,
+			CustomerName:    row.CustomerName,
+			CustomerPhone:   row.CustomerPhone,
+			PaymentMethod:   row.PaymentMethod,
+			ReferenceNumber: row.ReferenceNumber,
+			Subtotal:        row.TotalAmount,
+			TotalAmount:     row.TotalAmount,
+			AmountPaid:      amountPaid,
+			BalanceDue:      row.TotalAmount - amountPaid,
+			PaymentStatus:   paymentStatus,
+			Status:          models.SaleStatusCompleted,
+			CreatedAt:       row.CreatedAt,
+			UpdatedAt:       row.UpdatedAt,
+			Items: []models.SaleItem{{
+				ProductID:           row.ProductID,
+				ProductName:         row.ProductName,
+				Quantity:            row.Quantity,
+				ListPrice:           unitPrice,
+				GrossAmount:         row.TotalAmount,
+				UnitPrice:           unitPrice,
+				LineTotal:           row.TotalAmount,
+				LegacyTransactionID: &legacyID,
+				CreatedAt:           row.CreatedAt,
+			}},
+		}
+		if err := d.DB.Create(&sale).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyCreditWindow is how far apart the old till could record a credit
+// sale and its credit transaction, which were written in one transaction
+const legacyCreditWindow = 5 * time.Second
+
+// settleLegacyCredit links each migrated credit sale to the credit
+// transaction the old till recorded with it, and takes the deposit paid
+// from there. The old till kept one remaining balance for the whole basket
+// on every line's credit transaction, so it is shared over the basket's
+// lines by value. Only migrated sales that are still unpaid and unlinked
+// are looked at, so running it again is a no-op.
+func (d *DB) settleLegacyCredit() error {
+	var sales []struct {
+		ID           uint
+		CustomerName string
+		TotalAmount  float64
+		CreatedAt    time.Time
+		ProductID    uint
+		Quantity     int
+	}
+	if err := d.DB.Table("sales").
+		Select("sales.id, sales.customer_name, sales.total_amount, sales.created_at, "+
+			"sale_items.product_id, sale_items.quantity").
+		Joins("JOIN sale_items ON sale_items.sale_id = sales.id AND sale_items.legacy_transaction_id IS NOT NULL").
+		Where("sales.payment_method = ? AND sales.amount_paid = 0", models.PaymentCredit).
+		Where("NOT EXISTS (SELECT 1 FROM credit_transactions WHERE credit_transactions.sale_id = sales.id)").
+		Order("sales.id").
+		Scan(&sales).Error; err != nil {
+		return err
+	}
+
+	for _, sale := range sales {
+		from, to := sale.CreatedAt.Add(-legacyCreditWindow), sale.CreatedAt.Add(legacyCreditWindow)
+
+		var credit models.CreditTransaction
+		if err := d.DB.Where("sale_id IS NULL AND product_id = ? AND quantity = ? AND name = ? AND credit_amount = ?",
+			sale.ProductID, sale.Quantity, sale.CustomerName, sale.TotalAmount).
+			Where("created_at BETWEEN ? AND ?", from, to).
+			Order("id").Limit(1).Find(&credit).Error; err != nil {
+			return err
+		}
+		if credit.ID == 0 {
+			continue
+		}
+
+		// The basket is every line recorded with the same remaining balance
+		var basket float64
+		if err := d.DB.Model(&models.CreditTransaction{}).
+			Where("name = ? AND phone_number = ? AND balance_due = ?", credit.Name, credit.PhoneNumber, credit.BalanceDue).
+			Where("created_at BETWEEN ? AND ?", credit.CreatedAt.Add(-legacyCreditWindow), credit.CreatedAt.Add(legacyCreditWindow)).
+			Select("COALESCE(SUM(credit_amount), 0)").
+			Scan(&basket).Error; err != nil {
+			return err
+		}
+
+		balance := credit.BalanceDue
+		if basket > 0 {
+			balance = credit.BalanceDue * sale.TotalAmount / basket
+		}
+		balance = math.Round(math.Min(math.Max(balance, 0), sale.TotalAmount)*100) / 100
+		paid := math.Round((sale.TotalAmount-balance)*100) / 100
+
+		status := models.PaymentStatusPartial
+		switch {
+		case balance == 0:
+			status = models.PaymentStatusPaid
+		case paid == 0:
+			status = models.PaymentStatusUnpaid
+		}
+
+		if err := d.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Sale{}).Where("id = ?", sale.ID).Updates(map[string]interface{}{
+				"amount_paid":    paid,
+				"balance_due":    balance,
+				"payment_status": status,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&credit).Update("sale_id", sale.ID).Error
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) firstBusinessID() (uint, error) {
+	var business models.Business
+	if err := d.DB.Order("id").Limit(1).Find(&business).Error; err != nil {
+		return 0, err
+	}
+	return business.ID, nil
+}
+
+// ensureBusiness creates the shop's business profile on first run and
+// attaches any users created before businesses existed to it.
+func (d *DB) ensureBusiness() error {
+	var business models.Business
+	if err := d.DB.Order("id").Limit(1).Find(&business).Error; err != nil {
+		return err
+	}
+	if business.ID == 0 {
+		business = models.Business{Name: "My Shop"}
+		if err := d.DB.Create(&business).Error; err != nil {
+			return err
+		}
+	}
+	return d.DB.Model(&models.User{}).
+		Where("business_id = 0 OR business_id IS NULL").
+		Update("business_id", business.ID).Error
+}
+
+// ensureTaxClasses gives every business the built-in exempt, standard and
+// zero-rated tax classes it does not have yet.
+func (d *DB) ensureTaxClasses() error {
+	var businesses []models.Business
+	if err := d.DB.Find(&businesses).Error; err != nil {
+		return err
+	}
+	for _, business := range businesses {
+		for _, class := range models.DefaultTaxClasses {
+			class.BusinessID = business.ID
+			if err := d.DB.Where("business_id = ? AND code = ?", business.ID, class.Code).
+				FirstOrCreate(&class).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureOwner promotes the earliest account to owner when no user holds the
+// role yet, which is the case for accounts created before roles existed.
+func (d *DB) ensureOwner() error {
+	var owners int64
+	if err := d.DB.Model(&models.User{}).Where("role = ?", models.RoleOwner).Count(&owners).Error; err != nil {
+		return err
+	}
+	if owners > 0 {
+		return nil
+	}
+
+	var first models.User
+	if err := d.DB.Order("id").Limit(1).Find(&first).Error; err != nil {
+		return err
+	}
+	if first.ID == 0 {
+		return nil
+	}
+	return d.DB.Model(&first).Update("role", models.RoleOwner).Error
+}
+
+// dedupeLowStockAlerts collapses the duplicate alerts written before alerts
+// had a lifecycle: the newest unresolved alert per product stays open and
+// every older one is marked resolved. Alerts that already hold the open slot
+// are left alone, so running it again is a no-op.
+func (d *DB) dedupeLowStockAlerts() error {
+	now := time.Now()
+
+	if err := d.DB.Model(&models.LowStockAlert{}).
+		Where("resolved = ? AND status <> ?", true, models.AlertStatusResolved).
+		Updates(map[string]interface{}{
+			"status":      models.AlertStatusResolved,
+			"open_key":    nil,
+			"resolved_at": now,
+		}).Error; err != nil {
+		return err
+	}
+
+	var latest []struct {
+		ProductID uint
+		ID        uint
+	}
+	if err := d.DB.Model(&models.LowStockAlert{}).
+		Select("product_id, MAX(id) as id").
+		Where("status <> ?", models.AlertStatusResolved).
+		Group("product_id").
+		Having("SUM(CASE WHEN open_key IS NULL THEN 0 ELSE 1 END) = 0").
+		Scan(&latest).Error; err != nil {
+		return err
+	}
+
+	for _, row := range latest {
+		if err := d.DB.Model(&models.LowStockAlert{}).
+			Where("product_id = ? AND id <> ? AND status <> ?", row.ProductID, row.ID, models.AlertStatusResolved).
+			Updates(map[string]interface{}{
+				"status":          models.AlertStatusResolved,
+				"resolved":        true,
+				"resolved_at":     now,
+				"resolution_note": "Superseded by a newer alert",
+			}).Error; err != nil {
+			return err
+		}
+		if err := d.DB.Model(&models.LowStockAlert{}).
+			Where("id = ?", row.ID).
+			Update("open_key", row.ProductID).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
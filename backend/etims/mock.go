@@ -0,0 +1,127 @@
+// mock.go
+package etims
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockServer imitates the eTIMS sales endpoint for local testing. It checks
+// that invoices add up, issues receipt numbers and signatures, answers a
+// repeated invoice number with the receipt it gave the first time, and can
+// fail a share of requests to exercise retries.
+type MockServer struct {
+	// FailRate is the fraction of requests, from 0 to 1, answered with a
+	// server error before being processed
+	FailRate float64
+
+	mu       sync.Mutex
+	receipts map[string]ReceiptData
+	next     int
+}
+
+// NewMockServer creates an empty mock server
+func NewMockServer() *MockServer {
+	return &MockServer{receipts: make(map[string]ReceiptData)}
+}
+
+func (m *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/trnsSales/saveSales") {
+		http.NotFound(w, r)
+		return
+	}
+	if m.FailRate > 0 && rand.Float64() < m.FailRate {
+		http.Error(w, "simulated outage", http.StatusServiceUnavailable)
+		return
+	}
+
+	var invoice SalesInvoice
+	if err := json.NewDecoder(r.Body).Decode(&invoice); err != nil {
+		m.respond(w, "910", "Request parameter error", nil)
+		return
+	}
+	if r.Header.Get("tin") == "" || r.Header.Get("tin") != invoice.TIN || r.Header.Get("bhfId") != invoice.BranchID {
+		m.respond(w, "894", "Unregistered taxpayer or branch", nil)
+		return
+	}
+	if msg := checkInvoice(invoice); msg != "" {
+		m.respond(w, "910", msg, nil)
+		return
+	}
+
+	key := fmt.Sprintf("%s|%s|%d", invoice.TIN, invoice.BranchID, invoice.InvoiceNumber)
+
+	m.mu.Lock()
+	receipt, duplicate := m.receipts[key]
+	if !duplicate {
+		m.next++
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%.2f", key, m.next, invoice.TotalAmount)))
+		sign := strings.ToUpper(hex.EncodeToString(sum[:8]))
+		receipt = ReceiptData{
+			ReceiptNumber:      m.next,
+			TotalReceiptNumber: m.next,
+			InternalData:       strings.ToUpper(hex.EncodeToString(sum[8:18])),
+			ReceiptSignature:   sign,
+			SignedAt:           time.Now().Format("20060102150405"),
+			SDCID:              "KRACU0100000001",
+			MRCNumber:          "WIS00000001",
+		}
+		m.receipts[key] = receipt
+	}
+	m.mu.Unlock()
+
+	if duplicate {
+		m.respond(w, ResultDuplicate, "Invoice number already submitted", &receipt)
+		return
+	}
+	m.respond(w, ResultOK, "It is succeeded", &receipt)
+}
+
+func (m *MockServer) respond(w http.ResponseWriter, code, message string, data *ReceiptData) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		ResultCode:    code,
+		ResultMessage: message,
+		ResultDate:    time.Now().Format("20060102150405"),
+		Data:          data,
+	})
+}
+
+// checkInvoice returns why an invoice is invalid, or "" if it is fine
+func checkInvoice(invoice SalesInvoice) string {
+	if invoice.InvoiceNumber == 0 {
+		return "Invoice number is required"
+	}
+	if len(invoice.Items) == 0 || invoice.ItemCount != len(invoice.Items) {
+		return "Item count does not match the item list"
+	}
+
+	var taxable, tax, total float64
+	for _, item := range invoice.Items {
+		if item.ClassCode == "" || item.ItemCode == "" {
+			return fmt.Sprintf("Item %d is missing its item or classification code", item.Sequence)
+		}
+		if !strings.Contains("ABCDE", item.TaxType) || len(item.TaxType) != 1 {
+			return fmt.Sprintf("Item %d has an invalid tax type", item.Sequence)
+		}
+		taxable += item.TaxableAmount
+		tax += item.TaxAmount
+		total += item.TotalAmount
+	}
+	if !closeTo(taxable, invoice.TotalTaxable) || !closeTo(tax, invoice.TotalTax) || !closeTo(total, invoice.TotalAmount) {
+		return "Invoice totals do not match the items"
+	}
+	return ""
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 0.011
+}
@@ -0,0 +1,26 @@
+package etims
+
+import "testing"
+
+func TestCheckInvoice(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*SalesInvoice)
+		valid  bool
+	}{
+		{"valid", func(*SalesInvoice) {}, true},
+		{"no invoice number", func(i *SalesInvoice) { i.InvoiceNumber = 0 }, false},
+		{"item count off", func(i *SalesInvoice) { i.ItemCount = 1 }, false},
+		{"missing class code", func(i *SalesInvoice) { i.Items[0].ClassCode = "" }, false},
+		{"bad tax type", func(i *SalesInvoice) { i.Items[0].TaxType = "Z" }, false},
+		{"tax total off", func(i *SalesInvoice) { i.TotalTax = 20 }, false},
+		{"rounding within a cent", func(i *SalesInvoice) { i.TotalAmount = 250.01 }, true},
+	}
+	for _, tt := range tests {
+		invoice := testInvoice(1)
+		tt.change(&invoice)
+		if msg := checkInvoice(invoice); (msg == "") != tt.valid {
+			t.Errorf("%s: checkInvoice = %q, want valid %v", tt.name, msg, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// transport.go
+package etims
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transport sends invoices to eTIMS. HTTPTransport talks to the real API or
+// the mock server; tests and offline setups can plug in their own.
+type Transport interface {
+	SubmitSale(ctx context.Context, invoice SalesInvoice) (*ReceiptData, error)
+}
+
+// RejectedError is returned when eTIMS refuses an invoice. Sending the same
+// invoice again will be refused again, so it should not be retried as is.
+type RejectedError struct {
+	Code    string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("eTIMS rejected the invoice: %s %s", e.Code, e.Message)
+}
+
+// HTTPTransport submits invoices over the eTIMS HTTP API
+type HTTPTransport struct {
+	config Config
+	client *http.Client
+}
+
+// NewHTTPTransport creates a transport for the given branch
+func NewHTTPTransport(config Config) *HTTPTransport {
+	return &HTTPTransport{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// SubmitSale sends a sales invoice. An invoice number eTIMS has already
+// accepted is answered with the original receipt, so resubmitting after a
+// lost response is safe.
+func (t *HTTPTransport) SubmitSale(ctx context.Context, invoice SalesInvoice) (*ReceiptData, error) {
+	body, err := json.Marshal(invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(t.config.BaseURL, "/") + "/trnsSales/saveSales"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("tin", invoice.TIN)
+	req.Header.Set("bhfId", invoice.BranchID)
+	req.Header.Set("cmcKey", t.config.CMCKey)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("eTIMS server error: %s", resp.Status)
+	}
+
+	var result Response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid eTIMS response: %v", err)
+	}
+	if (result.ResultCode == ResultOK || result.ResultCode == ResultDuplicate) && result.Data != nil {
+		return result.Data, nil
+	}
+	return nil, &RejectedError{Code: result.ResultCode, Message: result.ResultMessage}
+}
+
+// QRData is the text encoded in the receipt's QR code, which lets the buyer
+// check the receipt with KRA.
+func QRData(config Config, invoice SalesInvoice, receipt ReceiptData) string {
+	return config.QRBaseURL + invoice.TIN + invoice.BranchID + receipt.ReceiptSignature
+}
@@ -0,0 +1,106 @@
+package etims
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testInvoice(number uint) SalesInvoice {
+	return SalesInvoice{
+		TIN:           "P051234567X",
+		BranchID:      "00",
+		InvoiceNumber: number,
+		ItemCount:     2,
+		TotalTaxable:  172.41,
+		TotalTax:      27.59,
+		TotalAmount:   250,
+		Items: []InvoiceItem{
+			{Sequence: 1, ItemCode: "KE000000001", ClassCode: "5020230000", TaxType: "B", TaxableAmount: 172.41, TaxAmount: 27.59, TotalAmount: 200},
+			{Sequence: 2, ItemCode: "KE000000002", ClassCode: "5020230000", TaxType: "A", TaxableAmount: 0, TaxAmount: 0, TotalAmount: 50},
+		},
+	}
+}
+
+func newTestTransport(t *testing.T, handler http.Handler) *HTTPTransport {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewHTTPTransport(Config{BaseURL: server.URL + "/", CMCKey: "key"})
+}
+
+func TestSubmitSale(t *testing.T) {
+	transport := newTestTransport(t, NewMockServer())
+
+	receipt, err := transport.SubmitSale(context.Background(), testInvoice(1))
+	if err != nil {
+		t.Fatalf("SubmitSale: %v", err)
+	}
+	if receipt.ReceiptNumber != 1 || receipt.ReceiptSignature == "" {
+		t.Errorf("receipt = %+v, want number 1 with a signature", receipt)
+	}
+
+	next, err := transport.SubmitSale(context.Background(), testInvoice(2))
+	if err != nil {
+		t.Fatalf("SubmitSale: %v", err)
+	}
+	if next.ReceiptNumber != 2 {
+		t.Errorf("second receipt number = %d, want 2", next.ReceiptNumber)
+	}
+}
+
+func TestSubmitSaleAgainReturnsTheFirstReceipt(t *testing.T) {
+	transport := newTestTransport(t, NewMockServer())
+
+	first, err := transport.SubmitSale(context.Background(), testInvoice(7))
+	if err != nil {
+		t.Fatalf("SubmitSale: %v", err)
+	}
+	again, err := transport.SubmitSale(context.Background(), testInvoice(7))
+	if err != nil {
+		t.Fatalf("resubmitting: %v", err)
+	}
+	if *again != *first {
+		t.Errorf("resubmitted receipt = %+v, want the first one %+v", again, first)
+	}
+}
+
+func TestSubmitSaleRejected(t *testing.T) {
+	transport := newTestTransport(t, NewMockServer())
+
+	invoice := testInvoice(3)
+	invoice.TotalAmount = 300
+	_, err := transport.SubmitSale(context.Background(), invoice)
+	var rejected *RejectedError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("SubmitSale with wrong totals = %v, want a RejectedError", err)
+	}
+	if rejected.Code != "910" {
+		t.Errorf("rejection code = %s, want 910", rejected.Code)
+	}
+}
+
+func TestSubmitSaleServerError(t *testing.T) {
+	mock := NewMockServer()
+	mock.FailRate = 1
+	transport := newTestTransport(t, mock)
+
+	_, err := transport.SubmitSale(context.Background(), testInvoice(4))
+	if err == nil {
+		t.Fatal("SubmitSale during an outage succeeded")
+	}
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		t.Errorf("outage reported as a rejection, which would stop retries: %v", err)
+	}
+}
+
+func TestQRData(t *testing.T) {
+	config := Config{QRBaseURL: "https://etims.example/verify?Data="}
+	got := QRData(config, testInvoice(1), ReceiptData{ReceiptSignature: "ABC123"})
+	if want := "https://etims.example/verify?Data=P051234567X00ABC123"; got != want {
+		t.Errorf("QRData = %q, want %q", got, want)
+	}
+}
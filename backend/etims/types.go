@@ -0,0 +1,126 @@
+// types.go
+package etims
+
+// Config holds the eTIMS connection settings. The taxpayer PIN and branch
+// travel with each invoice.
+type Config struct {
+	// BaseURL is the eTIMS (OSCU/VSCU) API address, or the mock server's
+	BaseURL string
+	// CMCKey is the communication key issued when the device was initialised
+	CMCKey string
+	// QRBaseURL is the receipt verification link printed as a QR code, to
+	// which the receipt's identifying data is appended
+	QRBaseURL string
+}
+
+// Result codes returned by eTIMS
+const (
+	ResultOK = "000"
+	// ResultDuplicate means an invoice with the same number was already
+	// accepted; the response carries the receipt issued the first time
+	ResultDuplicate = "924"
+)
+
+// Receipt types
+const (
+	ReceiptSale   = "S"
+	ReceiptRefund = "R"
+)
+
+// Payment type codes
+const (
+	PaymentCash        = "01"
+	PaymentCredit      = "02"
+	PaymentMobileMoney = "06"
+	PaymentOther       = "07"
+)
+
+// SalesInvoice is the sales transaction payload sent to eTIMS. Field names
+// follow the eTIMS API.
+type SalesInvoice struct {
+	// TIN is the seller's KRA PIN and BranchID its registered branch, "00"
+	// for the head office
+	TIN              string        `json:"tin"`
+	BranchID         string        `json:"bhfId"`
+	InvoiceNumber    uint          `json:"invcNo"`
+	OrgInvoiceNumber uint          `json:"orgInvcNo"`
+	CustomerTIN      string        `json:"custTin,omitempty"`
+	CustomerName     string        `json:"custNm,omitempty"`
+	SalesType        string        `json:"salesTyCd"`
+	ReceiptType      string        `json:"rcptTyCd"`
+	PaymentType      string        `json:"pmtTyCd"`
+	SalesStatus      string        `json:"salesSttsCd"`
+	ConfirmedAt      string        `json:"cfmDt"`
+	SalesDate        string        `json:"salesDt"`
+	ItemCount        int           `json:"totItemCnt"`
+	TaxableAmountA   float64       `json:"taxblAmtA"`
+	TaxableAmountB   float64       `json:"taxblAmtB"`
+	TaxableAmountC   float64       `json:"taxblAmtC"`
+	TaxableAmountD   float64       `json:"taxblAmtD"`
+	TaxableAmountE   float64       `json:"taxblAmtE"`
+	TaxRateA         float64       `json:"taxRtA"`
+	TaxRateB         float64       `json:"taxRtB"`
+	TaxRateC         float64       `json:"taxRtC"`
+	TaxRateD         float64       `json:"taxRtD"`
+	TaxRateE         float64       `json:"taxRtE"`
+	TaxAmountA       float64       `json:"taxAmtA"`
+	TaxAmountB       float64       `json:"taxAmtB"`
+	TaxAmountC       float64       `json:"taxAmtC"`
+	TaxAmountD       float64       `json:"taxAmtD"`
+	TaxAmountE       float64       `json:"taxAmtE"`
+	TotalTaxable     float64       `json:"totTaxblAmt"`
+	TotalTax         float64       `json:"totTaxAmt"`
+	TotalAmount      float64       `json:"totAmt"`
+	Remark           string        `json:"remark,omitempty"`
+	Receipt          InvoiceBuyer  `json:"receipt"`
+	Items            []InvoiceItem `json:"itemList"`
+}
+
+// InvoiceBuyer is the receipt section of a sales invoice
+type InvoiceBuyer struct {
+	CustomerTIN   string `json:"custTin,omitempty"`
+	CustomerPhone string `json:"custMblNo,omitempty"`
+	PublishedAt   string `json:"rcptPbctDt"`
+	BuyerAccepted string `json:"prchrAcptcYn"`
+	TraderName    string `json:"trdeNm,omitempty"`
+}
+
+// InvoiceItem is one line of a sales invoice
+type InvoiceItem struct {
+	Sequence       int     `json:"itemSeq"`
+	ItemCode       string  `json:"itemCd"`
+	ClassCode      string  `json:"itemClsCd"`
+	Name           string  `json:"itemNm"`
+	Barcode        string  `json:"bcd,omitempty"`
+	PackageUnit    string  `json:"pkgUnitCd"`
+	Packages       float64 `json:"pkg"`
+	QuantityUnit   string  `json:"qtyUnitCd"`
+	Quantity       float64 `json:"qty"`
+	UnitPrice      float64 `json:"prc"`
+	SupplyAmount   float64 `json:"splyAmt"`
+	DiscountRate   float64 `json:"dcRt"`
+	DiscountAmount float64 `json:"dcAmt"`
+	TaxType        string  `json:"taxTyCd"`
+	TaxableAmount  float64 `json:"taxblAmt"`
+	TaxAmount      float64 `json:"taxAmt"`
+	TotalAmount    float64 `json:"totAmt"`
+}
+
+// Response is the envelope eTIMS answers with
+type Response struct {
+	ResultCode    string       `json:"resultCd"`
+	ResultMessage string       `json:"resultMsg"`
+	ResultDate    string       `json:"resultDt"`
+	Data          *ReceiptData `json:"data"`
+}
+
+// ReceiptData is what eTIMS returns for an accepted invoice
+type ReceiptData struct {
+	ReceiptNumber      int    `json:"rcptNo"`
+	TotalReceiptNumber int    `json:"totRcptNo"`
+	InternalData       string `json:"intrlData"`
+	ReceiptSignature   string `json:"rcptSign"`
+	SignedAt           string `json:"sdcDateTime"`
+	SDCID              string `json:"sdcId"`
+	MRCNumber          string `json:"mrcNo"`
+}
@@ -0,0 +1,130 @@
+// events.go
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types pushed to dashboards
+const (
+	SaleRecorded     = "sale.recorded"
+	PaymentConfirmed = "payment.confirmed"
+	PaymentFailed    = "payment.failed"
+	StockChanged     = "stock.changed"
+	AlertRaised      = "alert.raised"
+	AlertResolved    = "alert.resolved"
+)
+
+// bufferSize is how many events a subscriber can fall behind by before
+// further events to it are dropped
+const bufferSize = 64
+
+// Event is something that happened in a business
+type Event struct {
+	ID         uint64      `json:"id"`
+	Type       string      `json:"type"`
+	BusinessID uint        `json:"business_id"`
+	Data       interface{} `json:"data"`
+	At         time.Time   `json:"at"`
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[uint]map[chan Event]struct{})
+	lastID      atomic.Uint64
+)
+
+// Subscribe returns a channel receiving the business's events from now on,
+// and a function that stops the subscription and closes the channel. A
+// subscriber that does not keep up misses events rather than holding up
+// the publisher.
+func Subscribe(businessID uint) (<-chan Event, func()) {
+	ch := make(chan Event, bufferSize)
+
+	mu.Lock()
+	if subscribers[businessID] == nil {
+		subscribers[businessID] = make(map[chan Event]struct{})
+	}
+	subscribers[businessID][ch] = struct{}{}
+	mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			mu.Lock()
+			delete(subscribers[businessID], ch)
+			if len(subscribers[businessID]) == 0 {
+				delete(subscribers, businessID)
+			}
+			mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends an event to the business's subscribers
+func Publish(businessID uint, eventType string, data interface{}) {
+	event := Event{
+		ID:         lastID.Add(1),
+		Type:       eventType,
+		BusinessID: businessID,
+		Data:       data,
+		At:         time.Now(),
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for ch := range subscribers[businessID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+type outboxKey struct{}
+
+// Outbox holds events raised inside a database transaction until it
+// commits, so subscribers never hear of work that was rolled back
+type Outbox struct {
+	businessID uint
+	mu         sync.Mutex
+	queued     []Event
+}
+
+// WithOutbox returns a context whose queued events go to the given business
+// once Flush is called
+func WithOutbox(ctx context.Context, businessID uint) (context.Context, *Outbox) {
+	outbox := &Outbox{businessID: businessID}
+	return context.WithValue(ctx, outboxKey{}, outbox), outbox
+}
+
+// Queue adds an event to the context's outbox. Without an outbox the event
+// has no business to go to and is dropped.
+func Queue(ctx context.Context, eventType string, data interface{}) {
+	if ctx == nil {
+		return
+	}
+	outbox, ok := ctx.Value(outboxKey{}).(*Outbox)
+	if !ok {
+		return
+	}
+	outbox.mu.Lock()
+	outbox.queued = append(outbox.queued, Event{Type: eventType, Data: data})
+	outbox.mu.Unlock()
+}
+
+// Flush publishes the queued events in the order they were raised
+func (o *Outbox) Flush() {
+	o.mu.Lock()
+	queued := o.queued
+	o.queued = nil
+	o.mu.Unlock()
+
+	for _, event := range queued {
+		Publish(o.businessID, event.Type, event.Data)
+	}
+}
@@ -0,0 +1,122 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// receive waits briefly for an event, reporting false if none arrives
+func receive(ch <-chan Event) (Event, bool) {
+	select {
+	case event, ok := <-ch:
+		return event, ok
+	case <-time.After(time.Second):
+		return Event{}, false
+	}
+}
+
+func TestPublishReachesOnlyTheBusiness(t *testing.T) {
+	mine, stopMine := Subscribe(1)
+	defer stopMine()
+	theirs, stopTheirs := Subscribe(2)
+	defer stopTheirs()
+
+	Publish(1, SaleRecorded, "sale")
+
+	event, ok := receive(mine)
+	if !ok {
+		t.Fatal("subscriber did not receive the event")
+	}
+	if event.Type != SaleRecorded || event.BusinessID != 1 || event.Data != "sale" || event.ID == 0 {
+		t.Errorf("event = %+v, want a numbered sale.recorded event for business 1", event)
+	}
+	select {
+	case event := <-theirs:
+		t.Errorf("another business received %+v", event)
+	default:
+	}
+}
+
+func TestEventIDsIncrease(t *testing.T) {
+	ch, stop := Subscribe(3)
+	defer stop()
+
+	Publish(3, StockChanged, 1)
+	Publish(3, StockChanged, 2)
+	first, _ := receive(ch)
+	second, _ := receive(ch)
+	if second.ID <= first.ID {
+		t.Errorf("event IDs %d then %d do not increase", first.ID, second.ID)
+	}
+}
+
+func TestSlowSubscriberDoesNotBlock(t *testing.T) {
+	ch, stop := Subscribe(4)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < bufferSize*2; i++ {
+			Publish(4, StockChanged, i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a subscriber that is not reading")
+	}
+	if len(ch) != bufferSize {
+		t.Errorf("subscriber has %d events queued, want the first %d", len(ch), bufferSize)
+	}
+}
+
+func TestStopClosesTheChannel(t *testing.T) {
+	ch, stop := Subscribe(5)
+	stop()
+	stop()
+
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after stop")
+	}
+	// Publishing to a business without subscribers is a no-op
+	Publish(5, SaleRecorded, nil)
+}
+
+func TestOutboxHoldsEventsUntilFlush(t *testing.T) {
+	ch, stop := Subscribe(6)
+	defer stop()
+
+	ctx, outbox := WithOutbox(context.Background(), 6)
+	Queue(ctx, SaleRecorded, "first")
+	Queue(ctx, StockChanged, "second")
+
+	select {
+	case event := <-ch:
+		t.Fatalf("event %+v sent before the outbox was flushed", event)
+	default:
+	}
+
+	outbox.Flush()
+	for _, want := range []string{"first", "second"} {
+		event, ok := receive(ch)
+		if !ok || event.Data != want {
+			t.Fatalf("received %+v, want %s", event, want)
+		}
+	}
+
+	// A flushed outbox is empty
+	outbox.Flush()
+	select {
+	case event := <-ch:
+		t.Errorf("second flush sent %+v again", event)
+	default:
+	}
+}
+
+func TestQueueWithoutOutbox(t *testing.T) {
+	// Neither call may panic; the events have nowhere to go
+	Queue(context.Background(), SaleRecorded, nil)
+	Queue(nil, SaleRecorded, nil)
+}
@@ -0,0 +1,15 @@
+// csv.go
+package export
+
+import "strings"
+
+// CSVText makes a text value safe to put in a CSV cell. Spreadsheets run a
+// cell starting with =, +, - or @ as a formula, so such values, and ones
+// starting with a tab or carriage return, are prefixed with a quote to keep
+// them as text.
+func CSVText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
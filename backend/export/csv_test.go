@@ -0,0 +1,24 @@
+package export
+
+import "testing"
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"Mama Mboga", "Mama Mboga"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+254712345678", "'+254712345678"},
+		{"-10", "'-10"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"\rcmd", "'\rcmd"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := CSVText(tt.value); got != tt.want {
+			t.Errorf("CSVText(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
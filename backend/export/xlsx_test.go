@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{27, "AB"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := columnName(tt.i); got != tt.want {
+			t.Errorf("columnName(%d) = %q, want %q", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestExcelTime(t *testing.T) {
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	tests := []struct {
+		t    time.Time
+		want float64
+	}{
+		{time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC), 2},
+		{time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC), 45292.5},
+		// Local wall time is kept, not converted to UTC
+		{time.Date(2024, time.January, 1, 12, 0, 0, 0, nairobi), 45292.5},
+	}
+	for _, tt := range tests {
+		if got := excelTime(tt.t); got != tt.want {
+			t.Errorf("excelTime(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestXMLEscape(t *testing.T) {
+	got := xmlEscape("Tom & Jerry <\"best\">\x01\tok")
+	want := "Tom &amp; Jerry &lt;&quot;best&quot;&gt;\tok"
+	if got != want {
+		t.Errorf("xmlEscape = %q, want %q", got, want)
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXWriter(&buf, "Sales & Returns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	if err := w.WriteRow("Sale", "Total", "Date"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow("S-1", 150.5, at, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("workbook is not a zip archive: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(body)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("workbook is missing %s", name)
+		}
+	}
+	if !strings.Contains(files["xl/workbook.xml"], `name="Sales &amp; Returns"`) {
+		t.Error("sheet name is not escaped in the workbook")
+	}
+
+	sheet := files["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">Sale</t></is></c>`,
+		`<c r="B2"><v>150.5</v></c>`,
+		`<c r="C2" s="1"><v>45292.500000</v></c>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("sheet does not contain %s", want)
+		}
+	}
+	// Empty values leave their cells out
+	if strings.Contains(sheet, `r="D2"`) || strings.Contains(sheet, `r="E2"`) {
+		t.Error("sheet has cells for empty values")
+	}
+	if !strings.HasSuffix(sheet, "</sheetData></worksheet>") {
+		t.Error("sheet is not closed")
+	}
+}
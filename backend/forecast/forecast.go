@@ -0,0 +1,145 @@
+// forecast.go
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+// serviceLevelZ is the z-score for a 95% chance of not running out during
+// the supplier lead time.
+const serviceLevelZ = 1.65
+
+// maxCoverDays caps the days-of-cover simulation for very slow movers.
+const maxCoverDays = 365
+
+// Day is one day of history for a single product.
+type Day struct {
+	Date    time.Time
+	Sold    int
+	InStock bool
+}
+
+// Params tunes a forecast for one product.
+type Params struct {
+	CurrentStock int
+	LeadTimeDays int
+	ReviewDays   int
+	PackSize     int
+}
+
+// Result is the forecast for one product.
+type Result struct {
+	DailyVelocity     float64    `json:"daily_velocity"`
+	WeekdayFactors    [7]float64 `json:"weekday_factors"`
+	InStockDays       int        `json:"in_stock_days"`
+	DaysOfCover       *int       `json:"days_of_cover"`
+	LeadTimeDemand    float64    `json:"lead_time_demand"`
+	SafetyStock       int        `json:"safety_stock"`
+	ReorderPoint      int        `json:"reorder_point"`
+	SuggestedOrderQty int        `json:"suggested_order_qty"`
+	StockoutDate      *time.Time `json:"stockout_date,omitempty"`
+	NeedsReorder      bool       `json:"needs_reorder"`
+}
+
+// Compute builds a forecast from daily history. Days on which the product was
+// out of stock are left out, so a stock-out does not read as zero demand.
+func Compute(history []Day, params Params, from time.Time) Result {
+	var result Result
+	for i := range result.WeekdayFactors {
+		result.WeekdayFactors[i] = 1
+	}
+
+	var total float64
+	var weekdayTotal [7]float64
+	var weekdayDays [7]int
+	var sold []float64
+	for _, day := range history {
+		if !day.InStock {
+			continue
+		}
+		total += float64(day.Sold)
+		weekdayTotal[day.Date.Weekday()] += float64(day.Sold)
+		weekdayDays[day.Date.Weekday()]++
+		sold = append(sold, float64(day.Sold))
+	}
+
+	result.InStockDays = len(sold)
+	if result.InStockDays == 0 {
+		return result
+	}
+	result.DailyVelocity = total / float64(result.InStockDays)
+
+	// Weekday factors need every weekday observed at least once; otherwise
+	// the flat velocity is a better guess than a half-filled week.
+	if result.DailyVelocity > 0 && result.InStockDays >= 7 {
+		complete := true
+		for _, n := range weekdayDays {
+			if n == 0 {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			for i := range result.WeekdayFactors {
+				result.WeekdayFactors[i] = (weekdayTotal[i] / float64(weekdayDays[i])) / result.DailyVelocity
+			}
+		}
+	}
+
+	var variance float64
+	for _, s := range sold {
+		variance += (s - result.DailyVelocity) * (s - result.DailyVelocity)
+	}
+	stdDev := math.Sqrt(variance / float64(len(sold)))
+
+	leadTime := params.LeadTimeDays
+	if leadTime < 0 {
+		leadTime = 0
+	}
+	result.LeadTimeDemand = result.demandOver(from, leadTime)
+	result.SafetyStock = int(math.Ceil(serviceLevelZ * stdDev * math.Sqrt(float64(leadTime))))
+	result.ReorderPoint = int(math.Ceil(result.LeadTimeDemand)) + result.SafetyStock
+
+	target := int(math.Ceil(result.demandOver(from, leadTime+params.ReviewDays))) + result.SafetyStock
+	if need := target - params.CurrentStock; need > 0 {
+		result.SuggestedOrderQty = roundUpToPack(need, params.PackSize)
+	}
+	result.NeedsReorder = params.CurrentStock <= result.ReorderPoint && result.SuggestedOrderQty > 0
+
+	if result.DailyVelocity > 0 {
+		stock := float64(params.CurrentStock)
+		days := 0
+		for days < maxCoverDays {
+			stock -= result.DailyVelocity * result.WeekdayFactors[from.AddDate(0, 0, days).Weekday()]
+			if stock < 0 {
+				break
+			}
+			days++
+		}
+		result.DaysOfCover = &days
+		if days < maxCoverDays {
+			stockout := from.AddDate(0, 0, days)
+			result.StockoutDate = &stockout
+		}
+	}
+
+	return result
+}
+
+// demandOver is the expected number of units sold over the next n days.
+func (r Result) demandOver(from time.Time, n int) float64 {
+	var demand float64
+	for i := 0; i < n; i++ {
+		demand += r.DailyVelocity * r.WeekdayFactors[from.AddDate(0, 0, i).Weekday()]
+	}
+	return demand
+}
+
+// roundUpToPack rounds a quantity up to a whole number of supplier packs.
+func roundUpToPack(quantity, packSize int) int {
+	if packSize <= 1 {
+		return quantity
+	}
+	return ((quantity + packSize - 1) / packSize) * packSize
+}
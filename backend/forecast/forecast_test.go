@@ -0,0 +1,128 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// history gives n days of sales ending the day before from
+func history(from time.Time, sold []int, inStock func(i int) bool) []Day {
+	days := make([]Day, len(sold))
+	for i, s := range sold {
+		days[i] = Day{Date: from.AddDate(0, 0, i-len(sold)), Sold: s, InStock: inStock(i)}
+	}
+	return days
+}
+
+func always(int) bool { return true }
+
+func TestComputeSteadyDemand(t *testing.T) {
+	from := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	sold := make([]int, 14)
+	for i := range sold {
+		sold[i] = 4
+	}
+
+	result := Compute(history(from, sold, always), Params{
+		CurrentStock: 20,
+		LeadTimeDays: 3,
+		ReviewDays:   7,
+		PackSize:     6,
+	}, from)
+
+	if result.DailyVelocity != 4 {
+		t.Errorf("DailyVelocity = %v, want 4", result.DailyVelocity)
+	}
+	for i, f := range result.WeekdayFactors {
+		if f != 1 {
+			t.Errorf("WeekdayFactors[%d] = %v, want 1", i, f)
+		}
+	}
+	if result.SafetyStock != 0 {
+		t.Errorf("SafetyStock = %d, want 0 for demand that never varies", result.SafetyStock)
+	}
+	if result.ReorderPoint != 12 {
+		t.Errorf("ReorderPoint = %d, want 12", result.ReorderPoint)
+	}
+	// Ten days of demand is 40, less the 20 in stock, rounded up to packs of 6
+	if result.SuggestedOrderQty != 24 {
+		t.Errorf("SuggestedOrderQty = %d, want 24", result.SuggestedOrderQty)
+	}
+	if result.NeedsReorder {
+		t.Error("NeedsReorder = true with stock above the reorder point")
+	}
+	if result.DaysOfCover == nil || *result.DaysOfCover != 5 {
+		t.Fatalf("DaysOfCover = %v, want 5", result.DaysOfCover)
+	}
+	if want := from.AddDate(0, 0, 5); result.StockoutDate == nil || !result.StockoutDate.Equal(want) {
+		t.Errorf("StockoutDate = %v, want %v", result.StockoutDate, want)
+	}
+}
+
+func TestComputeSkipsStockouts(t *testing.T) {
+	from := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	sold := []int{6, 6, 6, 6, 0, 0, 0, 0}
+	result := Compute(history(from, sold, func(i int) bool { return i < 4 }), Params{CurrentStock: 100}, from)
+
+	if result.InStockDays != 4 {
+		t.Errorf("InStockDays = %d, want 4", result.InStockDays)
+	}
+	if result.DailyVelocity != 6 {
+		t.Errorf("DailyVelocity = %v, want 6; days out of stock must not count as no demand", result.DailyVelocity)
+	}
+}
+
+func TestComputeWeekdayFactors(t *testing.T) {
+	// Two weeks of history starting on a Monday
+	from := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	var sold []int
+	for week := 0; week < 2; week++ {
+		// Monday to Sunday, with Saturdays twice as busy
+		sold = append(sold, 5, 5, 5, 5, 5, 10, 5)
+	}
+	result := Compute(history(from, sold, always), Params{}, from)
+
+	saturday := result.WeekdayFactors[time.Saturday]
+	monday := result.WeekdayFactors[time.Monday]
+	if math.Abs(saturday/monday-2) > 1e-9 {
+		t.Errorf("Saturday factor %v is not twice Monday's %v", saturday, monday)
+	}
+}
+
+func TestComputeNeedsAWholeWeekForFactors(t *testing.T) {
+	from := time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+	result := Compute(history(from, []int{1, 9, 1, 9, 1, 9}, always), Params{}, from)
+	for i, f := range result.WeekdayFactors {
+		if f != 1 {
+			t.Errorf("WeekdayFactors[%d] = %v, want 1 with under a week of history", i, f)
+		}
+	}
+}
+
+func TestComputeNoHistory(t *testing.T) {
+	result := Compute(nil, Params{CurrentStock: 10, LeadTimeDays: 5}, time.Now())
+	if result.DailyVelocity != 0 || result.SuggestedOrderQty != 0 || result.NeedsReorder {
+		t.Errorf("Compute with no history = %+v, want no demand and no order", result)
+	}
+	if result.DaysOfCover != nil {
+		t.Errorf("DaysOfCover = %d, want nil without sales", *result.DaysOfCover)
+	}
+}
+
+func TestRoundUpToPack(t *testing.T) {
+	tests := []struct {
+		quantity, packSize, want int
+	}{
+		{7, 0, 7},
+		{7, 1, 7},
+		{7, 6, 12},
+		{12, 6, 12},
+		{1, 24, 24},
+	}
+	for _, tt := range tests {
+		if got := roundUpToPack(tt.quantity, tt.packSize); got != tt.want {
+			t.Errorf("roundUpToPack(%d, %d) = %d, want %d", tt.quantity, tt.packSize, got, tt.want)
+		}
+	}
+}
@@ -25,6 +25,11 @@ func main() {
 		log.Fatalf("CALLBACK_URL is not set in the .env file")
 	}
 
+	// Tokens carry the user's role, so they must not be signed with a known key
+	if os.Getenv("JWT_SECRET") == "" {
+		log.Fatalf("JWT_SECRET is not set in the .env file")
+	}
+
 	fmt.Println("Initializing database connection...")
 	// Initialize database connection
 	db, err := database.Connect()
@@ -50,17 +55,27 @@ func main() {
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:5173", callbackURL}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "If-None-Match", "If-Modified-Since", "Idempotency-Key"}
+	config.ExposeHeaders = []string{"ETag", "Last-Modified", "X-Next-Cursor", "X-Total-Count"}
 	router.Use(cors.New(config))
 
 	fmt.Println("Gin router initialized successfully")
 
 	// Register routes
 	routes.AuthRoutes(router, db.DB)
+	routes.BusinessRoutes(router, db.DB)
 	routes.InventoryManagementRoutes(router, db.DB)
 	routes.SalesManagementRoutes(router, db.DB)
 	routes.MpesaRoutes(router, db.DB)
 	routes.CreditRoutes(router, db.DB)
+	routes.WriteOffRoutes(router, db.DB)
+	routes.EtimsRoutes(router, db.DB)
+	routes.ShiftRoutes(router, db.DB)
+	routes.ParkedSaleRoutes(router, db.DB)
+	routes.CustomerRoutes(router, db.DB)
+	routes.LoyaltyRoutes(router, db.DB)
+	routes.StandingOrderRoutes(router, db.DB)
+	routes.EventRoutes(router, db.DB)
 
 	fmt.Println("Server is running on port 8080")
 	// Start server on port 8080
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/OAthooh/BiasharaTrack.git/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// currentUserKey is the gin context key holding the authenticated user
+const currentUserKey = "currentUser"
+
+// RequireAuth rejects requests without a valid bearer token and stores the
+// token's user on the context for handlers to read with CurrentUser.
+func RequireAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := userFromToken(db, c.GetHeader("Authorization"))
+		if err != nil {
+			utils.WarningLogger("Unauthorized request to %s: %v", c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Set(currentUserKey, user)
+		c.Next()
+	}
+}
+
+// RequireStreamAuth is RequireAuth for event streams. Browsers cannot set
+// headers on an EventSource, so a stream ticket may be sent as the ticket
+// query parameter instead. Tickets are single use and expire within a
+// minute, so one that ends up in an access log is of no use to anyone.
+func RequireStreamAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user models.User
+		var err error
+		if ticket := c.Query("ticket"); ticket != "" && c.GetHeader("Authorization") == "" {
+			user, err = userFromTicket(db, ticket)
+		} else {
+			user, err = userFromToken(db, c.GetHeader("Authorization"))
+		}
+		if err != nil {
+			utils.WarningLogger("Unauthorized request to %s: %v", c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Set(currentUserKey, user)
+		c.Next()
+	}
+}
+
+// userFromTicket redeems a stream ticket. The update only succeeds for an
+// unused, unexpired ticket, so a ticket cannot be used twice even by two
+// servers at once.
+func userFromTicket(db *gorm.DB, ticket string) (models.User, error) {
+	now := time.Now()
+	hash := utils.HashToken(ticket)
+	result := db.Model(&models.StreamTicket{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return models.User{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.User{}, errors.New("invalid or used stream ticket")
+	}
+
+	var stored models.StreamTicket
+	if err := db.Where("token_hash = ?", hash).First(&stored).Error; err != nil {
+		return models.User{}, err
+	}
+	var user models.User
+	if err := db.First(&user, stored.UserID).Error; err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// OptionalAuth stores the token's user on the context when a valid token is
+// sent, and lets the request through either way.
+func OptionalAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("Authorization"); header != "" {
+			if user, err := userFromToken(db, header); err == nil {
+				c.Set(currentUserKey, user)
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireRole rejects requests from users below the given role. It must run
+// after RequireAuth.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok || !user.HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "You are not allowed to perform this action"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser returns the authenticated user for the request, if any
+func CurrentUser(c *gin.Context) (models.User, bool) {
+	value, ok := c.Get(currentUserKey)
+	if !ok {
+		return models.User{}, false
+	}
+	user, ok := value.(models.User)
+	return user, ok
+}
+
+func userFromToken(db *gorm.DB, header string) (models.User, error) {
+	tokenString := strings.TrimPrefix(header, "Bearer ")
+	if tokenString == "" {
+		return models.User{}, jwt.ErrTokenMalformed
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return utils.JWTSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return models.User{}, err
+	}
+	if !token.Valid {
+		return models.User{}, jwt.ErrTokenInvalidClaims
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return models.User{}, jwt.ErrTokenInvalidClaims
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return models.User{}, jwt.ErrTokenInvalidClaims
+	}
+
+	// Load the user so role changes take effect without a new token
+	var user models.User
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
@@ -12,11 +12,52 @@ type Register struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+// User roles, from least to most privileged
+const (
+	RoleCashier    = "CASHIER"
+	RoleSupervisor = "SUPERVISOR"
+	RoleManager    = "MANAGER"
+	RoleOwner      = "OWNER"
+)
+
+var roleRank = map[string]int{
+	RoleCashier:    1,
+	RoleSupervisor: 2,
+	RoleManager:    3,
+	RoleOwner:      4,
+}
+
+// ValidRole reports whether role is one of the known user roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	FullName  string    `gorm:"not null" json:"fullName"`
-	Email     string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
-	Password  string    `gorm:"not null" json:"password"`
-	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FullName   string    `gorm:"not null" json:"fullName"`
+	Email      string    `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
+	Password   string    `gorm:"not null" json:"-"`
+	Role       string    `gorm:"type:enum('CASHIER','SUPERVISOR','MANAGER','OWNER');default:'CASHIER'" json:"role"`
+	BusinessID uint      `gorm:"index" json:"business_id"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// HasRole reports whether the user's role is at least as privileged as role
+func (u User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+// StreamTicket lets a browser open the event stream, which cannot carry an
+// Authorization header. A ticket is good for one connection within a
+// minute of being issued. Only a hash of it is stored.
+type StreamTicket struct {
+	ID        uint       `gorm:"primaryKey" json:"-"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	UserID    uint       `gorm:"not null;index" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	UsedAt    *time.Time `json:"-"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"-"`
 }
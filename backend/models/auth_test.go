@@ -0,0 +1,35 @@
+package models
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role, required string
+		want           bool
+	}{
+		{RoleCashier, RoleCashier, true},
+		{RoleCashier, RoleSupervisor, false},
+		{RoleSupervisor, RoleCashier, true},
+		{RoleManager, RoleOwner, false},
+		{RoleOwner, RoleManager, true},
+		{"", RoleCashier, false},
+	}
+	for _, tt := range tests {
+		if got := (User{Role: tt.role}).HasRole(tt.required); got != tt.want {
+			t.Errorf("User{Role: %q}.HasRole(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{RoleCashier, RoleSupervisor, RoleManager, RoleOwner} {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+	for _, role := range []string{"", "cashier", "ADMIN"} {
+		if ValidRole(role) {
+			t.Errorf("ValidRole(%q) = true", role)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Business holds the shop's profile and the settings shared by its users
+type Business struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	Name    string `gorm:"not null" json:"name"`
+	Phone   string `json:"phone,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Address string `gorm:"type:text" json:"address,omitempty"`
+	KRAPin  string `gorm:"column:kra_pin" json:"kra_pin,omitempty"`
+	// VATRegistered turns on VAT for sales. PricesIncludeTax says whether
+	// catalogue prices already include VAT or have it added at the till.
+	VATRegistered    bool `gorm:"not null;default:false" json:"vat_registered"`
+	PricesIncludeTax bool `gorm:"not null;default:true" json:"prices_include_tax"`
+	// EtimsEnabled sends every sale to KRA eTIMS; it needs VAT registration
+	// and a KRA PIN
+	EtimsEnabled bool `gorm:"not null;default:false" json:"etims_enabled"`
+	// EtimsBranchID is the branch registered with KRA, "00" for the head
+	// office
+	EtimsBranchID string `gorm:"type:varchar(5);not null;default:'00'" json:"etims_branch_id"`
+	// Layaway rules. The first deposit must be at least
+	// LayawayMinDepositPercent of the order; a customer who cancels loses
+	// LayawayForfeitPercent of what they have paid. LayawayTermDays is how
+	// long they have to pay the order off.
+	LayawayMinDepositPercent float64 `gorm:"not null;default:10" json:"layaway_min_deposit_percent"`
+	LayawayForfeitPercent    float64 `gorm:"not null;default:10" json:"layaway_forfeit_percent"`
+	LayawayTermDays          int     `gorm:"not null;default:60" json:"layaway_term_days"`
+	// Loyalty programme. Customers earn LoyaltyPointsPerShilling points for
+	// every shilling spent, before any LoyaltyRule multipliers, and each
+	// point is worth LoyaltyPointValue shillings when redeemed. Points
+	// expire LoyaltyExpiryDays after they were earned, or never when it is
+	// zero, and at least LoyaltyMinRedeemPoints must be redeemed at a time.
+	LoyaltyEnabled           bool      `gorm:"not null;default:false" json:"loyalty_enabled"`
+	LoyaltyPointsPerShilling float64   `gorm:"not null;default:0.01" json:"loyalty_points_per_shilling"`
+	LoyaltyPointValue        float64   `gorm:"not null;default:1" json:"loyalty_point_value"`
+	LoyaltyExpiryDays        int       `gorm:"not null;default:365" json:"loyalty_expiry_days"`
+	LoyaltyMinRedeemPoints   int       `gorm:"not null;default:0" json:"loyalty_min_redeem_points"`
+	CreatedAt                time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt                time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// SearchSynonym is a group of interchangeable search words for a business,
+// such as "sukari, sugar", stored as a comma separated list.
+type SearchSynonym struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	BusinessID uint      `gorm:"not null;index" json:"business_id"`
+	Business   Business  `gorm:"foreignKey:BusinessID" json:"-"`
+	Words      string    `gorm:"type:text;not null" json:"words"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -13,6 +13,8 @@ type CreditCustomer struct {
 }
 type CreditTransaction struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
+	SaleID       *uint     `gorm:"index" json:"sale_id,omitempty"`
+	CustomerID   *uint     `gorm:"index" json:"customer_id,omitempty"`
 	ProductID    uint      `gorm:"not null" json:"product_id"`
 	Product      Product   `gorm:"foreignKey:ProductID" json:"-"`
 	Name         string    `gorm:"not null" json:"name"`
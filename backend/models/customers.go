@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Customer is a person the business sells to, known by their phone number.
+// Phone is kept in the 2547XXXXXXXX form so the same number written
+// different ways finds the same customer. A customer merged into another
+// keeps its row, pointing at the one it was merged into, so its phone
+// number still leads to the right customer.
+type Customer struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	BusinessID uint   `gorm:"not null;uniqueIndex:idx_customer_phone" json:"business_id"`
+	Name       string `gorm:"not null" json:"name"`
+	Phone      string `gorm:"type:varchar(12);not null;uniqueIndex:idx_customer_phone" json:"phone"`
+	Email      string `json:"email,omitempty"`
+	// KRAPin is the customer's KRA PIN for tax invoices
+	KRAPin string `gorm:"column:kra_pin;type:varchar(11)" json:"kra_pin,omitempty"`
+	Notes  string `gorm:"type:text" json:"notes,omitempty"`
+	// LoyaltyPoints is the customer's points balance, the sum of their
+	// LoyaltyEntry rows
+	LoyaltyPoints int        `gorm:"not null;default:0" json:"loyalty_points"`
+	MergedIntoID  *uint      `gorm:"index" json:"merged_into_id,omitempty"`
+	MergedAt      *time.Time `json:"merged_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
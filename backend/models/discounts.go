@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Discount types
+const (
+	DiscountPercent = "PERCENT"
+	DiscountFixed   = "FIXED"
+)
+
+// Discount reason codes
+const (
+	DiscountLoyalCustomer    = "LOYAL_CUSTOMER"
+	DiscountBulkPurchase     = "BULK_PURCHASE"
+	DiscountDamagedPackaging = "DAMAGED_PACKAGING"
+	DiscountPriceMatch       = "PRICE_MATCH"
+	DiscountStaff            = "STAFF"
+	DiscountOther            = "OTHER"
+)
+
+// ValidDiscountReason reports whether reason is a known discount reason code
+func ValidDiscountReason(reason string) bool {
+	switch reason {
+	case DiscountLoyalCustomer, DiscountBulkPurchase, DiscountDamagedPackaging,
+		DiscountPriceMatch, DiscountStaff, DiscountOther:
+		return true
+	}
+	return false
+}
+
+// DefaultDiscountLimits are the largest discounts, as a percentage of the
+// amount discounted, each role may give when the business has not set its
+// own.
+var DefaultDiscountLimits = map[string]float64{
+	RoleCashier:    5,
+	RoleSupervisor: 15,
+	RoleManager:    100,
+	RoleOwner:      100,
+}
+
+// DiscountLimit is a business's largest allowed discount for a role
+type DiscountLimit struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	BusinessID uint      `gorm:"not null;uniqueIndex:idx_discount_limit_role" json:"business_id"`
+	Role       string    `gorm:"type:enum('CASHIER','SUPERVISOR','MANAGER','OWNER');not null;uniqueIndex:idx_discount_limit_role" json:"role"`
+	MaxPercent float64   `gorm:"not null" json:"max_percent"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// eTIMS invoice statuses
+const (
+	EtimsStatusPending    = "PENDING"
+	EtimsStatusSubmitting = "SUBMITTING"
+	EtimsStatusSubmitted  = "SUBMITTED"
+	EtimsStatusFailed     = "FAILED"
+)
+
+// EtimsInvoice is a sale queued for submission to KRA eTIMS. Payload keeps
+// the invoice exactly as sent, so a retry sends the same invoice number and
+// eTIMS answers it with the original receipt instead of a second one.
+type EtimsInvoice struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	SaleID        uint   `gorm:"not null;uniqueIndex" json:"sale_id"`
+	BusinessID    uint   `gorm:"not null;index;uniqueIndex:idx_etims_invoice_number" json:"business_id"`
+	BranchID      string `gorm:"type:varchar(5);not null;default:'00';uniqueIndex:idx_etims_invoice_number" json:"branch_id"`
+	InvoiceNumber uint   `gorm:"not null;uniqueIndex:idx_etims_invoice_number" json:"invoice_number"`
+	Payload       string `gorm:"type:longtext" json:"payload,omitempty"`
+	Status        string `gorm:"type:enum('PENDING','SUBMITTING','SUBMITTED','FAILED');default:'PENDING';index" json:"status"`
+	Attempts      int    `gorm:"not null;default:0" json:"attempts"`
+	LastError     string `gorm:"type:text" json:"last_error,omitempty"`
+	// NextAttemptAt is when the worker may try again; it is cleared when
+	// eTIMS rejects the invoice, which then waits for a manual retry
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	// ClaimedAt is when a worker started submitting, so an attempt cut short
+	// by a crash can be picked up again
+	ClaimedAt        *time.Time `json:"claimed_at,omitempty"`
+	ReceiptNumber    int        `gorm:"not null;default:0" json:"receipt_number"`
+	InternalData     string     `json:"internal_data,omitempty"`
+	ReceiptSignature string     `json:"receipt_signature,omitempty"`
+	SDCID            string     `gorm:"column:sdc_id" json:"sdc_id,omitempty"`
+	MRCNumber        string     `gorm:"column:mrc_number" json:"mrc_number,omitempty"`
+	QRData           string     `gorm:"column:qr_data;type:text" json:"qr_data,omitempty"`
+	SubmittedAt      *time.Time `json:"submitted_at,omitempty"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// EtimsInvoiceCounter is the last invoice number a business's eTIMS branch
+// has given out. eTIMS expects each branch to number its invoices 1, 2,
+// 3... without gaps, so numbers are taken from here with the row locked, in
+// the same transaction as the sale.
+type EtimsInvoiceCounter struct {
+	BusinessID uint   `gorm:"primaryKey;autoIncrement:false" json:"business_id"`
+	BranchID   string `gorm:"type:varchar(5);primaryKey" json:"branch_id"`
+	LastNumber uint   `gorm:"not null;default:0" json:"last_number"`
+}
@@ -7,11 +7,33 @@ type Product struct {
 	Name        string    `gorm:"not null" json:"name"`
 	Description string    `gorm:"type:text" json:"description,omitempty"`
 	Category    string    `json:"category,omitempty"`
+	Aliases     string    `gorm:"type:text" json:"aliases,omitempty"`
 	Price       float64   `gorm:"not null" json:"price"`
+	CostPrice   float64   `gorm:"not null;default:0" json:"cost_price"`
 	Barcode     string    `gorm:"unique" json:"barcode,omitempty"`
 	PhotoPath   string    `json:"photo_path,omitempty"`
-	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	SupplierID  *uint     `json:"supplier_id,omitempty"`
+	Supplier    *Supplier `gorm:"foreignKey:SupplierID" json:"-"`
+	PackSize    int       `gorm:"not null;default:1" json:"pack_size"`
+	// TaxClassID is the product's VAT class; products without one are
+	// charged at the standard rate
+	TaxClassID *uint     `json:"tax_class_id,omitempty"`
+	TaxClass   *TaxClass `gorm:"foreignKey:TaxClassID" json:"-"`
+	// ItemClassCode is the KRA item classification code sent to eTIMS
+	ItemClassCode string    `gorm:"type:varchar(20)" json:"item_class_code,omitempty"`
+	Archived      bool      `gorm:"not null;default:false;index" json:"archived"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type Supplier struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"type:varchar(255);unique;not null" json:"name"`
+	Phone        string    `json:"phone,omitempty"`
+	Email        string    `json:"email,omitempty"`
+	LeadTimeDays int       `gorm:"not null;default:7" json:"lead_time_days"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
 type Inventory struct {
@@ -28,23 +50,59 @@ func (Inventory) TableName() string {
 	return "inventory"
 }
 
+// Stock movement change types
+const (
+	MovementSale       = "SALE"
+	MovementPurchase   = "PURCHASE"
+	MovementAdjustment = "ADJUSTMENT"
+	MovementWriteOff   = "WRITE_OFF"
+	MovementReturn     = "RETURN"
+)
+
 type StockMovement struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
 	ProductID      uint      `gorm:"not null" json:"product_id"`
 	Product        Product   `gorm:"foreignKey:ProductID" json:"-"`
-	ChangeType     string    `gorm:"type:enum('SALE','PURCHASE','ADJUSTMENT');not null" json:"change_type"`
+	ChangeType     string    `gorm:"type:enum('SALE','PURCHASE','ADJUSTMENT','WRITE_OFF','RETURN');not null" json:"change_type"`
 	QuantityChange int       `gorm:"not null" json:"quantity_change"`
 	Note           string    `gorm:"type:text" json:"note,omitempty"`
 	CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
+// Low stock alert statuses. An alert stays open (OPEN, ACKNOWLEDGED or
+// SNOOZED) until stock rises above the threshold or it is resolved by hand.
+const (
+	AlertStatusOpen         = "OPEN"
+	AlertStatusAcknowledged = "ACKNOWLEDGED"
+	AlertStatusSnoozed      = "SNOOZED"
+	AlertStatusResolved     = "RESOLVED"
+)
+
 type LowStockAlert struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	ProductID    uint      `gorm:"not null" json:"product_id"`
-	Product      Product   `gorm:"foreignKey:ProductID" json:"-"`
-	AlertMessage string    `gorm:"type:text;not null" json:"alert_message"`
-	Resolved     bool      `gorm:"default:false" json:"resolved"`
-	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	ProductID uint    `gorm:"not null;index" json:"product_id"`
+	Product   Product `gorm:"foreignKey:ProductID" json:"-"`
+	// OpenKey holds the product ID while the alert is unresolved and NULL
+	// afterwards, so the unique index allows only one open alert per product.
+	OpenKey           *uint      `gorm:"uniqueIndex" json:"-"`
+	AlertMessage      string     `gorm:"type:text;not null" json:"alert_message"`
+	Status            string     `gorm:"type:enum('OPEN','ACKNOWLEDGED','SNOOZED','RESOLVED');default:'OPEN'" json:"status"`
+	Resolved          bool       `gorm:"default:false" json:"resolved"`
+	LowestQuantity    int        `gorm:"not null;default:0" json:"lowest_quantity"`
+	AcknowledgedAt    *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedByID  *uint      `json:"acknowledged_by_id,omitempty"`
+	AcknowledgedBy    *User      `gorm:"foreignKey:AcknowledgedByID" json:"-"`
+	SnoozedUntil      *time.Time `json:"snoozed_until,omitempty"`
+	SnoozedByID       *uint      `json:"snoozed_by_id,omitempty"`
+	SnoozedBy         *User      `gorm:"foreignKey:SnoozedByID" json:"-"`
+	OutOfStockSince   *time.Time `json:"out_of_stock_since,omitempty"`
+	OutOfStockSeconds int64      `gorm:"not null;default:0" json:"out_of_stock_seconds"`
+	ResolvedAt        *time.Time `json:"resolved_at,omitempty"`
+	ResolvedByID      *uint      `json:"resolved_by_id,omitempty"`
+	ResolvedBy        *User      `gorm:"foreignKey:ResolvedByID" json:"-"`
+	ResolutionNote    string     `gorm:"type:text" json:"resolution_note,omitempty"`
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
 type Category struct {
@@ -54,4 +112,3 @@ type Category struct {
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
-
@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Layaway statuses
+const (
+	LayawayOpen      = "OPEN"
+	LayawayCompleted = "COMPLETED"
+	LayawayCancelled = "CANCELLED"
+)
+
+// Layaway payment kinds
+const (
+	LayawayDeposit = "DEPOSIT"
+	LayawayRefund  = "REFUND"
+)
+
+// Layaway is an order the customer pays off in instalments, lipa pole pole,
+// before collecting the goods. Its stock is reserved while it is open and
+// sold as a normal sale once the balance is cleared. The reservation lapses
+// on DueDate; an overdue layaway can still be paid off if the stock is
+// there. Payload keeps the order as requested.
+type Layaway struct {
+	ID            uint    `gorm:"primaryKey" json:"id"`
+	LayawayNumber string  `gorm:"type:varchar(40);uniqueIndex;not null" json:"layaway_number"`
+	BusinessID    uint    `gorm:"not null;index" json:"business_id"`
+	CreatedByID   uint    `gorm:"not null" json:"created_by_id"`
+	CustomerName  string  `gorm:"not null" json:"customer_name"`
+	CustomerPhone string  `gorm:"not null;index" json:"customer_phone"`
+	Status        string  `gorm:"type:enum('OPEN','COMPLETED','CANCELLED');default:'OPEN';index" json:"status"`
+	Payload       string  `gorm:"type:longtext" json:"-"`
+	Subtotal      float64 `gorm:"not null;default:0" json:"subtotal"`
+	DiscountTotal float64 `gorm:"not null;default:0" json:"discount_total"`
+	TaxTotal      float64 `gorm:"not null;default:0" json:"tax_total"`
+	TotalAmount   float64 `gorm:"not null;default:0" json:"total_amount"`
+	// AmountPaid is the deposits less any refund
+	AmountPaid float64   `gorm:"not null;default:0" json:"amount_paid"`
+	BalanceDue float64   `gorm:"not null;default:0" json:"balance_due"`
+	DueDate    time.Time `gorm:"not null;index" json:"due_date"`
+	// SaleID is the sale recorded when the goods were collected
+	SaleID        *uint      `json:"sale_id,omitempty"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	CancelledByID *uint      `json:"cancelled_by_id,omitempty"`
+	CancelReason  string     `gorm:"type:text" json:"cancel_reason,omitempty"`
+	// ForfeitedAmount is what the business kept of the deposits when the
+	// layaway was cancelled; the rest was refunded
+	ForfeitedAmount float64          `gorm:"not null;default:0" json:"forfeited_amount"`
+	RefundedAmount  float64          `gorm:"not null;default:0" json:"refunded_amount"`
+	Items           []LayawayItem    `gorm:"foreignKey:LayawayID" json:"items"`
+	Payments        []LayawayPayment `gorm:"foreignKey:LayawayID" json:"payments"`
+	CreatedAt       time.Time        `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt       time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// LayawayItem is one line of a layaway order priced as agreed when it was
+// opened, VAT included, so the sale that completes it charges the same
+// whatever has changed since. Lines from before the VAT was kept have a
+// zero GrossAmount.
+type LayawayItem struct {
+	ID            uint    `gorm:"primaryKey" json:"id"`
+	LayawayID     uint    `gorm:"not null;index" json:"layaway_id"`
+	ProductID     uint    `gorm:"not null;index" json:"product_id"`
+	ProductName   string  `json:"product_name"`
+	Quantity      int     `gorm:"not null" json:"quantity"`
+	ListPrice     float64 `gorm:"not null;default:0" json:"list_price"`
+	UnitPrice     float64 `gorm:"not null;default:0" json:"unit_price"`
+	GrossAmount   float64 `gorm:"not null;default:0" json:"gross_amount"`
+	Discount      float64 `gorm:"not null;default:0" json:"discount"`
+	TaxCode       string  `gorm:"type:varchar(10)" json:"tax_code,omitempty"`
+	TaxRate       float64 `gorm:"not null;default:0" json:"tax_rate"`
+	TaxableAmount float64 `gorm:"not null;default:0" json:"taxable_amount"`
+	TaxAmount     float64 `gorm:"not null;default:0" json:"tax_amount"`
+	LineTotal     float64 `gorm:"not null;default:0" json:"line_total"`
+}
+
+// LayawayPayment is a deposit towards a layaway, or the refund paid out
+// when it was cancelled. ShiftID is the till shift that took or paid out
+// the money.
+type LayawayPayment struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	LayawayID    uint      `gorm:"not null;index" json:"layaway_id"`
+	BusinessID   uint      `gorm:"not null;index" json:"business_id"`
+	ShiftID      *uint     `gorm:"index" json:"shift_id,omitempty"`
+	Kind         string    `gorm:"type:enum('DEPOSIT','REFUND');default:'DEPOSIT'" json:"kind"`
+	Method       string    `gorm:"type:enum('CASH','MPESA');not null" json:"method"`
+	Amount       float64   `gorm:"not null" json:"amount"`
+	Reference    string    `json:"reference,omitempty"`
+	ReceivedByID uint      `gorm:"not null" json:"received_by_id"`
+	CreatedAt    time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
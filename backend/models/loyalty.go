@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Loyalty rule kinds. A CATEGORY rule multiplies the points earned on one
+// product category; WEEKDAY and DATE rules multiply everything earned on a
+// day of the week or a single date.
+const (
+	LoyaltyRuleCategory = "CATEGORY"
+	LoyaltyRuleWeekday  = "WEEKDAY"
+	LoyaltyRuleDate     = "DATE"
+)
+
+// Loyalty ledger entry kinds
+const (
+	LoyaltyEarn    = "EARN"
+	LoyaltyRedeem  = "REDEEM"
+	LoyaltyExpire  = "EXPIRE"
+	LoyaltyReverse = "REVERSE"
+	LoyaltyRefund  = "REFUND"
+	LoyaltyAdjust  = "ADJUST"
+)
+
+// LoyaltyRule is an earning multiplier, such as double points on
+// household goods or on Saturdays
+type LoyaltyRule struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	BusinessID uint   `gorm:"not null;index" json:"business_id"`
+	Kind       string `gorm:"type:enum('CATEGORY','WEEKDAY','DATE');not null" json:"kind"`
+	Label      string `json:"label,omitempty"`
+	Category   string `json:"category,omitempty"`
+	// Weekday counts from Sunday, 0, to Saturday, 6
+	Weekday     *int       `json:"weekday,omitempty"`
+	Date        *time.Time `gorm:"type:date" json:"date,omitempty"`
+	Multiplier  float64    `gorm:"not null" json:"multiplier"`
+	Active      bool       `gorm:"not null;default:true;index" json:"active"`
+	CreatedByID uint       `json:"created_by_id"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// LoyaltyEntry is one change to a customer's points. Points is positive for
+// points added and negative for points taken off. Added points are used up
+// oldest first: Remaining is how many of them are left to redeem, and they
+// expire at ExpiresAt.
+type LoyaltyEntry struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	BusinessID   uint       `gorm:"not null;index" json:"business_id"`
+	CustomerID   uint       `gorm:"not null;index" json:"customer_id"`
+	Kind         string     `gorm:"type:enum('EARN','REDEEM','EXPIRE','REVERSE','REFUND','ADJUST');not null" json:"kind"`
+	Points       int        `gorm:"not null" json:"points"`
+	Remaining    int        `gorm:"not null;default:0" json:"remaining"`
+	ExpiresAt    *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	SaleID       *uint      `gorm:"index" json:"sale_id,omitempty"`
+	SaleReturnID *uint      `json:"sale_return_id,omitempty"`
+	Note         string     `json:"note,omitempty"`
+	CreatedByID  *uint      `json:"created_by_id,omitempty"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime;index" json:"created_at"`
+}
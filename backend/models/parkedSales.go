@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Parked sale statuses
+const (
+	ParkedSaleParked    = "PARKED"
+	ParkedSaleResumed   = "RESUMED"
+	ParkedSaleCompleted = "COMPLETED"
+	ParkedSaleCancelled = "CANCELLED"
+	ParkedSaleExpired   = "EXPIRED"
+)
+
+// ParkedSale is a basket put aside at the counter to be finished later.
+// Payload keeps the sale request as it was when parked. While it is parked
+// or resumed and before ExpiresAt, a sale parked with Reserved holds its
+// items' stock back from other sales.
+type ParkedSale struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	BusinessID uint   `gorm:"not null;index" json:"business_id"`
+	CashierID  uint   `gorm:"not null;index" json:"cashier_id"`
+	Till       string `gorm:"size:50;index" json:"till"`
+	// Label helps the cashier find the basket again, such as the customer's
+	// name or what they went back for
+	Label       string           `gorm:"size:100" json:"label"`
+	Payload     string           `gorm:"type:longtext" json:"-"`
+	Total       float64          `gorm:"not null;default:0" json:"total"`
+	Reserved    bool             `gorm:"not null;default:false" json:"reserved"`
+	Status      string           `gorm:"type:enum('PARKED','RESUMED','COMPLETED','CANCELLED','EXPIRED');default:'PARKED';index" json:"status"`
+	ExpiresAt   time.Time        `gorm:"not null;index" json:"expires_at"`
+	ResumedByID *uint            `json:"resumed_by_id,omitempty"`
+	ResumedAt   *time.Time       `json:"resumed_at,omitempty"`
+	SaleID      *uint            `json:"sale_id,omitempty"`
+	ClosedAt    *time.Time       `json:"closed_at,omitempty"`
+	Items       []ParkedSaleItem `gorm:"foreignKey:ParkedSaleID" json:"items"`
+	CreatedAt   time.Time        `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// ParkedSaleItem is one line of a parked basket and the stock it reserves
+type ParkedSaleItem struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	ParkedSaleID uint   `gorm:"not null;index" json:"parked_sale_id"`
+	ProductID    uint   `gorm:"not null;index" json:"product_id"`
+	ProductName  string `json:"product_name"`
+	Quantity     int    `gorm:"not null" json:"quantity"`
+}
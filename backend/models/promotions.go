@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Promotion lowers the selling price of a product, or of every product in a
+// category, for a period of time. It either takes a percentage off the
+// catalogue price or sets a fixed promotional price.
+type Promotion struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	BusinessID  uint       `gorm:"index" json:"business_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	ProductID   *uint      `gorm:"index" json:"product_id,omitempty"`
+	Product     *Product   `gorm:"foreignKey:ProductID" json:"-"`
+	Category    string     `json:"category,omitempty"`
+	PercentOff  float64    `gorm:"not null;default:0" json:"percent_off"`
+	FixedPrice  *float64   `json:"fixed_price,omitempty"`
+	MinQuantity int        `gorm:"not null;default:1" json:"min_quantity"`
+	StartsAt    time.Time  `gorm:"not null;index" json:"starts_at"`
+	EndsAt      *time.Time `gorm:"index" json:"ends_at,omitempty"`
+	CreatedByID *uint      `json:"created_by_id,omitempty"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Applies reports whether the promotion covers quantity units of product at t
+func (p Promotion) Applies(product Product, quantity int, t time.Time) bool {
+	if t.Before(p.StartsAt) || (p.EndsAt != nil && !t.Before(*p.EndsAt)) {
+		return false
+	}
+	if quantity < p.MinQuantity {
+		return false
+	}
+	if p.ProductID != nil {
+		return *p.ProductID == product.ID
+	}
+	return p.Category != "" && p.Category == product.Category
+}
+
+// UnitPrice is the promotional price for a product listed at price
+func (p Promotion) UnitPrice(price float64) float64 {
+	if p.FixedPrice != nil {
+		return *p.FixedPrice
+	}
+	return price * (1 - p.PercentOff/100)
+}
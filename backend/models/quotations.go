@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Quotation statuses
+const (
+	QuotationDraft     = "DRAFT"
+	QuotationSent      = "SENT"
+	QuotationAccepted  = "ACCEPTED"
+	QuotationRejected  = "REJECTED"
+	QuotationExpired   = "EXPIRED"
+	QuotationConverted = "CONVERTED"
+)
+
+// Quotation is a priced offer to a customer, also printed as a proforma
+// invoice. Payload keeps the request it was priced from, so converting it
+// to a sale asks for the same items and discounts and the till can check
+// the prices and stock again.
+type Quotation struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	QuoteNumber   string `gorm:"type:varchar(40);uniqueIndex;not null" json:"quote_number"`
+	BusinessID    uint   `gorm:"not null;index" json:"business_id"`
+	CreatedByID   uint   `gorm:"not null" json:"created_by_id"`
+	CustomerName  string `gorm:"not null" json:"customer_name"`
+	CustomerPhone string `json:"customer_phone,omitempty"`
+	CustomerEmail string `json:"customer_email,omitempty"`
+	CustomerPIN   string `gorm:"column:customer_pin;type:varchar(11)" json:"customer_pin,omitempty"`
+	Notes         string `gorm:"type:text" json:"notes,omitempty"`
+	Status        string `gorm:"type:enum('DRAFT','SENT','ACCEPTED','REJECTED','EXPIRED','CONVERTED');default:'DRAFT';index" json:"status"`
+	// ValidUntil is the last day the quoted prices hold
+	ValidUntil    time.Time       `gorm:"not null;index" json:"valid_until"`
+	Subtotal      float64         `gorm:"not null;default:0" json:"subtotal"`
+	DiscountTotal float64         `gorm:"not null;default:0" json:"discount_total"`
+	TaxTotal      float64         `gorm:"not null;default:0" json:"tax_total"`
+	TotalAmount   float64         `gorm:"not null;default:0" json:"total_amount"`
+	Payload       string          `gorm:"type:longtext" json:"-"`
+	SentAt        *time.Time      `json:"sent_at,omitempty"`
+	AcceptedAt    *time.Time      `json:"accepted_at,omitempty"`
+	RejectedAt    *time.Time      `json:"rejected_at,omitempty"`
+	SaleID        *uint           `json:"sale_id,omitempty"`
+	ConvertedAt   *time.Time      `json:"converted_at,omitempty"`
+	Items         []QuotationItem `gorm:"foreignKey:QuotationID" json:"items"`
+	CreatedAt     time.Time       `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt     time.Time       `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// QuotationItem is one priced line of a quotation
+type QuotationItem struct {
+	ID            uint    `gorm:"primaryKey" json:"id"`
+	QuotationID   uint    `gorm:"not null;index" json:"quotation_id"`
+	ProductID     uint    `gorm:"not null;index" json:"product_id"`
+	ProductName   string  `json:"product_name"`
+	Quantity      int     `gorm:"not null" json:"quantity"`
+	UnitPrice     float64 `gorm:"not null;default:0" json:"unit_price"`
+	GrossAmount   float64 `gorm:"not null;default:0" json:"gross_amount"`
+	Discount      float64 `gorm:"not null;default:0" json:"discount"`
+	TaxCode       string  `gorm:"type:varchar(10)" json:"tax_code,omitempty"`
+	TaxRate       float64 `gorm:"not null;default:0" json:"tax_rate"`
+	TaxableAmount float64 `gorm:"not null;default:0" json:"taxable_amount"`
+	TaxAmount     float64 `gorm:"not null;default:0" json:"tax_amount"`
+	LineTotal     float64 `gorm:"not null;default:0" json:"line_total"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Receipt formats
+const (
+	ReceiptPDFA4      = "PDF_A4"
+	ReceiptPDF80mm    = "PDF_80MM"
+	ReceiptESCPOS58mm = "ESCPOS_58MM"
+	ReceiptESCPOS80mm = "ESCPOS_80MM"
+	ReceiptSMS        = "SMS"
+)
+
+// ReceiptPrint records each receipt issued for a sale. The first printed
+// receipt is the original; every later one is a numbered duplicate.
+type ReceiptPrint struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	SaleID      uint   `gorm:"not null;index" json:"sale_id"`
+	BusinessID  uint   `gorm:"not null;index" json:"business_id"`
+	Format      string `gorm:"type:varchar(20);not null" json:"format"`
+	Copy        int    `gorm:"not null;default:0" json:"copy"`
+	PrintedByID *uint  `json:"printed_by_id,omitempty"`
+	// Reason is why a duplicate was needed
+	Reason    string    `gorm:"type:text" json:"reason,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
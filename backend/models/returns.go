@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// What happens to returned goods
+const (
+	ReturnRestock  = "RESTOCK"
+	ReturnWriteOff = "WRITE_OFF"
+)
+
+// How money is given back for a return. POINTS puts the value back on the
+// customer's loyalty account.
+const (
+	RefundCash          = "CASH"
+	RefundMpesaReversal = "MPESA_REVERSAL"
+	RefundCreditNote    = "CREDIT_NOTE"
+	RefundPoints        = "POINTS"
+)
+
+// Refund statuses. M-Pesa reversals stay pending until the reversal has
+// been made and its reference recorded.
+const (
+	RefundPending   = "PENDING"
+	RefundCompleted = "COMPLETED"
+)
+
+// SaleReturn records goods brought back against a sale, or a whole sale
+// voided, and the refund given for them.
+type SaleReturn struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	ReturnNumber  string `gorm:"type:varchar(40);uniqueIndex;not null" json:"return_number"`
+	BusinessID    uint   `gorm:"index" json:"business_id"`
+	SaleID        uint   `gorm:"not null;index" json:"sale_id"`
+	Sale          Sale   `gorm:"foreignKey:SaleID" json:"-"`
+	Void          bool   `gorm:"not null;default:false" json:"void"`
+	Reason        string `gorm:"type:text" json:"reason,omitempty"`
+	ProcessedByID uint   `gorm:"not null" json:"processed_by_id"`
+	ProcessedBy   User   `gorm:"foreignKey:ProcessedByID" json:"-"`
+	// ShiftID is the shift whose drawer paid out any cash refund
+	ShiftID *uint `gorm:"index" json:"shift_id,omitempty"`
+	// ReturnedAmount is the value of the goods returned; part of it may be
+	// taken off an unpaid balance rather than refunded
+	ReturnedAmount  float64          `gorm:"not null" json:"returned_amount"`
+	RefundAmount    float64          `gorm:"not null;default:0" json:"refund_amount"`
+	RefundMethod    *string          `gorm:"type:enum('CASH','MPESA_REVERSAL','CREDIT_NOTE','POINTS')" json:"refund_method,omitempty"`
+	RefundStatus    string           `gorm:"type:enum('PENDING','COMPLETED');default:'COMPLETED'" json:"refund_status"`
+	RefundReference string           `json:"refund_reference,omitempty"`
+	RefundedAt      *time.Time       `json:"refunded_at,omitempty"`
+	Items           []SaleReturnItem `gorm:"foreignKey:SaleReturnID" json:"items"`
+	CreatedAt       time.Time        `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt       time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// SaleReturnItem is one returned sale line
+type SaleReturnItem struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	SaleReturnID    uint           `gorm:"not null;index" json:"sale_return_id"`
+	SaleItemID      uint           `gorm:"not null;index" json:"sale_item_id"`
+	ProductID       uint           `gorm:"not null;index" json:"product_id"`
+	ProductName     string         `json:"product_name"`
+	Quantity        int            `gorm:"not null" json:"quantity"`
+	Amount          float64        `gorm:"not null" json:"amount"`
+	Disposition     string         `gorm:"type:enum('RESTOCK','WRITE_OFF');not null" json:"disposition"`
+	StockMovementID *uint          `json:"stock_movement_id,omitempty"`
+	StockMovement   *StockMovement `gorm:"foreignKey:StockMovementID" json:"-"`
+	WriteOffID      *uint          `json:"write_off_id,omitempty"`
+	WriteOff        *StockWriteOff `gorm:"foreignKey:WriteOffID" json:"-"`
+	CreatedAt       time.Time      `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// CreditNote is store credit given instead of cash for a return
+type CreditNote struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Number        string    `gorm:"type:varchar(40);uniqueIndex;not null" json:"number"`
+	BusinessID    uint      `gorm:"index" json:"business_id"`
+	SaleReturnID  uint      `gorm:"not null;index" json:"sale_return_id"`
+	CustomerName  string    `json:"customer_name,omitempty"`
+	CustomerPhone string    `gorm:"index" json:"customer_phone,omitempty"`
+	Amount        float64   `gorm:"not null" json:"amount"`
+	Balance       float64   `gorm:"not null" json:"balance"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
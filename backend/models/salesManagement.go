@@ -2,6 +2,8 @@ package models
 
 import "time"
 
+// SalesTransaction is the original one-row-per-product sale record. Rows
+// are migrated into Sale and SaleItem and no new ones are written.
 type SalesTransaction struct {
 	ID              uint      `gorm:"primaryKey" json:"id"`
 	ProductID       uint      `gorm:"not null" json:"product_id"`
@@ -16,8 +18,171 @@ type SalesTransaction struct {
 	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
+// Sale payment statuses
+const (
+	PaymentStatusPaid    = "PAID"
+	PaymentStatusPartial = "PARTIAL"
+	PaymentStatusUnpaid  = "UNPAID"
+)
+
+// Payment methods. A sale paid with more than one kind of tender is recorded
+// as SPLIT and its payments list the amounts.
+const (
+	PaymentCash   = "CASH"
+	PaymentMpesa  = "MPESA"
+	PaymentCredit = "CREDIT"
+	PaymentPoints = "POINTS"
+	// PaymentCreditNote spends store credit from a credit note
+	PaymentCreditNote = "CREDIT_NOTE"
+	PaymentSplit      = "SPLIT"
+)
+
+// Sale statuses
+const (
+	SaleStatusCompleted         = "COMPLETED"
+	SaleStatusPartiallyReturned = "PARTIALLY_RETURNED"
+	SaleStatusReturned          = "RETURNED"
+	SaleStatusVoided            = "VOIDED"
+)
+
+// Sale is one customer transaction at the till, with its products as items
+type Sale struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	SaleNumber string `gorm:"type:varchar(40);uniqueIndex;not null" json:"sale_number"`
+	BusinessID uint   `gorm:"index" json:"business_id"`
+	CashierID  *uint  `gorm:"index" json:"cashier_id,omitempty"`
+	Cashier    *User  `gorm:"foreignKey:CashierID" json:"-"`
+	// ShiftID is the till shift the sale was rung up in
+	ShiftID *uint `gorm:"index" json:"shift_id,omitempty"`
+	// LayawayID is the layaway order the sale completed; its money was
+	// taken as the layaway's deposits
+	LayawayID *uint `gorm:"index" json:"layaway_id,omitempty"`
+	// CustomerID is the customer directory entry for the buyer. The name
+	// and phone number are copied as they were given at the sale.
+	CustomerID    *uint  `gorm:"index" json:"customer_id,omitempty"`
+	CustomerName  string `json:"customer_name,omitempty"`
+	CustomerPhone string `json:"customer_phone,omitempty"`
+	// CustomerPIN is the buyer's KRA PIN, printed on the tax invoice
+	CustomerPIN     string `gorm:"column:customer_pin;type:varchar(11)" json:"customer_pin,omitempty"`
+	PaymentMethod   string `gorm:"type:enum('CASH','MPESA','CREDIT','POINTS','CREDIT_NOTE','SPLIT');not null" json:"payment_method"`
+	ReferenceNumber string `json:"reference_number,omitempty"`
+	// Subtotal is the gross amount before any discount. DiscountTotal adds up
+	// the line discounts and the basket discount.
+	Subtotal             float64 `gorm:"not null;default:0" json:"subtotal"`
+	DiscountTotal        float64 `gorm:"not null;default:0" json:"discount_total"`
+	BasketDiscount       float64 `gorm:"not null;default:0" json:"basket_discount"`
+	BasketDiscountType   string  `gorm:"type:varchar(20)" json:"basket_discount_type,omitempty"`
+	BasketDiscountValue  float64 `gorm:"not null;default:0" json:"basket_discount_value"`
+	BasketDiscountReason string  `gorm:"type:varchar(30)" json:"basket_discount_reason,omitempty"`
+	// DiscountApprovedByID is the manager who allowed a discount above the
+	// cashier's limit
+	DiscountApprovedByID *uint   `json:"discount_approved_by_id,omitempty"`
+	TaxTotal             float64 `gorm:"not null;default:0" json:"tax_total"`
+	TotalAmount          float64 `gorm:"not null;default:0" json:"total_amount"`
+	AmountPaid           float64 `gorm:"not null;default:0" json:"amount_paid"`
+	BalanceDue           float64 `gorm:"not null;default:0" json:"balance_due"`
+	// ChangeDue is the cash handed back when the customer overpaid in cash
+	ChangeDue float64 `gorm:"not null;default:0" json:"change_due"`
+	// ReturnedTotal is the value of goods returned; RefundedTotal is the money
+	// given back for them
+	ReturnedTotal float64    `gorm:"not null;default:0" json:"returned_total"`
+	RefundedTotal float64    `gorm:"not null;default:0" json:"refunded_total"`
+	PaymentStatus string     `gorm:"type:enum('PAID','PARTIAL','UNPAID');default:'PAID'" json:"payment_status"`
+	Status        string     `gorm:"type:enum('COMPLETED','PARTIALLY_RETURNED','RETURNED','VOIDED');default:'COMPLETED'" json:"status"`
+	VoidedAt      *time.Time `json:"voided_at,omitempty"`
+	VoidedByID    *uint      `json:"voided_by_id,omitempty"`
+	// IdempotencyKey is the client's key for the submission, so a retried
+	// request gets back the sale already recorded. RequestHash tells a
+	// retry apart from a different sale sent with the same key.
+	IdempotencyKey *string `gorm:"type:varchar(64);uniqueIndex" json:"idempotency_key,omitempty"`
+	RequestHash    string  `gorm:"type:varchar(64)" json:"-"`
+	// SyncedAt is when a sale recorded offline reached the server;
+	// CreatedAt keeps the time it was made at the till
+	SyncedAt *time.Time `json:"synced_at,omitempty"`
+	// The eTIMS fields are filled in once KRA has signed the invoice.
+	// EtimsInvoiceNumber is the control unit invoice number and
+	// EtimsSignature the receipt signature printed on the receipt.
+	EtimsInvoiceNumber string        `json:"etims_invoice_number,omitempty"`
+	EtimsInternalData  string        `json:"etims_internal_data,omitempty"`
+	EtimsSignature     string        `json:"etims_signature,omitempty"`
+	EtimsQRData        string        `gorm:"column:etims_qr_data;type:text" json:"etims_qr_data,omitempty"`
+	EtimsSubmittedAt   *time.Time    `json:"etims_submitted_at,omitempty"`
+	Items              []SaleItem    `gorm:"foreignKey:SaleID" json:"items"`
+	Payments           []SalePayment `gorm:"foreignKey:SaleID" json:"payments"`
+	CreatedAt          time.Time     `gorm:"autoCreateTime;index" json:"created_at"`
+	UpdatedAt          time.Time     `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// SaleItem is one product line on a sale. The product name and unit price
+// are copied at the time of sale so receipts and reports do not change when
+// the catalogue does.
+type SaleItem struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	SaleID      uint    `gorm:"not null;index" json:"sale_id"`
+	ProductID   uint    `gorm:"not null;index" json:"product_id"`
+	Product     Product `gorm:"foreignKey:ProductID" json:"-"`
+	ProductName string  `json:"product_name"`
+	Quantity    int     `gorm:"not null" json:"quantity"`
+	// ReturnedQuantity counts units brought back or voided
+	ReturnedQuantity int `gorm:"not null;default:0" json:"returned_quantity"`
+	// ListPrice is the catalogue price when the item was sold
+	ListPrice   float64 `gorm:"not null;default:0" json:"list_price"`
+	UnitPrice   float64 `gorm:"not null;default:0" json:"unit_price"`
+	PromotionID *uint   `json:"promotion_id,omitempty"`
+	// PriceOverridden is set when staff changed the price at the till
+	PriceOverridden bool   `gorm:"not null;default:false" json:"price_overridden"`
+	OverrideReason  string `gorm:"type:text" json:"override_reason,omitempty"`
+	OverriddenByID  *uint  `json:"overridden_by_id,omitempty"`
+	// GrossAmount is the unit price times quantity. Discount is the line's
+	// own discount and BasketDiscount its share of the basket discount;
+	// LineTotal is what is left.
+	GrossAmount    float64 `gorm:"not null;default:0" json:"gross_amount"`
+	Discount       float64 `gorm:"not null;default:0" json:"discount"`
+	DiscountType   string  `gorm:"type:varchar(20)" json:"discount_type,omitempty"`
+	DiscountValue  float64 `gorm:"not null;default:0" json:"discount_value"`
+	DiscountReason string  `gorm:"type:varchar(30)" json:"discount_reason,omitempty"`
+	BasketDiscount float64 `gorm:"not null;default:0" json:"basket_discount"`
+	// TaxCode and TaxRate copy the product's tax class at the time of sale.
+	// TaxableAmount is the line total net of VAT.
+	TaxCode       string  `gorm:"type:varchar(10)" json:"tax_code,omitempty"`
+	TaxRate       float64 `gorm:"not null;default:0" json:"tax_rate"`
+	TaxableAmount float64 `gorm:"not null;default:0" json:"taxable_amount"`
+	TaxAmount     float64 `gorm:"not null;default:0" json:"tax_amount"`
+	LineTotal     float64 `gorm:"not null" json:"line_total"`
+	// UnitCost copies the product's cost price at the time of sale so profit
+	// reports are not changed by later cost updates. It is only nil on items
+	// sold before costs were kept, until the migration fills it in.
+	UnitCost *float64 `json:"unit_cost,omitempty"`
+	Note     string   `gorm:"type:text" json:"note,omitempty"`
+	// LegacyTransactionID links items migrated from SalesTransaction
+	LegacyTransactionID *uint     `gorm:"uniqueIndex" json:"-"`
+	CreatedAt           time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// SalePayment is one tender on a sale. Amount is what it paid towards the
+// sale; Tendered is what the customer handed over, which is more than Amount
+// when cash change was given. A CREDIT payment is the part left owing and a
+// POINTS payment the value of loyalty points redeemed. A CREDIT_NOTE
+// payment's Reference is the number of the credit note it was taken from.
+type SalePayment struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	SaleID    uint    `gorm:"not null;index" json:"sale_id"`
+	Method    string  `gorm:"type:enum('CASH','MPESA','CREDIT','POINTS','CREDIT_NOTE');not null" json:"method"`
+	Amount    float64 `gorm:"not null" json:"amount"`
+	Tendered  float64 `gorm:"not null;default:0" json:"tendered"`
+	Reference string  `json:"reference,omitempty"`
+	// MpesaTransactionID is the confirmed STK push that paid an MPESA
+	// tender; each push pays for one tender only
+	MpesaTransactionID *string   `gorm:"type:varchar(36);uniqueIndex" json:"mpesa_transaction_id,omitempty"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// MpesaTransaction is an STK push, recorded as PENDING when it is started
+// and completed by M-Pesa's callback. BusinessID is the business that
+// started it; ReceiptNumber stays empty until the payment succeeds.
 type MpesaTransaction struct {
 	ID                string `gorm:"primaryKey;type:varchar(36)"`
+	BusinessID        uint   `gorm:"index"`
 	MerchantRequestID string `gorm:"uniqueIndex;type:varchar(50)"`
 	CheckoutRequestID string `gorm:"uniqueIndex;type:varchar(50)"`
 	ResultCode        int
@@ -25,7 +190,7 @@ type MpesaTransaction struct {
 	PhoneNumber       string    `gorm:"type:varchar(15)"`
 	Reference         string    `gorm:"type:varchar(50)"`
 	Description       string    `gorm:"type:varchar(100)"`
-	ReceiptNumber     string    `gorm:"uniqueIndex;type:varchar(50)"`
+	ReceiptNumber     *string   `gorm:"uniqueIndex;type:varchar(50)"`
 	TransactionDate   string    `gorm:"type:varchar(20)"`
 	Status            string    `gorm:"type:varchar(20)"`
 	CreatedAt         time.Time `gorm:"autoCreateTime"`
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Shift statuses
+const (
+	ShiftOpen   = "OPEN"
+	ShiftClosed = "CLOSED"
+)
+
+// Cash drawer movement types
+const (
+	CashIn  = "CASH_IN"
+	CashOut = "CASH_OUT"
+)
+
+// Shift is one cashier's session at the till, from opening the drawer with
+// a float to counting it at close. ExpectedCash and Variance are worked out
+// from the shift's records when it closes.
+type Shift struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	BusinessID   uint       `gorm:"not null;index" json:"business_id"`
+	CashierID    uint       `gorm:"not null;index" json:"cashier_id"`
+	Cashier      User       `gorm:"foreignKey:CashierID" json:"-"`
+	Status       string     `gorm:"type:enum('OPEN','CLOSED');default:'OPEN';index" json:"status"`
+	OpeningFloat float64    `gorm:"not null;default:0" json:"opening_float"`
+	OpenedAt     time.Time  `gorm:"not null;index" json:"opened_at"`
+	OpenNote     string     `gorm:"type:text" json:"open_note,omitempty"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	ClosedByID   *uint      `json:"closed_by_id,omitempty"`
+	CloseNote    string     `gorm:"type:text" json:"close_note,omitempty"`
+	CountedCash  *float64   `json:"counted_cash,omitempty"`
+	ExpectedCash float64    `gorm:"not null;default:0" json:"expected_cash"`
+	// Variance is counted less expected cash: negative when the drawer is short
+	Variance  float64   `gorm:"not null;default:0" json:"variance"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// CashMovement is cash put into or taken out of the drawer other than for a
+// sale or refund, such as paying a supplier on delivery
+type CashMovement struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ShiftID      uint      `gorm:"not null;index" json:"shift_id"`
+	BusinessID   uint      `gorm:"not null;index" json:"business_id"`
+	Type         string    `gorm:"type:enum('CASH_IN','CASH_OUT');not null" json:"type"`
+	Amount       float64   `gorm:"not null" json:"amount"`
+	Reason       string    `gorm:"type:text;not null" json:"reason"`
+	SupplierID   *uint     `json:"supplier_id,omitempty"`
+	RecordedByID uint      `gorm:"not null" json:"recorded_by_id"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
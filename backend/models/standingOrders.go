@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// Standing order statuses
+const (
+	StandingOrderActive = "ACTIVE"
+	StandingOrderPaused = "PAUSED"
+	StandingOrderEnded  = "ENDED"
+)
+
+// Standing order run statuses. A CREATED run made its sale; a SKIPPED run
+// was skipped on request; a FAILED run could not be sold, usually for lack
+// of stock, and can be tried again.
+const (
+	StandingRunCreated = "CREATED"
+	StandingRunSkipped = "SKIPPED"
+	StandingRunFailed  = "FAILED"
+)
+
+// StandingOrder is a delivery a customer takes on a schedule, such as milk
+// every morning. On each due date a sale is rung up for its items and paid
+// with PaymentMethod; CREDIT puts it on the customer's account.
+//
+// The schedule repeats every Interval days, weeks or months from StartDate.
+// Weekdays lists the days of a weekly order as comma separated numbers from
+// 0, Sunday, to 6, Saturday. NextRunDate is the next date a sale is due.
+type StandingOrder struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	OrderNumber   string     `gorm:"type:varchar(40);uniqueIndex;not null" json:"order_number"`
+	BusinessID    uint       `gorm:"not null;index" json:"business_id"`
+	CustomerID    uint       `gorm:"not null;index" json:"customer_id"`
+	Customer      *Customer  `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
+	CreatedByID   uint       `gorm:"not null" json:"created_by_id"`
+	PaymentMethod string     `gorm:"type:enum('CASH','MPESA','CREDIT');not null" json:"payment_method"`
+	Frequency     string     `gorm:"type:enum('DAILY','WEEKLY','MONTHLY');not null" json:"frequency"`
+	Interval      int        `gorm:"column:repeat_interval;not null;default:1" json:"interval"`
+	Weekdays      string     `gorm:"type:varchar(20)" json:"weekdays,omitempty"`
+	StartDate     time.Time  `gorm:"type:date;not null" json:"start_date"`
+	EndDate       *time.Time `gorm:"type:date" json:"end_date,omitempty"`
+	NextRunDate   *time.Time `gorm:"type:date;index" json:"next_run_date,omitempty"`
+	Status        string     `gorm:"type:enum('ACTIVE','PAUSED','ENDED');default:'ACTIVE';index" json:"status"`
+	// PausedUntil is the last day of a pause; the order resumes by itself
+	// the day after. A pause without it lasts until the order is resumed.
+	PausedUntil     *time.Time          `gorm:"type:date" json:"paused_until,omitempty"`
+	DeliveryAddress string              `gorm:"type:text" json:"delivery_address,omitempty"`
+	Notes           string              `gorm:"type:text" json:"notes,omitempty"`
+	EndedAt         *time.Time          `json:"ended_at,omitempty"`
+	Items           []StandingOrderItem `gorm:"foreignKey:StandingOrderID" json:"items"`
+	Runs            []StandingOrderRun  `gorm:"foreignKey:StandingOrderID" json:"runs,omitempty"`
+	CreatedAt       time.Time           `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time           `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// StandingOrderItem is one product delivered on each run
+type StandingOrderItem struct {
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	StandingOrderID uint   `gorm:"not null;index" json:"standing_order_id"`
+	ProductID       uint   `gorm:"not null" json:"product_id"`
+	ProductName     string `json:"product_name"`
+	Quantity        int    `gorm:"not null" json:"quantity"`
+}
+
+// StandingOrderRun records what happened to a standing order on one due
+// date. There is at most one run per order and date.
+type StandingOrderRun struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	StandingOrderID uint      `gorm:"not null;uniqueIndex:idx_standing_order_run" json:"standing_order_id"`
+	BusinessID      uint      `gorm:"not null;index" json:"business_id"`
+	DueDate         time.Time `gorm:"type:date;not null;uniqueIndex:idx_standing_order_run;index" json:"due_date"`
+	Status          string    `gorm:"type:enum('CREATED','SKIPPED','FAILED');not null" json:"status"`
+	SaleID          *uint     `json:"sale_id,omitempty"`
+	Error           string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedByID     *uint     `json:"created_by_id,omitempty"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Built-in tax class codes, following the KRA eTIMS tax type letters
+const (
+	TaxCodeExempt    = "A"
+	TaxCodeStandard  = "B"
+	TaxCodeZeroRated = "C"
+)
+
+// StandardVATRate is the Kenyan standard VAT rate in percent
+const StandardVATRate = 16.0
+
+// TaxClass is a VAT treatment products can be assigned to. Exempt supplies
+// carry no VAT and, unlike zero-rated ones, are reported apart from taxable
+// sales.
+type TaxClass struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	BusinessID uint      `gorm:"not null;uniqueIndex:idx_tax_class_code" json:"business_id"`
+	Code       string    `gorm:"type:varchar(10);not null;uniqueIndex:idx_tax_class_code" json:"code"`
+	Name       string    `gorm:"not null" json:"name"`
+	Rate       float64   `gorm:"not null;default:0" json:"rate"`
+	Exempt     bool      `gorm:"not null;default:false" json:"exempt"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DefaultTaxClasses are created for every business
+var DefaultTaxClasses = []TaxClass{
+	{Code: TaxCodeExempt, Name: "Exempt", Exempt: true},
+	{Code: TaxCodeStandard, Name: "Standard rate", Rate: StandardVATRate},
+	{Code: TaxCodeZeroRated, Name: "Zero-rated"},
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Write-off reason codes
+const (
+	WriteOffExpired     = "EXPIRED"
+	WriteOffDamaged     = "DAMAGED"
+	WriteOffTheft       = "THEFT"
+	WriteOffInternalUse = "INTERNAL_USE"
+)
+
+// Write-off approval statuses
+const (
+	WriteOffPending  = "PENDING"
+	WriteOffApproved = "APPROVED"
+	WriteOffRejected = "REJECTED"
+)
+
+// StockWriteOff records stock removed for a reason other than a sale. Stock is
+// only deducted once the write-off is approved.
+type StockWriteOff struct {
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	ProductID       uint           `gorm:"not null;index" json:"product_id"`
+	Product         Product        `gorm:"foreignKey:ProductID" json:"-"`
+	Quantity        int            `gorm:"not null" json:"quantity"`
+	Reason          string         `gorm:"type:enum('EXPIRED','DAMAGED','THEFT','INTERNAL_USE');not null" json:"reason"`
+	Note            string         `gorm:"type:text" json:"note,omitempty"`
+	PhotoPath       string         `json:"photo_path,omitempty"`
+	UnitCost        float64        `gorm:"not null" json:"unit_cost"`
+	TotalCost       float64        `gorm:"not null" json:"total_cost"`
+	Status          string         `gorm:"type:enum('PENDING','APPROVED','REJECTED');default:'PENDING'" json:"status"`
+	RecordedByID    uint           `gorm:"not null" json:"recorded_by_id"`
+	RecordedBy      User           `gorm:"foreignKey:RecordedByID" json:"-"`
+	ReviewedByID    *uint          `json:"reviewed_by_id,omitempty"`
+	ReviewedBy      *User          `gorm:"foreignKey:ReviewedByID" json:"-"`
+	ReviewedAt      *time.Time     `json:"reviewed_at,omitempty"`
+	ReviewNote      string         `gorm:"type:text" json:"review_note,omitempty"`
+	StockMovementID *uint          `json:"stock_movement_id,omitempty"`
+	StockMovement   *StockMovement `gorm:"foreignKey:StockMovementID" json:"-"`
+	CreatedAt       time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt       time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -81,6 +81,18 @@ func ValidatePhoneNumber(phoneNumber string) (uint64, error) {
 	return parsedNumber, nil
 }
 
+// NormalizePhoneNumber puts a Kenyan phone number written any of the usual
+// ways, such as 0712 345678 or +254712345678, into the 254712345678 form
+// that ValidatePhoneNumber accepts.
+func NormalizePhoneNumber(phoneNumber string) (string, error) {
+	phoneNumber = strings.TrimPrefix(strings.TrimSpace(phoneNumber), "+")
+	parsedNumber, err := ValidatePhoneNumber(phoneNumber)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(parsedNumber, 10), nil
+}
+
 // InitiateSTKPush initiates an STK push request
 func (m *MpesaClient) InitiateSTKPush(req STKPushRequest) (*mpesasdk.Response, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
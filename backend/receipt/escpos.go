@@ -0,0 +1,76 @@
+// escpos.go
+package receipt
+
+import (
+	"bytes"
+	"strings"
+)
+
+// ESC/POS commands
+var (
+	escInit        = []byte{0x1B, 0x40}
+	escAlignLeft   = []byte{0x1B, 0x61, 0x00}
+	escAlignCenter = []byte{0x1B, 0x61, 0x01}
+	escBoldOn      = []byte{0x1B, 0x45, 0x01}
+	escBoldOff     = []byte{0x1B, 0x45, 0x00}
+	escFeedAndCut  = []byte{0x1D, 0x56, 0x42, 0x03}
+)
+
+// ESCPOS renders the receipt as raw ESC/POS commands for a thermal printer
+// with the given line width, Width58mm or Width80mm. The eTIMS QR code is
+// drawn by the printer itself.
+func ESCPOS(r Receipt, width int) []byte {
+	var out bytes.Buffer
+	out.Write(escInit)
+
+	for _, line := range layout(r, width) {
+		text, bold, center := lineStyle(line)
+		if center {
+			out.Write(escAlignCenter)
+		}
+		if bold {
+			out.Write(escBoldOn)
+		}
+		out.WriteString(asciiOnly(text))
+		out.WriteByte('\n')
+		if bold {
+			out.Write(escBoldOff)
+		}
+		if center {
+			out.Write(escAlignLeft)
+		}
+	}
+
+	if r.Etims != nil && r.Etims.QRData != "" {
+		out.Write(escAlignCenter)
+		writeQR(&out, r.Etims.QRData)
+		out.Write(escAlignLeft)
+	}
+
+	out.Write(escFeedAndCut)
+	return out.Bytes()
+}
+
+// writeQR stores data in the printer's QR symbol buffer and prints it
+func writeQR(out *bytes.Buffer, data string) {
+	// Model 2, module size 4, error correction level M
+	out.Write([]byte{0x1D, 0x28, 0x6B, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00})
+	out.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x43, 0x04})
+	out.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x45, 0x31})
+
+	size := len(data) + 3
+	out.Write([]byte{0x1D, 0x28, 0x6B, byte(size % 256), byte(size / 256), 0x31, 0x50, 0x30})
+	out.WriteString(data)
+	out.Write([]byte{0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x51, 0x30})
+	out.WriteByte('\n')
+}
+
+// asciiOnly replaces characters outside the printer's basic code page
+func asciiOnly(text string) string {
+	return strings.Map(func(ch rune) rune {
+		if ch < 32 || ch > 126 {
+			return '?'
+		}
+		return ch
+	}, text)
+}
@@ -0,0 +1,128 @@
+// pdf.go
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PageSize is a PDF paper size
+type PageSize string
+
+const (
+	PageA4    PageSize = "a4"
+	Page80mm  PageSize = "80mm"
+	mmToPoint          = 72 / 25.4
+)
+
+// PDF renders the receipt as a PDF document. A4 receipts run over as many
+// pages as needed; 80 mm receipts are one page as long as the receipt, the
+// way roll printers expect.
+func PDF(r Receipt, size PageSize) []byte {
+	chars := WidthA4
+	if size == Page80mm {
+		chars = Width80mm
+	}
+	return renderPDF(layout(r, chars), size)
+}
+
+// renderPDF sets lines from a layout in Courier, sized so the layout's
+// width fills the page
+func renderPDF(lines []string, size PageSize) []byte {
+	width, margin, chars := 210*mmToPoint, 40.0, WidthA4
+	if size == Page80mm {
+		width, margin, chars = 80*mmToPoint, 4*mmToPoint, Width80mm
+	}
+	// Courier characters are 0.6 of the font size wide
+	fontSize := (width - 2*margin) / (float64(chars) * 0.6)
+	leading := fontSize * 1.25
+
+	var pages [][]string
+	height := 297 * mmToPoint
+	if size == Page80mm {
+		height = float64(len(lines))*leading + 2*margin
+		pages = [][]string{lines}
+	} else {
+		perPage := int((height - 2*margin) / leading)
+		for len(lines) > perPage {
+			pages = append(pages, lines[:perPage])
+			lines = lines[perPage:]
+		}
+		pages = append(pages, lines)
+	}
+
+	doc := &pdfWriter{}
+	doc.object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	doc.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	doc.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	doc.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		var content bytes.Buffer
+		y := height - margin - fontSize
+		for _, line := range page {
+			text, bold, center := lineStyle(line)
+			font := "F1"
+			if bold {
+				font = "F2"
+			}
+			x := margin
+			if center && len(text) < chars {
+				x += float64(chars-len(text)) / 2 * fontSize * 0.6
+			}
+			fmt.Fprintf(&content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, fontSize, x, y, pdfEscape(text))
+			y -= leading
+		}
+		doc.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			width, height, 6+2*i))
+		doc.object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+	return doc.bytes()
+}
+
+// pdfWriter builds a PDF file from numbered objects
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *pdfWriter) object(body string) {
+	if w.buf.Len() == 0 {
+		w.buf.WriteString("%PDF-1.4\n")
+	}
+	w.offsets = append(w.offsets, w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", len(w.offsets), body)
+}
+
+func (w *pdfWriter) bytes() []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, xref)
+	return w.buf.Bytes()
+}
+
+// pdfEscape makes text safe inside a PDF string, replacing characters the
+// standard fonts cannot show
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, ch := range text {
+		switch {
+		case ch == '(' || ch == ')' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(ch)
+		case ch < 32 || ch > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(ch)
+		}
+	}
+	return b.String()
+}
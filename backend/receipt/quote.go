@@ -0,0 +1,133 @@
+// quote.go
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Quote is a quotation or proforma invoice sent to a customer before a sale.
+// Like a Receipt, its figures are worked out before it is rendered.
+type Quote struct {
+	Business      Business
+	Number        string
+	Date          time.Time
+	ValidUntil    time.Time
+	PreparedBy    string
+	Customer      string
+	CustomerPhone string
+	CustomerEmail string
+	CustomerPIN   string
+	Items         []Item
+	Subtotal      float64
+	Discount      float64
+	Taxes         []Tax
+	Total         float64
+	Notes         string
+	// Proforma titles the document as a proforma invoice rather than a
+	// quotation
+	Proforma bool
+}
+
+// QuotePDF renders the quote as an A4 PDF document
+func QuotePDF(q Quote) []byte {
+	return renderPDF(quoteLayout(q, WidthA4), PageA4)
+}
+
+// quoteLayout sets the quote out as a table the given number of characters
+// wide, marked up like layout
+func quoteLayout(q Quote, width int) []string {
+	var lines []string
+	add := func(line string) { lines = append(lines, line) }
+	rule := strings.Repeat("-", width)
+
+	add(boldMark + centerMark + q.Business.Name)
+	if q.Business.Address != "" {
+		add(centerMark + q.Business.Address)
+	}
+	if q.Business.Phone != "" {
+		add(centerMark + "Tel: " + q.Business.Phone)
+	}
+	if q.Business.KRAPin != "" {
+		add(centerMark + "PIN: " + q.Business.KRAPin)
+	}
+	add("")
+	title := "QUOTATION"
+	if q.Proforma {
+		title = "PROFORMA INVOICE"
+	}
+	add(boldMark + centerMark + title)
+	add(rule)
+
+	add(pair("Number", q.Number, width))
+	add(pair("Date", q.Date.Format("02/01/2006"), width))
+	add(pair("Valid until", q.ValidUntil.Format("02/01/2006"), width))
+	if q.PreparedBy != "" {
+		add(pair("Prepared by", q.PreparedBy, width))
+	}
+	add("")
+	add(boldMark + "Customer")
+	for _, detail := range []string{q.Customer, q.CustomerPhone, q.CustomerEmail} {
+		if detail != "" {
+			add(detail)
+		}
+	}
+	if q.CustomerPIN != "" {
+		add("PIN: " + q.CustomerPIN)
+	}
+	add(rule)
+
+	// Description, then quantity, unit price, discount and total columns
+	nameWidth := width - 50
+	row := func(name, qty, price, discount, total string) string {
+		return fmt.Sprintf("%-*s%8s%14s%12s%16s", nameWidth, name, qty, price, discount, total)
+	}
+	add(boldMark + row("Description", "Qty", "Unit price", "Discount", "Amount"))
+	add(rule)
+	for _, item := range q.Items {
+		name := item.Name
+		if item.TaxCode != "" {
+			name += " " + item.TaxCode
+		}
+		parts := wrap(name, nameWidth-1)
+		discount := ""
+		if item.Discount > 0 {
+			discount = "-" + money(item.Discount)
+		}
+		add(row(parts[0], fmt.Sprint(item.Quantity), money(item.UnitPrice), discount, money(item.Total)))
+		for _, part := range parts[1:] {
+			add(part)
+		}
+	}
+	add(rule)
+
+	totals := func(label, value string) string {
+		return fmt.Sprintf("%*s%16s", width-16, label, value)
+	}
+	if q.Discount > 0 {
+		add(totals("Subtotal", money(q.Subtotal)))
+		add(totals("Discount", "-"+money(q.Discount)))
+	}
+	for _, tax := range q.Taxes {
+		add(totals(fmt.Sprintf("%s %s%% VAT on %s", tax.Code, trimRate(tax.Rate), money(tax.Taxable)), money(tax.Tax)))
+	}
+	add(boldMark + totals("TOTAL", money(q.Total)))
+
+	if q.Notes != "" {
+		add("")
+		add(boldMark + "Notes")
+		for _, paragraph := range strings.Split(q.Notes, "\n") {
+			for _, part := range wrap(strings.TrimSpace(paragraph), width) {
+				add(part)
+			}
+		}
+	}
+
+	add(rule)
+	add(fmt.Sprintf("Prices are valid until %s and subject to stock being available.", q.ValidUntil.Format("02/01/2006")))
+	if q.Proforma {
+		add("This proforma invoice is not a tax invoice.")
+	}
+	return lines
+}
@@ -0,0 +1,246 @@
+// receipt.go
+package receipt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Receipt is everything printed on a customer receipt, already worked out
+// by the sale. The renderers only lay it out.
+type Receipt struct {
+	Business    Business
+	SaleNumber  string
+	Date        time.Time
+	Cashier     string
+	Customer    string
+	CustomerPIN string
+	Items       []Item
+	Subtotal    float64
+	Discount    float64
+	Taxes       []Tax
+	Total       float64
+	Payments    []Payment
+	Change      float64
+	BalanceDue  float64
+	Etims       *Etims
+	// Copy is 0 for the original receipt and counts reprints from 1
+	Copy int
+}
+
+type Business struct {
+	Name    string
+	Address string
+	Phone   string
+	KRAPin  string
+}
+
+type Item struct {
+	Name      string
+	Quantity  int
+	UnitPrice float64
+	Discount  float64
+	Total     float64
+	TaxCode   string
+}
+
+// Tax is one line of the VAT breakdown
+type Tax struct {
+	Code    string
+	Rate    float64
+	Taxable float64
+	Tax     float64
+}
+
+// Payment is one tender. Reference holds the M-Pesa receipt number.
+type Payment struct {
+	Method    string
+	Amount    float64
+	Tendered  float64
+	Reference string
+}
+
+// Etims is the KRA control data for a signed invoice
+type Etims struct {
+	InvoiceNumber string
+	InternalData  string
+	Signature     string
+	QRData        string
+}
+
+// Duplicate reports whether this is a reprint
+func (r Receipt) Duplicate() bool {
+	return r.Copy > 0
+}
+
+// Line widths in characters for the paper sizes the renderers support
+const (
+	Width58mm = 32
+	Width80mm = 48
+	WidthA4   = 90
+)
+
+// layout sets the receipt out as monospaced text the given number of
+// characters wide. Lines starting with centerMark are centred and those
+// starting with boldMark printed bold where the output supports it.
+func layout(r Receipt, width int) []string {
+	var lines []string
+	add := func(line string) { lines = append(lines, line) }
+	rule := strings.Repeat("-", width)
+
+	add(boldMark + centerMark + r.Business.Name)
+	if r.Business.Address != "" {
+		add(centerMark + r.Business.Address)
+	}
+	if r.Business.Phone != "" {
+		add(centerMark + "Tel: " + r.Business.Phone)
+	}
+	if r.Business.KRAPin != "" {
+		add(centerMark + "PIN: " + r.Business.KRAPin)
+	}
+	if r.Duplicate() {
+		add(boldMark + centerMark + fmt.Sprintf("*** DUPLICATE - COPY %d ***", r.Copy))
+	}
+	add(rule)
+
+	add(pair("Receipt", r.SaleNumber, width))
+	add(pair("Date", r.Date.Format("02/01/2006 15:04"), width))
+	if r.Cashier != "" {
+		add(pair("Served by", r.Cashier, width))
+	}
+	if r.Customer != "" {
+		add(pair("Customer", r.Customer, width))
+	}
+	if r.CustomerPIN != "" {
+		add(pair("Customer PIN", r.CustomerPIN, width))
+	}
+	add(rule)
+
+	for _, item := range r.Items {
+		name := item.Name
+		if item.TaxCode != "" {
+			name += " " + item.TaxCode
+		}
+		for _, part := range wrap(name, width) {
+			add(part)
+		}
+		add(pair(fmt.Sprintf("  %d x %s", item.Quantity, money(item.UnitPrice)), money(item.Total), width))
+		if item.Discount > 0 {
+			add(pair("  Discount", "-"+money(item.Discount), width))
+		}
+	}
+	add(rule)
+
+	if r.Discount > 0 {
+		add(pair("Subtotal", money(r.Subtotal), width))
+		add(pair("Discount", "-"+money(r.Discount), width))
+	}
+	add(boldMark + pair("TOTAL", money(r.Total), width))
+	for _, tax := range r.Taxes {
+		label := fmt.Sprintf("%s %s%% VAT on %s", tax.Code, trimRate(tax.Rate), money(tax.Taxable))
+		add(pair(label, money(tax.Tax), width))
+	}
+	add(rule)
+
+	for _, payment := range r.Payments {
+		amount := payment.Amount
+		if payment.Tendered > amount {
+			amount = payment.Tendered
+		}
+		add(pair(methodName(payment.Method), money(amount), width))
+		if payment.Reference != "" {
+			add(pair("  Ref", payment.Reference, width))
+		}
+	}
+	if r.Change > 0 {
+		add(pair("Change", money(r.Change), width))
+	}
+	if r.BalanceDue > 0 {
+		add(boldMark + pair("Balance due", money(r.BalanceDue), width))
+	}
+
+	if r.Etims != nil {
+		add(rule)
+		add(centerMark + "KRA eTIMS")
+		add(pair("CU invoice", r.Etims.InvoiceNumber, width))
+		for _, part := range wrap("Internal data: "+r.Etims.InternalData, width) {
+			add(part)
+		}
+		for _, part := range wrap("Signature: "+r.Etims.Signature, width) {
+			add(part)
+		}
+	}
+
+	add(rule)
+	add(centerMark + "Thank you for shopping with us")
+	return lines
+}
+
+// Markers at the start of a line from layout
+const (
+	boldMark   = "\x01"
+	centerMark = "\x02"
+)
+
+// lineStyle strips the markers from a line and reports them
+func lineStyle(line string) (text string, bold, center bool) {
+	for {
+		switch {
+		case strings.HasPrefix(line, boldMark):
+			bold, line = true, line[len(boldMark):]
+		case strings.HasPrefix(line, centerMark):
+			center, line = true, line[len(centerMark):]
+		default:
+			return line, bold, center
+		}
+	}
+}
+
+// pair puts label on the left and value on the right of a line
+func pair(label, value string, width int) string {
+	gap := width - len(label) - len(value)
+	if gap < 1 {
+		label = label[:max(0, width-len(value)-1)]
+		gap = 1
+	}
+	return label + strings.Repeat(" ", gap) + value
+}
+
+// wrap breaks text into lines at most width characters long
+func wrap(text string, width int) []string {
+	var lines []string
+	for len(text) > width {
+		cut := strings.LastIndex(text[:width], " ")
+		if cut <= 0 {
+			cut = width
+		}
+		lines = append(lines, strings.TrimRight(text[:cut], " "))
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+	return append(lines, text)
+}
+
+func money(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
+
+func trimRate(rate float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", rate), "0"), ".")
+}
+
+func methodName(method string) string {
+	switch method {
+	case "MPESA":
+		return "M-Pesa"
+	case "CASH":
+		return "Cash"
+	case "CREDIT":
+		return "On account"
+	case "POINTS":
+		return "Loyalty points"
+	case "CREDIT_NOTE":
+		return "Credit note"
+	}
+	return method
+}
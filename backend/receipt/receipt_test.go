@@ -0,0 +1,128 @@
+package receipt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReceipt() Receipt {
+	return Receipt{
+		Business:   Business{Name: "Duka la Mama", Phone: "0712345678", KRAPin: "P051234567X"},
+		SaleNumber: "S20240301-000042",
+		Date:       time.Date(2024, time.March, 1, 14, 5, 0, 0, time.UTC),
+		Cashier:    "Wanjiku",
+		Items: []Item{
+			{Name: "Sukari 2kg", Quantity: 2, UnitPrice: 250, Total: 500, TaxCode: "B"},
+			{Name: "Unga wa Ngano 2kg", Quantity: 1, UnitPrice: 200, Discount: 20, Total: 180, TaxCode: "A"},
+		},
+		Subtotal: 700,
+		Discount: 20,
+		Taxes:    []Tax{{Code: "B", Rate: 16, Taxable: 431.03, Tax: 68.97}},
+		Total:    680,
+		Payments: []Payment{
+			{Method: "MPESA", Amount: 500, Tendered: 500, Reference: "SB12CD34EF"},
+			{Method: "CASH", Amount: 180, Tendered: 200},
+		},
+		Change: 20,
+	}
+}
+
+func TestLayoutFitsTheWidth(t *testing.T) {
+	r := testReceipt()
+	r.Items[0].Name = "A very long product name that will not fit on one line of a small receipt"
+	for _, width := range []int{Width58mm, Width80mm, WidthA4} {
+		for _, line := range layout(r, width) {
+			text, _, _ := lineStyle(line)
+			if len(text) > width {
+				t.Errorf("width %d: line %q is %d characters", width, text, len(text))
+			}
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	text := strings.Join(layout(testReceipt(), Width58mm), "\n")
+	for _, want := range []string{
+		"Receipt         S20240301-000042",
+		"Date            01/03/2024 14:05",
+		"  2 x 250.00              500.00",
+		"  Discount                -20.00",
+		"TOTAL                     680.00",
+		"B 16% VAT on 431.03        68.97",
+		"M-Pesa                    500.00",
+		"  Ref                 SB12CD34EF",
+		// Cash shows what was handed over, with the change below
+		"Cash                      200.00",
+		"Change                     20.00",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("layout is missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "DUPLICATE") {
+		t.Error("original receipt is marked as a duplicate")
+	}
+}
+
+func TestLayoutDuplicate(t *testing.T) {
+	r := testReceipt()
+	r.Copy = 2
+	text := strings.Join(layout(r, Width80mm), "\n")
+	if !strings.Contains(text, "*** DUPLICATE - COPY 2 ***") {
+		t.Errorf("reprint is not marked as copy 2:\n%s", text)
+	}
+}
+
+func TestLineStyle(t *testing.T) {
+	text, bold, center := lineStyle(boldMark + centerMark + "TOTAL")
+	if text != "TOTAL" || !bold || !center {
+		t.Errorf("lineStyle = %q, %v, %v, want TOTAL, true, true", text, bold, center)
+	}
+	text, bold, center = lineStyle("plain")
+	if text != "plain" || bold || center {
+		t.Errorf("lineStyle = %q, %v, %v, want plain, false, false", text, bold, center)
+	}
+}
+
+func TestPair(t *testing.T) {
+	tests := []struct {
+		label, value string
+		width        int
+		want         string
+	}{
+		{"Total", "10.00", 16, "Total      10.00"},
+		{"A label too long", "10.00", 12, "A labe 10.00"},
+	}
+	for _, tt := range tests {
+		if got := pair(tt.label, tt.value, tt.width); got != tt.want {
+			t.Errorf("pair(%q, %q, %d) = %q, want %q", tt.label, tt.value, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"Unga wa Ngano 2kg", 10, []string{"Unga wa", "Ngano 2kg"}},
+		{"ABCDEFGHIJKLMNOP", 10, []string{"ABCDEFGHIJ", "KLMNOP"}},
+	}
+	for _, tt := range tests {
+		if got := wrap(tt.text, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrap(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestTrimRate(t *testing.T) {
+	for rate, want := range map[float64]string{16: "16", 0: "0", 8.5: "8.5", 12.25: "12.25"} {
+		if got := trimRate(rate); got != want {
+			t.Errorf("trimRate(%v) = %q, want %q", rate, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// sms.go
+package receipt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SMS renders a short text receipt: the shop, the sale, what was paid and,
+// for eTIMS invoices, the control unit invoice number the buyer can verify.
+func SMS(r Receipt) string {
+	var parts []string
+	if r.Duplicate() {
+		parts = append(parts, "DUPLICATE")
+	}
+	parts = append(parts, fmt.Sprintf("%s receipt %s %s", r.Business.Name, r.SaleNumber, r.Date.Format("02/01/06 15:04")))
+
+	items := make([]string, 0, len(r.Items))
+	for _, item := range r.Items {
+		items = append(items, fmt.Sprintf("%dx %s %s", item.Quantity, item.Name, money(item.Total)))
+	}
+	if len(items) > 3 {
+		items = append(items[:3], fmt.Sprintf("+%d more", len(r.Items)-3))
+	}
+	parts = append(parts, strings.Join(items, ", "))
+
+	total := "Total KES " + money(r.Total)
+	var vat float64
+	for _, tax := range r.Taxes {
+		vat += tax.Tax
+	}
+	if vat > 0 {
+		total += " incl. VAT " + money(vat)
+	}
+	parts = append(parts, total)
+
+	for _, payment := range r.Payments {
+		paid := methodName(payment.Method) + " " + money(payment.Amount)
+		if payment.Reference != "" {
+			paid += " (" + payment.Reference + ")"
+		}
+		parts = append(parts, paid)
+	}
+	if r.BalanceDue > 0 {
+		parts = append(parts, "Balance due "+money(r.BalanceDue))
+	}
+	if r.Etims != nil {
+		parts = append(parts, "KRA CU "+r.Etims.InvoiceNumber)
+	}
+	return strings.Join(parts, ". ") + "."
+}
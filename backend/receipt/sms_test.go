@@ -0,0 +1,43 @@
+package receipt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMS(t *testing.T) {
+	r := testReceipt()
+	r.Etims = &Etims{InvoiceNumber: "0000123"}
+	got := SMS(r)
+	want := "Duka la Mama receipt S20240301-000042 01/03/24 14:05. " +
+		"2x Sukari 2kg 500.00, 1x Unga wa Ngano 2kg 180.00. " +
+		"Total KES 680.00 incl. VAT 68.97. " +
+		"M-Pesa 500.00 (SB12CD34EF). Cash 180.00. " +
+		"KRA CU 0000123."
+	if got != want {
+		t.Errorf("SMS =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSMSShortensLongBaskets(t *testing.T) {
+	r := testReceipt()
+	for i := 0; i < 3; i++ {
+		r.Items = append(r.Items, Item{Name: "Maziwa", Quantity: 1, Total: 60})
+	}
+	if got := SMS(r); !strings.Contains(got, "+2 more") {
+		t.Errorf("SMS for five items does not shorten the list: %s", got)
+	}
+}
+
+func TestSMSDuplicateAndBalance(t *testing.T) {
+	r := testReceipt()
+	r.Copy = 1
+	r.BalanceDue = 100
+	got := SMS(r)
+	if !strings.HasPrefix(got, "DUPLICATE. ") {
+		t.Errorf("reprinted SMS does not start with DUPLICATE: %s", got)
+	}
+	if !strings.Contains(got, "Balance due 100.00") {
+		t.Errorf("SMS does not show the balance due: %s", got)
+	}
+}
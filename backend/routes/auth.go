@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -13,4 +15,8 @@ func AuthRoutes(router *gin.Engine, db *gorm.DB) {
 	router.POST("/login", auth.Login)
 	router.POST("/register", auth.Register)
 	router.GET("/verify-token", auth.VerifyToken)
+
+	owner := router.Group("/", middleware.RequireAuth(db), middleware.RequireRole(models.RoleOwner))
+	owner.GET("/users", auth.GetUsers)
+	owner.PUT("/update-user-role/:id", auth.UpdateUserRole)
 }
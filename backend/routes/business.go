@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func BusinessRoutes(router *gin.Engine, db *gorm.DB) {
+	bh := controllers.NewBusinessHandler(db)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.GET("/business", bh.GetBusiness)
+	authed.GET("/search-synonyms", bh.GetSearchSynonyms)
+	authed.GET("/tax-classes", bh.GetTaxClasses)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.POST("/search-synonyms", bh.CreateSearchSynonym)
+	managers.PUT("/search-synonyms/:id", bh.UpdateSearchSynonym)
+	managers.DELETE("/search-synonyms/:id", bh.DeleteSearchSynonym)
+
+	owners := authed.Group("/", middleware.RequireRole(models.RoleOwner))
+	owners.PUT("/business", bh.UpdateBusiness)
+	owners.POST("/tax-classes", bh.CreateTaxClass)
+	owners.PUT("/tax-classes/:id", bh.UpdateTaxClass)
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func CustomerRoutes(router *gin.Engine, db *gorm.DB) {
+	ch := controllers.NewCustomerHandler(db)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.POST("/create-customer", ch.CreateCustomer)
+	authed.GET("/customers", ch.GetCustomers)
+	authed.GET("/customer/:id", ch.GetCustomer)
+	authed.PUT("/customer/:id", ch.UpdateCustomer)
+	authed.GET("/customer-sales/:id", ch.GetCustomerSales)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.GET("/customer-duplicates", ch.GetCustomerDuplicates)
+	managers.POST("/merge-customers", ch.MergeCustomers)
+}
@@ -0,0 +1,58 @@
+// etims.go
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/etims"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultEtimsQRURL is KRA's receipt verification link
+const defaultEtimsQRURL = "https://etims.kra.go.ke/common/link/etims/receipt/indexEtimsReceiptData?Data="
+
+// EtimsRoutes registers the eTIMS queue endpoints and starts the worker that
+// submits invoices. With ETIMS_MODE set to "http" invoices are sent to
+// ETIMS_URL, which must then be set; otherwise they stay queued. For local
+// testing, point ETIMS_URL at the etims-mock command.
+func EtimsRoutes(router *gin.Engine, db *gorm.DB) {
+	fmt.Println("Registering eTIMS routes...")
+
+	config := etims.Config{
+		BaseURL:   os.Getenv("ETIMS_URL"),
+		CMCKey:    os.Getenv("ETIMS_CMC_KEY"),
+		QRBaseURL: os.Getenv("ETIMS_QR_URL"),
+	}
+	if config.QRBaseURL == "" {
+		config.QRBaseURL = defaultEtimsQRURL
+	}
+
+	switch strings.ToLower(os.Getenv("ETIMS_MODE")) {
+	case "http":
+		if config.BaseURL == "" {
+			log.Fatalf("ETIMS_URL is not set in the .env file")
+		}
+		worker := controllers.NewEtimsWorker(db, etims.NewHTTPTransport(config), config)
+		go worker.Run(context.Background())
+	default:
+		fmt.Println("eTIMS submission is off, invoices will stay queued")
+	}
+
+	sm := controllers.NewSalesManagementHandler(db)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.GET("/etims-invoices", sm.GetEtimsInvoices)
+	managers.GET("/etims-payload/:id", sm.GetEtimsPayload)
+	managers.POST("/retry-etims-invoice/:id", sm.RetryEtimsInvoice)
+
+	fmt.Println("eTIMS routes registered successfully")
+}
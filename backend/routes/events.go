@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// EventRoutes registers the stream dashboards listen on for sales,
+// payments, stock changes and alerts as they happen
+func EventRoutes(router *gin.Engine, db *gorm.DB) {
+	eh := controllers.NewEventHandler(db)
+
+	router.POST("/event-ticket", middleware.RequireAuth(db), eh.IssueStreamTicket)
+	router.GET("/events", middleware.RequireStreamAuth(db), eh.StreamEvents)
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -11,12 +13,24 @@ func InventoryManagementRoutes(router *gin.Engine, db *gorm.DB) {
 
 	router.Static("/uploads", "./uploads")
 
-	router.POST("/create-product", im.CreateProduct)
-	router.PUT("/update-product/:id", im.UpdateProduct)
+	router.POST("/create-product", middleware.OptionalAuth(db), im.CreateProduct)
+	router.PUT("/update-product/:id", middleware.OptionalAuth(db), im.UpdateProduct)
 	router.DELETE("/delete-product/:id", im.DeleteProduct)
 	router.GET("/get-product/:id", im.GetProduct)
 	router.GET("/get-all-products", im.GetAllProducts)
 	router.GET("/get-low-stock-alerts", im.GetLowStockAlerts)
+	router.GET("/low-stock-alert-history", im.GetLowStockAlertHistory)
+	router.POST("/acknowledge-low-stock-alert/:id", middleware.RequireAuth(db), im.AcknowledgeLowStockAlert)
+	router.POST("/snooze-low-stock-alert/:id", middleware.RequireAuth(db), im.SnoozeLowStockAlert)
+	router.POST("/resolve-low-stock-alert/:id", middleware.RequireAuth(db), im.ResolveLowStockAlert)
 	router.GET("/lookup-barcode/:barcode", im.LookupBarcode)
-	router.GET("/search-products", im.SearchProducts)
+	router.GET("/search-products", middleware.OptionalAuth(db), im.SearchProducts)
+
+	router.POST("/create-supplier", middleware.RequireAuth(db), im.CreateSupplier)
+	router.PUT("/update-supplier/:id", middleware.RequireAuth(db), im.UpdateSupplier)
+	router.GET("/get-all-suppliers", im.GetAllSuppliers)
+
+	router.GET("/demand-forecast", im.GetDemandForecast)
+	router.GET("/reorder-suggestions", im.GetReorderSuggestions)
+	router.POST("/apply-reorder-point/:id", middleware.RequireAuth(db), middleware.RequireRole(models.RoleSupervisor), im.ApplySuggestedReorderPoint)
 }
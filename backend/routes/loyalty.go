@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// LoyaltyRoutes registers the loyalty endpoints and starts expiring points
+// that are past their expiry date. The programme's rates are set on the
+// business.
+func LoyaltyRoutes(router *gin.Engine, db *gorm.DB) {
+	lh := controllers.NewLoyaltyHandler(db)
+	go controllers.NewLoyaltyExpirer(db).Run(context.Background())
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.GET("/loyalty-balance", lh.GetLoyaltyBalance)
+	authed.GET("/loyalty-ledger/:id", lh.GetLoyaltyLedger)
+	authed.GET("/loyalty-rules", lh.GetLoyaltyRules)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.POST("/create-loyalty-rule", lh.CreateLoyaltyRule)
+	managers.POST("/end-loyalty-rule/:id", lh.EndLoyaltyRule)
+	managers.POST("/loyalty-adjustment/:id", lh.AdjustLoyaltyPoints)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
 	"github.com/OAthooh/BiasharaTrack.git/mpesa"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -27,7 +28,7 @@ func MpesaRoutes(router *gin.Engine, db *gorm.DB) {
 
 	mpesaGroup := router.Group("/api/mpesa")
 	{
-		mpesaGroup.POST("/initiate", handler.InitiatePayment)
+		mpesaGroup.POST("/initiate", middleware.OptionalAuth(db), handler.InitiatePayment)
 		mpesaGroup.POST("/callback", handler.HandleCallback)
 	}
 
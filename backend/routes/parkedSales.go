@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultParkedSaleHold applies when PARKED_SALE_HOLD is unset
+const defaultParkedSaleHold = 30 * time.Minute
+
+// ParkedSaleRoutes registers the parked sale endpoints and starts expiring
+// abandoned ones. PARKED_SALE_HOLD, a duration such as "45m", sets how long
+// a parked sale and its reserved stock are kept.
+func ParkedSaleRoutes(router *gin.Engine, db *gorm.DB) {
+	hold := defaultParkedSaleHold
+	if d, err := time.ParseDuration(os.Getenv("PARKED_SALE_HOLD")); err == nil && d > 0 {
+		hold = d
+	}
+
+	ph := controllers.NewParkedSaleHandler(db, hold)
+	go controllers.NewParkedSaleExpirer(db).Run(context.Background())
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.POST("/park-sale", ph.ParkSale)
+	authed.GET("/parked-sales", ph.GetParkedSales)
+	authed.POST("/resume-parked-sale/:id", ph.ResumeParkedSale)
+	authed.POST("/cancel-parked-sale/:id", ph.CancelParkedSale)
+}
@@ -2,6 +2,8 @@ package routes
 
 import (
 	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -9,6 +11,51 @@ import (
 func SalesManagementRoutes(router *gin.Engine, db *gorm.DB) {
 	sm := controllers.NewSalesManagementHandler(db)
 
-	router.POST("/record-sale", sm.SellProducts)
-	router.GET("/sales-history", sm.FetchSalesHistory)
+	router.POST("/record-sale", middleware.OptionalAuth(db), sm.SellProducts)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.GET("/sales-history", sm.FetchSalesHistory)
+	authed.GET("/promotions", sm.GetPromotions)
+	authed.POST("/sync-sales", sm.SyncSales)
+	authed.GET("/sale-returns", sm.GetSaleReturns)
+	authed.GET("/credit-notes", sm.GetCreditNotes)
+	authed.GET("/discount-limits", sm.GetDiscountLimits)
+	authed.GET("/sale-receipt/:id", sm.GetSaleReceipt)
+	authed.POST("/reprint-receipt/:id", sm.ReprintReceipt)
+	authed.GET("/receipt-prints/:id", sm.GetReceiptPrints)
+	authed.POST("/create-quotation", sm.CreateQuotation)
+	authed.GET("/quotations", sm.GetQuotations)
+	authed.GET("/quotation/:id", sm.GetQuotation)
+	authed.GET("/quotation-pdf/:id", sm.GetQuotationPDF)
+	authed.POST("/quotation-status/:id", sm.UpdateQuotationStatus)
+	authed.POST("/convert-quotation/:id", sm.ConvertQuotation)
+	authed.POST("/create-layaway", sm.CreateLayaway)
+	authed.POST("/layaway-deposit/:id", sm.RecordLayawayDeposit)
+	authed.GET("/layaways", sm.GetLayaways)
+	authed.GET("/layaway/:id", sm.GetLayaway)
+
+	supervisors := authed.Group("/", middleware.RequireRole(models.RoleSupervisor))
+	supervisors.POST("/void-sale/:id", sm.VoidSale)
+	supervisors.POST("/return-sale/:id", sm.ReturnSale)
+	supervisors.POST("/complete-refund/:id", sm.CompleteRefund)
+	supervisors.POST("/cancel-layaway/:id", sm.CancelLayaway)
+	supervisors.GET("/sales-history-totals", sm.GetSalesHistoryTotals)
+	supervisors.GET("/export-sales-history", sm.ExportSalesHistory)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.POST("/create-promotion", sm.CreatePromotion)
+	managers.POST("/end-promotion/:id", sm.EndPromotion)
+	managers.GET("/discount-report", sm.GetDiscountReport)
+	managers.GET("/vat-report", sm.GetVATReport)
+	managers.GET("/sales-summary", sm.GetSalesSummary)
+	managers.GET("/sales-trend", sm.GetSalesTrend)
+	managers.GET("/product-performance", sm.GetProductPerformance)
+	managers.GET("/sales-by-category", sm.GetSalesByCategory)
+	managers.GET("/sales-heatmap", sm.GetSalesHeatmap)
+	managers.GET("/payment-mix", sm.GetPaymentMix)
+	managers.GET("/profit-report", sm.GetProfitReport)
+	managers.GET("/below-cost-sales", sm.GetBelowCostSales)
+
+	owners := authed.Group("/", middleware.RequireRole(models.RoleOwner))
+	owners.PUT("/discount-limits", sm.UpdateDiscountLimit)
 }
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func ShiftRoutes(router *gin.Engine, db *gorm.DB) {
+	sh := controllers.NewShiftHandler(db)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.POST("/open-shift", sh.OpenShift)
+	authed.GET("/current-shift", sh.GetCurrentShift)
+	authed.POST("/cash-movement", sh.RecordCashMovement)
+	authed.POST("/close-shift", sh.CloseShift)
+	authed.GET("/z-report/:id", sh.GetZReport)
+
+	supervisors := authed.Group("/", middleware.RequireRole(models.RoleSupervisor))
+	supervisors.POST("/close-shift/:id", sh.CloseShiftFor)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.GET("/shifts", sh.GetShifts)
+}
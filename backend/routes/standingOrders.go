@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StandingOrderRoutes registers the standing order endpoints and starts
+// ringing up their sales as they fall due
+func StandingOrderRoutes(router *gin.Engine, db *gorm.DB) {
+	sh := controllers.NewStandingOrderHandler(db)
+	go controllers.NewStandingOrderScheduler(db).Run(context.Background())
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.POST("/create-standing-order", sh.CreateStandingOrder)
+	authed.GET("/standing-orders", sh.GetStandingOrders)
+	authed.GET("/standing-order/:id", sh.GetStandingOrder)
+	authed.POST("/skip-standing-order/:id", sh.SkipStandingOrder)
+	authed.POST("/pause-standing-order/:id", sh.PauseStandingOrder)
+	authed.POST("/resume-standing-order/:id", sh.ResumeStandingOrder)
+	authed.GET("/delivery-list", sh.GetDeliveryList)
+
+	supervisors := authed.Group("/", middleware.RequireRole(models.RoleSupervisor))
+	supervisors.PUT("/update-standing-order/:id", sh.UpdateStandingOrder)
+	supervisors.POST("/end-standing-order/:id", sh.EndStandingOrder)
+	supervisors.POST("/retry-standing-order-run/:id", sh.RetryStandingOrderRun)
+}
@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/OAthooh/BiasharaTrack.git/controllers"
+	"github.com/OAthooh/BiasharaTrack.git/middleware"
+	"github.com/OAthooh/BiasharaTrack.git/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultWriteOffApprovalLimit applies when WRITE_OFF_APPROVAL_LIMIT is unset
+const defaultWriteOffApprovalLimit = 1000
+
+func WriteOffRoutes(router *gin.Engine, db *gorm.DB) {
+	approvalLimit := float64(defaultWriteOffApprovalLimit)
+	if limit, err := strconv.ParseFloat(os.Getenv("WRITE_OFF_APPROVAL_LIMIT"), 64); err == nil && limit >= 0 {
+		approvalLimit = limit
+	}
+
+	wh := controllers.NewWriteOffHandler(db, approvalLimit)
+
+	authed := router.Group("/", middleware.RequireAuth(db))
+	authed.POST("/record-write-off", wh.RecordWriteOff)
+	authed.GET("/write-offs", wh.GetWriteOffs)
+
+	supervisors := authed.Group("/", middleware.RequireRole(models.RoleSupervisor))
+	supervisors.GET("/shrinkage-report", wh.GetShrinkageReport)
+
+	managers := authed.Group("/", middleware.RequireRole(models.RoleManager))
+	managers.POST("/approve-write-off/:id", wh.ApproveWriteOff)
+	managers.POST("/reject-write-off/:id", wh.RejectWriteOff)
+}
@@ -0,0 +1,125 @@
+// schedule.go
+package schedule
+
+import "time"
+
+// Frequencies a schedule can repeat at
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+// maxSearchDays bounds the search for the next due date. Every valid
+// schedule falls due at least once in this many days.
+const maxSearchDays = 3 * 366
+
+// Rule says which days something falls due. Dates are calendar days;
+// only their year, month and day are used.
+//
+// A daily rule is due every Interval days from Start. A weekly rule is due
+// on each of Weekdays, or on Start's weekday when none are given, in every
+// Interval-th week from Start's week. A monthly rule is due every
+// Interval-th month on Start's day of the month, or the month's last day
+// when it is shorter.
+type Rule struct {
+	Frequency string
+	Interval  int
+	Weekdays  []time.Weekday
+	Start     time.Time
+	// End is the last day the rule can fall due; nil means it never ends
+	End *time.Time
+}
+
+// Valid reports whether the rule can be used
+func (r Rule) Valid() bool {
+	if r.Interval < 1 || r.Start.IsZero() {
+		return false
+	}
+	if r.End != nil && day(*r.End).Before(day(r.Start)) {
+		return false
+	}
+	switch r.Frequency {
+	case Daily, Monthly:
+		return true
+	case Weekly:
+		for _, w := range r.Weekdays {
+			if w < time.Sunday || w > time.Saturday {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Due reports whether the rule falls due on the given day
+func (r Rule) Due(t time.Time) bool {
+	d, start := day(t), day(r.Start)
+	if d.Before(start) || (r.End != nil && d.After(day(*r.End))) || r.Interval < 1 {
+		return false
+	}
+
+	switch r.Frequency {
+	case Daily:
+		return daysBetween(start, d)%r.Interval == 0
+	case Weekly:
+		weekdays := r.Weekdays
+		if len(weekdays) == 0 {
+			weekdays = []time.Weekday{start.Weekday()}
+		}
+		matches := false
+		for _, w := range weekdays {
+			if d.Weekday() == w {
+				matches = true
+				break
+			}
+		}
+		// Weeks are counted from the Sunday starting Start's week
+		weeks := daysBetween(start.AddDate(0, 0, -int(start.Weekday())), d) / 7
+		return matches && weeks%r.Interval == 0
+	case Monthly:
+		months := (d.Year()-start.Year())*12 + int(d.Month()-start.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		last := time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, d.Location()).Day()
+		return d.Day() == min(start.Day(), last)
+	}
+	return false
+}
+
+// Next returns the first day after t on which the rule falls due. It
+// returns false when the rule has ended.
+func (r Rule) Next(t time.Time) (time.Time, bool) {
+	d := day(t)
+	if start := day(r.Start); d.Before(start) {
+		d = start.AddDate(0, 0, -1)
+	}
+	for i := 0; i < maxSearchDays; i++ {
+		d = d.AddDate(0, 0, 1)
+		if r.End != nil && d.After(day(*r.End)) {
+			return time.Time{}, false
+		}
+		if r.Due(d) {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// First returns the first day on or after t on which the rule falls due
+func (r Rule) First(t time.Time) (time.Time, bool) {
+	return r.Next(day(t).AddDate(0, 0, -1))
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// daysBetween counts calendar days from a to b, ignoring daylight saving
+func daysBetween(a, b time.Time) int {
+	ua := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	ub := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(ub.Sub(ua).Hours() / 24)
+}
@@ -0,0 +1,190 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNext(t *testing.T) {
+	end := date(2024, time.March, 31)
+	tests := []struct {
+		name string
+		rule Rule
+		from time.Time
+		want time.Time
+		ok   bool
+	}{
+		{
+			name: "daily",
+			rule: Rule{Frequency: Daily, Interval: 1, Start: date(2024, time.January, 1)},
+			from: date(2024, time.January, 1),
+			want: date(2024, time.January, 2),
+			ok:   true,
+		},
+		{
+			name: "every third day across a month end",
+			rule: Rule{Frequency: Daily, Interval: 3, Start: date(2024, time.January, 29)},
+			from: date(2024, time.January, 29),
+			want: date(2024, time.February, 1),
+			ok:   true,
+		},
+		{
+			name: "before the start",
+			rule: Rule{Frequency: Daily, Interval: 2, Start: date(2024, time.May, 10)},
+			from: date(2024, time.May, 1),
+			want: date(2024, time.May, 10),
+			ok:   true,
+		},
+		{
+			name: "weekly on the start's weekday",
+			rule: Rule{Frequency: Weekly, Interval: 1, Start: date(2024, time.January, 3)},
+			from: date(2024, time.January, 3),
+			want: date(2024, time.January, 10),
+			ok:   true,
+		},
+		{
+			name: "weekly on several weekdays",
+			rule: Rule{Frequency: Weekly, Interval: 1, Weekdays: []time.Weekday{time.Monday, time.Friday}, Start: date(2024, time.January, 1)},
+			from: date(2024, time.January, 1),
+			want: date(2024, time.January, 5),
+			ok:   true,
+		},
+		{
+			name: "fortnightly skips the week in between",
+			rule: Rule{Frequency: Weekly, Interval: 2, Weekdays: []time.Weekday{time.Friday}, Start: date(2024, time.January, 1)},
+			from: date(2024, time.January, 5),
+			want: date(2024, time.January, 19),
+			ok:   true,
+		},
+		{
+			name: "monthly on the 31st falls on the last day of February",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2023, time.January, 31)},
+			from: date(2023, time.January, 31),
+			want: date(2023, time.February, 28),
+			ok:   true,
+		},
+		{
+			name: "monthly on the 31st in a leap year",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 31)},
+			from: date(2024, time.January, 31),
+			want: date(2024, time.February, 29),
+			ok:   true,
+		},
+		{
+			name: "monthly goes back to the 31st after a short month",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 31)},
+			from: date(2024, time.February, 29),
+			want: date(2024, time.March, 31),
+			ok:   true,
+		},
+		{
+			name: "monthly on the 30th after February",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 30)},
+			from: date(2024, time.February, 29),
+			want: date(2024, time.March, 30),
+			ok:   true,
+		},
+		{
+			name: "quarterly across a year end",
+			rule: Rule{Frequency: Monthly, Interval: 3, Start: date(2023, time.November, 15)},
+			from: date(2023, time.November, 15),
+			want: date(2024, time.February, 15),
+			ok:   true,
+		},
+		{
+			name: "yearly from a leap day",
+			rule: Rule{Frequency: Monthly, Interval: 12, Start: date(2024, time.February, 29)},
+			from: date(2024, time.February, 29),
+			want: date(2025, time.February, 28),
+			ok:   true,
+		},
+		{
+			name: "yearly from a leap day reaches the next leap day",
+			rule: Rule{Frequency: Monthly, Interval: 12, Start: date(2024, time.February, 29)},
+			from: date(2027, time.February, 28),
+			want: date(2028, time.February, 29),
+			ok:   true,
+		},
+		{
+			name: "last date before the end",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 31), End: &end},
+			from: date(2024, time.February, 29),
+			want: date(2024, time.March, 31),
+			ok:   true,
+		},
+		{
+			name: "ended",
+			rule: Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 31), End: &end},
+			from: date(2024, time.March, 31),
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.rule.Next(tt.from)
+			if ok != tt.ok {
+				t.Fatalf("Next(%s) ok = %v, want %v", tt.from.Format("2006-01-02"), ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from.Format("2006-01-02"), got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+func TestFirst(t *testing.T) {
+	rule := Rule{Frequency: Monthly, Interval: 1, Start: date(2024, time.January, 31)}
+	tests := []struct {
+		from time.Time
+		want time.Time
+	}{
+		{date(2024, time.January, 31), date(2024, time.January, 31)},
+		{date(2024, time.February, 1), date(2024, time.February, 29)},
+		{date(2023, time.December, 1), date(2024, time.January, 31)},
+	}
+
+	for _, tt := range tests {
+		got, ok := rule.First(tt.from)
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("First(%s) = %s, %v, want %s", tt.from.Format("2006-01-02"), got.Format("2006-01-02"), ok, tt.want.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestDueIgnoresTimeOfDay(t *testing.T) {
+	rule := Rule{Frequency: Daily, Interval: 2, Start: time.Date(2024, time.March, 1, 18, 30, 0, 0, time.UTC)}
+	if !rule.Due(time.Date(2024, time.March, 3, 6, 0, 0, 0, time.UTC)) {
+		t.Error("Due on the second day after the start = false, want true")
+	}
+	if rule.Due(time.Date(2024, time.March, 4, 6, 0, 0, 0, time.UTC)) {
+		t.Error("Due on the third day after the start = true, want false")
+	}
+}
+
+func TestValid(t *testing.T) {
+	start := date(2024, time.January, 1)
+	before := date(2023, time.December, 31)
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"daily", Rule{Frequency: Daily, Interval: 1, Start: start}, true},
+		{"zero interval", Rule{Frequency: Daily, Interval: 0, Start: start}, false},
+		{"no start", Rule{Frequency: Daily, Interval: 1}, false},
+		{"end before start", Rule{Frequency: Daily, Interval: 1, Start: start, End: &before}, false},
+		{"unknown frequency", Rule{Frequency: "YEARLY", Interval: 1, Start: start}, false},
+		{"bad weekday", Rule{Frequency: Weekly, Interval: 1, Weekdays: []time.Weekday{7}, Start: start}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.Valid(); got != tt.want {
+			t.Errorf("%s: Valid() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
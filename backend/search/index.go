@@ -0,0 +1,351 @@
+// index.go
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Field weights: a hit in the name counts for more than one in the description
+const (
+	weightName        = 3.0
+	weightBarcode     = 3.0
+	weightAlias       = 2.5
+	weightCategory    = 1.5
+	weightDescription = 1.0
+)
+
+// Match quality multipliers
+const (
+	qualityExact   = 1.0
+	qualitySynonym = 0.9
+	qualityPrefix  = 0.8
+	qualityFuzzy1  = 0.6
+	qualityFuzzy2  = 0.4
+)
+
+// Document is a product as seen by the index
+type Document struct {
+	ID          uint
+	Name        string
+	Description string
+	Barcode     string
+	Category    string
+	Aliases     []string
+}
+
+// Hit is a ranked search result
+type Hit struct {
+	ID    uint
+	Score float64
+}
+
+// Index is an in-memory inverted index over product documents. It is built
+// once and then only read, so it is safe for concurrent searches.
+type Index struct {
+	// postings maps a term to the best field weight it has in each document
+	postings map[string]map[uint]float64
+	// terms is the sorted vocabulary, used for prefix and fuzzy lookups
+	terms []string
+	// barcodes maps a normalised barcode to its documents
+	barcodes map[string][]uint
+}
+
+// NewIndex builds an index over docs
+func NewIndex(docs []Document) *Index {
+	idx := &Index{
+		postings: make(map[string]map[uint]float64),
+		barcodes: make(map[string][]uint),
+	}
+
+	for _, doc := range docs {
+		idx.addField(doc.ID, doc.Name, weightName)
+		idx.addField(doc.ID, doc.Category, weightCategory)
+		idx.addField(doc.ID, doc.Description, weightDescription)
+		for _, alias := range doc.Aliases {
+			idx.addField(doc.ID, alias, weightAlias)
+		}
+		if barcode := strings.ToLower(strings.TrimSpace(doc.Barcode)); barcode != "" {
+			idx.barcodes[barcode] = append(idx.barcodes[barcode], doc.ID)
+		}
+	}
+
+	idx.terms = make([]string, 0, len(idx.postings))
+	for term := range idx.postings {
+		idx.terms = append(idx.terms, term)
+	}
+	sort.Strings(idx.terms)
+	return idx
+}
+
+func (idx *Index) addField(id uint, text string, weight float64) {
+	for _, term := range Tokenize(text) {
+		docs, ok := idx.postings[term]
+		if !ok {
+			docs = make(map[uint]float64)
+			idx.postings[term] = docs
+		}
+		if weight > docs[id] {
+			docs[id] = weight
+		}
+	}
+}
+
+// Search ranks documents against query. Every query word must match, either
+// exactly, through a synonym, as a prefix (the last word only, for
+// search-as-you-type) or within a small edit distance. When nothing matches
+// every word, documents matching most of them are returned instead. boost
+// gives each document's popularity multiplier; documents missing from it
+// get 1.
+func (idx *Index) Search(query string, synonyms Synonyms, boost map[uint]float64, limit int) []Hit {
+	scores := make(map[uint]float64)
+	matched := make(map[uint]int)
+
+	// A query that is a barcode, or the start of one, goes straight to it
+	if code := strings.ToLower(strings.TrimSpace(query)); code != "" && !strings.ContainsAny(code, " \t") {
+		for barcode, ids := range idx.barcodes {
+			quality := 0.0
+			if barcode == code {
+				quality = qualityExact
+			} else if len(code) >= 4 && strings.HasPrefix(barcode, code) {
+				quality = qualityPrefix
+			}
+			for _, id := range ids {
+				if quality > 0 {
+					// A barcode hit counts as matching every query word
+					scores[id] += weightBarcode * quality * 2
+					matched[id] = math.MaxInt32
+				}
+			}
+		}
+	}
+
+	words := Tokenize(query)
+	for i := 0; i < len(words); {
+		// A synonym entry may take up several query words, which its
+		// synonyms then stand in for together
+		n, alts := synonyms.Match(words[i:])
+		n = max(n, 1)
+		bests := make([]map[uint]float64, n)
+		for j, word := range words[i : i+n] {
+			isLast := i+j == len(words)-1
+			bests[j] = idx.matchWord(word, isLast)
+		}
+		for _, alt := range alts {
+			for id, score := range idx.matchPhrase(alt) {
+				for _, best := range bests {
+					if score*qualitySynonym > best[id] {
+						best[id] = score * qualitySynonym
+					}
+				}
+			}
+		}
+		for _, best := range bests {
+			for id, score := range best {
+				scores[id] += score
+				matched[id]++
+			}
+		}
+		i += n
+	}
+
+	required := len(words)
+	hits := collect(scores, matched, required, boost)
+	for len(hits) == 0 && required > 1 {
+		required--
+		hits = collect(scores, matched, required, boost)
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func collect(scores map[uint]float64, matched map[uint]int, required int, boost map[uint]float64) []Hit {
+	hits := make([]Hit, 0)
+	for id, score := range scores {
+		if matched[id] < required {
+			continue
+		}
+		if b, ok := boost[id]; ok {
+			score *= b
+		}
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	return hits
+}
+
+// matchWord scores every document containing a term that matches word,
+// keeping the best score per document.
+func (idx *Index) matchWord(word string, allowPrefix bool) map[uint]float64 {
+	best := make(map[uint]float64)
+	apply := func(term string, quality float64) {
+		for id, weight := range idx.postings[term] {
+			if weight*quality > best[id] {
+				best[id] = weight * quality
+			}
+		}
+	}
+
+	if _, ok := idx.postings[word]; ok {
+		apply(word, qualityExact)
+	}
+
+	if allowPrefix {
+		start := sort.SearchStrings(idx.terms, word)
+		for i := start; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], word); i++ {
+			if idx.terms[i] != word {
+				apply(idx.terms[i], qualityPrefix)
+			}
+		}
+	}
+
+	// Numbers and sizes must match exactly: 1kg is not 2kg
+	maxEdits := allowedEdits(word)
+	if maxEdits == 0 || hasDigit(word) {
+		return best
+	}
+	for _, term := range idx.terms {
+		if term == word || hasDigit(term) {
+			continue
+		}
+		if d := abs(len(term) - len(word)); d > maxEdits {
+			continue
+		}
+		switch d := editDistance(word, term, maxEdits); {
+		case d > maxEdits:
+		case d == 1:
+			apply(term, qualityFuzzy1)
+		case d == 2:
+			apply(term, qualityFuzzy2)
+		}
+	}
+	return best
+}
+
+// matchPhrase scores the documents matching every word of phrase, each by
+// its weakest word
+func (idx *Index) matchPhrase(phrase []string) map[uint]float64 {
+	var best map[uint]float64
+	for _, word := range phrase {
+		scores := idx.matchWord(word, false)
+		if best == nil {
+			best = scores
+			continue
+		}
+		for id, score := range best {
+			if other, ok := scores[id]; !ok {
+				delete(best, id)
+			} else if other < score {
+				best[id] = other
+			}
+		}
+	}
+	return best
+}
+
+// allowedEdits is how many typos a word of this length may contain
+func allowedEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Tokenize lower-cases text and splits it into words, also splitting where
+// letters meet digits so "1kg" and "1 Kg" give the same terms.
+func Tokenize(text string) []string {
+	var tokens []string
+	var current []rune
+	var lastDigit bool
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, string(current))
+			current = current[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		isDigit := unicode.IsDigit(r)
+		if len(current) > 0 && isDigit != lastDigit {
+			flush()
+		}
+		current = append(current, r)
+		lastDigit = isDigit
+	}
+	flush()
+	return tokens
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b, giving up with max+1 once it exceeds max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prev2[j-2]+1 < curr[j] {
+				curr[j] = prev2[j-2] + 1
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Sukari 2Kg", []string{"sukari", "2", "kg"}},
+		{"1kg", []string{"1", "kg"}},
+		{"1 Kg", []string{"1", "kg"}},
+		{"Coca-Cola 500ml", []string{"coca", "cola", "500", "ml"}},
+		{"  Unga  wa   Ngano ", []string{"unga", "wa", "ngano"}},
+		{"ABC123def", []string{"abc", "123", "def"}},
+		{"Maziwa (Mala)", []string{"maziwa", "mala"}},
+		{"", nil},
+		{" - ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func ids(hits []Hit) []uint {
+	result := make([]uint, 0, len(hits))
+	for _, hit := range hits {
+		result = append(result, hit.ID)
+	}
+	return result
+}
+
+func TestSearchSynonymPhrases(t *testing.T) {
+	index := NewIndex([]Document{
+		{ID: 1, Name: "Kabras Sugar 2kg"},
+		{ID: 2, Name: "Brown Sugar 1kg"},
+		{ID: 3, Name: "Nyekundu Tomatoes"},
+	})
+	synonyms := NewSynonyms([][]string{
+		{"sukari", "sugar"},
+		{"sukari nyekundu", "brown sugar"},
+	})
+
+	tests := []struct {
+		query string
+		want  []uint
+	}{
+		// The phrase finds brown sugar ahead of plain sugar
+		{"sukari nyekundu", []uint{2}},
+		{"sukari", []uint{1, 2}},
+		// A word of a phrase does not pull in its other words' synonyms
+		{"nyekundu", []uint{3}},
+		{"sugar", []uint{1, 2}},
+	}
+	for _, tt := range tests {
+		got := ids(index.Search(tt.query, synonyms, nil, 0))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSizesMatchExactly(t *testing.T) {
+	index := NewIndex([]Document{
+		{ID: 1, Name: "Sugar 1kg"},
+		{ID: 2, Name: "Sugar 2kg"},
+	})
+	got := ids(index.Search("sugar 2kg", Synonyms{}, nil, 0))
+	if !reflect.DeepEqual(got, []uint{2}) {
+		t.Errorf("Search(sugar 2kg) = %v, want [2]", got)
+	}
+}
+
+func TestSearchTypos(t *testing.T) {
+	index := NewIndex([]Document{
+		{ID: 1, Name: "Cooking Oil"},
+		{ID: 2, Name: "Maize Flour"},
+	})
+	got := ids(index.Search("cookng", Synonyms{}, nil, 0))
+	if !reflect.DeepEqual(got, []uint{1}) {
+		t.Errorf("Search(cookng) = %v, want [1]", got)
+	}
+}
+
+func TestSearchBarcode(t *testing.T) {
+	index := NewIndex([]Document{
+		{ID: 1, Name: "Sugar", Barcode: "6161101234567"},
+		{ID: 2, Name: "Flour", Barcode: "6161109999999"},
+	})
+	got := ids(index.Search("6161101234567", Synonyms{}, nil, 0))
+	if !reflect.DeepEqual(got, []uint{1}) {
+		t.Errorf("Search by barcode = %v, want [1]", got)
+	}
+}
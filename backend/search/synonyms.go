@@ -0,0 +1,77 @@
+// synonyms.go
+package search
+
+import "strings"
+
+// Synonyms maps each entry in a synonym group to the other entries, such as
+// "sukari" and "sugar". Entries of several words are phrases: they only
+// match when all their words are searched for together, so "sukari
+// nyekundu" and "brown sugar" do not make "sugar" a synonym of "nyekundu".
+type Synonyms struct {
+	// phrases maps an entry's words, joined by spaces, to the words of the
+	// entries it is interchangeable with
+	phrases map[string][][]string
+	// longest is the most words in any entry
+	longest int
+}
+
+// NewSynonyms builds a lookup from groups of interchangeable words
+func NewSynonyms(groups [][]string) Synonyms {
+	synonyms := Synonyms{phrases: make(map[string][][]string)}
+	for _, group := range groups {
+		var entries [][]string
+		for _, entry := range group {
+			if words := Tokenize(entry); len(words) > 0 {
+				entries = append(entries, words)
+			}
+		}
+		for _, entry := range entries {
+			key := strings.Join(entry, " ")
+			synonyms.longest = max(synonyms.longest, len(entry))
+			for _, other := range entries {
+				if otherKey := strings.Join(other, " "); otherKey != key && !containsPhrase(synonyms.phrases[key], otherKey) {
+					synonyms.phrases[key] = append(synonyms.phrases[key], other)
+				}
+			}
+		}
+	}
+	return synonyms
+}
+
+// Expand returns the synonyms of phrase, not including phrase itself, each
+// as its words
+func (s Synonyms) Expand(phrase string) [][]string {
+	return s.phrases[strings.Join(Tokenize(phrase), " ")]
+}
+
+// Match finds the longest entry that words start with. It returns how many
+// of the words the entry takes up and the entry's synonyms, or zero when
+// the first word does not start an entry.
+func (s Synonyms) Match(words []string) (int, [][]string) {
+	for n := min(s.longest, len(words)); n > 0; n-- {
+		if others, ok := s.phrases[strings.Join(words[:n], " ")]; ok {
+			return n, others
+		}
+	}
+	return 0, nil
+}
+
+// ParseWords splits a comma separated word list, dropping blanks
+func ParseWords(list string) []string {
+	var words []string
+	for _, w := range strings.Split(list, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+func containsPhrase(list [][]string, phrase string) bool {
+	for _, item := range list {
+		if strings.Join(item, " ") == phrase {
+			return true
+		}
+	}
+	return false
+}
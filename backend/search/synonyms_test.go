@@ -0,0 +1,75 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSynonymsExpand(t *testing.T) {
+	synonyms := NewSynonyms([][]string{
+		{"sukari", "sugar"},
+		{"sukari nyekundu", "brown sugar"},
+		{"Unga", "flour", "unga"},
+	})
+
+	tests := []struct {
+		phrase string
+		want   [][]string
+	}{
+		{"sukari", [][]string{{"sugar"}}},
+		{"SUGAR", [][]string{{"sukari"}}},
+		{"sukari nyekundu", [][]string{{"brown", "sugar"}}},
+		{"brown  sugar", [][]string{{"sukari", "nyekundu"}}},
+		{"unga", [][]string{{"flour"}}},
+		// Words of a phrase are not synonyms on their own
+		{"nyekundu", nil},
+		{"brown", nil},
+	}
+	for _, tt := range tests {
+		if got := synonyms.Expand(tt.phrase); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Expand(%q) = %v, want %v", tt.phrase, got, tt.want)
+		}
+	}
+}
+
+func TestSynonymsMatch(t *testing.T) {
+	synonyms := NewSynonyms([][]string{
+		{"sukari", "sugar"},
+		{"sukari nyekundu", "brown sugar"},
+	})
+
+	tests := []struct {
+		words []string
+		n     int
+		want  [][]string
+	}{
+		{[]string{"sukari", "nyekundu", "2kg"}, 2, [][]string{{"brown", "sugar"}}},
+		{[]string{"sukari", "2kg"}, 1, [][]string{{"sugar"}}},
+		{[]string{"nyekundu", "sukari"}, 0, nil},
+		{nil, 0, nil},
+	}
+	for _, tt := range tests {
+		n, got := synonyms.Match(tt.words)
+		if n != tt.n || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%q) = %d, %v, want %d, %v", tt.words, n, got, tt.n, tt.want)
+		}
+	}
+}
+
+func TestZeroSynonyms(t *testing.T) {
+	var synonyms Synonyms
+	if got := synonyms.Expand("sukari"); got != nil {
+		t.Errorf("Expand on empty synonyms = %v, want nil", got)
+	}
+	if n, _ := synonyms.Match([]string{"sukari"}); n != 0 {
+		t.Errorf("Match on empty synonyms = %d, want 0", n)
+	}
+}
+
+func TestParseWords(t *testing.T) {
+	got := ParseWords(" sukari, sugar ,, brown sugar ,")
+	want := []string{"sukari", "sugar", "brown sugar"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseWords = %q, want %q", got, want)
+	}
+}
@@ -1,10 +1,27 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
 	"github.com/google/uuid"
 )
 
 // GenerateUUID generates a new UUID string
 func GenerateUUID() string {
 	return uuid.New().String()
-}
\ No newline at end of file
+}
+
+// JWTSecret returns the key used to sign and verify auth tokens. The server
+// refuses to start without JWT_SECRET, so it is never empty.
+func JWTSecret() []byte {
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+// HashToken returns the hex SHA-256 of a bearer secret, for storing it
+// without keeping the secret itself
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
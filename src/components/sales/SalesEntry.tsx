@@ -27,6 +27,9 @@ export default function SalesEntry() {
   const debouncedSearch = useDebounce(searchQuery, 300);
   const [cartTotal, setCartTotal] = useState(0);
   const searchResultsRef = useRef<HTMLDivElement>(null);
+  // One key per cart, so resubmitting after a dropped connection cannot
+  // record the same sale twice
+  const saleKeyRef = useRef<string>(crypto.randomUUID());
   const [selectedProducts, setSelectedProducts] = useState<Product[]>([]);
   const [isProcessingMpesa, setIsProcessingMpesa] = useState(false);
   const [mpesaStatus, setMpesaStatus] = useState<'idle' | 'pending' | 'success' | 'failed'>('idle');
@@ -137,6 +140,10 @@ export default function SalesEntry() {
     setCartTotal(newTotal);
   }, [formData.products, selectedProducts]);
 
+  useEffect(() => {
+    saleKeyRef.current = crypto.randomUUID();
+  }, [formData]);
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     if (formData.products.length === 0) {
@@ -159,7 +166,7 @@ export default function SalesEntry() {
       };
 
       console.log('Submitting sale data:', saleData);
-      const response = await inventoryApi.recordSale(saleData);
+      const response = await inventoryApi.recordSale(saleData, saleKeyRef.current);
       if (response.success) {
         setSuccess(t('salesEntry.messages.success'));
         setFormData({
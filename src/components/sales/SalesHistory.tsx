@@ -1,6 +1,6 @@
 import { useState, useEffect } from 'react';
 import { Search, Download, Eye } from 'lucide-react';
-import { Sale, SalesTransaction } from '../../types/sales';
+import { Sale, SaleRecord } from '../../types/sales';
 import { formatDate, formatCurrency } from '../../utils/formatters';
 import { inventoryApi } from '../../utils/api';
 import { useTranslation } from 'react-i18next';
@@ -15,20 +15,17 @@ export default function SalesHistory() {
       const response = await inventoryApi.fetchSalesHistory();
      
       if (response.success && Array.isArray(response.data)) {
-        const salesData: Sale[] = response.data.map((transaction: SalesTransaction) => {
-          console.log("transaction", transaction);
-          return {
-            id: transaction.id,
-            product_name: transaction.product_name,
-            product_id: transaction.product_id,
-            quantity: transaction.quantity,
-            total_amount: transaction.total_amount,
-            payment_method: transaction.payment_method.toLowerCase() as "cash" | "mpesa" | "credit",
-            customerName: '',
-            created_at: new Date(transaction.created_at),
-            updated_at: new Date(transaction.updated_at),
-          };
-        });
+        const salesData: Sale[] = response.data.map((sale: SaleRecord) => ({
+          id: sale.id,
+          product_name: sale.items.map((item) => item.product_name).join(', '),
+          product_id: sale.items[0]?.product_id ?? 0,
+          quantity: sale.items.reduce((sum, item) => sum + item.quantity, 0),
+          total_amount: sale.total_amount,
+          payment_method: sale.payment_method.toLowerCase() as Sale['payment_method'],
+          customerName: sale.customer_name,
+          created_at: new Date(sale.created_at),
+          updated_at: new Date(sale.updated_at),
+        }));
         setSales(salesData);
       } else {
         setSales([]);
@@ -6,7 +6,7 @@ export interface Sale {
   product_id: number;
   quantity: number;
   total_amount: number;
-  payment_method: 'cash' | 'mpesa' | 'credit';
+  payment_method: 'cash' | 'mpesa' | 'credit' | 'split';
   customerName?: string;
   created_at: Date;
   updated_at: Date;
@@ -50,14 +50,43 @@ export interface SalesMetrics {
   };
   
 }
-export interface SalesTransaction {
+export interface SaleItemRecord {
   id: number;
-  product_name: string;
   product_id: number;
-  payment_method: 'cash' | 'mpesa' | 'credit';
+  product_name: string;
   quantity: number;
-  total_amount: number;
-  created_at: Date;
-  updated_at: Date;
+  unit_price: number;
+  discount: number;
+  tax_amount: number;
+  line_total: number;
+  note: string;
 }
 
+export interface SalePaymentRecord {
+  id: number;
+  method: 'CASH' | 'MPESA' | 'CREDIT';
+  amount: number;
+  tendered: number;
+  reference: string;
+}
+
+export interface SaleRecord {
+  id: number;
+  sale_number: string;
+  customer_name: string;
+  customer_phone: string;
+  payment_method: 'CASH' | 'MPESA' | 'CREDIT' | 'SPLIT';
+  reference_number: string;
+  subtotal: number;
+  discount_total: number;
+  tax_total: number;
+  total_amount: number;
+  amount_paid: number;
+  balance_due: number;
+  change_due: number;
+  payment_status: 'PAID' | 'PARTIAL' | 'UNPAID';
+  items: SaleItemRecord[];
+  payments: SalePaymentRecord[];
+  created_at: string;
+  updated_at: string;
+}
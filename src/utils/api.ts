@@ -1,6 +1,6 @@
 import { Product } from "../types/inventory";
 import { StockAlert } from "../types/inventory";
-import { SaleFormData, SalesTransaction } from "../types/sales";
+import { SaleFormData, SaleRecord } from "../types/sales";
 import { CreditCustomer } from "../types/credits";
 
 const API_URL = 'http://localhost:8080';
@@ -177,13 +177,14 @@ export const inventoryApi = {
   }
   ,
 
-  recordSale: async (saleData: SaleFormData): Promise<ApiResponse<null>> => {
+  recordSale: async (saleData: SaleFormData, idempotencyKey?: string): Promise<ApiResponse<null>> => {
     try {
       console.log("saleData====>",saleData);
       const response = await fetch(`${API_URL}/record-sale`, {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          ...(idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {})
         },
         body: JSON.stringify(saleData)
       });
@@ -206,7 +207,7 @@ export const inventoryApi = {
   }
   ,
 
-  fetchSalesHistory: async (): Promise<ApiResponse<SalesTransaction[]>> => {
+  fetchSalesHistory: async (): Promise<ApiResponse<SaleRecord[]>> => {
     try {
       const response = await fetch(`${API_URL}/sales-history`);
       const data = await response.json();
@@ -217,7 +218,7 @@ export const inventoryApi = {
 
       return {
         success: true,
-        data: data as SalesTransaction[]
+        data: data as SaleRecord[]
       };
     } catch (error) {
       return {